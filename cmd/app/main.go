@@ -1,18 +1,34 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"tor_project/internal/config"
 	"tor_project/internal/db"
 	"tor_project/internal/httpapi"
 	"tor_project/internal/network"
+	"tor_project/internal/reader"
 	"tor_project/internal/service"
+	"tor_project/internal/storage"
 	"tor_project/internal/telegram"
 )
 
 func main() {
+	// Служебные команды работают только с БД и не требуют остальной конфигурации.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
 	// 1. Загрузка конфигурации
 	cfg, err := config.Load()
 	if err != nil {
@@ -21,6 +37,11 @@ func main() {
 
 	log.Println("=== TOR BOOK BOT STARTING ===")
 
+	// Ctrl+C / SIGTERM отменяют ctx: бот перестаёт принимать обновления,
+	// а начатые запросы через Tor обрываются вместе с ним.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// 2. Инициализация сети (Tor)
 	torClient, err := network.NewTorClient(cfg.TorProxyAddr)
 	if err != nil {
@@ -29,7 +50,14 @@ func main() {
 
 	// 3. Инициализация Сервиса (Бизнес-логика)
 	// Создаем сервис ДО бота, чтобы передать его внутрь
-	svc := service.NewFlibustaClient(torClient, cfg.FlibustaURL)
+	mirrors := service.NewMirrors(cfg.FlibustaMirrors...)
+	log.Printf("Зеркала: %s", strings.Join(cfg.FlibustaMirrors, ", "))
+
+	svc := service.NewFlibustaClient(torClient, mirrors)
+	if cfg.CatalogSource == "opds" {
+		svc.UseOPDS(service.NewOPDSClient(torClient, mirrors))
+		log.Println("Каталог: OPDS (HTML как запасной вариант)")
+	}
 
 	// 3.1 Инициализация БД
 	store, err := db.Open(cfg.SQLitePath)
@@ -38,29 +66,263 @@ func main() {
 	}
 	defer store.Close()
 	log.Printf("SQLite: %s", cfg.SQLitePath)
-	log.Printf("Storage: %s", cfg.StorageDir)
+
+	var blobs storage.BlobStore
+	if cfg.StorageBackend == "s3" {
+		blobs, err = storage.NewS3Store(storage.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			Prefix:    cfg.S3Prefix,
+		})
+		if err != nil {
+			log.Fatalf("Ошибка хранилища: %v", err)
+		}
+		log.Printf("Storage: s3 %s/%s/%s", cfg.S3Endpoint, cfg.S3Bucket, cfg.S3Prefix)
+	} else {
+		blobs = storage.NewFSStore(cfg.StorageDir)
+		log.Printf("Storage: %s", cfg.StorageDir)
+
+		// Проверка хранилища идёт в фоне: хэшировать все файлы может быть долго.
+		// В S3 целостность объектов проверяет сам сервис, и скачивать весь бакет при каждом старте незачем.
+		go verifyStorage(ctx, store, blobs)
+	}
+
+	// Книги, скачанные архивами до того, как бот научился их распаковывать.
+	go unpackStoredArchives(ctx, store, blobs)
+
+	// Тексты книг для поиска по библиотеке: файлы разбираются в фоне, новые — при следующем проходе.
+	if cfg.SearchBookText {
+		go indexBookTexts(ctx, store, blobs, bookTextInterval)
+	}
+
+	// Уборка хранилища: файлы без записей в БД, записи без файлов и предел размера.
+	// Файлы без записей не трогаем, если бакет делят экземпляры со своими БД.
+	storageManager := storage.NewManager(store, blobs, storage.ManagerConfig{
+		MaxBytes:    cfg.StorageMaxBytes,
+		Interval:    cfg.StorageGCInterval,
+		KeepOrphans: !cfg.StorageGCOrphans,
+	})
+	go storageManager.Run(ctx)
 
 	// 3.2 HTTP API для Mini App
-	api := httpapi.New(store, cfg.StorageDir, cfg.TelegramToken)
+	api := httpapi.New(store, blobs, cfg.TelegramToken)
+	httpServer := &http.Server{
+		Addr:    cfg.HTTPAddr,
+		Handler: api.Handler(),
+		// Контексты запросов наследуют ctx и отменяются при остановке.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
 	go func() {
 		log.Printf("HTTP API запущен на %s", cfg.HTTPAddr)
-		if err := http.ListenAndServe(cfg.HTTPAddr, api.Handler()); err != nil {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("Ошибка HTTP API: %v", err)
 		}
 	}()
 
+	// 3.3 Кэш поиска и карточек книг перед Tor
+	cacheCfg := service.CacheConfig{
+		Size:       cfg.CacheSize,
+		SearchTTL:  cfg.CacheSearchTTL,
+		DetailsTTL: cfg.CacheDetailsTTL,
+		StaleTTL:   cfg.CacheStaleTTL,
+	}
+	if cfg.CachePersist {
+		cacheCfg.Store = store
+		maxAge := max(cfg.CacheSearchTTL, cfg.CacheDetailsTTL) + cfg.CacheStaleTTL
+		if n, err := store.PruneCache(ctx, time.Now().Add(-maxAge)); err != nil {
+			log.Printf("Ошибка очистки кэша: %v", err)
+		} else if n > 0 {
+			log.Printf("Кэш: удалено устаревших записей: %d", n)
+		}
+	}
+	catalog := service.NewCachedCatalog(svc, svc, cacheCfg)
+
 	// 4. Инициализация Бота
-	// ВАЖНО: Мы передаем svc (сервис) вторым аргументом!
-	// (Убедись, что ты обновил файл internal/telegram/bot.go, как в инструкции выше)
-	bot, err := telegram.NewBot(cfg.TelegramToken, svc, store, cfg.StorageDir, cfg.MiniAppURL)
+	// Поиск и карточки книг идут через кэш, скачивание — напрямую.
+	bot, err := telegram.NewBot(cfg.TelegramToken, catalog, catalog, svc, store, blobs, cfg.MiniAppURL)
 	if err != nil {
 		log.Fatalf("Ошибка при создании бота: %v", err)
 	}
+	bot.SetWorkerPool(telegram.WorkerPoolConfig{
+		Workers:      cfg.BotWorkers,
+		QueueSize:    cfg.BotQueueSize,
+		DrainTimeout: cfg.BotDrainTimeout,
+	})
+	bot.SetDownloadQueue(telegram.DownloadQueueConfig{
+		Workers:       cfg.DownloadWorkers,
+		MaxAttempts:   cfg.DownloadMaxAttempts,
+		RetryDelay:    30 * time.Second,
+		MaxRetryDelay: 10 * time.Minute,
+		UserQuota:     cfg.StorageUserQuota,
+	})
 
 	// 5. Запуск Бота
 	log.Println("Бот запущен! Открой Telegram и напиши /start или название книги.")
 
 	// Эта функция блокирует выполнение (вечный цикл),
 	// пока ты не остановишь программу (Ctrl+C).
-	bot.Start()
+	bot.Start(ctx)
+
+	log.Println("Остановка...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Ошибка остановки HTTP API: %v", err)
+	}
+}
+
+// bookTextInterval — как часто искать книги, тексты которых ещё не в поиске.
+const bookTextInterval = 10 * time.Minute
+
+// maxBookText — сколько байт текста одной книги попадает в поиск.
+const maxBookText = 4 << 20
+
+// indexBookTexts складывает тексты скачанных книг в поиск по библиотеке. Файлы, которые
+// не удалось прочитать из хранилища, пробуются снова на следующем проходе.
+func indexBookTexts(ctx context.Context, store *db.Store, blobs storage.BlobStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		indexed := 0
+		var afterID int64
+		for ctx.Err() == nil {
+			files, err := store.FilesWithoutText(ctx, afterID, 20)
+			if err != nil {
+				log.Printf("Индексация текстов: %v", err)
+				break
+			}
+			if len(files) == 0 {
+				break
+			}
+			for _, file := range files {
+				afterID = file.ID
+				text, err := bookText(ctx, blobs, file)
+				if err != nil {
+					log.Printf("Индексация текстов: %s: %v", file.Path, err)
+					continue
+				}
+				if err := store.SetBookText(ctx, file.ID, text); err != nil {
+					log.Printf("Индексация текстов: %v", err)
+					continue
+				}
+				indexed++
+			}
+		}
+		if indexed > 0 {
+			log.Printf("Индексация текстов: добавлено книг: %d", indexed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// bookText — текст книги для поиска. Книги, которые читалка не открывает, дают пустой текст:
+// их не надо разбирать снова. Ошибка — файл сейчас не прочитать.
+func bookText(ctx context.Context, blobs storage.BlobStore, file db.BookFile) (string, error) {
+	mimeType := file.MimeType
+	if mimeType == "" {
+		mimeType = reader.MimeTypeForFormat(file.Format)
+	}
+	if mimeType == "" {
+		return "", nil
+	}
+
+	body, err := blobs.Get(ctx, file.Path)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+
+	book, err := reader.Open(data, mimeType)
+	if err != nil {
+		return "", nil
+	}
+	text := book.Text()
+	if len(text) > maxBookText {
+		text = strings.ToValidUTF8(text[:maxBookText], "")
+	}
+	return text, nil
+}
+
+// verifyStorage сверяет файлы на диске с хэшами из book_files. Повреждённые файлы удаляются:
+// бот считает их отсутствующими и при следующем запросе скачает книгу заново.
+func verifyStorage(ctx context.Context, store *db.Store, blobs storage.BlobStore) {
+	files, err := store.ListBookFiles(ctx)
+	if err != nil {
+		log.Printf("Проверка хранилища: %v", err)
+		return
+	}
+
+	checks := make([]storage.FileCheck, 0, len(files))
+	for _, file := range files {
+		checks = append(checks, storage.FileCheck{ID: file.ID, Path: file.Path, SHA256: file.SHA256})
+	}
+
+	report, err := storage.Verify(ctx, blobs, checks)
+	if err != nil {
+		log.Printf("Проверка хранилища прервана: %v", err)
+		return
+	}
+
+	for _, file := range report.Missing {
+		log.Printf("Проверка хранилища: нет файла %s (book_files.id=%d)", file.Path, file.ID)
+	}
+	for _, file := range report.Corrupted {
+		log.Printf("Проверка хранилища: файл %s (book_files.id=%d) повреждён, удаляю", file.Path, file.ID)
+		if err := blobs.Delete(ctx, file.Path); err != nil {
+			log.Printf("Не удалось удалить %s: %v", file.Path, err)
+		}
+	}
+	log.Printf("Проверка хранилища: файлов %d, отсутствует %d, повреждено %d", report.Checked, len(report.Missing), len(report.Corrupted))
+}
+
+// unpackStoredArchives распаковывает книги, которые лежат в хранилище zip-архивами:
+// в библиотеке остаётся сама книга, а архив — для отправки в Telegram.
+func unpackStoredArchives(ctx context.Context, store *db.Store, blobs storage.BlobStore) {
+	files, err := store.ListBookFiles(ctx)
+	if err != nil {
+		log.Printf("Распаковка архивов: %v", err)
+		return
+	}
+
+	unpacked := 0
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		if file.ArchivePath != "" || !strings.HasSuffix(strings.ToLower(file.Path), ".zip") {
+			continue
+		}
+
+		book, err := storage.UnpackBook(ctx, blobs, file.Path, storage.MaxUnpackedSize)
+		if err != nil {
+			if !errors.Is(err, storage.ErrNotArchive) && !errors.Is(err, storage.ErrMissing) {
+				log.Printf("Распаковка архивов: %s: %v", file.Path, err)
+			}
+			continue
+		}
+
+		file.ArchivePath, file.ArchiveSizeBytes = file.Path, file.SizeBytes
+		file.Path, file.SizeBytes, file.SHA256, file.MimeType = book.RelativePath, book.SizeBytes, book.SHA256, book.MimeType
+		if err := store.UpdateBookFileContent(ctx, file.ID, file); err != nil {
+			log.Printf("Распаковка архивов: %v", err)
+			continue
+		}
+		unpacked++
+	}
+	if unpacked > 0 {
+		log.Printf("Распаковка архивов: распаковано книг: %d", unpacked)
+	}
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"tor_project/internal/config"
+	"tor_project/internal/db"
+)
+
+// runMigrate — команда `app migrate [status|up] [-db путь]`:
+//
+//	status — показать миграции БД, ничего в ней не меняя (по умолчанию);
+//	up     — применить недостающие миграции и выйти (обычный запуск делает то же самое).
+//
+// Код возврата 1 — ошибка или БД, с которой бот не запустится (миграция изменена или неизвестна).
+func runMigrate(args []string) int {
+	fset := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dbPath := fset.String("db", "", "путь к SQLite (по умолчанию SQLITE_PATH или data/app.db)")
+	fset.Usage = func() {
+		fmt.Fprintln(fset.Output(), "Использование: app migrate [status|up] [-db путь]")
+		fset.PrintDefaults()
+	}
+
+	command := "status"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command, args = args[0], args[1:]
+	}
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+	if *dbPath == "" {
+		*dbPath = config.SQLitePath()
+	}
+
+	switch command {
+	case "status":
+		return printMigrations(*dbPath)
+	case "up":
+		store, err := db.Open(*dbPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Ошибка БД: %v\n", err)
+			return 1
+		}
+		store.Close()
+		return printMigrations(*dbPath)
+	default:
+		fset.Usage()
+		return 2
+	}
+}
+
+func printMigrations(dbPath string) int {
+	statuses, err := db.ReadMigrations(context.Background(), dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка БД: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("БД: %s\n\n", dbPath)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ВЕРСИЯ\tНАЗВАНИЕ\tСОСТОЯНИЕ\tПРИМЕНЕНА\tSHA256")
+	code, pending := 0, 0
+	for _, s := range statuses {
+		state, applied := "применена", "-"
+		if s.AppliedAt != 0 {
+			applied = time.UnixMilli(s.AppliedAt).Format("2006-01-02 15:04:05")
+		}
+		switch {
+		case s.Modified:
+			state, code = "ИЗМЕНЕНА после применения", 1
+		case s.Unknown:
+			state, code = "неизвестна приложению", 1
+		case s.AppliedAt == 0:
+			state = "ожидает"
+			pending++
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\t%.12s\n", s.Version, s.Name, state, applied, s.Checksum)
+	}
+	tw.Flush()
+
+	switch {
+	case code != 0:
+		fmt.Println("\nБот с этой БД не запустится: применённые миграции расходятся с приложением.")
+	case pending > 0:
+		fmt.Printf("\nОжидают применения: %d (применятся при запуске или командой app migrate up).\n", pending)
+	}
+	return code
+}
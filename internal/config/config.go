@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -12,13 +14,55 @@ import (
 // Config — структура, хранящая все настройки приложения.
 // Используем её, чтобы передавать параметры одной "пачкой".
 type Config struct {
-	TorProxyAddr  string
-	FlibustaURL   string
-	TelegramToken string
-	SQLitePath    string
-	StorageDir    string
-	HTTPAddr      string
-	MiniAppURL    string
+	TorProxyAddr string
+	FlibustaURL  string
+	// FlibustaMirrors — все адреса сайта в порядке предпочтения (первый — FlibustaURL).
+	FlibustaMirrors []string
+	TelegramToken   string
+	SQLitePath      string
+	StorageDir      string
+	HTTPAddr        string
+	MiniAppURL      string
+
+	// StorageBackend — где хранить файлы книг: "fs" (StorageDir) или "s3" (общий бакет для нескольких реплик).
+	StorageBackend string
+	S3Endpoint     string
+	S3Region       string
+	S3Bucket       string
+	S3AccessKey    string
+	S3SecretKey    string
+	S3Prefix       string
+
+	// CatalogSource — откуда брать каталог: "html" (парсинг страниц) или "opds" (с HTML как запасным вариантом).
+	CatalogSource string
+
+	// Кэш поиска и карточек книг.
+	CacheSize       int
+	CacheSearchTTL  time.Duration
+	CacheDetailsTTL time.Duration
+	CacheStaleTTL   time.Duration
+	// CachePersist — хранить кэш ещё и в SQLite, чтобы он переживал перезапуск.
+	CachePersist bool
+
+	// Параллельная обработка обновлений бота.
+	BotWorkers      int
+	BotQueueSize    int
+	BotDrainTimeout time.Duration
+
+	// Очередь скачиваний.
+	DownloadWorkers     int
+	DownloadMaxAttempts int
+
+	// Уборка хранилища книг (0 — без ограничения).
+	StorageMaxBytes   int64
+	StorageUserQuota  int64
+	StorageGCInterval time.Duration
+	// StorageGCOrphans — удалять из хранилища файлы, которых нет в БД. Для общего бакета S3
+	// по умолчанию выключено: у других экземпляров бота свои БД.
+	StorageGCOrphans bool
+
+	// SearchBookText — искать по библиотеке ещё и по текстам книг (тексты индексируются в фоне).
+	SearchBookText bool
 }
 
 // Load считывает .env файл и заполняет структуру Config.
@@ -33,35 +77,168 @@ func Load() (*Config, error) {
 	// 2. Читаем переменные
 	proxy := os.Getenv("TOR_PROXY")
 	url := os.Getenv("FLIBUSTA_URL")
+	mirrors := splitList(os.Getenv("FLIBUSTA_MIRRORS"))
 	token := os.Getenv("TELEGRAM_TOKEN")
-	sqlitePath := os.Getenv("SQLITE_PATH")
 	storageDir := os.Getenv("STORAGE_DIR")
 	httpAddr := os.Getenv("HTTP_ADDR")
 	miniAppURL := os.Getenv("MINIAPP_URL")
+	catalogSource := strings.ToLower(strings.TrimSpace(withDefault(os.Getenv("CATALOG_SOURCE"), "html")))
+	storageBackend := strings.ToLower(strings.TrimSpace(withDefault(os.Getenv("STORAGE_BACKEND"), "fs")))
 
 	// 3. Валидация (проверяем, что настройки не пустые)
 	if proxy == "" {
 		return nil, fmt.Errorf("переменная TOR_PROXY не задана")
 	}
-	if url == "" {
+	if url == "" && len(mirrors) == 0 {
 		return nil, fmt.Errorf("переменная FLIBUSTA_URL не задана")
 	}
+	if url != "" {
+		mirrors = append([]string{url}, mirrors...)
+	}
 	if token == "" {
 		return nil, fmt.Errorf("переменная TELEGRAM_TOKEN не задана")
 	}
+	if catalogSource != "html" && catalogSource != "opds" {
+		return nil, fmt.Errorf("переменная CATALOG_SOURCE должна быть html или opds, получено %q", catalogSource)
+	}
+
+	if storageBackend != "fs" && storageBackend != "s3" {
+		return nil, fmt.Errorf("переменная STORAGE_BACKEND должна быть fs или s3, получено %q", storageBackend)
+	}
+	if storageBackend == "s3" {
+		for _, name := range []string{"S3_ENDPOINT", "S3_BUCKET", "S3_ACCESS_KEY", "S3_SECRET_KEY"} {
+			if strings.TrimSpace(os.Getenv(name)) == "" {
+				return nil, fmt.Errorf("переменная %s не задана (STORAGE_BACKEND=s3)", name)
+			}
+		}
+	}
+
+	cacheSize, err := intFromEnv("CACHE_SIZE", 500)
+	if err != nil {
+		return nil, err
+	}
+	cacheSearchTTL, err := durationFromEnv("CACHE_SEARCH_TTL", 30*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	cacheDetailsTTL, err := durationFromEnv("CACHE_DETAILS_TTL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	cacheStaleTTL, err := durationFromEnv("CACHE_STALE_TTL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	cachePersist, err := boolFromEnv("CACHE_PERSIST", true)
+	if err != nil {
+		return nil, err
+	}
+
+	botWorkers, err := intFromEnv("BOT_WORKERS", 8)
+	if err != nil {
+		return nil, err
+	}
+	if botWorkers == 0 {
+		return nil, fmt.Errorf("переменная BOT_WORKERS должна быть больше нуля")
+	}
+	botQueueSize, err := intFromEnv("BOT_QUEUE_SIZE", 64)
+	if err != nil {
+		return nil, err
+	}
+	botDrainTimeout, err := durationFromEnv("BOT_DRAIN_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadWorkers, err := intFromEnv("DOWNLOAD_WORKERS", 2)
+	if err != nil {
+		return nil, err
+	}
+	if downloadWorkers == 0 {
+		return nil, fmt.Errorf("переменная DOWNLOAD_WORKERS должна быть больше нуля")
+	}
+	downloadMaxAttempts, err := intFromEnv("DOWNLOAD_MAX_ATTEMPTS", 5)
+	if err != nil {
+		return nil, err
+	}
+	if downloadMaxAttempts == 0 {
+		return nil, fmt.Errorf("переменная DOWNLOAD_MAX_ATTEMPTS должна быть больше нуля")
+	}
+
+	storageMaxMB, err := intFromEnv("STORAGE_MAX_MB", 0)
+	if err != nil {
+		return nil, err
+	}
+	storageUserQuotaMB, err := intFromEnv("STORAGE_USER_QUOTA_MB", 0)
+	if err != nil {
+		return nil, err
+	}
+	storageGCInterval, err := durationFromEnv("STORAGE_GC_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if storageGCInterval == 0 {
+		return nil, fmt.Errorf("переменная STORAGE_GC_INTERVAL должна быть больше нуля")
+	}
+	storageGCOrphans, err := boolFromEnv("STORAGE_GC_ORPHANS", storageBackend == "fs")
+	if err != nil {
+		return nil, err
+	}
+
+	searchBookText, err := boolFromEnv("SEARCH_BOOK_TEXT", false)
+	if err != nil {
+		return nil, err
+	}
 
 	// 4. Возвращаем готовый конфиг
 	return &Config{
-		TorProxyAddr:  proxy,
-		FlibustaURL:   url,
-		TelegramToken: token,
-		SQLitePath:    resolvePath(withDefault(sqlitePath, "data/app.db")),
-		StorageDir:    resolvePath(withDefault(storageDir, "storage/books")),
-		HTTPAddr:      withDefault(httpAddr, ":8080"),
-		MiniAppURL:    miniAppURL,
+		TorProxyAddr:    proxy,
+		FlibustaURL:     mirrors[0],
+		FlibustaMirrors: mirrors,
+		TelegramToken:   token,
+		SQLitePath:      sqlitePathFromEnv(),
+		StorageDir:      resolvePath(withDefault(storageDir, "storage/books")),
+		StorageBackend:  storageBackend,
+		S3Endpoint:      os.Getenv("S3_ENDPOINT"),
+		S3Region:        withDefault(os.Getenv("S3_REGION"), "us-east-1"),
+		S3Bucket:        os.Getenv("S3_BUCKET"),
+		S3AccessKey:     os.Getenv("S3_ACCESS_KEY"),
+		S3SecretKey:     os.Getenv("S3_SECRET_KEY"),
+		S3Prefix:        os.Getenv("S3_PREFIX"),
+		HTTPAddr:        withDefault(httpAddr, ":8080"),
+		MiniAppURL:      miniAppURL,
+		CatalogSource:   catalogSource,
+		CacheSize:       cacheSize,
+		CacheSearchTTL:  cacheSearchTTL,
+		CacheDetailsTTL: cacheDetailsTTL,
+		CacheStaleTTL:   cacheStaleTTL,
+		CachePersist:    cachePersist,
+		BotWorkers:      botWorkers,
+		BotQueueSize:    botQueueSize,
+		BotDrainTimeout: botDrainTimeout,
+
+		DownloadWorkers:     downloadWorkers,
+		DownloadMaxAttempts: downloadMaxAttempts,
+
+		StorageMaxBytes:   int64(storageMaxMB) * 1024 * 1024,
+		StorageUserQuota:  int64(storageUserQuotaMB) * 1024 * 1024,
+		StorageGCInterval: storageGCInterval,
+		StorageGCOrphans:  storageGCOrphans,
+
+		SearchBookText: searchBookText,
 	}, nil
 }
 
+// SQLitePath возвращает только путь к БД — для служебных команд, которым не нужны Tor и токен бота.
+func SQLitePath() string {
+	_ = godotenv.Load()
+	return sqlitePathFromEnv()
+}
+
+func sqlitePathFromEnv() string {
+	return resolvePath(withDefault(os.Getenv("SQLITE_PATH"), "data/app.db"))
+}
+
 func withDefault(value string, fallback string) string {
 	if strings.TrimSpace(value) == "" {
 		return fallback
@@ -69,6 +246,56 @@ func withDefault(value string, fallback string) string {
 	return value
 }
 
+// intFromEnv читает неотрицательное целое из переменной name (fallback, если она пустая).
+func intFromEnv(name string, fallback int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("переменная %s должна быть неотрицательным числом, получено %q", name, value)
+	}
+	return n, nil
+}
+
+// durationFromEnv читает длительность вида "30m" или "24h" из переменной name.
+func durationFromEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("переменная %s должна быть длительностью вида 30m или 24h, получено %q", name, value)
+	}
+	return d, nil
+}
+
+func boolFromEnv(name string, fallback bool) (bool, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("переменная %s должна быть true или false, получено %q", name, value)
+	}
+	return b, nil
+}
+
+// splitList разбирает список через запятую, пропуская пустые элементы.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func resolvePath(p string) string {
 	p = strings.TrimSpace(p)
 	if p == "" {
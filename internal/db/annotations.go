@@ -0,0 +1,349 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound — закладки, выделения или заметки с таким id у пользователя нет.
+var ErrNotFound = errors.New("запись не найдена")
+
+// Bookmark — закладка на абзаце книги.
+type Bookmark struct {
+	ID     int64 `json:"id"`
+	FileID int64 `json:"file_id"`
+	// Chapter и Offset — глава и абзац (с нуля); Location — тот же якорь строкой ("c3p12").
+	Chapter   int    `json:"chapter"`
+	Offset    int    `json:"offset"`
+	Location  string `json:"location"`
+	Title     string `json:"title"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Highlight — выделенный фрагмент главы: от символа StartChar абзаца StartParagraph
+// до символа EndChar абзаца EndParagraph (конец не включается).
+type Highlight struct {
+	ID             int64  `json:"id"`
+	FileID         int64  `json:"file_id"`
+	Chapter        int    `json:"chapter"`
+	StartParagraph int    `json:"start_paragraph"`
+	StartChar      int    `json:"start_char"`
+	EndParagraph   int    `json:"end_paragraph"`
+	EndChar        int    `json:"end_char"`
+	Color          string `json:"color"`
+	// Text — выделенный текст, как его увидел пользователь (для экспорта без разбора книги).
+	Text      string `json:"text"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// Note — заметка на абзаце или к выделению (HighlightID).
+type Note struct {
+	ID          int64  `json:"id"`
+	FileID      int64  `json:"file_id"`
+	HighlightID *int64 `json:"highlight_id,omitempty"`
+	Chapter     int    `json:"chapter"`
+	Offset      int    `json:"offset"`
+	Location    string `json:"location"`
+	Text        string `json:"text"`
+	CreatedAt   int64  `json:"created_at"`
+	UpdatedAt   int64  `json:"updated_at"`
+}
+
+// AnnotatedBook — книга библиотеки, в которой есть выделения.
+type AnnotatedBook struct {
+	FileID     int64
+	Title      string
+	Author     string
+	Highlights int
+}
+
+// AddBookmark добавляет закладку; ErrNotInLibrary — книги нет в библиотеке пользователя.
+func (s *Store) AddBookmark(ctx context.Context, userID int64, b Bookmark) (Bookmark, error) {
+	b.CreatedAt = time.Now().UnixMilli()
+	// INSERT ... SELECT из user_library: ничего не вставится, если книги нет в библиотеке.
+	res, err := s.db.ExecContext(ctx, `
+INSERT INTO bookmarks (user_id, book_file_id, chapter, paragraph, location, title, created_at)
+SELECT user_id, book_file_id, ?, ?, ?, ?, ?
+FROM user_library
+WHERE user_id = ? AND book_file_id = ?
+`, b.Chapter, b.Offset, b.Location, b.Title, b.CreatedAt, userID, b.FileID)
+	if err != nil {
+		return Bookmark{}, fmt.Errorf("ошибка сохранения закладки: %w", err)
+	}
+	if b.ID, err = insertedID(res); err != nil {
+		return Bookmark{}, err
+	}
+	return b, nil
+}
+
+// ListBookmarks возвращает закладки книги по порядку чтения.
+func (s *Store) ListBookmarks(ctx context.Context, userID int64, fileID int64) ([]Bookmark, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, book_file_id, chapter, paragraph, location, title, created_at
+FROM bookmarks
+WHERE user_id = ? AND book_file_id = ?
+ORDER BY chapter, paragraph, id
+`, userID, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения закладок: %w", err)
+	}
+	defer rows.Close()
+
+	bookmarks := []Bookmark{}
+	for rows.Next() {
+		var (
+			b     Bookmark
+			title sql.NullString
+		)
+		if err := rows.Scan(&b.ID, &b.FileID, &b.Chapter, &b.Offset, &b.Location, &title, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка чтения закладок: %w", err)
+		}
+		b.Title = title.String
+		bookmarks = append(bookmarks, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения закладок: %w", err)
+	}
+	return bookmarks, nil
+}
+
+// RenameBookmark меняет подпись закладки.
+func (s *Store) RenameBookmark(ctx context.Context, userID int64, id int64, title string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE bookmarks SET title = ? WHERE id = ? AND user_id = ?`, title, id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения закладки: %w", err)
+	}
+	return affectedOne(res)
+}
+
+// DeleteBookmark удаляет закладку.
+func (s *Store) DeleteBookmark(ctx context.Context, userID int64, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM bookmarks WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка удаления закладки: %w", err)
+	}
+	return affectedOne(res)
+}
+
+// AddHighlight добавляет выделение; ErrNotInLibrary — книги нет в библиотеке пользователя.
+func (s *Store) AddHighlight(ctx context.Context, userID int64, h Highlight) (Highlight, error) {
+	h.CreatedAt = time.Now().UnixMilli()
+	h.UpdatedAt = h.CreatedAt
+	res, err := s.db.ExecContext(ctx, `
+INSERT INTO highlights (user_id, book_file_id, chapter, start_paragraph, start_char, end_paragraph, end_char, color, text, created_at, updated_at)
+SELECT user_id, book_file_id, ?, ?, ?, ?, ?, ?, ?, ?, ?
+FROM user_library
+WHERE user_id = ? AND book_file_id = ?
+`, h.Chapter, h.StartParagraph, h.StartChar, h.EndParagraph, h.EndChar, h.Color, h.Text, h.CreatedAt, h.UpdatedAt, userID, h.FileID)
+	if err != nil {
+		return Highlight{}, fmt.Errorf("ошибка сохранения выделения: %w", err)
+	}
+	if h.ID, err = insertedID(res); err != nil {
+		return Highlight{}, err
+	}
+	return h, nil
+}
+
+// ListHighlights возвращает выделения книги по порядку чтения.
+func (s *Store) ListHighlights(ctx context.Context, userID int64, fileID int64) ([]Highlight, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, book_file_id, chapter, start_paragraph, start_char, end_paragraph, end_char, color, text, created_at, updated_at
+FROM highlights
+WHERE user_id = ? AND book_file_id = ?
+ORDER BY chapter, start_paragraph, start_char, id
+`, userID, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения выделений: %w", err)
+	}
+	defer rows.Close()
+
+	highlights := []Highlight{}
+	for rows.Next() {
+		var (
+			h    Highlight
+			text sql.NullString
+		)
+		if err := rows.Scan(&h.ID, &h.FileID, &h.Chapter, &h.StartParagraph, &h.StartChar, &h.EndParagraph, &h.EndChar,
+			&h.Color, &text, &h.CreatedAt, &h.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка чтения выделений: %w", err)
+		}
+		h.Text = text.String
+		highlights = append(highlights, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения выделений: %w", err)
+	}
+	return highlights, nil
+}
+
+// RecolorHighlight меняет цвет выделения.
+func (s *Store) RecolorHighlight(ctx context.Context, userID int64, id int64, color string) error {
+	res, err := s.db.ExecContext(ctx, `
+UPDATE highlights SET color = ?, updated_at = ? WHERE id = ? AND user_id = ?
+`, color, time.Now().UnixMilli(), id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения выделения: %w", err)
+	}
+	return affectedOne(res)
+}
+
+// DeleteHighlight удаляет выделение вместе с заметками к нему.
+func (s *Store) DeleteHighlight(ctx context.Context, userID int64, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM highlights WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка удаления выделения: %w", err)
+	}
+	return affectedOne(res)
+}
+
+// AddNote добавляет заметку. Заметка к выделению должна относиться к той же книге,
+// иначе ErrNotFound; ErrNotInLibrary — книги нет в библиотеке пользователя.
+func (s *Store) AddNote(ctx context.Context, userID int64, n Note) (Note, error) {
+	if n.HighlightID != nil {
+		var fileID int64
+		err := s.db.QueryRowContext(ctx, `
+SELECT book_file_id FROM highlights WHERE id = ? AND user_id = ?
+`, *n.HighlightID, userID).Scan(&fileID)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && fileID != n.FileID {
+			return Note{}, ErrNotFound
+		}
+		if err != nil {
+			return Note{}, fmt.Errorf("ошибка сохранения заметки: %w", err)
+		}
+	}
+
+	n.CreatedAt = time.Now().UnixMilli()
+	n.UpdatedAt = n.CreatedAt
+	res, err := s.db.ExecContext(ctx, `
+INSERT INTO notes (user_id, book_file_id, highlight_id, chapter, paragraph, location, text, created_at, updated_at)
+SELECT user_id, book_file_id, ?, ?, ?, ?, ?, ?, ?
+FROM user_library
+WHERE user_id = ? AND book_file_id = ?
+`, n.HighlightID, n.Chapter, n.Offset, n.Location, n.Text, n.CreatedAt, n.UpdatedAt, userID, n.FileID)
+	if err != nil {
+		return Note{}, fmt.Errorf("ошибка сохранения заметки: %w", err)
+	}
+	if n.ID, err = insertedID(res); err != nil {
+		return Note{}, err
+	}
+	return n, nil
+}
+
+// ListNotes возвращает заметки книги по порядку чтения.
+func (s *Store) ListNotes(ctx context.Context, userID int64, fileID int64) ([]Note, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, book_file_id, highlight_id, chapter, paragraph, location, text, created_at, updated_at
+FROM notes
+WHERE user_id = ? AND book_file_id = ?
+ORDER BY chapter, paragraph, id
+`, userID, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения заметок: %w", err)
+	}
+	defer rows.Close()
+
+	notes := []Note{}
+	for rows.Next() {
+		var (
+			n           Note
+			highlightID sql.NullInt64
+		)
+		if err := rows.Scan(&n.ID, &n.FileID, &highlightID, &n.Chapter, &n.Offset, &n.Location, &n.Text, &n.CreatedAt, &n.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка чтения заметок: %w", err)
+		}
+		if highlightID.Valid {
+			n.HighlightID = &highlightID.Int64
+		}
+		notes = append(notes, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения заметок: %w", err)
+	}
+	return notes, nil
+}
+
+// EditNote меняет текст заметки.
+func (s *Store) EditNote(ctx context.Context, userID int64, id int64, text string) error {
+	res, err := s.db.ExecContext(ctx, `
+UPDATE notes SET text = ?, updated_at = ? WHERE id = ? AND user_id = ?
+`, text, time.Now().UnixMilli(), id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения заметки: %w", err)
+	}
+	return affectedOne(res)
+}
+
+// DeleteNote удаляет заметку.
+func (s *Store) DeleteNote(ctx context.Context, userID int64, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM notes WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка удаления заметки: %w", err)
+	}
+	return affectedOne(res)
+}
+
+// AnnotatedBooks возвращает книги библиотеки, в которых есть выделения, — последние выделенные первыми.
+func (s *Store) AnnotatedBooks(ctx context.Context, userID int64) ([]AnnotatedBook, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT h.book_file_id, b.title, b.author, COUNT(*)
+FROM highlights h
+JOIN book_files bf ON bf.id = h.book_file_id
+JOIN books b ON b.id = bf.book_id
+WHERE h.user_id = ?
+GROUP BY h.book_file_id
+ORDER BY MAX(h.created_at) DESC
+`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения выделений: %w", err)
+	}
+	defer rows.Close()
+
+	var books []AnnotatedBook
+	for rows.Next() {
+		var (
+			book   AnnotatedBook
+			title  sql.NullString
+			author sql.NullString
+		)
+		if err := rows.Scan(&book.FileID, &title, &author, &book.Highlights); err != nil {
+			return nil, fmt.Errorf("ошибка чтения выделений: %w", err)
+		}
+		book.Title, book.Author = title.String, author.String
+		books = append(books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения выделений: %w", err)
+	}
+	return books, nil
+}
+
+// insertedID возвращает id строки, вставленной через INSERT ... SELECT FROM user_library.
+func insertedID(res sql.Result) (int64, error) {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка вставки: %w", err)
+	}
+	if n == 0 {
+		return 0, ErrNotInLibrary
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка вставки: %w", err)
+	}
+	return id, nil
+}
+
+func affectedOne(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("ошибка записи: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"tor_project/internal/models"
+)
+
+func addTestBook(t *testing.T, store *Store, userID int64, details models.BookDetails) int64 {
+	t.Helper()
+	ctx := context.Background()
+	bookID, err := store.SaveBookDetails(ctx, details)
+	if err != nil {
+		t.Fatalf("SaveBookDetails: %v", err)
+	}
+	fileID, err := store.InsertBookFile(ctx, bookID, BookFile{Path: details.ID + ".fb2", Format: "fb2", SHA256: details.ID})
+	if err != nil {
+		t.Fatalf("InsertBookFile: %v", err)
+	}
+	if userID != 0 {
+		if err := store.EnsureUser(ctx, userID, "reader"); err != nil {
+			t.Fatalf("EnsureUser: %v", err)
+		}
+		if err := store.AddToLibrary(ctx, userID, fileID); err != nil {
+			t.Fatalf("AddToLibrary: %v", err)
+		}
+	}
+	return fileID
+}
+
+// holdConnection занимает соединение пула до конца теста, чтобы следующие запросы
+// пошли через новое соединение.
+func holdConnection(t *testing.T, store *Store) {
+	t.Helper()
+	conn, err := store.db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Conn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+}
+
+func TestDeleteHighlightCascadesOnEveryConnection(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	fileID := addTestBook(t, store, 42, models.BookDetails{ID: "1", Title: "1984", Author: "Джордж Оруэлл"})
+	holdConnection(t, store)
+
+	var foreignKeys int
+	if err := store.db.QueryRowContext(ctx, `PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil {
+		t.Fatalf("PRAGMA: %v", err)
+	}
+	if foreignKeys != 1 {
+		t.Fatal("foreign_keys is off on a new connection")
+	}
+
+	highlight, err := store.AddHighlight(ctx, 42, Highlight{FileID: fileID, EndChar: 5, Color: "yellow", Text: "Война"})
+	if err != nil {
+		t.Fatalf("AddHighlight: %v", err)
+	}
+	if _, err := store.AddNote(ctx, 42, Note{FileID: fileID, HighlightID: &highlight.ID, Text: "к выделению"}); err != nil {
+		t.Fatalf("AddNote: %v", err)
+	}
+	free, err := store.AddNote(ctx, 42, Note{FileID: fileID, Chapter: 1, Location: "c1p0", Text: "на абзаце"})
+	if err != nil {
+		t.Fatalf("AddNote: %v", err)
+	}
+
+	if err := store.DeleteHighlight(ctx, 42, highlight.ID); err != nil {
+		t.Fatalf("DeleteHighlight: %v", err)
+	}
+	notes, err := store.ListNotes(ctx, 42, fileID)
+	if err != nil {
+		t.Fatalf("ListNotes: %v", err)
+	}
+	if len(notes) != 1 || notes[0].ID != free.ID {
+		t.Fatalf("notes = %+v", notes)
+	}
+}
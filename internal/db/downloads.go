@@ -0,0 +1,303 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Статусы задания на скачивание.
+const (
+	DownloadQueued      = "queued"
+	DownloadDownloading = "downloading"
+	DownloadUploading   = "uploading"
+	DownloadDone        = "done"
+	DownloadFailed      = "failed"
+	DownloadCanceled    = "canceled"
+)
+
+// DownloadJob — задание на скачивание книги в одном формате. Одно задание
+// обслуживает всех пользователей, попросивших ту же книгу в том же формате.
+type DownloadJob struct {
+	ID            int64
+	SourceID      string
+	Format        string
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+}
+
+// DownloadRequest — пользователь, ждущий результат задания, и его статусное сообщение.
+type DownloadRequest struct {
+	ID        int64
+	JobID     int64
+	UserID    int64
+	Username  string
+	ChatID    int64
+	MessageID int
+	Title     string
+	Author    string
+}
+
+// activeStatuses — задания, к которым можно присоединиться новым запросом.
+const activeStatuses = `('queued', 'downloading', 'uploading')`
+
+// EnqueueDownload ставит книгу в очередь или присоединяет запрос к уже идущему заданию
+// на ту же книгу и формат. joined=true — задание уже было.
+func (s *Store) EnqueueDownload(ctx context.Context, sourceID string, format string, req DownloadRequest) (job DownloadJob, requestID int64, joined bool, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return DownloadJob{}, 0, false, fmt.Errorf("ошибка постановки в очередь: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Сначала вставка: уникальный индекс по активным заданиям (idx_download_jobs_active)
+	// не даст двум одновременным запросам создать два задания, а пишущая первой транзакция
+	// сразу берёт блокировку записи.
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO download_jobs (source_id, format, status, next_attempt_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT DO NOTHING
+`, sourceID, format, DownloadQueued, time.Now().Unix())
+	if err != nil {
+		return DownloadJob{}, 0, false, fmt.Errorf("ошибка постановки в очередь: %w", err)
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return DownloadJob{}, 0, false, fmt.Errorf("ошибка постановки в очередь: %w", err)
+	}
+
+	if inserted == 1 {
+		id, err := res.LastInsertId()
+		if err != nil {
+			return DownloadJob{}, 0, false, fmt.Errorf("ошибка постановки в очередь: %w", err)
+		}
+		job = DownloadJob{ID: id, SourceID: sourceID, Format: format, Status: DownloadQueued}
+	} else {
+		job, err = scanDownloadJob(tx.QueryRowContext(ctx, `
+SELECT id, source_id, format, status, attempts, next_attempt_at, last_error
+FROM download_jobs
+WHERE source_id = ? AND format = ? AND status IN `+activeStatuses+`
+`, sourceID, format))
+		if err != nil {
+			return DownloadJob{}, 0, false, fmt.Errorf("ошибка поиска задания: %w", err)
+		}
+		joined = true
+	}
+
+	res, err = tx.ExecContext(ctx, `
+INSERT INTO download_requests (job_id, user_id, username, chat_id, title, author)
+VALUES (?, ?, ?, ?, ?, ?)
+`, job.ID, req.UserID, req.Username, req.ChatID, req.Title, req.Author)
+	if err != nil {
+		return DownloadJob{}, 0, false, fmt.Errorf("ошибка постановки в очередь: %w", err)
+	}
+	requestID, err = res.LastInsertId()
+	if err != nil {
+		return DownloadJob{}, 0, false, fmt.Errorf("ошибка постановки в очередь: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return DownloadJob{}, 0, false, fmt.Errorf("ошибка постановки в очередь: %w", err)
+	}
+	return job, requestID, joined, nil
+}
+
+// SetDownloadRequestMessage запоминает статусное сообщение запроса (его бот будет редактировать).
+func (s *Store) SetDownloadRequestMessage(ctx context.Context, requestID int64, messageID int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE download_requests SET message_id = ? WHERE id = ?`, messageID, requestID)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления запроса: %w", err)
+	}
+	return nil
+}
+
+// ClaimDownloadJob берёт из очереди следующее готовое задание и переводит его в downloading.
+// ok=false — готовых заданий нет.
+func (s *Store) ClaimDownloadJob(ctx context.Context, now time.Time) (DownloadJob, bool, error) {
+	job, err := scanDownloadJob(s.db.QueryRowContext(ctx, `
+UPDATE download_jobs
+SET status = ?, attempts = attempts + 1, updated_at = CURRENT_TIMESTAMP
+WHERE id = (
+	SELECT id FROM download_jobs
+	WHERE status = ? AND next_attempt_at <= ?
+	ORDER BY next_attempt_at, id
+	LIMIT 1
+)
+RETURNING id, source_id, format, status, attempts, next_attempt_at, last_error
+`, DownloadDownloading, DownloadQueued, now.Unix()))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return DownloadJob{}, false, nil
+		}
+		return DownloadJob{}, false, fmt.Errorf("ошибка выборки задания: %w", err)
+	}
+	return job, true, nil
+}
+
+// SetDownloadJobStatus меняет статус задания (например, downloading -> uploading).
+func (s *Store) SetDownloadJobStatus(ctx context.Context, jobID int64, status string, lastError string) error {
+	_, err := s.db.ExecContext(ctx, `
+UPDATE download_jobs
+SET status = ?, last_error = ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = ?
+`, status, lastError, jobID)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления задания: %w", err)
+	}
+	return nil
+}
+
+// RetryDownloadJob возвращает задание в очередь; оно станет доступно не раньше next.
+func (s *Store) RetryDownloadJob(ctx context.Context, jobID int64, next time.Time, lastError string) error {
+	_, err := s.db.ExecContext(ctx, `
+UPDATE download_jobs
+SET status = ?, next_attempt_at = ?, last_error = ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND status <> ?
+`, DownloadQueued, next.Unix(), lastError, jobID, DownloadCanceled)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления задания: %w", err)
+	}
+	return nil
+}
+
+// RequeueDownloadJob возвращает прерванное остановкой задание в очередь, не засчитывая попытку.
+func (s *Store) RequeueDownloadJob(ctx context.Context, jobID int64) error {
+	_, err := s.db.ExecContext(ctx, `
+UPDATE download_jobs
+SET status = ?, attempts = MAX(attempts - 1, 0), updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND status <> ?
+`, DownloadQueued, jobID, DownloadCanceled)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления задания: %w", err)
+	}
+	return nil
+}
+
+// ResetStaleDownloadJobs возвращает в очередь задания, прерванные падением или перезапуском.
+func (s *Store) ResetStaleDownloadJobs(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+UPDATE download_jobs
+SET status = ?, updated_at = CURRENT_TIMESTAMP
+WHERE status IN (?, ?)
+`, DownloadQueued, DownloadDownloading, DownloadUploading)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка восстановления очереди: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// GetDownloadJobStatus возвращает текущий статус задания.
+func (s *Store) GetDownloadJobStatus(ctx context.Context, jobID int64) (string, error) {
+	var status string
+	if err := s.db.QueryRowContext(ctx, `SELECT status FROM download_jobs WHERE id = ?`, jobID).Scan(&status); err != nil {
+		return "", fmt.Errorf("ошибка чтения задания: %w", err)
+	}
+	return status, nil
+}
+
+// DownloadRequests возвращает всех, кто ждёт задание, в порядке запросов.
+func (s *Store) DownloadRequests(ctx context.Context, jobID int64) ([]DownloadRequest, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, job_id, user_id, COALESCE(username, ''), chat_id, COALESCE(message_id, 0), COALESCE(title, ''), COALESCE(author, '')
+FROM download_requests
+WHERE job_id = ?
+ORDER BY id
+`, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения запросов: %w", err)
+	}
+	defer rows.Close()
+
+	var requests []DownloadRequest
+	for rows.Next() {
+		var r DownloadRequest
+		if err := rows.Scan(&r.ID, &r.JobID, &r.UserID, &r.Username, &r.ChatID, &r.MessageID, &r.Title, &r.Author); err != nil {
+			return nil, fmt.Errorf("ошибка чтения запросов: %w", err)
+		}
+		requests = append(requests, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения запросов: %w", err)
+	}
+	return requests, nil
+}
+
+// CancelDownloadRequests снимает запросы чата с незавершённых заданий и возвращает их. Задания,
+// которые после этого никто не ждёт, помечаются отменёнными; их ID возвращаются, чтобы остановить работу.
+func (s *Store) CancelDownloadRequests(ctx context.Context, chatID int64) (removed []DownloadRequest, canceledJobs []int64, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка отмены скачивания: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+DELETE FROM download_requests
+WHERE chat_id = ? AND job_id IN (SELECT id FROM download_jobs WHERE status IN `+activeStatuses+`)
+RETURNING id, job_id, user_id, COALESCE(username, ''), chat_id, COALESCE(message_id, 0), COALESCE(title, ''), COALESCE(author, '')
+`, chatID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка отмены скачивания: %w", err)
+	}
+	for rows.Next() {
+		var r DownloadRequest
+		if err := rows.Scan(&r.ID, &r.JobID, &r.UserID, &r.Username, &r.ChatID, &r.MessageID, &r.Title, &r.Author); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("ошибка отмены скачивания: %w", err)
+		}
+		removed = append(removed, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("ошибка отмены скачивания: %w", err)
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+UPDATE download_jobs
+SET status = ?, updated_at = CURRENT_TIMESTAMP
+WHERE status IN `+activeStatuses+`
+	AND NOT EXISTS (SELECT 1 FROM download_requests r WHERE r.job_id = download_jobs.id)
+RETURNING id
+`, DownloadCanceled)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка отмены скачивания: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("ошибка отмены скачивания: %w", err)
+		}
+		canceledJobs = append(canceledJobs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("ошибка отмены скачивания: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("ошибка отмены скачивания: %w", err)
+	}
+	return removed, canceledJobs, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDownloadJob(row rowScanner) (DownloadJob, error) {
+	var (
+		job       DownloadJob
+		next      int64
+		lastError sql.NullString
+	)
+	if err := row.Scan(&job.ID, &job.SourceID, &job.Format, &job.Status, &job.Attempts, &next, &lastError); err != nil {
+		return DownloadJob{}, err
+	}
+	job.NextAttemptAt = time.Unix(next, 0)
+	job.LastError = lastError.String
+	return job, nil
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestEnqueueDownloadConcurrentDedupe(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	const users = 16
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		jobIDs  = make(map[int64]bool)
+		created int
+	)
+	start := make(chan struct{})
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(userID int64) {
+			defer wg.Done()
+			<-start
+			job, _, joined, err := store.EnqueueDownload(ctx, "1", "fb2", DownloadRequest{UserID: userID, ChatID: userID})
+			if err != nil {
+				t.Errorf("EnqueueDownload: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			jobIDs[job.ID] = true
+			if !joined {
+				created++
+			}
+		}(int64(i + 1))
+	}
+	close(start)
+	wg.Wait()
+
+	if len(jobIDs) != 1 || created != 1 {
+		t.Fatalf("jobs %v, created %d; want one job", jobIDs, created)
+	}
+	var requests int
+	if err := store.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM download_requests`).Scan(&requests); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if requests != users {
+		t.Fatalf("requests = %d, want %d", requests, users)
+	}
+
+	// Завершённое задание не мешает поставить книгу в очередь заново.
+	for id := range jobIDs {
+		if _, err := store.db.ExecContext(ctx, `UPDATE download_jobs SET status = ? WHERE id = ?`, DownloadDone, id); err != nil {
+			t.Fatalf("finish job: %v", err)
+		}
+	}
+	if job, _, joined, err := store.EnqueueDownload(ctx, "1", "fb2", DownloadRequest{UserID: 1, ChatID: 1}); err != nil || joined || jobIDs[job.ID] {
+		t.Fatalf("enqueue after done: job %+v, joined %v, err %v", job, joined, err)
+	}
+}
+
+func TestMigrateMergesDuplicateDownloadJobs(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "app.db")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	store.Close()
+
+	// База до 0003: индекса нет, и гонка успела создать два задания на одну книгу.
+	raw := openRaw(t, path)
+	_, err = raw.Exec(`
+DROP INDEX idx_download_jobs_active;
+DELETE FROM schema_migrations WHERE version = 3;
+INSERT INTO download_jobs (id, source_id, format, status) VALUES (1, '1', 'fb2', 'queued'), (2, '1', 'fb2', 'downloading'), (3, '1', 'epub', 'queued');
+INSERT INTO download_requests (job_id, user_id, chat_id) VALUES (1, 1, 1), (2, 2, 2), (3, 3, 3);
+`)
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	raw.Close()
+
+	store, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+
+	rows, err := store.db.QueryContext(ctx, `
+SELECT r.user_id, r.job_id, j.status FROM download_requests r JOIN download_jobs j ON j.id = r.job_id ORDER BY r.user_id`)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	want := map[int64]int64{1: 1, 2: 1, 3: 3}
+	for rows.Next() {
+		var userID, jobID int64
+		var status string
+		if err := rows.Scan(&userID, &jobID, &status); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		if jobID != want[userID] || status != DownloadQueued {
+			t.Errorf("user %d: job %d (%s), want job %d", userID, jobID, status, want[userID])
+		}
+	}
+
+	var status string
+	if err := store.db.QueryRowContext(ctx, `SELECT status FROM download_jobs WHERE id = 2`).Scan(&status); err != nil || status != DownloadCanceled {
+		t.Fatalf("duplicate job: %q, %v", status, err)
+	}
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TouchBookFile отмечает, что файл только что отдавали пользователю.
+func (s *Store) TouchBookFile(ctx context.Context, fileID int64, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE book_files SET last_used_at = ? WHERE id = ?`, at.Unix(), fileID)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления файла: %w", err)
+	}
+	return nil
+}
+
+// UpdateBookFileContent записывает новое содержимое файла: путь, размер, хэш, тип и архив.
+func (s *Store) UpdateBookFileContent(ctx context.Context, fileID int64, file BookFile) error {
+	_, err := s.db.ExecContext(ctx, `
+UPDATE book_files
+SET path = ?, size_bytes = ?, sha256 = NULLIF(?, ''), mime_type = NULLIF(?, ''),
+	archive_path = NULLIF(?, ''), archive_size_bytes = NULLIF(?, 0)
+WHERE id = ?
+`, file.Path, file.SizeBytes, file.SHA256, file.MimeType, file.ArchivePath, file.ArchiveSizeBytes, fileID)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления файла: %w", err)
+	}
+	return nil
+}
+
+// StorageUsage — сколько байт занимают все файлы хранилища вместе с архивами. Один файл
+// может принадлежать нескольким строкам book_files, поэтому считаем каждый путь один раз.
+func (s *Store) StorageUsage(ctx context.Context) (int64, error) {
+	var total int64
+	err := s.db.QueryRowContext(ctx, `
+SELECT COALESCE(SUM(size), 0)
+FROM (
+	SELECT MAX(size) AS size
+	FROM (
+		SELECT path, COALESCE(size_bytes, 0) AS size FROM book_files
+		UNION ALL
+		SELECT archive_path, COALESCE(archive_size_bytes, 0) FROM book_files WHERE archive_path IS NOT NULL
+	)
+	GROUP BY path
+)
+`).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка подсчёта размера хранилища: %w", err)
+	}
+	return total, nil
+}
+
+// UserStorageUsage — сколько байт занимают книги из библиотеки пользователя.
+func (s *Store) UserStorageUsage(ctx context.Context, userID int64) (int64, error) {
+	var total int64
+	err := s.db.QueryRowContext(ctx, `
+SELECT COALESCE(SUM(COALESCE(bf.size_bytes, 0) + COALESCE(bf.archive_size_bytes, 0)), 0)
+FROM user_library ul
+JOIN book_files bf ON bf.id = ul.book_file_id
+WHERE ul.user_id = ?
+`, userID).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка подсчёта размера библиотеки: %w", err)
+	}
+	return total, nil
+}
+
+// EvictionCandidates возвращает файлы, которых нет ни в одной библиотеке и которые не
+// использовались с usedBefore, — давно не нужные первыми. Файлы, которые ни разу не
+// отдавали, считаются использованными в момент скачивания.
+func (s *Store) EvictionCandidates(ctx context.Context, usedBefore time.Time) ([]BookFile, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, path, COALESCE(format, ''), COALESCE(size_bytes, 0), COALESCE(telegram_file_id, ''), COALESCE(sha256, ''), COALESCE(mime_type, ''),
+	COALESCE(archive_path, ''), COALESCE(archive_size_bytes, 0)
+FROM (
+	SELECT bf.*, COALESCE(bf.last_used_at, CAST(strftime('%s', bf.created_at) AS INTEGER)) AS used_at
+	FROM book_files bf
+	WHERE NOT EXISTS (SELECT 1 FROM user_library ul WHERE ul.book_file_id = bf.id)
+)
+WHERE used_at <= ?
+ORDER BY used_at, id
+`, usedBefore.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения файлов: %w", err)
+	}
+	defer rows.Close()
+
+	var files []BookFile
+	for rows.Next() {
+		var file BookFile
+		if err := rows.Scan(&file.ID, &file.Path, &file.Format, &file.SizeBytes, &file.TelegramFileID, &file.SHA256, &file.MimeType, &file.ArchivePath, &file.ArchiveSizeBytes); err != nil {
+			return nil, fmt.Errorf("ошибка чтения файлов: %w", err)
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения файлов: %w", err)
+	}
+	return files, nil
+}
+
+// DeleteUnusedBookFile удаляет строку файла, если его так и нет ни в одной библиотеке.
+// deleted=false — файл успели добавить в библиотеку. unusedPaths — пути (файл и архив),
+// на которые больше не ссылается ни одна строка: их можно удалять из хранилища.
+func (s *Store) DeleteUnusedBookFile(ctx context.Context, fileID int64) (deleted bool, unusedPaths []string, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, nil, fmt.Errorf("ошибка удаления файла: %w", err)
+	}
+	defer tx.Rollback()
+
+	var path, archivePath string
+	err = tx.QueryRowContext(ctx, `
+DELETE FROM book_files
+WHERE id = ? AND NOT EXISTS (SELECT 1 FROM user_library WHERE book_file_id = ?)
+RETURNING path, COALESCE(archive_path, '')
+`, fileID, fileID).Scan(&path, &archivePath)
+	if err == sql.ErrNoRows {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, fmt.Errorf("ошибка удаления файла: %w", err)
+	}
+
+	for _, p := range []string{path, archivePath} {
+		if p == "" {
+			continue
+		}
+		var refs int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM book_files WHERE path = ? OR archive_path = ?`, p, p).Scan(&refs); err != nil {
+			return false, nil, fmt.Errorf("ошибка удаления файла: %w", err)
+		}
+		if refs == 0 {
+			unusedPaths = append(unusedPaths, p)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, nil, fmt.Errorf("ошибка удаления файла: %w", err)
+	}
+	return true, unusedPaths, nil
+}
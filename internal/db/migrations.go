@@ -0,0 +1,366 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Схема БД описана миграциями migrations/NNNN_название.sql. Они применяются по порядку номеров,
+// каждая в своей транзакции, и записываются в schema_migrations вместе с контрольной суммой.
+// Применённый файл менять нельзя: изменение схемы — это новый файл со следующим номером.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// baselineVersion — схема на момент перехода на миграции. Базы, созданные до него,
+// доводятся до неё вместо того, чтобы создаваться заново.
+const baselineVersion = 1
+
+var (
+	// ErrMigrationChecksum — применённая миграция не совпадает с файлом в приложении.
+	ErrMigrationChecksum = errors.New("миграция изменена после применения")
+	// ErrUnknownMigration — в БД применена миграция, которой нет в приложении (БД обновляли более новой версией).
+	ErrUnknownMigration = errors.New("миграция неизвестна приложению")
+)
+
+type migration struct {
+	version  int
+	name     string
+	sql      string
+	checksum string
+}
+
+// MigrationStatus — состояние одной миграции.
+type MigrationStatus struct {
+	Version  int
+	Name     string
+	Checksum string
+	// AppliedAt — когда миграция применена (unix-миллисекунды); 0 — ещё не применялась.
+	AppliedAt int64
+	// Modified — файл миграции изменился после применения.
+	Modified bool
+	// Unknown — миграция есть только в БД.
+	Unknown bool
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt int64
+}
+
+func loadMigrations() ([]migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения миграций: %w", err)
+	}
+
+	migrations := make([]migration, 0, len(names))
+	seen := make(map[int]string)
+	for _, name := range names {
+		base := strings.TrimSuffix(path.Base(name), ".sql")
+		num, title, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("имя миграции %s не в формате NNNN_название.sql", name)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("миграции %s и %s с одним номером", other, name)
+		}
+		seen[version] = name
+
+		data, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения миграции %s: %w", name, err)
+		}
+		sum := sha256.Sum256(data)
+		migrations = append(migrations, migration{
+			version:  version,
+			name:     title,
+			sql:      string(data),
+			checksum: hex.EncodeToString(sum[:]),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+func migrate(db *sql.DB) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return applyMigrations(context.Background(), db, migrations)
+}
+
+// applyMigrations применяет недостающие миграции. Если хоть одна применённая не сходится
+// с приложением, не применяется ничего: со схемой неизвестного вида работать нельзя.
+func applyMigrations(ctx context.Context, db *sql.DB, migrations []migration) error {
+	_, err := db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	applied_at INTEGER NOT NULL
+)
+`)
+	if err != nil {
+		return fmt.Errorf("ошибка миграции: %w", err)
+	}
+
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, status := range migrationStatuses(migrations, applied) {
+		switch {
+		case status.Modified:
+			return fmt.Errorf("%w: %04d_%s", ErrMigrationChecksum, status.Version, status.Name)
+		case status.Unknown:
+			return fmt.Errorf("%w: %04d_%s", ErrUnknownMigration, status.Version, status.Name)
+		}
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		if err := applyMigration(ctx, db, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("ошибка миграции %04d_%s: %w", m.version, m.name, err)
+	}
+	defer tx.Rollback()
+
+	if m.version == baselineVersion {
+		if err := adoptLegacyColumns(ctx, tx, m.sql); err != nil {
+			return fmt.Errorf("ошибка миграции %04d_%s: %w", m.version, m.name, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return fmt.Errorf("ошибка миграции %04d_%s: %w", m.version, m.name, err)
+	}
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)
+`, m.version, m.name, m.checksum, time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("ошибка миграции %04d_%s: %w", m.version, m.name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка миграции %04d_%s: %w", m.version, m.name, err)
+	}
+	return nil
+}
+
+// adoptLegacyColumns досоздаёт колонки, которых нет в таблицах базы, созданной до миграций:
+// раньше новые колонки добавлялись к существующим таблицам по одной, и старая база может
+// остановиться на любой из них. Нужные колонки берутся из схемы, развёрнутой в пустой БД в памяти.
+func adoptLegacyColumns(ctx context.Context, tx *sql.Tx, schema string) error {
+	scratch, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return err
+	}
+	defer scratch.Close()
+	// У каждого соединения своя БД в памяти.
+	scratch.SetMaxOpenConns(1)
+	if _, err := scratch.ExecContext(ctx, schema); err != nil {
+		return err
+	}
+
+	rows, err := scratch.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		existing, err := tableColumns(ctx, tx, table)
+		if err != nil {
+			return err
+		}
+		// Таблицы ещё нет — её целиком создаст сама миграция.
+		if len(existing) == 0 {
+			continue
+		}
+		have := make(map[string]bool, len(existing))
+		for _, col := range existing {
+			have[col.name] = true
+		}
+		want, err := tableColumns(ctx, scratch, table)
+		if err != nil {
+			return err
+		}
+		for _, col := range want {
+			if have[col.name] {
+				continue
+			}
+			def := col.typ
+			if col.dflt.Valid {
+				if col.notNull {
+					def += " NOT NULL"
+				}
+				def += " DEFAULT " + col.dflt.String
+			} else if col.notNull {
+				return fmt.Errorf("колонку %s.%s NOT NULL без DEFAULT нельзя досоздать", table, col.name)
+			}
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, col.name, def)); err != nil {
+				return fmt.Errorf("не удалось добавить колонку %s.%s: %w", table, col.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+type column struct {
+	name    string
+	typ     string
+	notNull bool
+	dflt    sql.NullString
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// tableColumns возвращает колонки таблицы в порядке объявления; пустой результат — таблицы нет.
+func tableColumns(ctx context.Context, q queryer, table string) ([]column, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения схемы %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var columns []column
+	for rows.Next() {
+		var (
+			cid     int
+			col     column
+			notNull int
+			pk      int
+		)
+		if err := rows.Scan(&cid, &col.name, &col.typ, &notNull, &col.dflt, &pk); err != nil {
+			return nil, fmt.Errorf("ошибка чтения схемы %s: %w", table, err)
+		}
+		col.notNull = notNull != 0
+		columns = append(columns, col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения схемы %s: %w", table, err)
+	}
+	return columns, nil
+}
+
+func appliedMigrations(ctx context.Context, q queryer) (map[int]appliedMigration, error) {
+	rows, err := q.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var (
+			version int
+			a       appliedMigration
+		)
+		if err := rows.Scan(&version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, fmt.Errorf("ошибка чтения schema_migrations: %w", err)
+		}
+		applied[version] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения schema_migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// migrationStatuses сводит миграции приложения с применёнными в БД по порядку номеров.
+func migrationStatuses(migrations []migration, applied map[int]appliedMigration) []MigrationStatus {
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	known := make(map[int]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.version] = true
+		status := MigrationStatus{Version: m.version, Name: m.name, Checksum: m.checksum}
+		if a, ok := applied[m.version]; ok {
+			status.AppliedAt = a.appliedAt
+			status.Modified = a.checksum != m.checksum
+		}
+		statuses = append(statuses, status)
+	}
+	for version, a := range applied {
+		if !known[version] {
+			statuses = append(statuses, MigrationStatus{
+				Version:   version,
+				Name:      a.name,
+				Checksum:  a.checksum,
+				AppliedAt: a.appliedAt,
+				Unknown:   true,
+			})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses
+}
+
+// ReadMigrations показывает состояние миграций БД по пути dbPath, ничего в ней не меняя
+// (в отличие от Open, которая сразу применяет недостающие).
+func ReadMigrations(ctx context.Context, dbPath string) ([]MigrationStatus, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("БД недоступна: %w", err)
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия БД: %w", err)
+	}
+	defer db.Close()
+
+	var tables int
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&tables)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения БД: %w", err)
+	}
+	// Базы, созданные до миграций, таблицы schema_migrations не имеют: для них всё впереди.
+	applied := map[int]appliedMigration{}
+	if tables > 0 {
+		if applied, err = appliedMigrations(ctx, db); err != nil {
+			return nil, err
+		}
+	}
+	return migrationStatuses(migrations, applied), nil
+}
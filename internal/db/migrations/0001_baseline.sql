@@ -0,0 +1,198 @@
+-- Схема на момент перехода на версионные миграции. Базы, созданные раньше, доводятся
+-- до неё при первом запуске: недостающие таблицы создаются, недостающие колонки досоздаются.
+
+CREATE TABLE IF NOT EXISTS users (
+	telegram_id INTEGER PRIMARY KEY,
+	username TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS books (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	source_id TEXT,
+	title TEXT,
+	author TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	annotation TEXT,
+	genres TEXT,
+	year INTEGER,
+	language TEXT,
+	translator TEXT,
+	series_id TEXT,
+	series_title TEXT,
+	series_number INTEGER,
+	pages INTEGER,
+	size_label TEXT
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_books_source_id ON books(source_id);
+
+CREATE TABLE IF NOT EXISTS book_files (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	book_id INTEGER NOT NULL,
+	format TEXT,
+	path TEXT NOT NULL,
+	size_bytes INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	-- telegram_file_id — file_id первой успешной отправки: повторно файл шлётся без загрузки.
+	telegram_file_id TEXT,
+	-- sha256 и mime_type — хэш содержимого (он же имя файла в хранилище) и тип файла.
+	sha256 TEXT,
+	mime_type TEXT,
+	-- last_used_at — когда файл последний раз отдавали (unix-время), для вытеснения давно не нужных.
+	last_used_at INTEGER,
+	-- archive_path — исходный архив (fb2.zip), если в path лежит распакованная из него книга.
+	archive_path TEXT,
+	archive_size_bytes INTEGER,
+	FOREIGN KEY(book_id) REFERENCES books(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_book_files_sha256 ON book_files(sha256);
+
+CREATE TABLE IF NOT EXISTS user_library (
+	user_id INTEGER NOT NULL,
+	book_file_id INTEGER NOT NULL,
+	added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	current_location TEXT,
+	-- Позиция чтения: глава и абзац (current_location — тот же якорь строкой), процент,
+	-- устройство и время сохранения по часам сервера (unix-миллисекунды).
+	progress_chapter INTEGER,
+	progress_offset INTEGER,
+	progress_percent REAL,
+	progress_device TEXT,
+	progress_updated_at INTEGER,
+	PRIMARY KEY(user_id, book_file_id),
+	FOREIGN KEY(user_id) REFERENCES users(telegram_id),
+	FOREIGN KEY(book_file_id) REFERENCES book_files(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_library_user_id ON user_library(user_id);
+
+CREATE TABLE IF NOT EXISTS cache_entries (
+	key TEXT PRIMARY KEY,
+	value BLOB NOT NULL,
+	stored_at INTEGER NOT NULL
+);
+
+-- Очередь скачиваний: одно задание на книгу и формат, к нему присоединяются запросы пользователей.
+CREATE TABLE IF NOT EXISTS download_jobs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	source_id TEXT NOT NULL,
+	format TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_download_jobs_status ON download_jobs(status, next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS download_requests (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	job_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	username TEXT,
+	chat_id INTEGER NOT NULL,
+	message_id INTEGER,
+	title TEXT,
+	author TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(job_id) REFERENCES download_jobs(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_download_requests_job_id ON download_requests(job_id);
+
+-- Закладки, выделения и заметки привязаны к книге в библиотеке пользователя и удаляются вместе с ней.
+CREATE TABLE IF NOT EXISTS bookmarks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	book_file_id INTEGER NOT NULL,
+	chapter INTEGER NOT NULL,
+	paragraph INTEGER NOT NULL,
+	location TEXT NOT NULL,
+	title TEXT,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id, book_file_id) REFERENCES user_library(user_id, book_file_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_bookmarks_user_file ON bookmarks(user_id, book_file_id);
+
+CREATE TABLE IF NOT EXISTS highlights (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	book_file_id INTEGER NOT NULL,
+	chapter INTEGER NOT NULL,
+	start_paragraph INTEGER NOT NULL,
+	start_char INTEGER NOT NULL,
+	end_paragraph INTEGER NOT NULL,
+	end_char INTEGER NOT NULL,
+	color TEXT NOT NULL,
+	text TEXT,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id, book_file_id) REFERENCES user_library(user_id, book_file_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_highlights_user_file ON highlights(user_id, book_file_id);
+
+CREATE TABLE IF NOT EXISTS notes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	book_file_id INTEGER NOT NULL,
+	highlight_id INTEGER,
+	chapter INTEGER NOT NULL,
+	paragraph INTEGER NOT NULL,
+	location TEXT NOT NULL,
+	text TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id, book_file_id) REFERENCES user_library(user_id, book_file_id) ON DELETE CASCADE,
+	FOREIGN KEY(highlight_id) REFERENCES highlights(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_notes_user_file ON notes(user_id, book_file_id);
+CREATE INDEX IF NOT EXISTS idx_notes_highlight_id ON notes(highlight_id);
+
+-- Полки, статусы и теги привязаны к книге (books.id), а не к файлу: книгу можно отложить, не скачивая.
+CREATE TABLE IF NOT EXISTS shelves (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL COLLATE NOCASE,
+	created_at INTEGER NOT NULL,
+	UNIQUE(user_id, name),
+	FOREIGN KEY(user_id) REFERENCES users(telegram_id)
+);
+
+CREATE TABLE IF NOT EXISTS shelf_books (
+	shelf_id INTEGER NOT NULL,
+	book_id INTEGER NOT NULL,
+	added_at INTEGER NOT NULL,
+	PRIMARY KEY(shelf_id, book_id),
+	FOREIGN KEY(shelf_id) REFERENCES shelves(id) ON DELETE CASCADE,
+	FOREIGN KEY(book_id) REFERENCES books(id)
+);
+
+CREATE TABLE IF NOT EXISTS book_statuses (
+	user_id INTEGER NOT NULL,
+	book_id INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	updated_at INTEGER NOT NULL,
+	PRIMARY KEY(user_id, book_id),
+	FOREIGN KEY(user_id) REFERENCES users(telegram_id),
+	FOREIGN KEY(book_id) REFERENCES books(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_book_statuses_status ON book_statuses(user_id, status);
+
+CREATE TABLE IF NOT EXISTS book_tags (
+	user_id INTEGER NOT NULL,
+	book_id INTEGER NOT NULL,
+	tag TEXT NOT NULL,
+	PRIMARY KEY(user_id, book_id, tag),
+	FOREIGN KEY(user_id) REFERENCES users(telegram_id),
+	FOREIGN KEY(book_id) REFERENCES books(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_book_tags_tag ON book_tags(user_id, tag);
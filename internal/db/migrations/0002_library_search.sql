@@ -0,0 +1,42 @@
+-- Полнотекстовый поиск по библиотеке. book_search индексирует названия, авторов и аннотации
+-- прямо из books (content='books') и обновляется триггерами.
+CREATE VIRTUAL TABLE book_search USING fts5(
+	title,
+	author,
+	annotation,
+	content = 'books',
+	content_rowid = 'id',
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+INSERT INTO book_search(book_search) VALUES ('rebuild');
+
+CREATE TRIGGER books_search_insert AFTER INSERT ON books BEGIN
+	INSERT INTO book_search(rowid, title, author, annotation) VALUES (new.id, new.title, new.author, new.annotation);
+END;
+
+CREATE TRIGGER books_search_delete AFTER DELETE ON books BEGIN
+	INSERT INTO book_search(book_search, rowid, title, author, annotation) VALUES ('delete', old.id, old.title, old.author, old.annotation);
+END;
+
+CREATE TRIGGER books_search_update AFTER UPDATE OF title, author, annotation ON books BEGIN
+	INSERT INTO book_search(book_search, rowid, title, author, annotation) VALUES ('delete', old.id, old.title, old.author, old.annotation);
+	INSERT INTO book_search(rowid, title, author, annotation) VALUES (new.id, new.title, new.author, new.annotation);
+END;
+
+-- book_text — текст самих книг (rowid = book_files.id), заполняется в фоне, если включён SEARCH_BOOK_TEXT.
+-- Пустой текст — файл уже разбирали, но читать его не умеем.
+CREATE VIRTUAL TABLE book_text USING fts5(
+	text,
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER book_files_text_delete AFTER DELETE ON book_files BEGIN
+	DELETE FROM book_text WHERE rowid = old.id;
+END;
+
+-- Распакованный из архива или перекачанный файл нужно проиндексировать заново.
+CREATE TRIGGER book_files_text_update AFTER UPDATE OF sha256 ON book_files
+WHEN old.sha256 IS NOT new.sha256 BEGIN
+	DELETE FROM book_text WHERE rowid = old.id;
+END;
@@ -0,0 +1,31 @@
+-- Одно активное задание на книгу и формат. Раньше это проверялось только выборкой перед
+-- вставкой, и два одновременных запроса могли создать два задания. Такие дубли уже могли
+-- накопиться: их запросы переносим на самое раннее задание, а сами дубли отменяем.
+UPDATE download_requests
+SET job_id = (
+	SELECT MIN(first.id) FROM download_jobs AS first, download_jobs AS dup
+	WHERE dup.id = download_requests.job_id
+		AND first.source_id = dup.source_id AND first.format = dup.format
+		AND first.status IN ('queued', 'downloading', 'uploading')
+)
+WHERE job_id IN (
+	SELECT dup.id FROM download_jobs AS dup
+	WHERE dup.status IN ('queued', 'downloading', 'uploading')
+		AND EXISTS (
+			SELECT 1 FROM download_jobs AS first
+			WHERE first.source_id = dup.source_id AND first.format = dup.format
+				AND first.status IN ('queued', 'downloading', 'uploading') AND first.id < dup.id
+		)
+);
+
+UPDATE download_jobs
+SET status = 'canceled', updated_at = CURRENT_TIMESTAMP
+WHERE status IN ('queued', 'downloading', 'uploading')
+	AND EXISTS (
+		SELECT 1 FROM download_jobs AS first
+		WHERE first.source_id = download_jobs.source_id AND first.format = download_jobs.format
+			AND first.status IN ('queued', 'downloading', 'uploading') AND first.id < download_jobs.id
+	);
+
+CREATE UNIQUE INDEX idx_download_jobs_active ON download_jobs(source_id, format)
+	WHERE status IN ('queued', 'downloading', 'uploading');
@@ -0,0 +1,183 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// legacySchema — схема первых версий бота, когда миграций ещё не было.
+const legacySchema = `
+CREATE TABLE users (
+	telegram_id INTEGER PRIMARY KEY,
+	username TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE books (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	source_id TEXT,
+	title TEXT,
+	author TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX idx_books_source_id ON books(source_id);
+CREATE TABLE book_files (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	book_id INTEGER NOT NULL,
+	format TEXT,
+	path TEXT NOT NULL,
+	size_bytes INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(book_id) REFERENCES books(id)
+);
+CREATE TABLE user_library (
+	user_id INTEGER NOT NULL,
+	book_file_id INTEGER NOT NULL,
+	added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	current_location TEXT,
+	PRIMARY KEY(user_id, book_file_id),
+	FOREIGN KEY(user_id) REFERENCES users(telegram_id),
+	FOREIGN KEY(book_file_id) REFERENCES book_files(id)
+);
+CREATE INDEX idx_user_library_user_id ON user_library(user_id);
+
+INSERT INTO users (telegram_id, username) VALUES (42, 'reader');
+INSERT INTO books (source_id, title, author) VALUES ('123', '1984', 'Оруэлл');
+INSERT INTO book_files (book_id, format, path, size_bytes) VALUES (1, 'fb2', '123.fb2', 10);
+INSERT INTO user_library (user_id, book_file_id, current_location) VALUES (42, 1, 'c0p3');
+`
+
+func openRaw(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateFreshDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	store.Close()
+
+	// Повторное открытие ничего не применяет заново.
+	store, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	store.Close()
+
+	statuses, err := ReadMigrations(context.Background(), path)
+	if err != nil {
+		t.Fatalf("ReadMigrations: %v", err)
+	}
+	if len(statuses) == 0 {
+		t.Fatal("no migrations")
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == 0 || s.Modified || s.Unknown {
+			t.Fatalf("migration %d: %+v", s.Version, s)
+		}
+	}
+}
+
+func TestMigrateLegacyDB(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "app.db")
+	if _, err := openRaw(t, path).Exec(legacySchema); err != nil {
+		t.Fatalf("legacy schema: %v", err)
+	}
+
+	statuses, err := ReadMigrations(ctx, path)
+	if err != nil {
+		t.Fatalf("ReadMigrations: %v", err)
+	}
+	if statuses[0].Version != baselineVersion || statuses[0].AppliedAt != 0 {
+		t.Fatalf("baseline should be pending: %+v", statuses[0])
+	}
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	// Старые строки на месте, новые колонки и таблицы работают.
+	items, err := store.ListLibrary(ctx, 42)
+	if err != nil {
+		t.Fatalf("ListLibrary: %v", err)
+	}
+	if len(items) != 1 || items[0].Title != "1984" || items[0].CurrentLocation != "c0p3" {
+		t.Fatalf("library = %+v", items)
+	}
+	if _, err := store.SaveProgress(ctx, 42, 1, Progress{Chapter: 1, Offset: 2, Location: "c1p2", Device: "phone"}, 0, false); err != nil {
+		t.Fatalf("SaveProgress: %v", err)
+	}
+	if _, err := store.CreateShelf(ctx, 42, "Отпуск"); err != nil {
+		t.Fatalf("CreateShelf: %v", err)
+	}
+}
+
+func TestMigrateChecksumMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.db")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	store.Close()
+
+	if _, err := openRaw(t, path).Exec(`UPDATE schema_migrations SET checksum = 'edited' WHERE version = ?`, baselineVersion); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, err := Open(path); !errors.Is(err, ErrMigrationChecksum) {
+		t.Fatalf("Open err = %v, want ErrMigrationChecksum", err)
+	}
+
+	statuses, err := ReadMigrations(context.Background(), path)
+	if err != nil {
+		t.Fatalf("ReadMigrations: %v", err)
+	}
+	if !statuses[0].Modified {
+		t.Fatalf("baseline should be modified: %+v", statuses[0])
+	}
+}
+
+func TestMigrateFailedStepRollsBack(t *testing.T) {
+	ctx := context.Background()
+	db := openRaw(t, filepath.Join(t.TempDir(), "app.db"))
+
+	migrations := []migration{
+		{version: 1, name: "first", sql: `CREATE TABLE a (id INTEGER PRIMARY KEY);`, checksum: "1"},
+		{version: 2, name: "broken", sql: `CREATE TABLE b (id INTEGER PRIMARY KEY); INSERT INTO missing VALUES (1);`, checksum: "2"},
+	}
+	if err := applyMigrations(ctx, db, migrations); err == nil {
+		t.Fatal("broken migration applied")
+	}
+
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'b'`).Scan(&tables); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if tables != 0 {
+		t.Fatal("table from failed migration left behind")
+	}
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		t.Fatalf("appliedMigrations: %v", err)
+	}
+	if len(applied) != 1 || applied[1].name != "first" {
+		t.Fatalf("applied = %+v", applied)
+	}
+
+	// Миграция, которой нет в приложении, останавливает запуск.
+	if err := applyMigrations(ctx, db, nil); !errors.Is(err, ErrUnknownMigration) {
+		t.Fatalf("err = %v, want ErrUnknownMigration", err)
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotInLibrary — книги нет в библиотеке пользователя.
+var ErrNotInLibrary = errors.New("книги нет в библиотеке")
+
+// ErrProgressConflict — позицию успели сохранить с другого устройства после того,
+// как это устройство её прочитало.
+var ErrProgressConflict = errors.New("позиция чтения изменена на другом устройстве")
+
+// Progress — позиция чтения книги.
+type Progress struct {
+	// Chapter и Offset — глава и абзац в ней (с нуля); Location — тот же якорь строкой ("c3p12").
+	Chapter  int     `json:"chapter"`
+	Offset   int     `json:"offset"`
+	Location string  `json:"location"`
+	Percent  float64 `json:"percent"`
+	// Device — устройство, с которого сохранена позиция.
+	Device string `json:"device"`
+	// UpdatedAt — время сохранения по часам сервера, unix-миллисекунды. По нему же
+	// устройство сообщает, какую версию позиции оно видело.
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// GetProgress возвращает позицию чтения; ok=false, если её ещё не сохраняли.
+func (s *Store) GetProgress(ctx context.Context, userID int64, fileID int64) (Progress, bool, error) {
+	p, saved, err := getProgress(ctx, s.db, userID, fileID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Progress{}, false, ErrNotInLibrary
+	}
+	if err != nil {
+		return Progress{}, false, fmt.Errorf("ошибка чтения прогресса: %w", err)
+	}
+	return p, saved, nil
+}
+
+func getProgress(ctx context.Context, q *sql.DB, userID int64, fileID int64) (Progress, bool, error) {
+	var (
+		p         Progress
+		chapter   sql.NullInt64
+		offset    sql.NullInt64
+		location  sql.NullString
+		percent   sql.NullFloat64
+		device    sql.NullString
+		updatedAt sql.NullInt64
+	)
+	err := q.QueryRowContext(ctx, `
+SELECT progress_chapter, progress_offset, current_location, progress_percent, progress_device, progress_updated_at
+FROM user_library
+WHERE user_id = ? AND book_file_id = ?
+`, userID, fileID).Scan(&chapter, &offset, &location, &percent, &device, &updatedAt)
+	if err != nil {
+		return Progress{}, false, err
+	}
+	p = Progress{
+		Chapter:   int(chapter.Int64),
+		Offset:    int(offset.Int64),
+		Location:  location.String,
+		Percent:   percent.Float64,
+		Device:    device.String,
+		UpdatedAt: updatedAt.Int64,
+	}
+	return p, updatedAt.Valid, nil
+}
+
+// SaveProgress сохраняет позицию чтения. baseUpdatedAt — UpdatedAt той версии, которую
+// устройство видело последней (0 — никакой). Если с тех пор позицию сохранило другое
+// устройство, возвращается ErrProgressConflict вместе с текущей позицией — устройство
+// решает, перейти на неё или перезаписать её (force). Устройство без имени своим
+// не считается никогда. Иначе побеждает последняя запись, а UpdatedAt ставится по
+// часам сервера.
+func (s *Store) SaveProgress(ctx context.Context, userID int64, fileID int64, p Progress, baseUpdatedAt int64, force bool) (Progress, error) {
+	// Проверка и запись — одним UPDATE, чтобы между ними не вклинилось другое устройство.
+	// Метки строго растут, даже если два сохранения пришли в одну миллисекунду.
+	err := s.db.QueryRowContext(ctx, `
+UPDATE user_library
+SET progress_chapter = ?, progress_offset = ?, current_location = ?, progress_percent = ?,
+	progress_device = ?, progress_updated_at = MAX(?, COALESCE(progress_updated_at, 0) + 1)
+WHERE user_id = ? AND book_file_id = ?
+	AND (? OR progress_updated_at IS NULL OR progress_updated_at = ? OR (progress_device <> '' AND progress_device = ?))
+RETURNING progress_updated_at
+`, p.Chapter, p.Offset, p.Location, p.Percent, p.Device, time.Now().UnixMilli(),
+		userID, fileID, force, baseUpdatedAt, p.Device).Scan(&p.UpdatedAt)
+	if err == nil {
+		return p, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Progress{}, fmt.Errorf("ошибка сохранения прогресса: %w", err)
+	}
+
+	// Ничего не обновилось: либо книги нет в библиотеке, либо позиция уже другая.
+	current, _, err := getProgress(ctx, s.db, userID, fileID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Progress{}, ErrNotInLibrary
+	}
+	if err != nil {
+		return Progress{}, fmt.Errorf("ошибка сохранения прогресса: %w", err)
+	}
+	return current, ErrProgressConflict
+}
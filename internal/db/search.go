@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Найденные слова в сниппетах обрамлены этими символами: как их показать, решает тот,
+// кто выводит результат.
+const (
+	SnippetOpen  = "\x02"
+	SnippetClose = "\x03"
+)
+
+// maxSearchWords — сколько слов запроса учитывать.
+const maxSearchWords = 16
+
+// SearchHit — книга из библиотеки пользователя, найденная полнотекстовым поиском.
+type SearchHit struct {
+	FileID int64
+	BookID int64
+	// SourceID — ID книги на сайте ("" у книг, добавленных без карточки).
+	SourceID string
+	Title    string
+	Author   string
+	Format   string
+	// Snippet — фрагмент названия, автора или аннотации с найденными словами.
+	Snippet string
+	// TextSnippet — фрагмент текста книги, если нашлось в нём.
+	TextSnippet string
+}
+
+// SearchLibrary ищет в библиотеке пользователя по названиям, авторам и аннотациям, а если
+// тексты книг проиндексированы — и по ним. Совпадения в описании идут первыми (название
+// весит больше автора, автор — больше аннотации), за ними книги, где слова нашлись только в тексте.
+func (s *Store) SearchLibrary(ctx context.Context, userID int64, input string, limit int) ([]SearchHit, error) {
+	query := searchQuery(input)
+	if query == "" {
+		return nil, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+SELECT bf.id, b.id, COALESCE(b.source_id, ''), COALESCE(b.title, ''), COALESCE(b.author, ''), COALESCE(bf.format, ''),
+	snippet(book_search, -1, ?, ?, '…', 16)
+FROM book_search
+JOIN books b ON b.id = book_search.rowid
+JOIN book_files bf ON bf.book_id = b.id
+JOIN user_library ul ON ul.book_file_id = bf.id
+WHERE book_search MATCH ? AND ul.user_id = ?
+ORDER BY bm25(book_search, 10.0, 5.0, 1.0), ul.added_at DESC
+LIMIT ?
+`, SnippetOpen, SnippetClose, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска по библиотеке: %w", err)
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	byFile := make(map[int64]int)
+	for rows.Next() {
+		var hit SearchHit
+		if err := rows.Scan(&hit.FileID, &hit.BookID, &hit.SourceID, &hit.Title, &hit.Author, &hit.Format, &hit.Snippet); err != nil {
+			return nil, fmt.Errorf("ошибка поиска по библиотеке: %w", err)
+		}
+		byFile[hit.FileID] = len(hits)
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка поиска по библиотеке: %w", err)
+	}
+	rows.Close()
+
+	rows, err = s.db.QueryContext(ctx, `
+SELECT bf.id, b.id, COALESCE(b.source_id, ''), COALESCE(b.title, ''), COALESCE(b.author, ''), COALESCE(bf.format, ''),
+	snippet(book_text, 0, ?, ?, '…', 24)
+FROM book_text
+JOIN book_files bf ON bf.id = book_text.rowid
+JOIN books b ON b.id = bf.book_id
+JOIN user_library ul ON ul.book_file_id = bf.id
+WHERE book_text MATCH ? AND ul.user_id = ?
+ORDER BY bm25(book_text)
+LIMIT ?
+`, SnippetOpen, SnippetClose, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска по текстам: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hit SearchHit
+		if err := rows.Scan(&hit.FileID, &hit.BookID, &hit.SourceID, &hit.Title, &hit.Author, &hit.Format, &hit.TextSnippet); err != nil {
+			return nil, fmt.Errorf("ошибка поиска по текстам: %w", err)
+		}
+		if i, ok := byFile[hit.FileID]; ok {
+			hits[i].TextSnippet = hit.TextSnippet
+		} else if len(hits) < limit {
+			hits = append(hits, hit)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка поиска по текстам: %w", err)
+	}
+	return hits, nil
+}
+
+// searchQuery превращает ввод пользователя в запрос FTS5: каждое слово ищется как начало
+// слова, нужны все слова. Кавычки и операторы FTS5 из ввода не проходят.
+func searchQuery(input string) string {
+	words := strings.FieldsFunc(input, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > maxSearchWords {
+		words = words[:maxSearchWords]
+	}
+	for i, word := range words {
+		words[i] = `"` + word + `"*`
+	}
+	return strings.Join(words, " ")
+}
+
+// FilesWithoutText возвращает файлы с id больше afterID, текст которых ещё не попал в поиск.
+func (s *Store) FilesWithoutText(ctx context.Context, afterID int64, limit int) ([]BookFile, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, path, COALESCE(format, ''), COALESCE(size_bytes, 0), COALESCE(telegram_file_id, ''), COALESCE(sha256, ''), COALESCE(mime_type, ''),
+	COALESCE(archive_path, ''), COALESCE(archive_size_bytes, 0)
+FROM book_files
+WHERE id > ? AND id NOT IN (SELECT rowid FROM book_text)
+ORDER BY id
+LIMIT ?
+`, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения файлов: %w", err)
+	}
+	defer rows.Close()
+
+	var files []BookFile
+	for rows.Next() {
+		var file BookFile
+		if err := rows.Scan(&file.ID, &file.Path, &file.Format, &file.SizeBytes, &file.TelegramFileID, &file.SHA256, &file.MimeType, &file.ArchivePath, &file.ArchiveSizeBytes); err != nil {
+			return nil, fmt.Errorf("ошибка чтения файлов: %w", err)
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения файлов: %w", err)
+	}
+	return files, nil
+}
+
+// SetBookText сохраняет текст файла для поиска. Пустой текст отмечает файл, который
+// прочитать не удалось, чтобы не разбирать его снова.
+func (s *Store) SetBookText(ctx context.Context, fileID int64, text string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("ошибка индексации текста: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM book_text WHERE rowid = ?`, fileID); err != nil {
+		return fmt.Errorf("ошибка индексации текста: %w", err)
+	}
+	// Файл могли удалить, пока его разбирали.
+	_, err = tx.ExecContext(ctx, `INSERT INTO book_text (rowid, text) SELECT id, ? FROM book_files WHERE id = ?`, text, fileID)
+	if err != nil {
+		return fmt.Errorf("ошибка индексации текста: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка индексации текста: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tor_project/internal/models"
+)
+
+func TestSearchLibrary(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	orwell := addTestBook(t, store, 42, models.BookDetails{ID: "1", Title: "1984", Author: "Джордж Оруэлл", Annotation: "Антиутопия о Большом Брате."})
+	farm := addTestBook(t, store, 42, models.BookDetails{ID: "2", Title: "Скотный двор", Author: "Джордж Оруэлл"})
+	brave := addTestBook(t, store, 42, models.BookDetails{ID: "3", Title: "О дивный новый мир", Author: "Олдос Хаксли", Annotation: "Ещё одна антиутопия."})
+	addTestBook(t, store, 7, models.BookDetails{ID: "4", Title: "Мы", Author: "Евгений Замятин", Annotation: "Антиутопия."})
+
+	// Только своя библиотека, слово ищется по началу.
+	hits, err := store.SearchLibrary(ctx, 42, "антиутоп", 10)
+	if err != nil {
+		t.Fatalf("SearchLibrary: %v", err)
+	}
+	if len(hits) != 2 || hits[0].FileID != orwell && hits[0].FileID != brave {
+		t.Fatalf("hits = %+v", hits)
+	}
+	if !strings.Contains(strings.ToLower(hits[0].Snippet), SnippetOpen+"антиутопия"+SnippetClose) {
+		t.Fatalf("snippet = %q", hits[0].Snippet)
+	}
+
+	hits, err = store.SearchLibrary(ctx, 42, `оруэлл "скот`, 10)
+	if err != nil {
+		t.Fatalf("SearchLibrary: %v", err)
+	}
+	if len(hits) != 1 || hits[0].FileID != farm {
+		t.Fatalf("hits = %+v", hits)
+	}
+
+	// Карточка обновилась — индекс тоже.
+	if _, err := store.SaveBookDetails(ctx, models.BookDetails{ID: "2", Title: "Animal Farm"}); err != nil {
+		t.Fatalf("SaveBookDetails: %v", err)
+	}
+	if hits, _ := store.SearchLibrary(ctx, 42, "скотный", 10); len(hits) != 0 {
+		t.Fatalf("stale title found: %+v", hits)
+	}
+	if hits, _ := store.SearchLibrary(ctx, 42, "farm", 10); len(hits) != 1 {
+		t.Fatalf("new title not found: %+v", hits)
+	}
+
+	// Текст книг: сначала совпадения в описании, потом только в тексте.
+	files, err := store.FilesWithoutText(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("FilesWithoutText: %v", err)
+	}
+	if len(files) != 4 {
+		t.Fatalf("files without text = %d", len(files))
+	}
+	if err := store.SetBookText(ctx, farm, "Все животные равны, но некоторые животные равнее других."); err != nil {
+		t.Fatalf("SetBookText: %v", err)
+	}
+	if err := store.SetBookText(ctx, orwell, "Старший Брат смотрит на тебя. Некоторые животные тут ни при чём."); err != nil {
+		t.Fatalf("SetBookText: %v", err)
+	}
+	hits, err = store.SearchLibrary(ctx, 42, "брат", 10)
+	if err != nil {
+		t.Fatalf("SearchLibrary: %v", err)
+	}
+	if len(hits) != 1 || hits[0].FileID != orwell || hits[0].Snippet == "" || hits[0].TextSnippet == "" {
+		t.Fatalf("hits = %+v", hits)
+	}
+	hits, err = store.SearchLibrary(ctx, 42, "животные равны", 10)
+	if err != nil {
+		t.Fatalf("SearchLibrary: %v", err)
+	}
+	if len(hits) != 1 || hits[0].FileID != farm || hits[0].Snippet != "" || !strings.Contains(hits[0].TextSnippet, SnippetOpen+"равны"+SnippetClose) {
+		t.Fatalf("hits = %+v", hits)
+	}
+	if files, _ := store.FilesWithoutText(ctx, farm, 10); len(files) != 2 || files[0].ID != brave {
+		t.Fatalf("files without text = %d", len(files))
+	}
+
+	if hits, err := store.SearchLibrary(ctx, 42, `"*"`, 10); err != nil || len(hits) != 0 {
+		t.Fatalf("punctuation query: %v, %+v", err, hits)
+	}
+}
@@ -0,0 +1,434 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Статусы чтения книги.
+const (
+	StatusWantToRead = "want"
+	StatusReading    = "reading"
+	StatusFinished   = "finished"
+	StatusAbandoned  = "abandoned"
+)
+
+// ReadingStatuses — статусы в порядке показа.
+var ReadingStatuses = []string{StatusWantToRead, StatusReading, StatusFinished, StatusAbandoned}
+
+// ValidStatus сообщает, известен ли статус.
+func ValidStatus(status string) bool {
+	for _, s := range ReadingStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrShelfExists — полка с таким названием у пользователя уже есть.
+var ErrShelfExists = errors.New("такая полка уже есть")
+
+// Shelf — полка пользователя.
+type Shelf struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Books     int    `json:"books"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// UserBook — книга на полке, со статусом или тегом. Полки и статусы относятся к книге,
+// а не к файлу: положить на полку можно и нескачанную книгу. FileID — скачанный файл
+// из библиотеки пользователя, если он есть.
+type UserBook struct {
+	BookID   int64    `json:"book_id"`
+	SourceID string   `json:"source_id,omitempty"`
+	Title    string   `json:"title"`
+	Author   string   `json:"author"`
+	Status   string   `json:"status,omitempty"`
+	Tags     []string `json:"tags"`
+	FileID   int64    `json:"file_id,omitempty"`
+}
+
+// TagCount — тег и число книг с ним.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Books int    `json:"books"`
+}
+
+// BookIDBySource возвращает id книги по её ID на сайте; ErrNotFound — карточку ещё не сохраняли.
+func (s *Store) BookIDBySource(ctx context.Context, sourceID string) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM books WHERE source_id = ?`, sourceID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ошибка поиска книги: %w", err)
+	}
+	return id, nil
+}
+
+// CreateShelf создаёт полку.
+func (s *Store) CreateShelf(ctx context.Context, userID int64, name string) (Shelf, error) {
+	shelf := Shelf{Name: name, CreatedAt: time.Now().UnixMilli()}
+	res, err := s.db.ExecContext(ctx, `
+INSERT INTO shelves (user_id, name, created_at) VALUES (?, ?, ?)
+ON CONFLICT(user_id, name) DO NOTHING
+`, userID, name, shelf.CreatedAt)
+	if err != nil {
+		return Shelf{}, fmt.Errorf("ошибка создания полки: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return Shelf{}, ErrShelfExists
+	}
+	if shelf.ID, err = res.LastInsertId(); err != nil {
+		return Shelf{}, fmt.Errorf("ошибка создания полки: %w", err)
+	}
+	return shelf, nil
+}
+
+const shelvesQuery = `
+SELECT s.id, s.name, s.created_at, (SELECT COUNT(*) FROM shelf_books sb WHERE sb.shelf_id = s.id)
+FROM shelves s
+`
+
+// ListShelves возвращает полки пользователя по алфавиту.
+func (s *Store) ListShelves(ctx context.Context, userID int64) ([]Shelf, error) {
+	rows, err := s.db.QueryContext(ctx, shelvesQuery+`WHERE s.user_id = ? ORDER BY s.name`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения полок: %w", err)
+	}
+	defer rows.Close()
+
+	shelves := []Shelf{}
+	for rows.Next() {
+		var shelf Shelf
+		if err := rows.Scan(&shelf.ID, &shelf.Name, &shelf.CreatedAt, &shelf.Books); err != nil {
+			return nil, fmt.Errorf("ошибка чтения полок: %w", err)
+		}
+		shelves = append(shelves, shelf)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения полок: %w", err)
+	}
+	return shelves, nil
+}
+
+// GetShelf возвращает полку пользователя по id; ErrNotFound — такой нет.
+func (s *Store) GetShelf(ctx context.Context, userID int64, id int64) (Shelf, error) {
+	return s.scanShelf(s.db.QueryRowContext(ctx, shelvesQuery+`WHERE s.user_id = ? AND s.id = ?`, userID, id))
+}
+
+// FindShelf ищет полку пользователя по названию (без учёта регистра латиницы).
+func (s *Store) FindShelf(ctx context.Context, userID int64, name string) (Shelf, error) {
+	return s.scanShelf(s.db.QueryRowContext(ctx, shelvesQuery+`WHERE s.user_id = ? AND s.name = ?`, userID, name))
+}
+
+func (s *Store) scanShelf(row *sql.Row) (Shelf, error) {
+	var shelf Shelf
+	err := row.Scan(&shelf.ID, &shelf.Name, &shelf.CreatedAt, &shelf.Books)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Shelf{}, ErrNotFound
+	}
+	if err != nil {
+		return Shelf{}, fmt.Errorf("ошибка чтения полки: %w", err)
+	}
+	return shelf, nil
+}
+
+// RenameShelf переименовывает полку.
+func (s *Store) RenameShelf(ctx context.Context, userID int64, id int64, name string) error {
+	if other, err := s.FindShelf(ctx, userID, name); err == nil && other.ID != id {
+		return ErrShelfExists
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE shelves SET name = ? WHERE id = ? AND user_id = ?`, name, id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка переименования полки: %w", err)
+	}
+	return affectedOne(res)
+}
+
+// DeleteShelf удаляет полку; книги остаются в библиотеке и на других полках.
+func (s *Store) DeleteShelf(ctx context.Context, userID int64, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM shelves WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка удаления полки: %w", err)
+	}
+	return affectedOne(res)
+}
+
+// AddToShelf кладёт книгу на полку (повторно — ничего не меняет). ErrNotFound — нет такой
+// полки у пользователя или такой книги.
+func (s *Store) AddToShelf(ctx context.Context, userID int64, shelfID int64, bookID int64) error {
+	if err := s.bookExists(ctx, bookID); err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `
+INSERT INTO shelf_books (shelf_id, book_id, added_at)
+SELECT id, ?, ? FROM shelves WHERE id = ? AND user_id = ?
+ON CONFLICT(shelf_id, book_id) DO NOTHING
+`, bookID, time.Now().UnixMilli(), shelfID, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка добавления на полку: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		// Ничего не вставилось: либо книга уже на полке, либо полка чужая.
+		if _, err := s.GetShelf(ctx, userID, shelfID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveFromShelf снимает книгу с полки.
+func (s *Store) RemoveFromShelf(ctx context.Context, userID int64, shelfID int64, bookID int64) error {
+	res, err := s.db.ExecContext(ctx, `
+DELETE FROM shelf_books
+WHERE shelf_id = ? AND book_id = ? AND shelf_id IN (SELECT id FROM shelves WHERE user_id = ?)
+`, shelfID, bookID, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка удаления с полки: %w", err)
+	}
+	return affectedOne(res)
+}
+
+// BookShelves возвращает id полок пользователя, на которых лежит книга.
+func (s *Store) BookShelves(ctx context.Context, userID int64, bookID int64) (map[int64]bool, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT sb.shelf_id
+FROM shelf_books sb
+JOIN shelves s ON s.id = sb.shelf_id
+WHERE s.user_id = ? AND sb.book_id = ?
+`, userID, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения полок: %w", err)
+	}
+	defer rows.Close()
+
+	shelves := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("ошибка чтения полок: %w", err)
+		}
+		shelves[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения полок: %w", err)
+	}
+	return shelves, nil
+}
+
+// ShelfBooks возвращает книги полки, последние добавленные первыми; ErrNotFound — полка чужая.
+func (s *Store) ShelfBooks(ctx context.Context, userID int64, shelfID int64) ([]UserBook, error) {
+	if _, err := s.GetShelf(ctx, userID, shelfID); err != nil {
+		return nil, err
+	}
+	return s.listUserBooks(ctx, userID, `
+FROM shelf_books sb
+JOIN books b ON b.id = sb.book_id
+WHERE sb.shelf_id = ?
+ORDER BY sb.added_at DESC
+`, shelfID)
+}
+
+// SetStatus задаёт статус чтения книги; пустой статус его снимает.
+func (s *Store) SetStatus(ctx context.Context, userID int64, bookID int64, status string) error {
+	if status == "" {
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM book_statuses WHERE user_id = ? AND book_id = ?`, userID, bookID); err != nil {
+			return fmt.Errorf("ошибка сохранения статуса: %w", err)
+		}
+		return nil
+	}
+	if err := s.bookExists(ctx, bookID); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO book_statuses (user_id, book_id, status, updated_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(user_id, book_id) DO UPDATE SET status = excluded.status, updated_at = excluded.updated_at
+`, userID, bookID, status, time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения статуса: %w", err)
+	}
+	return nil
+}
+
+// BookStatus возвращает статус чтения книги ("" — не задан).
+func (s *Store) BookStatus(ctx context.Context, userID int64, bookID int64) (string, error) {
+	var status string
+	err := s.db.QueryRowContext(ctx, `
+SELECT status FROM book_statuses WHERE user_id = ? AND book_id = ?
+`, userID, bookID).Scan(&status)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("ошибка чтения статуса: %w", err)
+	}
+	return status, nil
+}
+
+// StatusCounts возвращает число книг пользователя в каждом статусе.
+func (s *Store) StatusCounts(ctx context.Context, userID int64) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT status, COUNT(*) FROM book_statuses WHERE user_id = ? GROUP BY status
+`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения статусов: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var (
+			status string
+			n      int
+		)
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, fmt.Errorf("ошибка чтения статусов: %w", err)
+		}
+		counts[status] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения статусов: %w", err)
+	}
+	return counts, nil
+}
+
+// BooksByStatus возвращает книги в статусе, последние изменённые первыми.
+func (s *Store) BooksByStatus(ctx context.Context, userID int64, status string) ([]UserBook, error) {
+	return s.listUserBooks(ctx, userID, `
+FROM book_statuses st
+JOIN books b ON b.id = st.book_id
+WHERE st.user_id = ? AND st.status = ?
+ORDER BY st.updated_at DESC
+`, userID, status)
+}
+
+// SetTags заменяет теги книги.
+func (s *Store) SetTags(ctx context.Context, userID int64, bookID int64, tags []string) error {
+	if len(tags) > 0 {
+		if err := s.bookExists(ctx, bookID); err != nil {
+			return err
+		}
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения тегов: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM book_tags WHERE user_id = ? AND book_id = ?`, userID, bookID); err != nil {
+		return fmt.Errorf("ошибка сохранения тегов: %w", err)
+	}
+	for _, tag := range tags {
+		_, err := tx.ExecContext(ctx, `
+INSERT INTO book_tags (user_id, book_id, tag) VALUES (?, ?, ?)
+ON CONFLICT(user_id, book_id, tag) DO NOTHING
+`, userID, bookID, tag)
+		if err != nil {
+			return fmt.Errorf("ошибка сохранения тегов: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка сохранения тегов: %w", err)
+	}
+	return nil
+}
+
+// ListTags возвращает теги пользователя с числом книг, по алфавиту.
+func (s *Store) ListTags(ctx context.Context, userID int64) ([]TagCount, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT tag, COUNT(*) FROM book_tags WHERE user_id = ? GROUP BY tag ORDER BY tag
+`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения тегов: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []TagCount{}
+	for rows.Next() {
+		var t TagCount
+		if err := rows.Scan(&t.Tag, &t.Books); err != nil {
+			return nil, fmt.Errorf("ошибка чтения тегов: %w", err)
+		}
+		tags = append(tags, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения тегов: %w", err)
+	}
+	return tags, nil
+}
+
+// BooksByTag возвращает книги с тегом по алфавиту.
+func (s *Store) BooksByTag(ctx context.Context, userID int64, tag string) ([]UserBook, error) {
+	return s.listUserBooks(ctx, userID, `
+FROM book_tags t
+JOIN books b ON b.id = t.book_id
+WHERE t.user_id = ? AND t.tag = ?
+ORDER BY b.title
+`, userID, tag)
+}
+
+func (s *Store) bookExists(ctx context.Context, bookID int64) error {
+	var id int64
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM books WHERE id = ?`, bookID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка поиска книги: %w", err)
+	}
+	return nil
+}
+
+// tagSeparator разделяет теги в group_concat (в самих тегах управляющих символов нет).
+const tagSeparator = "\x1f"
+
+// listUserBooks выбирает книги с их статусом, тегами и скачанным файлом пользователя;
+// from — FROM/WHERE/ORDER BY с таблицей books под псевдонимом b.
+func (s *Store) listUserBooks(ctx context.Context, userID int64, from string, args ...any) ([]UserBook, error) {
+	query := `
+SELECT b.id, COALESCE(b.source_id, ''), COALESCE(b.title, ''), COALESCE(b.author, ''),
+	COALESCE((SELECT status FROM book_statuses st2 WHERE st2.user_id = ? AND st2.book_id = b.id), ''),
+	COALESCE((SELECT group_concat(tag, char(31)) FROM book_tags t2 WHERE t2.user_id = ? AND t2.book_id = b.id), ''),
+	COALESCE((SELECT MAX(ul.book_file_id) FROM user_library ul
+		JOIN book_files bf ON bf.id = ul.book_file_id
+		WHERE ul.user_id = ? AND bf.book_id = b.id), 0)
+` + from
+	rows, err := s.db.QueryContext(ctx, query, append([]any{userID, userID, userID}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения книг: %w", err)
+	}
+	defer rows.Close()
+
+	books := []UserBook{}
+	for rows.Next() {
+		var (
+			book UserBook
+			tags string
+		)
+		if err := rows.Scan(&book.BookID, &book.SourceID, &book.Title, &book.Author, &book.Status, &tags, &book.FileID); err != nil {
+			return nil, fmt.Errorf("ошибка чтения книг: %w", err)
+		}
+		book.Tags = splitTags(tags)
+		books = append(books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения книг: %w", err)
+	}
+	return books, nil
+}
+
+func splitTags(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	tags := strings.Split(s, tagSeparator)
+	sort.Strings(tags)
+	return tags
+}
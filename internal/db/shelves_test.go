@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"tor_project/internal/models"
+)
+
+func TestDeleteShelfCascadesOnEveryConnection(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	addTestBook(t, store, 42, models.BookDetails{ID: "1", Title: "1984", Author: "Джордж Оруэлл"})
+	bookID, err := store.BookIDBySource(ctx, "1")
+	if err != nil {
+		t.Fatalf("BookIDBySource: %v", err)
+	}
+	holdConnection(t, store)
+
+	vacation, err := store.CreateShelf(ctx, 42, "Отпуск")
+	if err != nil {
+		t.Fatalf("CreateShelf: %v", err)
+	}
+	favorites, err := store.CreateShelf(ctx, 42, "Любимое")
+	if err != nil {
+		t.Fatalf("CreateShelf: %v", err)
+	}
+	for _, shelf := range []Shelf{vacation, favorites} {
+		if err := store.AddToShelf(ctx, 42, shelf.ID, bookID); err != nil {
+			t.Fatalf("AddToShelf: %v", err)
+		}
+	}
+
+	if err := store.DeleteShelf(ctx, 42, vacation.ID); err != nil {
+		t.Fatalf("DeleteShelf: %v", err)
+	}
+	var rows int
+	if err := store.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM shelf_books WHERE shelf_id = ?`, vacation.ID).Scan(&rows); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if rows != 0 {
+		t.Fatalf("shelf_books rows left for deleted shelf: %d", rows)
+	}
+	shelves, err := store.BookShelves(ctx, 42, bookID)
+	if err != nil {
+		t.Fatalf("BookShelves: %v", err)
+	}
+	if len(shelves) != 1 || !shelves[favorites.ID] {
+		t.Fatalf("book shelves = %v", shelves)
+	}
+}
@@ -6,6 +6,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
+
+	"tor_project/internal/models"
 
 	_ "modernc.org/sqlite"
 )
@@ -22,15 +26,31 @@ type LibraryItem struct {
 	Format          string `json:"format"`
 	AddedAt         string `json:"added_at"`
 	CurrentLocation string `json:"current_location,omitempty"`
+	// Progress — позиция чтения; nil, пока книгу не открывали в читалке.
+	Progress *Progress `json:"progress,omitempty"`
+	// Status и Tags — статус чтения и теги книги (общие для всех её файлов).
+	Status string   `json:"status,omitempty"`
+	Tags   []string `json:"tags"`
 }
 
 type BookFile struct {
-	ID        int64
-	Path      string
-	Format    string
-	SizeBytes int64
+	ID             int64
+	Path           string
+	Format         string
+	SizeBytes      int64
+	TelegramFileID string
+	SHA256         string
+	MimeType       string
+	// ArchivePath — архив, из которого распакован файл (для отправки в Telegram), если книга пришла в zip.
+	ArchivePath      string
+	ArchiveSizeBytes int64
 }
 
+// connectionPragmas выполняются драйвером на каждом новом соединении пула. foreign_keys и
+// busy_timeout действуют только в своём соединении: выполненные один раз через db.Exec, они
+// достались бы случайному соединению, и ON DELETE CASCADE срабатывал бы через раз.
+const connectionPragmas = "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+
 func Open(path string) (*Store, error) {
 	if path == "" {
 		return nil, fmt.Errorf("путь к SQLite пустой")
@@ -41,14 +61,15 @@ func Open(path string) (*Store, error) {
 		return nil, fmt.Errorf("не удалось создать директорию БД: %w", err)
 	}
 
-	db, err := sql.Open("sqlite", path)
+	db, err := sql.Open("sqlite", path+connectionPragmas)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка открытия БД: %w", err)
 	}
 
-	if err := applyPragmas(db); err != nil {
+	// sql.Open соединений не открывает: проверяем, что БД открывается и PRAGMA применились.
+	if err := db.Ping(); err != nil {
 		db.Close()
-		return nil, err
+		return nil, fmt.Errorf("ошибка открытия БД: %w", err)
 	}
 
 	if err := migrate(db); err != nil {
@@ -66,69 +87,6 @@ func (s *Store) Close() error {
 	return s.db.Close()
 }
 
-func applyPragmas(db *sql.DB) error {
-	pragma := []string{
-		"PRAGMA journal_mode = WAL;",
-		"PRAGMA foreign_keys = ON;",
-		"PRAGMA busy_timeout = 5000;",
-	}
-
-	for _, stmt := range pragma {
-		if _, err := db.Exec(stmt); err != nil {
-			return fmt.Errorf("ошибка PRAGMA: %w", err)
-		}
-	}
-	return nil
-}
-
-func migrate(db *sql.DB) error {
-	schema := `
-CREATE TABLE IF NOT EXISTS users (
-	telegram_id INTEGER PRIMARY KEY,
-	username TEXT,
-	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE TABLE IF NOT EXISTS books (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	source_id TEXT,
-	title TEXT,
-	author TEXT,
-	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE UNIQUE INDEX IF NOT EXISTS idx_books_source_id ON books(source_id);
-
-CREATE TABLE IF NOT EXISTS book_files (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	book_id INTEGER NOT NULL,
-	format TEXT,
-	path TEXT NOT NULL,
-	size_bytes INTEGER,
-	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-	FOREIGN KEY(book_id) REFERENCES books(id)
-);
-
-CREATE TABLE IF NOT EXISTS user_library (
-	user_id INTEGER NOT NULL,
-	book_file_id INTEGER NOT NULL,
-	added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-	current_location TEXT,
-	PRIMARY KEY(user_id, book_file_id),
-	FOREIGN KEY(user_id) REFERENCES users(telegram_id),
-	FOREIGN KEY(book_file_id) REFERENCES book_files(id)
-);
-
-CREATE INDEX IF NOT EXISTS idx_user_library_user_id ON user_library(user_id);
-`
-
-	_, err := db.Exec(schema)
-	if err != nil {
-		return fmt.Errorf("ошибка миграции: %w", err)
-	}
-	return nil
-}
-
 func (s *Store) EnsureUser(ctx context.Context, telegramID int64, username string) error {
 	_, err := s.db.ExecContext(ctx, `
 INSERT INTO users (telegram_id, username)
@@ -168,17 +126,231 @@ ON CONFLICT(source_id) DO UPDATE SET title = excluded.title, author = excluded.a
 	return id, nil
 }
 
-func (s *Store) InsertBookFile(ctx context.Context, bookID int64, format string, path string, size int64) (int64, error) {
+// EnsureBook возвращает ID книги по source_id и создаёт её, если книги ещё нет. У уже
+// сохранённой книги заполняются только пустые название и автор: строки из поисковой
+// выдачи не должны затирать карточку с сайта или описание из FB2.
+func (s *Store) EnsureBook(ctx context.Context, sourceID string, title string, author string) (int64, error) {
+	if sourceID == "" {
+		return s.UpsertBook(ctx, sourceID, title, author)
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO books (source_id, title, author)
+VALUES (?, ?, ?)
+ON CONFLICT(source_id) DO UPDATE SET
+	title = COALESCE(NULLIF(books.title, ''), excluded.title),
+	author = COALESCE(NULLIF(books.author, ''), excluded.author)
+`, sourceID, title, author)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка upsert книги: %w", err)
+	}
+
+	var id int64
+	if err := s.db.QueryRowContext(ctx, `SELECT id FROM books WHERE source_id = ?`, sourceID).Scan(&id); err != nil {
+		return 0, fmt.Errorf("ошибка поиска книги: %w", err)
+	}
+	return id, nil
+}
+
+// SaveBookDetails сохраняет карточку книги (аннотацию, жанры, серию и т.д.).
+// Пустые поля не затирают уже сохранённые значения: карточка из OPDS или с неполной
+// страницы не стирает то, что было известно раньше.
+func (s *Store) SaveBookDetails(ctx context.Context, details models.BookDetails) (int64, error) {
+	if details.ID == "" {
+		return 0, fmt.Errorf("пустой source_id")
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO books (source_id, title, author, annotation, genres, year, language, translator,
+	series_id, series_title, series_number, pages, size_label)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(source_id) DO UPDATE SET
+	title = COALESCE(NULLIF(excluded.title, ''), books.title),
+	author = COALESCE(NULLIF(excluded.author, ''), books.author),
+	annotation = COALESCE(NULLIF(excluded.annotation, ''), books.annotation),
+	genres = COALESCE(NULLIF(excluded.genres, ''), books.genres),
+	year = COALESCE(NULLIF(excluded.year, 0), books.year),
+	language = COALESCE(NULLIF(excluded.language, ''), books.language),
+	translator = COALESCE(NULLIF(excluded.translator, ''), books.translator),
+	series_id = COALESCE(NULLIF(excluded.series_id, ''), books.series_id),
+	series_title = COALESCE(NULLIF(excluded.series_title, ''), books.series_title),
+	series_number = COALESCE(NULLIF(excluded.series_number, 0), books.series_number),
+	pages = COALESCE(NULLIF(excluded.pages, 0), books.pages),
+	size_label = COALESCE(NULLIF(excluded.size_label, ''), books.size_label)
+`, details.ID, details.Title, details.Author, details.Annotation, strings.Join(details.Genres, ", "),
+		details.Year, details.Language, details.Translator,
+		details.SeriesID, details.Series, details.SeriesNumber, details.Pages, details.Size)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка сохранения карточки книги: %w", err)
+	}
+
+	var id int64
+	if err := s.db.QueryRowContext(ctx, `SELECT id FROM books WHERE source_id = ?`, details.ID).Scan(&id); err != nil {
+		return 0, fmt.Errorf("ошибка поиска книги: %w", err)
+	}
+	return id, nil
+}
+
+// SaveFileMetadata уточняет карточку книги по метаданным из самого файла: название, автор,
+// язык, переводчик, год и серия из файла точнее строк поисковой выдачи и заменяют их.
+// Аннотация и жанры из файла только заполняют пустые поля — карточка с сайта обычно полнее.
+func (s *Store) SaveFileMetadata(ctx context.Context, bookID int64, details models.BookDetails) error {
+	_, err := s.db.ExecContext(ctx, `
+UPDATE books SET
+	title = COALESCE(NULLIF(?, ''), title),
+	author = COALESCE(NULLIF(?, ''), author),
+	language = COALESCE(NULLIF(?, ''), language),
+	translator = COALESCE(NULLIF(?, ''), translator),
+	year = COALESCE(NULLIF(?, 0), year),
+	series_title = COALESCE(NULLIF(?, ''), series_title),
+	series_number = CASE WHEN ? <> '' THEN ? ELSE series_number END,
+	annotation = COALESCE(NULLIF(annotation, ''), NULLIF(?, '')),
+	genres = COALESCE(NULLIF(genres, ''), NULLIF(?, ''))
+WHERE id = ?
+`, details.Title, details.Author, details.Language, details.Translator, details.Year,
+		details.Series, details.Series, details.SeriesNumber,
+		details.Annotation, strings.Join(details.Genres, ", "), bookID)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения метаданных файла: %w", err)
+	}
+	return nil
+}
+
+// GetBookAnnotation возвращает сохранённую аннотацию книги по source_id.
+func (s *Store) GetBookAnnotation(ctx context.Context, sourceID string) (string, error) {
+	var annotation sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT annotation FROM books WHERE source_id = ?`, sourceID).Scan(&annotation)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("книга не найдена")
+		}
+		return "", fmt.Errorf("ошибка поиска книги: %w", err)
+	}
+	return annotation.String, nil
+}
+
+// InsertBookFile записывает файл книги. Если у книги уже есть файл того же формата
+// с тем же SHA-256, возвращается его ID (путь обновляется — файл мог быть перекачан).
+func (s *Store) InsertBookFile(ctx context.Context, bookID int64, file BookFile) (int64, error) {
+	if file.SHA256 != "" {
+		var id int64
+		err := s.db.QueryRowContext(ctx, `
+SELECT id FROM book_files WHERE book_id = ? AND format = ? AND sha256 = ? ORDER BY id LIMIT 1
+`, bookID, file.Format, file.SHA256).Scan(&id)
+		switch {
+		case err == nil:
+			if err := s.UpdateBookFileContent(ctx, id, file); err != nil {
+				return 0, err
+			}
+			return id, nil
+		case err != sql.ErrNoRows:
+			return 0, fmt.Errorf("ошибка поиска файла: %w", err)
+		}
+	}
+
 	res, err := s.db.ExecContext(ctx, `
-INSERT INTO book_files (book_id, format, path, size_bytes)
-VALUES (?, ?, ?, ?)
-`, bookID, format, path, size)
+INSERT INTO book_files (book_id, format, path, size_bytes, sha256, mime_type, archive_path, archive_size_bytes)
+VALUES (?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, 0))
+`, bookID, file.Format, file.Path, file.SizeBytes, file.SHA256, file.MimeType, file.ArchivePath, file.ArchiveSizeBytes)
 	if err != nil {
 		return 0, fmt.Errorf("ошибка вставки файла: %w", err)
 	}
 	return res.LastInsertId()
 }
 
+// ListBookFiles возвращает все файлы книг (для проверки хранилища).
+func (s *Store) ListBookFiles(ctx context.Context) ([]BookFile, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, path, COALESCE(format, ''), COALESCE(size_bytes, 0), COALESCE(telegram_file_id, ''), COALESCE(sha256, ''), COALESCE(mime_type, ''),
+	COALESCE(archive_path, ''), COALESCE(archive_size_bytes, 0)
+FROM book_files
+ORDER BY id
+`)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения файлов: %w", err)
+	}
+	defer rows.Close()
+
+	var files []BookFile
+	for rows.Next() {
+		var file BookFile
+		if err := rows.Scan(&file.ID, &file.Path, &file.Format, &file.SizeBytes, &file.TelegramFileID, &file.SHA256, &file.MimeType, &file.ArchivePath, &file.ArchiveSizeBytes); err != nil {
+			return nil, fmt.Errorf("ошибка чтения файлов: %w", err)
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения файлов: %w", err)
+	}
+	return files, nil
+}
+
+// FindBookFiles возвращает уже скачанные файлы книги в нужном формате, новые первыми.
+func (s *Store) FindBookFiles(ctx context.Context, sourceID string, format string) ([]BookFile, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT bf.id, bf.path, bf.format, COALESCE(bf.size_bytes, 0), COALESCE(bf.telegram_file_id, ''), COALESCE(bf.sha256, ''), COALESCE(bf.mime_type, ''),
+	COALESCE(bf.archive_path, ''), COALESCE(bf.archive_size_bytes, 0)
+FROM book_files bf
+JOIN books b ON b.id = bf.book_id
+WHERE b.source_id = ? AND bf.format = ?
+ORDER BY bf.id DESC
+`, sourceID, format)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска файла: %w", err)
+	}
+	defer rows.Close()
+
+	var files []BookFile
+	for rows.Next() {
+		var file BookFile
+		if err := rows.Scan(&file.ID, &file.Path, &file.Format, &file.SizeBytes, &file.TelegramFileID, &file.SHA256, &file.MimeType, &file.ArchivePath, &file.ArchiveSizeBytes); err != nil {
+			return nil, fmt.Errorf("ошибка поиска файла: %w", err)
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка поиска файла: %w", err)
+	}
+	return files, nil
+}
+
+// GetTelegramFileID возвращает сохранённый file_id Telegram для файла ("" — файл ещё не отправлялся).
+func (s *Store) GetTelegramFileID(ctx context.Context, fileID int64) (string, error) {
+	var telegramFileID sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT telegram_file_id FROM book_files WHERE id = ?`, fileID).Scan(&telegramFileID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("файл не найден")
+		}
+		return "", fmt.Errorf("ошибка поиска файла: %w", err)
+	}
+	return telegramFileID.String, nil
+}
+
+// SetTelegramFileID сохраняет file_id Telegram для файла; пустая строка сбрасывает его.
+func (s *Store) SetTelegramFileID(ctx context.Context, fileID int64, telegramFileID string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE book_files SET telegram_file_id = NULLIF(?, '') WHERE id = ?`, telegramFileID, fileID)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления файла: %w", err)
+	}
+	return nil
+}
+
+// InLibrary сообщает, есть ли файл в библиотеке пользователя.
+func (s *Store) InLibrary(ctx context.Context, userID int64, fileID int64) (bool, error) {
+	var found int
+	err := s.db.QueryRowContext(ctx, `
+SELECT 1 FROM user_library WHERE user_id = ? AND book_file_id = ?
+`, userID, fileID).Scan(&found)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("ошибка чтения библиотеки: %w", err)
+	}
+	return true, nil
+}
+
 func (s *Store) AddToLibrary(ctx context.Context, userID int64, fileID int64) error {
 	_, err := s.db.ExecContext(ctx, `
 INSERT OR IGNORE INTO user_library (user_id, book_file_id)
@@ -192,7 +364,10 @@ VALUES (?, ?)
 
 func (s *Store) ListLibrary(ctx context.Context, userID int64) ([]LibraryItem, error) {
 	rows, err := s.db.QueryContext(ctx, `
-SELECT bf.id, b.id, b.title, b.author, bf.format, ul.added_at, ul.current_location
+SELECT bf.id, b.id, b.title, b.author, bf.format, ul.added_at, ul.current_location,
+	ul.progress_chapter, ul.progress_offset, ul.progress_percent, ul.progress_device, ul.progress_updated_at,
+	COALESCE((SELECT status FROM book_statuses st WHERE st.user_id = ul.user_id AND st.book_id = b.id), ''),
+	COALESCE((SELECT group_concat(tag, char(31)) FROM book_tags t WHERE t.user_id = ul.user_id AND t.book_id = b.id), '')
 FROM user_library ul
 JOIN book_files bf ON bf.id = ul.book_file_id
 JOIN books b ON b.id = bf.book_id
@@ -206,14 +381,34 @@ ORDER BY ul.added_at DESC
 
 	var items []LibraryItem
 	for rows.Next() {
-		var item LibraryItem
-		var current sql.NullString
-		if err := rows.Scan(&item.FileID, &item.BookID, &item.Title, &item.Author, &item.Format, &item.AddedAt, &current); err != nil {
+		var (
+			item      LibraryItem
+			current   sql.NullString
+			chapter   sql.NullInt64
+			offset    sql.NullInt64
+			percent   sql.NullFloat64
+			device    sql.NullString
+			updatedAt sql.NullInt64
+			tags      string
+		)
+		if err := rows.Scan(&item.FileID, &item.BookID, &item.Title, &item.Author, &item.Format, &item.AddedAt, &current,
+			&chapter, &offset, &percent, &device, &updatedAt, &item.Status, &tags); err != nil {
 			return nil, fmt.Errorf("ошибка скана библиотеки: %w", err)
 		}
+		item.Tags = splitTags(tags)
 		if current.Valid {
 			item.CurrentLocation = current.String
 		}
+		if updatedAt.Valid {
+			item.Progress = &Progress{
+				Chapter:   int(chapter.Int64),
+				Offset:    int(offset.Int64),
+				Location:  current.String,
+				Percent:   percent.Float64,
+				Device:    device.String,
+				UpdatedAt: updatedAt.Int64,
+			}
+		}
 		items = append(items, item)
 	}
 
@@ -223,14 +418,43 @@ ORDER BY ul.added_at DESC
 	return items, nil
 }
 
+// LibrarySourceIDs возвращает source_id всех книг, которые есть в библиотеке пользователя.
+func (s *Store) LibrarySourceIDs(ctx context.Context, userID int64) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT DISTINCT b.source_id
+FROM user_library ul
+JOIN book_files bf ON bf.id = ul.book_file_id
+JOIN books b ON b.id = bf.book_id
+WHERE ul.user_id = ? AND b.source_id IS NOT NULL
+`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения библиотеки: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("ошибка скана библиотеки: %w", err)
+		}
+		ids[id] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка rows: %w", err)
+	}
+	return ids, nil
+}
+
 func (s *Store) GetFileForUser(ctx context.Context, userID int64, fileID int64) (BookFile, error) {
 	var file BookFile
 	err := s.db.QueryRowContext(ctx, `
-SELECT bf.id, bf.path, bf.format, bf.size_bytes
+SELECT bf.id, bf.path, bf.format, bf.size_bytes, COALESCE(bf.mime_type, '')
 FROM book_files bf
 JOIN user_library ul ON ul.book_file_id = bf.id
 WHERE ul.user_id = ? AND bf.id = ?
-`, userID, fileID).Scan(&file.ID, &file.Path, &file.Format, &file.SizeBytes)
+`, userID, fileID).Scan(&file.ID, &file.Path, &file.Format, &file.SizeBytes, &file.MimeType)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return BookFile{}, fmt.Errorf("файл не найден")
@@ -240,14 +464,40 @@ WHERE ul.user_id = ? AND bf.id = ?
 	return file, nil
 }
 
-func (s *Store) UpdateProgress(ctx context.Context, userID int64, fileID int64, location string) error {
+// GetCacheEntry возвращает значение из постоянного кэша и время его записи; ok=false — записи нет.
+func (s *Store) GetCacheEntry(ctx context.Context, key string) ([]byte, time.Time, bool, error) {
+	var (
+		value    []byte
+		storedAt int64
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT value, stored_at FROM cache_entries WHERE key = ?`, key).Scan(&value, &storedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, time.Time{}, false, nil
+		}
+		return nil, time.Time{}, false, fmt.Errorf("ошибка чтения кэша: %w", err)
+	}
+	return value, time.Unix(storedAt, 0), true, nil
+}
+
+// PutCacheEntry сохраняет (или перезаписывает) значение в постоянном кэше.
+func (s *Store) PutCacheEntry(ctx context.Context, key string, value []byte, storedAt time.Time) error {
 	_, err := s.db.ExecContext(ctx, `
-UPDATE user_library
-SET current_location = ?
-WHERE user_id = ? AND book_file_id = ?
-`, location, userID, fileID)
+INSERT INTO cache_entries (key, value, stored_at)
+VALUES (?, ?, ?)
+ON CONFLICT(key) DO UPDATE SET value = excluded.value, stored_at = excluded.stored_at
+`, key, value, storedAt.Unix())
 	if err != nil {
-		return fmt.Errorf("ошибка сохранения прогресса: %w", err)
+		return fmt.Errorf("ошибка записи кэша: %w", err)
 	}
 	return nil
 }
+
+// PruneCache удаляет записи кэша, сохранённые раньше before, и возвращает их число.
+func (s *Store) PruneCache(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM cache_entries WHERE stored_at < ?`, before.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("ошибка очистки кэша: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"tor_project/internal/models"
+)
+
+func TestSaveBookDetailsKeepsKnownFields(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	full := models.BookDetails{
+		ID: "1", Title: "1984", Author: "Джордж Оруэлл", Annotation: "Антиутопия.",
+		Genres: []string{"Антиутопия"}, Year: 1949, Language: "ru", Translator: "В. Голышев",
+		SeriesID: "5", Series: "Оруэлл", SeriesNumber: 1, Pages: 320, Size: "1 MB",
+	}
+	id, err := store.SaveBookDetails(ctx, full)
+	if err != nil {
+		t.Fatalf("SaveBookDetails: %v", err)
+	}
+
+	// Карточка без аннотации и прочих полей (скажем, из ленты OPDS) ничего не стирает.
+	if _, err := store.SaveBookDetails(ctx, models.BookDetails{ID: "1", Title: "1984 (другое издание)"}); err != nil {
+		t.Fatalf("SaveBookDetails: %v", err)
+	}
+
+	var (
+		title, author, annotation, genres, language, translator, seriesID, series, size string
+		year, seriesNumber, pages                                                       int
+	)
+	err = store.db.QueryRowContext(ctx, `
+SELECT title, author, annotation, genres, year, language, translator,
+	series_id, series_title, series_number, pages, size_label
+FROM books WHERE id = ?`, id).Scan(&title, &author, &annotation, &genres, &year, &language, &translator,
+		&seriesID, &series, &seriesNumber, &pages, &size)
+	if err != nil {
+		t.Fatalf("select: %v", err)
+	}
+
+	got := []any{title, author, annotation, genres, year, language, translator, seriesID, series, seriesNumber, pages, size}
+	want := []any{"1984 (другое издание)", "Джордж Оруэлл", "Антиутопия.", "Антиутопия", 1949, "ru", "В. Голышев", "5", "Оруэлл", 1, 320, "1 MB"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("column %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestEnsureBookFillsOnlyEmptyFields(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	id, err := store.SaveBookDetails(ctx, models.BookDetails{ID: "1", Title: "1984"})
+	if err != nil {
+		t.Fatalf("SaveBookDetails: %v", err)
+	}
+	// Строки из поисковой выдачи не затирают название, но заполняют пустого автора.
+	got, err := store.EnsureBook(ctx, "1", "1984 (fb2)", "Джордж Оруэлл")
+	if err != nil || got != id {
+		t.Fatalf("EnsureBook: id %d, err %v; want id %d", got, err, id)
+	}
+
+	var title, author string
+	if err := store.db.QueryRowContext(ctx, `SELECT title, author FROM books WHERE id = ?`, id).Scan(&title, &author); err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if title != "1984" || author != "Джордж Оруэлл" {
+		t.Fatalf("title %q, author %q", title, author)
+	}
+
+	if other, err := store.EnsureBook(ctx, "2", "Скотный двор", ""); err != nil || other == id {
+		t.Fatalf("EnsureBook new book: id %d, err %v", other, err)
+	}
+}
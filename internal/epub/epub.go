@@ -0,0 +1,396 @@
+// Package epub собирает EPUB 3 из разобранной FB2-книги: обложка, оглавление (nav и NCX
+// для старых читалок), сноски и метаданные серии.
+package epub
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"tor_project/internal/fb2"
+)
+
+// Options — то, чего нет в самой книге.
+type Options struct {
+	// Identifier — dc:identifier; пустой заменяется urn:uuid, посчитанным из названия и авторов.
+	Identifier string
+	// Modified — dcterms:modified; по умолчанию текущее время.
+	Modified time.Time
+}
+
+// Convert пишет книгу в w в формате EPUB 3.
+func Convert(w io.Writer, book *fb2.Book, opts Options) error {
+	c := newConverter(book)
+
+	if opts.Identifier == "" {
+		opts.Identifier = bookUUID(book)
+	}
+	if opts.Modified.IsZero() {
+		opts.Modified = time.Now()
+	}
+
+	zw := zip.NewWriter(w)
+	// mimetype — первым и без сжатия, иначе читалки не узнают EPUB.
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return fmt.Errorf("ошибка записи epub: %w", err)
+	}
+	if _, err := io.WriteString(mw, "application/epub+zip"); err != nil {
+		return fmt.Errorf("ошибка записи epub: %w", err)
+	}
+
+	files := []zipFile{
+		{"META-INF/container.xml", []byte(containerXML)},
+		{"OEBPS/content.opf", []byte(c.opf(opts))},
+		{"OEBPS/nav.xhtml", []byte(c.nav())},
+		{"OEBPS/toc.ncx", []byte(c.ncx(opts.Identifier))},
+		{"OEBPS/style.css", []byte(styleCSS)},
+	}
+	for _, p := range c.pages {
+		files = append(files, zipFile{"OEBPS/" + p.file, []byte(p.content)})
+	}
+	for _, img := range c.images {
+		files = append(files, zipFile{"OEBPS/" + img.file, img.binary.Data})
+	}
+
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return fmt.Errorf("ошибка записи epub: %w", err)
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return fmt.Errorf("ошибка записи epub: %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("ошибка записи epub: %w", err)
+	}
+	return nil
+}
+
+const containerXML = `<?xml version="1.0" encoding="utf-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const styleCSS = `body { margin: 0 1em; line-height: 1.4; }
+h1, h2, h3, h4, h5, h6 { text-align: center; page-break-after: avoid; }
+p { margin: 0; text-indent: 1.5em; text-align: justify; }
+p.empty-line { text-indent: 0; }
+p.subtitle { text-indent: 0; text-align: center; font-weight: bold; margin: 1em 0; }
+p.text-author, p.date { text-align: right; font-style: italic; }
+blockquote { margin: 1em 2em; }
+.epigraph { margin-left: 40%; font-style: italic; }
+.poem { margin: 1em 2em; }
+.stanza { margin-bottom: 1em; }
+p.v { text-indent: 0; text-align: left; }
+img.image { display: block; max-width: 100%; margin: 1em auto; }
+.cover { text-align: center; }
+.cover img { max-width: 100%; max-height: 100%; }
+.title-page { text-align: center; margin-top: 3em; }
+.title-page p { text-indent: 0; text-align: center; }
+aside { margin-bottom: 1em; }
+`
+
+type zipFile struct {
+	name string
+	data []byte
+}
+
+// page — XHTML-файл книги.
+type page struct {
+	file    string
+	title   string
+	content string
+}
+
+// image — картинка из binary, сохранённая отдельным файлом.
+type image struct {
+	id     string
+	file   string
+	binary *fb2.Binary
+}
+
+// tocEntry — пункт оглавления.
+type tocEntry struct {
+	title    string
+	href     string
+	children []tocEntry
+}
+
+type converter struct {
+	book *fb2.Book
+	lang string
+
+	pages  []page
+	images []image
+	toc    []tocEntry
+
+	// anchors — в каком файле лежит элемент с данным id (для ссылок "#id" и сносок).
+	anchors map[string]string
+	// imageFiles — файл картинки по id binary.
+	imageFiles map[string]string
+	// sectionIDs — якоря разделов; у разделов без id они генерируются.
+	sectionIDs map[*fb2.Section]string
+	generated  int
+	cover      string
+}
+
+func newConverter(book *fb2.Book) *converter {
+	c := &converter{
+		book:       book,
+		lang:       book.Lang,
+		anchors:    make(map[string]string),
+		imageFiles: make(map[string]string),
+		sectionIDs: make(map[*fb2.Section]string),
+	}
+	if c.lang == "" {
+		c.lang = "und"
+	}
+	c.collectImages()
+
+	// Сначала раскладываем якоря по файлам, чтобы ссылки вперёд (на сноски и главы) были верными.
+	chapters := make([]string, len(book.Body.Sections))
+	for i := range book.Body.Sections {
+		chapters[i] = fmt.Sprintf("chapter-%03d.xhtml", i+1)
+		c.assignAnchors(&book.Body.Sections[i], chapters[i])
+	}
+	c.collectNodeAnchors(book.Body.Content, "title.xhtml")
+	for i := range book.Notes {
+		c.assignAnchors(&book.Notes[i], "notes.xhtml")
+	}
+
+	if c.cover != "" {
+		c.pages = append(c.pages, page{file: "cover.xhtml", title: "Обложка", content: c.coverPage()})
+	}
+	c.pages = append(c.pages, page{file: "title.xhtml", title: c.title(), content: c.titlePage()})
+	c.toc = append(c.toc, tocEntry{title: c.title(), href: "title.xhtml"})
+	for i := range book.Body.Sections {
+		section := &book.Body.Sections[i]
+		title := section.Title
+		if title == "" {
+			title = c.title()
+		}
+		c.pages = append(c.pages, page{file: chapters[i], title: title, content: c.chapterPage(section, title)})
+		c.toc = append(c.toc, c.tocEntries(section, chapters[i])...)
+	}
+	if len(book.Notes) > 0 {
+		c.pages = append(c.pages, page{file: "notes.xhtml", title: "Примечания", content: c.notesPage()})
+		c.toc = append(c.toc, tocEntry{title: "Примечания", href: "notes.xhtml"})
+	}
+	return c
+}
+
+func (c *converter) title() string {
+	if c.book.Title == "" {
+		return "Без названия"
+	}
+	return c.book.Title
+}
+
+// collectImages раскладывает картинки по файлам images/<id>.
+func (c *converter) collectImages() {
+	ids := make([]string, 0, len(c.book.Binaries))
+	for id, b := range c.book.Binaries {
+		if strings.HasPrefix(b.ContentType, "image/") {
+			ids = append(ids, id)
+		}
+	}
+	// Порядок файлов в архиве не должен зависеть от обхода map.
+	sort.Strings(ids)
+
+	used := make(map[string]bool)
+	for _, id := range ids {
+		file := "images/" + safeName(id)
+		for n := 2; used[file]; n++ {
+			file = fmt.Sprintf("images/%d-%s", n, safeName(id))
+		}
+		used[file] = true
+		c.imageFiles[id] = file
+		c.images = append(c.images, image{id: id, file: file, binary: c.book.Binaries[id]})
+	}
+	if c.book.Cover != nil {
+		c.cover = c.imageFiles[c.book.Cover.ID]
+	}
+}
+
+func (c *converter) assignAnchors(s *fb2.Section, file string) {
+	id := s.ID
+	if id == "" {
+		c.generated++
+		id = fmt.Sprintf("toc-%d", c.generated)
+	}
+	c.sectionIDs[s] = id
+	if _, ok := c.anchors[id]; !ok {
+		c.anchors[id] = file
+	}
+	c.collectNodeAnchors(s.Content, file)
+	for i := range s.Sections {
+		c.assignAnchors(&s.Sections[i], file)
+	}
+}
+
+func (c *converter) collectNodeAnchors(nodes []*fb2.Node, file string) {
+	for _, n := range nodes {
+		if id := n.Attr("id"); id != "" {
+			if _, ok := c.anchors[id]; !ok {
+				c.anchors[id] = file
+			}
+		}
+		c.collectNodeAnchors(n.Children, file)
+	}
+}
+
+// tocEntries — пункты оглавления раздела. Разделы без заголовка в оглавление не попадают,
+// их подразделы поднимаются на уровень выше.
+func (c *converter) tocEntries(s *fb2.Section, file string) []tocEntry {
+	var children []tocEntry
+	for i := range s.Sections {
+		children = append(children, c.tocEntries(&s.Sections[i], file)...)
+	}
+	if s.Title == "" {
+		return children
+	}
+	return []tocEntry{{title: s.Title, href: file + "#" + c.sectionIDs[s], children: children}}
+}
+
+func (c *converter) opf(opts Options) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="` + esc(c.lang) + `">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	fmt.Fprintf(&b, "    <dc:identifier id=\"book-id\">%s</dc:identifier>\n", esc(opts.Identifier))
+	fmt.Fprintf(&b, "    <dc:title>%s</dc:title>\n", esc(c.title()))
+	fmt.Fprintf(&b, "    <dc:language>%s</dc:language>\n", esc(c.lang))
+	for i, a := range c.book.Authors {
+		fmt.Fprintf(&b, "    <dc:creator id=\"author-%d\">%s</dc:creator>\n", i+1, esc(a.Name()))
+		fmt.Fprintf(&b, "    <meta refines=\"#author-%d\" property=\"role\" scheme=\"marc:relators\">aut</meta>\n", i+1)
+	}
+	for i, t := range c.book.Translators {
+		fmt.Fprintf(&b, "    <dc:contributor id=\"translator-%d\">%s</dc:contributor>\n", i+1, esc(t.Name()))
+		fmt.Fprintf(&b, "    <meta refines=\"#translator-%d\" property=\"role\" scheme=\"marc:relators\">trl</meta>\n", i+1)
+	}
+	if c.book.Year != 0 {
+		fmt.Fprintf(&b, "    <dc:date>%04d</dc:date>\n", c.book.Year)
+	}
+	if c.book.Annotation != "" {
+		fmt.Fprintf(&b, "    <dc:description>%s</dc:description>\n", esc(c.book.Annotation))
+	}
+	for _, g := range c.book.Genres {
+		fmt.Fprintf(&b, "    <dc:subject>%s</dc:subject>\n", esc(g))
+	}
+	fmt.Fprintf(&b, "    <meta property=\"dcterms:modified\">%s</meta>\n", opts.Modified.UTC().Format("2006-01-02T15:04:05Z"))
+	if len(c.book.Sequences) > 0 {
+		s := c.book.Sequences[0]
+		fmt.Fprintf(&b, "    <meta property=\"belongs-to-collection\" id=\"series\">%s</meta>\n", esc(s.Name))
+		b.WriteString("    <meta refines=\"#series\" property=\"collection-type\">series</meta>\n")
+		// calibre:series понимают читалки, которые не знают EPUB 3 коллекций.
+		fmt.Fprintf(&b, "    <meta name=\"calibre:series\" content=\"%s\"/>\n", esc(s.Name))
+		if s.Number > 0 {
+			fmt.Fprintf(&b, "    <meta refines=\"#series\" property=\"group-position\">%d</meta>\n", s.Number)
+			fmt.Fprintf(&b, "    <meta name=\"calibre:series_index\" content=\"%d\"/>\n", s.Number)
+		}
+	}
+	if c.cover != "" {
+		b.WriteString("    <meta name=\"cover\" content=\"cover-image\"/>\n")
+	}
+	b.WriteString("  </metadata>\n  <manifest>\n")
+	b.WriteString("    <item id=\"nav\" href=\"nav.xhtml\" media-type=\"application/xhtml+xml\" properties=\"nav\"/>\n")
+	b.WriteString("    <item id=\"ncx\" href=\"toc.ncx\" media-type=\"application/x-dtbncx+xml\"/>\n")
+	b.WriteString("    <item id=\"css\" href=\"style.css\" media-type=\"text/css\"/>\n")
+	for i, p := range c.pages {
+		fmt.Fprintf(&b, "    <item id=\"page-%d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", i+1, esc(p.file))
+	}
+	for i, img := range c.images {
+		id, props := fmt.Sprintf("image-%d", i+1), ""
+		if img.file == c.cover {
+			id, props = "cover-image", ` properties="cover-image"`
+		}
+		fmt.Fprintf(&b, "    <item id=\"%s\" href=\"%s\" media-type=\"%s\"%s/>\n", id, esc(img.file), esc(img.binary.ContentType), props)
+	}
+	b.WriteString("  </manifest>\n  <spine toc=\"ncx\">\n")
+	for i := range c.pages {
+		fmt.Fprintf(&b, "    <itemref idref=\"page-%d\"/>\n", i+1)
+	}
+	b.WriteString("  </spine>\n</package>\n")
+	return b.String()
+}
+
+func (c *converter) nav() string {
+	var b strings.Builder
+	b.WriteString(`<nav epub:type="toc" id="toc">
+<h1>Содержание</h1>
+`)
+	var list func([]tocEntry)
+	list = func(entries []tocEntry) {
+		b.WriteString("<ol>\n")
+		for _, e := range entries {
+			fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a>", esc(e.href), esc(e.title))
+			if len(e.children) > 0 {
+				b.WriteString("\n")
+				list(e.children)
+			}
+			b.WriteString("</li>\n")
+		}
+		b.WriteString("</ol>\n")
+	}
+	list(c.toc)
+	b.WriteString("</nav>\n")
+	return c.xhtml("Содержание", b.String())
+}
+
+func (c *converter) ncx(identifier string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+<head>
+`)
+	fmt.Fprintf(&b, "<meta name=\"dtb:uid\" content=\"%s\"/>\n</head>\n", esc(identifier))
+	fmt.Fprintf(&b, "<docTitle><text>%s</text></docTitle>\n<navMap>\n", esc(c.title()))
+
+	order := 0
+	var points func([]tocEntry)
+	points = func(entries []tocEntry) {
+		for _, e := range entries {
+			order++
+			fmt.Fprintf(&b, "<navPoint id=\"nav-%d\" playOrder=\"%d\"><navLabel><text>%s</text></navLabel><content src=\"%s\"/>\n",
+				order, order, esc(e.title), esc(e.href))
+			points(e.children)
+			b.WriteString("</navPoint>\n")
+		}
+	}
+	points(c.toc)
+	b.WriteString("</navMap>\n</ncx>\n")
+	return b.String()
+}
+
+// bookUUID — стабильный urn:uuid (версия 5-подобная, из SHA-1) по названию и авторам.
+func bookUUID(book *fb2.Book) string {
+	sum := sha1.Sum([]byte(book.Title + "\x00" + book.AuthorNames()))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// safeName оставляет в имени файла только латиницу, цифры, точку, дефис и подчёркивание.
+func safeName(id string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, id)
+	if name == "" || name == "." || name == ".." {
+		name = "image"
+	}
+	return name
+}
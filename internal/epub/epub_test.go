@@ -0,0 +1,137 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"tor_project/internal/fb2"
+)
+
+const sampleFB2 = `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description>
+ <title-info>
+  <genre>sf_social</genre>
+  <author><first-name>Джордж</first-name><last-name>Оруэлл</last-name></author>
+  <book-title>1984 &amp; другие</book-title>
+  <annotation><p>Роман-антиутопия.</p></annotation>
+  <coverpage><image l:href="#cover.jpg"/></coverpage>
+  <lang>ru</lang>
+  <sequence name="Антиутопии" number="2"/>
+ </title-info>
+</description>
+<body>
+ <epigraph><p>Война — это мир.</p></epigraph>
+ <section id="part1">
+  <title><p>Часть первая</p></title>
+  <section>
+   <title><p>Глава 1</p></title>
+   <p>Был холодный ясный апрельский день<a l:href="#n1" type="note">1</a>.</p>
+   <empty-line/>
+   <poem><stanza><v>Строка</v></stanza></poem>
+  </section>
+ </section>
+ <section>
+  <p>Без заголовка, но <a l:href="#part1">со ссылкой</a>.</p>
+ </section>
+</body>
+<body name="notes">
+ <section id="n1"><title><p>1</p></title><p>Сноска.</p></section>
+</body>
+<binary id="cover.jpg" content-type="image/jpeg">/9j/4AAQSkZJRg==</binary>
+</FictionBook>`
+
+func TestConvert(t *testing.T) {
+	book, err := fb2.Parse(strings.NewReader(sampleFB2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Convert(&buf, book, Options{Identifier: "flibusta:1", Modified: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := zr.File[0]; first.Name != "mimetype" || first.Method != zip.Store {
+		t.Fatalf("first entry: %s (method %d)", first.Name, first.Method)
+	}
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+
+		// Всё, кроме картинок, должно быть корректным XML.
+		if strings.HasSuffix(f.Name, ".xhtml") || strings.HasSuffix(f.Name, ".opf") || strings.HasSuffix(f.Name, ".ncx") || strings.HasSuffix(f.Name, ".xml") {
+			dec := xml.NewDecoder(bytes.NewReader(data))
+			for {
+				if _, err := dec.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("%s: %v", f.Name, err)
+				}
+			}
+		}
+	}
+
+	opf := files["OEBPS/content.opf"]
+	for _, want := range []string{
+		`<dc:title>1984 &amp; другие</dc:title>`,
+		`<dc:creator id="author-1">Джордж Оруэлл</dc:creator>`,
+		`<dc:language>ru</dc:language>`,
+		`<meta property="dcterms:modified">2024-01-02T03:04:05Z</meta>`,
+		`<meta property="belongs-to-collection" id="series">Антиутопии</meta>`,
+		`<meta refines="#series" property="group-position">2</meta>`,
+		`href="images/cover.jpg" media-type="image/jpeg" properties="cover-image"`,
+		`properties="nav"`,
+	} {
+		if !strings.Contains(opf, want) {
+			t.Fatalf("opf has no %q:\n%s", want, opf)
+		}
+	}
+	if !strings.HasPrefix(files["OEBPS/images/cover.jpg"], "\xFF\xD8\xFF") || files["OEBPS/cover.xhtml"] == "" {
+		t.Fatal("cover is missing")
+	}
+
+	// Оглавление: титул, часть с вложенной главой (раздел без заголовка пропущен), примечания.
+	nav := files["OEBPS/nav.xhtml"]
+	for _, want := range []string{
+		`<a href="chapter-001.xhtml#part1">Часть первая</a>`,
+		`<a href="chapter-001.xhtml#toc-1">Глава 1</a>`,
+		`<a href="notes.xhtml">Примечания</a>`,
+	} {
+		if !strings.Contains(nav, want) {
+			t.Fatalf("nav has no %q:\n%s", want, nav)
+		}
+	}
+	if strings.Contains(nav, "chapter-002") {
+		t.Fatalf("untitled section in nav:\n%s", nav)
+	}
+
+	chapter := files["OEBPS/chapter-001.xhtml"]
+	if !strings.Contains(chapter, `<a href="notes.xhtml#n1" epub:type="noteref">1</a>`) || !strings.Contains(chapter, `<div class="poem">`) {
+		t.Fatalf("chapter:\n%s", chapter)
+	}
+	if !strings.Contains(files["OEBPS/chapter-002.xhtml"], `<a href="chapter-001.xhtml#part1">со ссылкой</a>`) {
+		t.Fatalf("chapter 2:\n%s", files["OEBPS/chapter-002.xhtml"])
+	}
+	if notes := files["OEBPS/notes.xhtml"]; !strings.Contains(notes, `<aside epub:type="footnote" id="n1">`) {
+		t.Fatalf("notes:\n%s", notes)
+	}
+	if title := files["OEBPS/title.xhtml"]; !strings.Contains(title, `<blockquote class="epigraph">`) || !strings.Contains(title, "Антиутопии #2") {
+		t.Fatalf("title page:\n%s", title)
+	}
+}
@@ -0,0 +1,196 @@
+package epub
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"tor_project/internal/fb2"
+)
+
+// xhtml оборачивает тело страницы в XHTML-документ.
+func (c *converter) xhtml(title string, body string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<!DOCTYPE html>
+`)
+	fmt.Fprintf(&b, "<html xmlns=\"http://www.w3.org/1999/xhtml\" xmlns:epub=\"http://www.idpf.org/2007/ops\" xml:lang=\"%s\" lang=\"%s\">\n", esc(c.lang), esc(c.lang))
+	fmt.Fprintf(&b, "<head>\n<meta charset=\"utf-8\"/>\n<title>%s</title>\n", esc(title))
+	b.WriteString("<link rel=\"stylesheet\" type=\"text/css\" href=\"style.css\"/>\n</head>\n<body>\n")
+	b.WriteString(body)
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
+
+func (c *converter) coverPage() string {
+	return c.xhtml("Обложка", fmt.Sprintf("<div class=\"cover\"><img src=\"%s\" alt=\"%s\"/></div>\n", esc(c.cover), esc(c.title())))
+}
+
+// titlePage — титул: авторы, название, серия, затем заголовок и эпиграфы тела книги.
+func (c *converter) titlePage() string {
+	var b strings.Builder
+	b.WriteString("<div class=\"title-page\">\n")
+	if authors := c.book.AuthorNames(); authors != "" {
+		fmt.Fprintf(&b, "<p class=\"author\">%s</p>\n", esc(authors))
+	}
+	fmt.Fprintf(&b, "<h1>%s</h1>\n", esc(c.title()))
+	if len(c.book.Sequences) > 0 {
+		s := c.book.Sequences[0]
+		label := s.Name
+		if s.Number > 0 {
+			label = fmt.Sprintf("%s #%d", s.Name, s.Number)
+		}
+		fmt.Fprintf(&b, "<p class=\"series\">%s</p>\n", esc(label))
+	}
+	b.WriteString("</div>\n")
+
+	if c.book.Body.Title != "" && c.book.Body.Title != c.book.Title {
+		b.WriteString("<div class=\"title-page\">\n")
+		for _, line := range strings.Split(c.book.Body.Title, "\n") {
+			fmt.Fprintf(&b, "<p>%s</p>\n", esc(line))
+		}
+		b.WriteString("</div>\n")
+	}
+	c.renderNodes(&b, c.book.Body.Content)
+	return c.xhtml(c.title(), b.String())
+}
+
+func (c *converter) chapterPage(s *fb2.Section, title string) string {
+	var b strings.Builder
+	c.renderSection(&b, s, 1)
+	return c.xhtml(title, b.String())
+}
+
+func (c *converter) renderSection(b *strings.Builder, s *fb2.Section, depth int) {
+	fmt.Fprintf(b, "<section id=\"%s\">\n", esc(c.sectionIDs[s]))
+	if s.Title != "" {
+		level := min(depth, 6)
+		fmt.Fprintf(b, "<h%d>%s</h%d>\n", level, esc(s.Title), level)
+	}
+	c.renderNodes(b, s.Content)
+	for i := range s.Sections {
+		c.renderSection(b, &s.Sections[i], depth+1)
+	}
+	b.WriteString("</section>\n")
+}
+
+// notesPage — сноски. epub:type="footnote" позволяет читалкам показывать их во всплывающем окне.
+func (c *converter) notesPage() string {
+	var b strings.Builder
+	b.WriteString("<h1>Примечания</h1>\n")
+	for i := range c.book.Notes {
+		note := &c.book.Notes[i]
+		fmt.Fprintf(&b, "<aside epub:type=\"footnote\" id=\"%s\">\n", esc(c.sectionIDs[note]))
+		if note.Title != "" {
+			fmt.Fprintf(&b, "<p><strong>%s</strong></p>\n", esc(note.Title))
+		}
+		c.renderNodes(&b, note.Content)
+		for j := range note.Sections {
+			c.renderSection(&b, &note.Sections[j], 2)
+		}
+		b.WriteString("</aside>\n")
+	}
+	return c.xhtml("Примечания", b.String())
+}
+
+// elements — во что превращаются элементы FB2: тег и класс.
+var elements = map[string][2]string{
+	"p":             {"p", ""},
+	"v":             {"p", "v"},
+	"subtitle":      {"p", "subtitle"},
+	"text-author":   {"p", "text-author"},
+	"date":          {"p", "date"},
+	"emphasis":      {"em", ""},
+	"strong":        {"strong", ""},
+	"strikethrough": {"del", ""},
+	"sub":           {"sub", ""},
+	"sup":           {"sup", ""},
+	"code":          {"code", ""},
+	"style":         {"span", ""},
+	"poem":          {"div", "poem"},
+	"stanza":        {"div", "stanza"},
+	"title":         {"div", "title"},
+	"epigraph":      {"blockquote", "epigraph"},
+	"cite":          {"blockquote", "cite"},
+	"annotation":    {"div", "annotation"},
+	"table":         {"table", ""},
+	"tr":            {"tr", ""},
+	"th":            {"th", ""},
+	"td":            {"td", ""},
+}
+
+func (c *converter) renderNodes(b *strings.Builder, nodes []*fb2.Node) {
+	for _, n := range nodes {
+		c.renderNode(b, n)
+	}
+}
+
+func (c *converter) renderNode(b *strings.Builder, n *fb2.Node) {
+	if n.IsText() {
+		b.WriteString(esc(n.Text))
+		return
+	}
+
+	switch n.Name {
+	case "empty-line":
+		b.WriteString("<p class=\"empty-line\">&#160;</p>\n")
+		return
+	case "image":
+		href := strings.TrimPrefix(n.Attr("href"), "#")
+		if file, ok := c.imageFiles[href]; ok {
+			fmt.Fprintf(b, "<img class=\"image\" src=\"%s\" alt=\"%s\"%s/>", esc(file), esc(n.Attr("alt")), c.idAttr(n))
+		}
+		return
+	case "a":
+		href := n.Attr("href")
+		if strings.HasPrefix(href, "#") {
+			if file, ok := c.anchors[href[1:]]; ok {
+				href = file + href
+			}
+		}
+		noteref := ""
+		if n.Attr("type") == "note" {
+			noteref = ` epub:type="noteref"`
+		}
+		fmt.Fprintf(b, "<a href=\"%s\"%s%s>", esc(href), noteref, c.idAttr(n))
+		c.renderNodes(b, n.Children)
+		b.WriteString("</a>")
+		return
+	}
+
+	el, ok := elements[n.Name]
+	if !ok {
+		// Незнакомый элемент: оставляем только содержимое.
+		c.renderNodes(b, n.Children)
+		return
+	}
+	tag, class := el[0], el[1]
+	b.WriteString("<" + tag)
+	if class != "" {
+		fmt.Fprintf(b, " class=\"%s\"", class)
+	}
+	b.WriteString(c.idAttr(n))
+	for _, attr := range []string{"colspan", "rowspan"} {
+		if (tag == "td" || tag == "th") && n.Attr(attr) != "" {
+			fmt.Fprintf(b, " %s=\"%s\"", attr, esc(n.Attr(attr)))
+		}
+	}
+	b.WriteString(">")
+	c.renderNodes(b, n.Children)
+	b.WriteString("</" + tag + ">")
+	if tag == "p" || tag == "div" || tag == "blockquote" || tag == "table" || tag == "tr" {
+		b.WriteString("\n")
+	}
+}
+
+func (c *converter) idAttr(n *fb2.Node) string {
+	if id := n.Attr("id"); id != "" {
+		return fmt.Sprintf(" id=\"%s\"", esc(id))
+	}
+	return ""
+}
+
+// esc экранирует текст для XHTML. html.EscapeString годится: он заменяет только <, >, &, ' и ".
+func esc(s string) string {
+	return html.EscapeString(s)
+}
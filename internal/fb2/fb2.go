@@ -0,0 +1,301 @@
+// Package fb2 разбирает книги в формате FictionBook 2: описание (название, авторы,
+// серия, аннотация, обложка), тело книги по разделам и сноски.
+package fb2
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Book — разобранная книга.
+type Book struct {
+	Title       string
+	Authors     []Person
+	Translators []Person
+	// Genres — коды жанров FB2 ("sf_history", "prose_classic", ...).
+	Genres    []string
+	Lang      string
+	Year      int
+	Sequences []Sequence
+	// Annotation — аннотация простым текстом, абзацы через "\n".
+	Annotation string
+	Keywords   string
+
+	// Cover — картинка обложки из <coverpage>, если она есть среди binary.
+	Cover *Binary
+	// Body — основной текст; Notes — разделы тел-сносок (<body name="notes">), по ним ведут ссылки из текста.
+	Body  Body
+	Notes []Section
+	// Binaries — вложенные картинки по id (ссылки в тексте — "#id").
+	Binaries map[string]*Binary
+}
+
+// Person — автор или переводчик.
+type Person struct {
+	FirstName  string
+	MiddleName string
+	LastName   string
+	Nickname   string
+}
+
+// Name — "Имя Отчество Фамилия" или псевдоним, если имени нет.
+func (p Person) Name() string {
+	name := strings.Join(strings.Fields(p.FirstName+" "+p.MiddleName+" "+p.LastName), " ")
+	if name == "" {
+		return strings.TrimSpace(p.Nickname)
+	}
+	return name
+}
+
+// Sequence — серия и номер книги в ней (0, если не указан).
+type Sequence struct {
+	Name   string
+	Number int
+}
+
+// Binary — вложенный файл (как правило, картинка).
+type Binary struct {
+	ID          string
+	ContentType string
+	Data        []byte
+}
+
+// Body — тело книги: заголовок, эпиграфы и картинки перед разделами, сами разделы.
+type Body struct {
+	Title    string
+	Content  []*Node
+	Sections []Section
+}
+
+// Section — раздел (глава). Content — всё, кроме заголовка и вложенных разделов, в исходном порядке.
+type Section struct {
+	ID       string
+	Title    string
+	Content  []*Node
+	Sections []Section
+}
+
+// fictionBook — корень документа для encoding/xml.
+type fictionBook struct {
+	Description struct {
+		TitleInfo   titleInfo `xml:"title-info"`
+		PublishInfo struct {
+			Year      string     `xml:"year"`
+			Sequences []sequence `xml:"sequence"`
+		} `xml:"publish-info"`
+	} `xml:"description"`
+	Bodies   []Node   `xml:"body"`
+	Binaries []binary `xml:"binary"`
+}
+
+type titleInfo struct {
+	Genres      []string   `xml:"genre"`
+	Authors     []person   `xml:"author"`
+	BookTitle   string     `xml:"book-title"`
+	Annotation  *Node      `xml:"annotation"`
+	Keywords    string     `xml:"keywords"`
+	Date        date       `xml:"date"`
+	Coverpage   *Node      `xml:"coverpage"`
+	Lang        string     `xml:"lang"`
+	Translators []person   `xml:"translator"`
+	Sequences   []sequence `xml:"sequence"`
+}
+
+type person struct {
+	FirstName  string `xml:"first-name"`
+	MiddleName string `xml:"middle-name"`
+	LastName   string `xml:"last-name"`
+	Nickname   string `xml:"nickname"`
+}
+
+type date struct {
+	Value string `xml:"value,attr"`
+	Text  string `xml:",chardata"`
+}
+
+type sequence struct {
+	Name   string `xml:"name,attr"`
+	Number string `xml:"number,attr"`
+}
+
+type binary struct {
+	ID          string `xml:"id,attr"`
+	ContentType string `xml:"content-type,attr"`
+	Data        string `xml:",chardata"`
+}
+
+// Parse читает FB2 из r. Кодировка берётся из XML-объявления (UTF-8, windows-1251,
+// KOI8-R, windows-1252, Latin-1) или из BOM (UTF-16).
+func Parse(r io.Reader) (*Book, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения fb2: %w", err)
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(toUTF8(data)))
+	dec.CharsetReader = charsetReader
+	// В книгах встречаются HTML-сущности (&nbsp; и т.п.) и мелкие ошибки разметки.
+	dec.Strict = false
+	dec.Entity = xml.HTMLEntity
+	dec.AutoClose = xml.HTMLAutoClose
+
+	var doc fictionBook
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("ошибка разбора fb2: %w", err)
+	}
+	return doc.book(), nil
+}
+
+func (doc *fictionBook) book() *Book {
+	info := doc.Description.TitleInfo
+	book := &Book{
+		Title:      cleanText(info.BookTitle),
+		Lang:       strings.TrimSpace(info.Lang),
+		Keywords:   cleanText(info.Keywords),
+		Annotation: info.Annotation.PlainText(),
+		Binaries:   make(map[string]*Binary),
+	}
+
+	for _, a := range info.Authors {
+		if p := a.person(); p.Name() != "" {
+			book.Authors = append(book.Authors, p)
+		}
+	}
+	for _, t := range info.Translators {
+		if p := t.person(); p.Name() != "" {
+			book.Translators = append(book.Translators, p)
+		}
+	}
+	for _, g := range info.Genres {
+		if g = strings.TrimSpace(g); g != "" {
+			book.Genres = append(book.Genres, g)
+		}
+	}
+
+	sequences := info.Sequences
+	if len(sequences) == 0 {
+		sequences = doc.Description.PublishInfo.Sequences
+	}
+	for _, s := range sequences {
+		if name := cleanText(s.Name); name != "" {
+			number, _ := strconv.Atoi(strings.TrimSpace(s.Number))
+			book.Sequences = append(book.Sequences, Sequence{Name: name, Number: number})
+		}
+	}
+
+	book.Year = parseYear(info.Date.Value)
+	if book.Year == 0 {
+		book.Year = parseYear(info.Date.Text)
+	}
+	if book.Year == 0 {
+		book.Year = parseYear(doc.Description.PublishInfo.Year)
+	}
+
+	for _, b := range doc.Binaries {
+		if b.ID == "" {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(b.Data), ""))
+		if err != nil {
+			continue
+		}
+		book.Binaries[b.ID] = &Binary{ID: b.ID, ContentType: b.ContentType, Data: data}
+	}
+	if image := info.Coverpage.Child("image"); image != nil {
+		book.Cover = book.Binaries[strings.TrimPrefix(image.Attr("href"), "#")]
+	}
+
+	for i := range doc.Bodies {
+		body := &doc.Bodies[i]
+		switch body.Attr("name") {
+		case "notes", "comments":
+			book.Notes = append(book.Notes, sections(body)...)
+		default:
+			// Основное тело — первое без имени; прочие безымянные тела (редкость) дописываем к нему.
+			if book.Body.Title == "" {
+				book.Body.Title = body.Child("title").PlainText()
+			}
+			for _, c := range body.Children {
+				if !c.IsText() && c.Name != "title" && c.Name != "section" {
+					book.Body.Content = append(book.Body.Content, c)
+				}
+			}
+			book.Body.Sections = append(book.Body.Sections, sections(body)...)
+		}
+	}
+	return book
+}
+
+// sections собирает дочерние разделы элемента.
+func sections(n *Node) []Section {
+	var out []Section
+	for _, c := range n.Elements("section") {
+		section := Section{
+			ID:       c.Attr("id"),
+			Title:    strings.ReplaceAll(c.Child("title").PlainText(), "\n", ". "),
+			Sections: sections(c),
+		}
+		for _, child := range c.Children {
+			if child.Name == "title" || child.Name == "section" {
+				continue
+			}
+			if child.IsText() && strings.TrimSpace(child.Text) == "" {
+				continue
+			}
+			section.Content = append(section.Content, child)
+		}
+		out = append(out, section)
+	}
+	return out
+}
+
+func (p person) person() Person {
+	return Person{
+		FirstName:  cleanText(p.FirstName),
+		MiddleName: cleanText(p.MiddleName),
+		LastName:   cleanText(p.LastName),
+		Nickname:   cleanText(p.Nickname),
+	}
+}
+
+// AuthorNames — имена авторов через запятую.
+func (b *Book) AuthorNames() string {
+	return joinNames(b.Authors)
+}
+
+// TranslatorNames — имена переводчиков через запятую.
+func (b *Book) TranslatorNames() string {
+	return joinNames(b.Translators)
+}
+
+func joinNames(people []Person) string {
+	names := make([]string, 0, len(people))
+	for _, p := range people {
+		names = append(names, p.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+func cleanText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// parseYear достаёт год из строк вида "2004", "2004-05-12" или "май 2004".
+func parseYear(s string) int {
+	for i := 0; i+4 <= len(s); i++ {
+		if i > 0 && s[i-1] >= '0' && s[i-1] <= '9' {
+			continue
+		}
+		if year, err := strconv.Atoi(s[i : i+4]); err == nil && year >= 1000 && year <= 2999 {
+			if i+4 == len(s) || s[i+4] < '0' || s[i+4] > '9' {
+				return year
+			}
+		}
+	}
+	return 0
+}
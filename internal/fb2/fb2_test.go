@@ -0,0 +1,140 @@
+package fb2
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+const sampleFB2 = `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description>
+ <title-info>
+  <genre>sf_social</genre>
+  <genre>prose_classic</genre>
+  <author><first-name>Джордж</first-name><last-name>Оруэлл</last-name></author>
+  <book-title>1984</book-title>
+  <annotation><p>Роман-антиутопия.</p><p>Большой   Брат следит&nbsp;за тобой.</p></annotation>
+  <date value="1949-06-08">1949</date>
+  <coverpage><image l:href="#cover.jpg"/></coverpage>
+  <lang>ru</lang>
+  <translator><first-name>Виктор</first-name><last-name>Голышев</last-name></translator>
+  <sequence name="Антиутопии" number="2"/>
+ </title-info>
+</description>
+<body>
+ <title><p>Джордж Оруэлл</p><p>1984</p></title>
+ <epigraph><p>Война — это мир.</p></epigraph>
+ <section id="part1">
+  <title><p>Часть первая</p></title>
+  <section>
+   <title><p>Глава 1</p></title>
+   <p>Был холодный ясный апрельский день<a l:href="#n1" type="note">1</a>.</p>
+   <empty-line/>
+   <p>Часы били <emphasis>тринадцать</emphasis>.</p>
+  </section>
+ </section>
+</body>
+<body name="notes">
+ <section id="n1"><title><p>1</p></title><p>Сноска.</p></section>
+</body>
+<binary id="cover.jpg" content-type="image/jpeg">/9j/4AAQ
+SkZJRg==</binary>
+</FictionBook>`
+
+func TestParse(t *testing.T) {
+	book, err := Parse(strings.NewReader(sampleFB2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if book.Title != "1984" || book.AuthorNames() != "Джордж Оруэлл" || book.TranslatorNames() != "Виктор Голышев" {
+		t.Fatalf("title/authors: %q / %q / %q", book.Title, book.AuthorNames(), book.TranslatorNames())
+	}
+	if book.Lang != "ru" || book.Year != 1949 || len(book.Genres) != 2 || book.Genres[0] != "sf_social" {
+		t.Fatalf("lang/year/genres: %q %d %v", book.Lang, book.Year, book.Genres)
+	}
+	if len(book.Sequences) != 1 || book.Sequences[0] != (Sequence{Name: "Антиутопии", Number: 2}) {
+		t.Fatalf("sequences: %+v", book.Sequences)
+	}
+	if book.Annotation != "Роман-антиутопия.\nБольшой Брат следит за тобой." {
+		t.Fatalf("annotation: %q", book.Annotation)
+	}
+	if book.Cover == nil || book.Cover.ContentType != "image/jpeg" || !bytes.HasPrefix(book.Cover.Data, []byte{0xFF, 0xD8, 0xFF}) {
+		t.Fatalf("cover: %+v", book.Cover)
+	}
+
+	if book.Body.Title != "Джордж Оруэлл\n1984" || len(book.Body.Content) != 1 || book.Body.Content[0].Name != "epigraph" {
+		t.Fatalf("body: %q %+v", book.Body.Title, book.Body.Content)
+	}
+	if len(book.Body.Sections) != 1 || book.Body.Sections[0].ID != "part1" || book.Body.Sections[0].Title != "Часть первая" {
+		t.Fatalf("sections: %+v", book.Body.Sections)
+	}
+	chapter := book.Body.Sections[0].Sections[0]
+	if chapter.Title != "Глава 1" || len(chapter.Content) != 3 {
+		t.Fatalf("chapter: %+v", chapter)
+	}
+	link := chapter.Content[0].Child("a")
+	if link.Attr("href") != "#n1" || link.Attr("type") != "note" {
+		t.Fatalf("note link: %+v", link)
+	}
+	if got := chapter.Content[2].PlainText(); got != "Часы били тринадцать." {
+		t.Fatalf("paragraph: %q", got)
+	}
+
+	if len(book.Notes) != 1 || book.Notes[0].ID != "n1" || book.Notes[0].Content[0].PlainText() != "Сноска." {
+		t.Fatalf("notes: %+v", book.Notes)
+	}
+}
+
+func TestParseEncodings(t *testing.T) {
+	doc := func(encoding string) string {
+		return `<?xml version="1.0" encoding="` + encoding + `"?>
+<FictionBook><description><title-info><book-title>Ёжик в тумане</book-title>
+<author><nickname>Козлов</nickname></author></title-info></description><body/></FictionBook>`
+	}
+	encode := func(s string, table *[128]rune) []byte {
+		reverse := make(map[rune]byte)
+		for i, r := range table {
+			reverse[r] = byte(0x80 + i)
+		}
+		var out []byte
+		for _, r := range s {
+			if r < 0x80 {
+				out = append(out, byte(r))
+			} else {
+				out = append(out, reverse[r])
+			}
+		}
+		return out
+	}
+	utf16le := func(s string) []byte {
+		out := []byte{0xFF, 0xFE}
+		for _, u := range utf16.Encode([]rune(s)) {
+			out = append(out, byte(u), byte(u>>8))
+		}
+		return out
+	}
+
+	inputs := map[string][]byte{
+		"windows-1251": encode(doc("windows-1251"), &windows1251),
+		"koi8-r":       encode(doc("KOI8-R"), &koi8r),
+		"utf-8 bom":    append([]byte{0xEF, 0xBB, 0xBF}, doc("utf-8")...),
+		"utf-16":       utf16le(doc("utf-16")),
+	}
+	for name, data := range inputs {
+		book, err := Parse(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if book.Title != "Ёжик в тумане" || book.AuthorNames() != "Козлов" {
+			t.Fatalf("%s: %q / %q", name, book.Title, book.AuthorNames())
+		}
+	}
+
+	// Известные байты windows-1251: "Привет" и «№».
+	if got := string(decodeCharmap([]byte{0xCF, 0xF0, 0xE8, 0xE2, 0xE5, 0xF2, 0x20, 0xAB, 0xB9, 0xBB}, &windows1251)); got != "Привет «№»" {
+		t.Fatalf("cp1251: %q", got)
+	}
+}
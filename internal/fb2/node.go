@@ -0,0 +1,141 @@
+package fb2
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// Node — элемент (или текст) тела книги: абзацы, стихи, выделения, ссылки и картинки
+// хранятся деревом как есть, чтобы их можно было отрисовать в любом формате.
+type Node struct {
+	// Name — локальное имя элемента ("p", "emphasis", "a", "image", ...); пустое у текстового узла.
+	Name string
+	// Attrs — атрибуты по локальному имени ("id", "href", "type", ...), без пространств имён.
+	Attrs    map[string]string
+	Text     string
+	Children []*Node
+}
+
+// UnmarshalXML собирает поддерево элемента.
+func (n *Node) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	n.Name = start.Name.Local
+	n.Attrs = attrs(start.Attr)
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child := &Node{}
+			if err := child.UnmarshalXML(d, t); err != nil {
+				return err
+			}
+			n.Children = append(n.Children, child)
+		case xml.CharData:
+			n.Children = append(n.Children, &Node{Text: string(t)})
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+func attrs(list []xml.Attr) map[string]string {
+	if len(list) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(list))
+	for _, a := range list {
+		// xmlns-объявления нам не нужны; "l:href" и "xlink:href" становятся просто "href".
+		if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" {
+			continue
+		}
+		m[a.Name.Local] = a.Value
+	}
+	return m
+}
+
+// Attr возвращает атрибут по локальному имени.
+func (n *Node) Attr(name string) string {
+	if n == nil {
+		return ""
+	}
+	return n.Attrs[name]
+}
+
+// IsText — текстовый узел.
+func (n *Node) IsText() bool {
+	return n.Name == ""
+}
+
+// Child возвращает первый дочерний элемент с именем name.
+func (n *Node) Child(name string) *Node {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Elements возвращает дочерние элементы с именем name.
+func (n *Node) Elements(name string) []*Node {
+	if n == nil {
+		return nil
+	}
+	var out []*Node
+	for _, c := range n.Children {
+		if c.Name == name {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// PlainText — текст узла без разметки: блоки ("p", "v", "subtitle", ...) разделены
+// переводом строки, пробелы внутри строк схлопнуты.
+func (n *Node) PlainText() string {
+	if n == nil {
+		return ""
+	}
+	var lines []string
+	var cur strings.Builder
+	flush := func() {
+		if line := strings.Join(strings.Fields(cur.String()), " "); line != "" {
+			lines = append(lines, line)
+		}
+		cur.Reset()
+	}
+
+	var walk func(*Node)
+	walk = func(node *Node) {
+		if node.IsText() {
+			cur.WriteString(node.Text)
+			return
+		}
+		block := blockElements[node.Name]
+		if block {
+			flush()
+		}
+		for _, c := range node.Children {
+			walk(c)
+		}
+		if block {
+			flush()
+		}
+	}
+	walk(n)
+	flush()
+	return strings.Join(lines, "\n")
+}
+
+// blockElements — элементы FB2, которые начинают новую строку текста.
+var blockElements = map[string]bool{
+	"p": true, "v": true, "subtitle": true, "text-author": true, "title": true,
+	"stanza": true, "poem": true, "cite": true, "epigraph": true, "empty-line": true,
+	"section": true, "annotation": true, "tr": true,
+}
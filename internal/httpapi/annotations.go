@@ -0,0 +1,311 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"tor_project/internal/db"
+	"tor_project/internal/reader"
+)
+
+// Ограничения на текст от клиента (в байтах).
+const (
+	maxBookmarkTitle = 200
+	maxHighlightText = 8000
+	maxNoteText      = 8000
+)
+
+// highlightColors — цвета выделений, которые умеет показывать читалка.
+var highlightColors = map[string]bool{
+	"yellow": true,
+	"green":  true,
+	"blue":   true,
+	"pink":   true,
+	"orange": true,
+}
+
+const defaultHighlightColor = "yellow"
+
+// handleBookmarks — закладки:
+//
+//	GET    /api/bookmarks?file_id=N — закладки книги;
+//	POST   /api/bookmarks           — добавить ({file_id, location, title});
+//	PUT    /api/bookmarks/{id}      — переименовать ({title});
+//	DELETE /api/bookmarks/{id}      — удалить.
+func (s *Server) handleBookmarks(w http.ResponseWriter, r *http.Request) {
+	id, ok := resourceID(w, r, "/api/bookmarks")
+	if !ok {
+		return
+	}
+	s.withUser(w, r, func(ctx context.Context, user TelegramUser) {
+		switch {
+		case id == 0 && r.Method == http.MethodGet:
+			fileID, ok := fileIDParam(w, r)
+			if !ok {
+				return
+			}
+			bookmarks, err := s.store.ListBookmarks(ctx, user.ID, fileID)
+			writeResult(w, map[string]any{"bookmarks": bookmarks}, err)
+		case id == 0 && r.Method == http.MethodPost:
+			var body struct {
+				FileID   int64  `json:"file_id"`
+				Location string `json:"location"`
+				Title    string `json:"title"`
+			}
+			if !decodeJSON(w, r, &body) {
+				return
+			}
+			chapter, paragraph, ok := reader.ParseAnchor(body.Location)
+			if !ok {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "location некорректен"})
+				return
+			}
+			title := strings.TrimSpace(body.Title)
+			if len(title) > maxBookmarkTitle {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "слишком длинная подпись"})
+				return
+			}
+			bookmark, err := s.store.AddBookmark(ctx, user.ID, db.Bookmark{
+				FileID:   body.FileID,
+				Chapter:  chapter,
+				Offset:   paragraph,
+				Location: reader.ParagraphAnchor(chapter, paragraph),
+				Title:    title,
+			})
+			writeResult(w, map[string]any{"bookmark": bookmark}, err)
+		case id != 0 && r.Method == http.MethodPut:
+			var body struct {
+				Title string `json:"title"`
+			}
+			if !decodeJSON(w, r, &body) {
+				return
+			}
+			title := strings.TrimSpace(body.Title)
+			if len(title) > maxBookmarkTitle {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "слишком длинная подпись"})
+				return
+			}
+			writeResult(w, map[string]any{"ok": true}, s.store.RenameBookmark(ctx, user.ID, id, title))
+		case id != 0 && r.Method == http.MethodDelete:
+			writeResult(w, map[string]any{"ok": true}, s.store.DeleteBookmark(ctx, user.ID, id))
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		}
+	})
+}
+
+// handleHighlights — выделения:
+//
+//	GET    /api/highlights?file_id=N — выделения книги;
+//	POST   /api/highlights           — добавить ({file_id, chapter, start_paragraph, start_char,
+//	                                   end_paragraph, end_char, color, text});
+//	PUT    /api/highlights/{id}      — сменить цвет ({color});
+//	DELETE /api/highlights/{id}      — удалить вместе с заметками к нему.
+func (s *Server) handleHighlights(w http.ResponseWriter, r *http.Request) {
+	id, ok := resourceID(w, r, "/api/highlights")
+	if !ok {
+		return
+	}
+	s.withUser(w, r, func(ctx context.Context, user TelegramUser) {
+		switch {
+		case id == 0 && r.Method == http.MethodGet:
+			fileID, ok := fileIDParam(w, r)
+			if !ok {
+				return
+			}
+			highlights, err := s.store.ListHighlights(ctx, user.ID, fileID)
+			writeResult(w, map[string]any{"highlights": highlights}, err)
+		case id == 0 && r.Method == http.MethodPost:
+			var h db.Highlight
+			if !decodeJSON(w, r, &h) {
+				return
+			}
+			h.Text = strings.TrimSpace(h.Text)
+			if h.Color == "" {
+				h.Color = defaultHighlightColor
+			}
+			if msg := validateHighlight(h); msg != "" {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+				return
+			}
+			h, err := s.store.AddHighlight(ctx, user.ID, h)
+			writeResult(w, map[string]any{"highlight": h}, err)
+		case id != 0 && r.Method == http.MethodPut:
+			var body struct {
+				Color string `json:"color"`
+			}
+			if !decodeJSON(w, r, &body) {
+				return
+			}
+			if !highlightColors[body.Color] {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "неизвестный цвет"})
+				return
+			}
+			writeResult(w, map[string]any{"ok": true}, s.store.RecolorHighlight(ctx, user.ID, id, body.Color))
+		case id != 0 && r.Method == http.MethodDelete:
+			writeResult(w, map[string]any{"ok": true}, s.store.DeleteHighlight(ctx, user.ID, id))
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		}
+	})
+}
+
+// handleNotes — заметки:
+//
+//	GET    /api/notes?file_id=N — заметки книги;
+//	POST   /api/notes           — добавить ({file_id, location, text} или {file_id, highlight_id, text});
+//	PUT    /api/notes/{id}      — изменить текст ({text});
+//	DELETE /api/notes/{id}      — удалить.
+func (s *Server) handleNotes(w http.ResponseWriter, r *http.Request) {
+	id, ok := resourceID(w, r, "/api/notes")
+	if !ok {
+		return
+	}
+	s.withUser(w, r, func(ctx context.Context, user TelegramUser) {
+		switch {
+		case id == 0 && r.Method == http.MethodGet:
+			fileID, ok := fileIDParam(w, r)
+			if !ok {
+				return
+			}
+			notes, err := s.store.ListNotes(ctx, user.ID, fileID)
+			writeResult(w, map[string]any{"notes": notes}, err)
+		case id == 0 && r.Method == http.MethodPost:
+			var body struct {
+				FileID      int64  `json:"file_id"`
+				HighlightID *int64 `json:"highlight_id"`
+				Location    string `json:"location"`
+				Text        string `json:"text"`
+			}
+			if !decodeJSON(w, r, &body) {
+				return
+			}
+			text := strings.TrimSpace(body.Text)
+			if msg := validateNoteText(text); msg != "" {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+				return
+			}
+			note := db.Note{FileID: body.FileID, HighlightID: body.HighlightID, Text: text}
+			if body.HighlightID == nil || body.Location != "" {
+				chapter, paragraph, ok := reader.ParseAnchor(body.Location)
+				if !ok {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "location некорректен"})
+					return
+				}
+				note.Chapter, note.Offset, note.Location = chapter, paragraph, reader.ParagraphAnchor(chapter, paragraph)
+			} else if h, ok := s.findHighlight(ctx, user.ID, body.FileID, *body.HighlightID); ok {
+				// Заметка к выделению без location стоит там же, где начинается выделение.
+				note.Chapter, note.Offset = h.Chapter, h.StartParagraph
+				note.Location = reader.ParagraphAnchor(h.Chapter, h.StartParagraph)
+			}
+			note, err := s.store.AddNote(ctx, user.ID, note)
+			writeResult(w, map[string]any{"note": note}, err)
+		case id != 0 && r.Method == http.MethodPut:
+			var body struct {
+				Text string `json:"text"`
+			}
+			if !decodeJSON(w, r, &body) {
+				return
+			}
+			text := strings.TrimSpace(body.Text)
+			if msg := validateNoteText(text); msg != "" {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+				return
+			}
+			writeResult(w, map[string]any{"ok": true}, s.store.EditNote(ctx, user.ID, id, text))
+		case id != 0 && r.Method == http.MethodDelete:
+			writeResult(w, map[string]any{"ok": true}, s.store.DeleteNote(ctx, user.ID, id))
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		}
+	})
+}
+
+// findHighlight ищет выделение пользователя в книге; ok=false — такого нет.
+func (s *Server) findHighlight(ctx context.Context, userID int64, fileID int64, id int64) (db.Highlight, bool) {
+	highlights, err := s.store.ListHighlights(ctx, userID, fileID)
+	if err != nil {
+		return db.Highlight{}, false
+	}
+	for _, h := range highlights {
+		if h.ID == id {
+			return h, true
+		}
+	}
+	return db.Highlight{}, false
+}
+
+func validateHighlight(h db.Highlight) string {
+	switch {
+	case h.Chapter < 0 || h.StartParagraph < 0 || h.StartChar < 0 || h.EndParagraph < 0 || h.EndChar < 0:
+		return "диапазон некорректен"
+	case h.EndParagraph < h.StartParagraph || h.EndParagraph == h.StartParagraph && h.EndChar <= h.StartChar:
+		return "конец выделения раньше начала"
+	case !highlightColors[h.Color]:
+		return "неизвестный цвет"
+	case len(h.Text) > maxHighlightText:
+		return "слишком длинное выделение"
+	}
+	return ""
+}
+
+func validateNoteText(text string) string {
+	switch {
+	case text == "":
+		return "текст заметки пустой"
+	case len(text) > maxNoteText:
+		return "слишком длинная заметка"
+	}
+	return ""
+}
+
+// resourceID разбирает путь prefix или prefix/{id}: 0 — вся коллекция.
+func resourceID(w http.ResponseWriter, r *http.Request, prefix string) (int64, bool) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if rest == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || id <= 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return 0, false
+	}
+	return id, true
+}
+
+func fileIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	fileID, err := strconv.ParseInt(r.URL.Query().Get("file_id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file id некорректен"})
+		return 0, false
+	}
+	return fileID, true
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		return false
+	}
+	return true
+}
+
+// writeResult отвечает resp или ошибкой хранилища с подходящим статусом.
+func writeResult(w http.ResponseWriter, resp map[string]any, err error) {
+	switch {
+	case errors.Is(err, db.ErrNotInLibrary):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "файл не найден"})
+	case errors.Is(err, db.ErrNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, db.ErrShelfExists):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,110 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"tor_project/internal/db"
+)
+
+func sendJSON(t *testing.T, h http.Handler, method string, path string, initData string, body any, v any) int {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("X-Telegram-InitData", initData)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAnnotations(t *testing.T) {
+	srv, fileID, initData := newTestServer(t)
+	h := srv.Handler()
+	file := strconv.FormatInt(fileID, 10)
+
+	var added struct {
+		Bookmark  db.Bookmark  `json:"bookmark"`
+		Highlight db.Highlight `json:"highlight"`
+		Note      db.Note      `json:"note"`
+	}
+	if code := sendJSON(t, h, http.MethodPost, "/api/bookmarks", initData, map[string]any{"file_id": fileID, "location": "c1p0", "title": " Глава 2 "}, &added); code != http.StatusOK {
+		t.Fatalf("add bookmark: %d", code)
+	}
+	if added.Bookmark.ID == 0 || added.Bookmark.Chapter != 1 || added.Bookmark.Title != "Глава 2" {
+		t.Fatalf("bookmark: %+v", added.Bookmark)
+	}
+	highlight := map[string]any{"file_id": fileID, "chapter": 0, "start_paragraph": 1, "start_char": 0, "end_paragraph": 1, "end_char": 6, "text": "Первый"}
+	if code := sendJSON(t, h, http.MethodPost, "/api/highlights", initData, highlight, &added); code != http.StatusOK {
+		t.Fatalf("add highlight: %d", code)
+	}
+	if added.Highlight.Color != "yellow" {
+		t.Fatalf("highlight: %+v", added.Highlight)
+	}
+	if code := sendJSON(t, h, http.MethodPost, "/api/notes", initData, map[string]any{"file_id": fileID, "highlight_id": added.Highlight.ID, "text": "Важно"}, &added); code != http.StatusOK {
+		t.Fatalf("add note: %d", code)
+	}
+	if added.Note.Location != "c0p1" || added.Note.HighlightID == nil || *added.Note.HighlightID != added.Highlight.ID {
+		t.Fatalf("note: %+v", added.Note)
+	}
+
+	for name, req := range map[string]struct {
+		path string
+		body map[string]any
+		want int
+	}{
+		"bad location":      {"/api/bookmarks", map[string]any{"file_id": fileID, "location": "x"}, http.StatusBadRequest},
+		"not in library":    {"/api/bookmarks", map[string]any{"file_id": 999, "location": "c0p0"}, http.StatusNotFound},
+		"backwards range":   {"/api/highlights", map[string]any{"file_id": fileID, "start_paragraph": 2, "end_paragraph": 1}, http.StatusBadRequest},
+		"unknown color":     {"/api/highlights", map[string]any{"file_id": fileID, "end_char": 1, "color": "red"}, http.StatusBadRequest},
+		"empty note":        {"/api/notes", map[string]any{"file_id": fileID, "location": "c0p0", "text": " "}, http.StatusBadRequest},
+		"foreign highlight": {"/api/notes", map[string]any{"file_id": fileID, "highlight_id": 999, "text": "?"}, http.StatusNotFound},
+	} {
+		if code := sendJSON(t, h, http.MethodPost, req.path, initData, req.body, nil); code != req.want {
+			t.Fatalf("%s: got %d, want %d", name, code, req.want)
+		}
+	}
+
+	// Чужие записи не видны и не меняются.
+	other := buildSignedInitDataWithAlgo(t, "123456:ABCDEF", TelegramUser{ID: 7, Username: "other"}, time.Now(), true)
+	var list struct {
+		Highlights []db.Highlight `json:"highlights"`
+	}
+	if code := getJSON(t, h, "/api/highlights?file_id="+file, other, &list); code != http.StatusOK || len(list.Highlights) != 0 {
+		t.Fatalf("other user list: %d %+v", code, list.Highlights)
+	}
+	highlightPath := "/api/highlights/" + strconv.FormatInt(added.Highlight.ID, 10)
+	if code := sendJSON(t, h, http.MethodDelete, highlightPath, other, nil, nil); code != http.StatusNotFound {
+		t.Fatalf("other user delete: %d", code)
+	}
+
+	if code := sendJSON(t, h, http.MethodPut, highlightPath, initData, map[string]any{"color": "green"}, nil); code != http.StatusOK {
+		t.Fatalf("recolor: %d", code)
+	}
+	if code := getJSON(t, h, "/api/highlights?file_id="+file, initData, &list); code != http.StatusOK || len(list.Highlights) != 1 || list.Highlights[0].Color != "green" {
+		t.Fatalf("list: %d %+v", code, list.Highlights)
+	}
+
+	// Удаление выделения удаляет и заметки к нему.
+	if code := sendJSON(t, h, http.MethodDelete, highlightPath, initData, nil, nil); code != http.StatusOK {
+		t.Fatalf("delete: %d", code)
+	}
+	var notes struct {
+		Notes []db.Note `json:"notes"`
+	}
+	if code := getJSON(t, h, "/api/notes?file_id="+file, initData, &notes); code != http.StatusOK || len(notes.Notes) != 0 {
+		t.Fatalf("notes after delete: %d %+v", code, notes.Notes)
+	}
+	if code := sendJSON(t, h, http.MethodDelete, "/api/bookmarks/abc", initData, nil, nil); code != http.StatusNotFound {
+		t.Fatalf("bad id: %d", code)
+	}
+}
@@ -0,0 +1,188 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tor_project/internal/db"
+	"tor_project/internal/reader"
+)
+
+// bookCacheSize — сколько разобранных книг держать в памяти: читатель листает главы подряд,
+// и разбирать файл заново на каждую главу незачем.
+const bookCacheSize = 8
+
+// bookCache — разобранные книги по ключу файла в хранилище (он же хэш содержимого).
+type bookCache struct {
+	mu    sync.Mutex
+	books map[string]*reader.Book
+	order []string
+}
+
+func (c *bookCache) get(key string) (*reader.Book, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	book, ok := c.books[key]
+	return book, ok
+}
+
+func (c *bookCache) put(key string, book *reader.Book) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.books == nil {
+		c.books = make(map[string]*reader.Book)
+	}
+	if _, ok := c.books[key]; ok {
+		return
+	}
+	if len(c.order) >= bookCacheSize {
+		delete(c.books, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.books[key] = book
+	c.order = append(c.order, key)
+}
+
+type tocChapter struct {
+	Index      int    `json:"index"`
+	Title      string `json:"title"`
+	Anchor     string `json:"anchor"`
+	Paragraphs int    `json:"paragraphs"`
+}
+
+type tocEntry struct {
+	Title   string `json:"title"`
+	Level   int    `json:"level"`
+	Chapter int    `json:"chapter"`
+	Anchor  string `json:"anchor"`
+}
+
+type tocResponse struct {
+	FileID   int64        `json:"file_id"`
+	Title    string       `json:"title"`
+	Chapters []tocChapter `json:"chapters"`
+	TOC      []tocEntry   `json:"toc"`
+}
+
+type chapterResponse struct {
+	Index  int    `json:"index"`
+	Title  string `json:"title"`
+	Anchor string `json:"anchor"`
+	Total  int    `json:"total"`
+	HTML   string `json:"html"`
+}
+
+// handleBook отдаёт книгу для читалки:
+//
+//	GET /api/books/{file_id}/toc          — главы и оглавление;
+//	GET /api/books/{file_id}/chapters/{n} — глава n (с нуля) очищенным HTML.
+func (s *Server) handleBook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/books/"), "/"), "/")
+	fileID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file id некорректен"})
+		return
+	}
+	chapter := -1
+	switch {
+	case len(parts) == 2 && parts[1] == "toc":
+	case len(parts) == 3 && parts[1] == "chapters":
+		if chapter, err = strconv.Atoi(parts[2]); err != nil || chapter < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "номер главы некорректен"})
+			return
+		}
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+
+	s.withUser(w, r, func(ctx context.Context, user TelegramUser) {
+		file, err := s.store.GetFileForUser(ctx, user.ID, fileID)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "файл не найден"})
+			return
+		}
+		book, err := s.openBook(ctx, file)
+		if errors.Is(err, reader.ErrUnsupported) {
+			writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("book %d: %v", file.ID, err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "не удалось открыть книгу"})
+			return
+		}
+
+		if chapter < 0 {
+			resp := tocResponse{FileID: file.ID, Title: book.Title, Chapters: []tocChapter{}, TOC: []tocEntry{}}
+			for _, ch := range book.Chapters {
+				resp.Chapters = append(resp.Chapters, tocChapter{Index: ch.Index, Title: ch.Title, Anchor: ch.Anchor, Paragraphs: ch.Paragraphs})
+			}
+			for _, e := range book.TOC {
+				resp.TOC = append(resp.TOC, tocEntry{Title: e.Title, Level: e.Level, Chapter: e.Chapter, Anchor: e.Anchor})
+			}
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+
+		if chapter >= len(book.Chapters) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "глава не найдена"})
+			return
+		}
+		ch := book.Chapters[chapter]
+		writeJSON(w, http.StatusOK, chapterResponse{
+			Index:  ch.Index,
+			Title:  ch.Title,
+			Anchor: ch.Anchor,
+			Total:  len(book.Chapters),
+			HTML:   ch.HTML,
+		})
+	})
+}
+
+// openBook читает файл из хранилища и разбирает его на главы (с кэшем).
+func (s *Server) openBook(ctx context.Context, file db.BookFile) (*reader.Book, error) {
+	if book, ok := s.books.get(file.Path); ok {
+		return book, nil
+	}
+	mimeType := file.MimeType
+	if mimeType == "" {
+		mimeType = reader.MimeTypeForFormat(file.Format)
+	}
+	if mimeType != "application/x-fictionbook+xml" && mimeType != "application/epub+zip" {
+		return nil, reader.ErrUnsupported
+	}
+
+	if err := s.store.TouchBookFile(ctx, file.ID, time.Now()); err != nil {
+		log.Printf("TouchBookFile error: %v", err)
+	}
+	body, err := s.blobs.Get(ctx, file.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+
+	book, err := reader.Open(data, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	s.books.put(file.Path, book)
+	return book, nil
+}
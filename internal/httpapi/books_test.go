@@ -0,0 +1,113 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"tor_project/internal/db"
+	"tor_project/internal/storage"
+)
+
+const testBook = `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>1984</book-title></title-info></description>
+<body>
+ <section><title><p>Глава 1</p></title><p>Первый абзац.</p><p onclick="x()">Второй &lt;b&gt;абзац&lt;/b&gt;.</p></section>
+ <section><title><p>Глава 2</p></title><p>Третий.</p></section>
+</body></FictionBook>`
+
+// newTestServer возвращает сервер с одной книгой в библиотеке пользователя 42 и initData для него.
+func newTestServer(t *testing.T) (*Server, int64, string) {
+	t.Helper()
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := db.Open(filepath.Join(dir, "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	blobs := storage.NewFSStore(filepath.Join(dir, "books"))
+
+	saved, err := storage.SaveBookFile(ctx, blobs, bytes.NewReader([]byte(testBook)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookID, err := store.UpsertBook(ctx, "1", "1984", "Оруэлл")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileID, err := store.InsertBookFile(ctx, bookID, db.BookFile{Path: saved.RelativePath, Format: "fb2", SizeBytes: saved.SizeBytes, SHA256: saved.SHA256, MimeType: saved.MimeType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := TelegramUser{ID: 42, Username: "reader"}
+	if err := store.EnsureUser(ctx, user.ID, user.Username); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddToLibrary(ctx, user.ID, fileID); err != nil {
+		t.Fatal(err)
+	}
+
+	token := "123456:ABCDEF"
+	return New(store, blobs, token), fileID, buildSignedInitDataWithAlgo(t, token, user, time.Now(), true)
+}
+
+func getJSON(t *testing.T, h http.Handler, path string, initData string, v any) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-Telegram-InitData", initData)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestBookChapters(t *testing.T) {
+	srv, fileID, initData := newTestServer(t)
+	h := srv.Handler()
+	base := "/api/books/" + strconv.FormatInt(fileID, 10)
+
+	var toc tocResponse
+	if code := getJSON(t, h, base+"/toc", initData, &toc); code != http.StatusOK {
+		t.Fatalf("toc: %d", code)
+	}
+	if len(toc.Chapters) != 2 || toc.Chapters[1].Title != "Глава 2" || toc.Chapters[0].Paragraphs != 3 {
+		t.Fatalf("toc: %+v", toc)
+	}
+	if len(toc.TOC) != 2 || toc.TOC[1].Anchor != "c1p0" {
+		t.Fatalf("toc entries: %+v", toc.TOC)
+	}
+
+	var ch chapterResponse
+	if code := getJSON(t, h, base+"/chapters/0", initData, &ch); code != http.StatusOK {
+		t.Fatalf("chapter: %d", code)
+	}
+	if ch.Total != 2 || !strings.Contains(ch.HTML, `<p id="c0p2">Второй &lt;b&gt;абзац&lt;/b&gt;.</p>`) || strings.Contains(ch.HTML, "onclick") {
+		t.Fatalf("chapter: %+v", ch)
+	}
+
+	for path, want := range map[string]int{
+		base + "/chapters/5":  http.StatusNotFound,
+		base + "/chapters/-1": http.StatusBadRequest,
+		base + "/pages":       http.StatusNotFound,
+		"/api/books/999/toc":  http.StatusNotFound,
+	} {
+		if code := getJSON(t, h, path, initData, nil); code != want {
+			t.Fatalf("%s: got %d, want %d", path, code, want)
+		}
+	}
+	if code := getJSON(t, h, base+"/toc", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("without initData: %d", code)
+	}
+}
@@ -0,0 +1,126 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"tor_project/internal/db"
+	"tor_project/internal/reader"
+)
+
+// maxDeviceLen — ограничение на имя устройства от клиента (в символах).
+const maxDeviceLen = 64
+
+type progressRequest struct {
+	FileID int64 `json:"file_id"`
+	// Location — якорь абзаца ("c3p12"); Chapter и Offset нужны, только если его нет.
+	Location string  `json:"location"`
+	Chapter  int     `json:"chapter"`
+	Offset   int     `json:"offset"`
+	Percent  float64 `json:"percent"`
+	Device   string  `json:"device"`
+	// BaseUpdatedAt — updated_at позиции, от которой устройство продолжило чтение.
+	BaseUpdatedAt int64 `json:"base_updated_at"`
+	// Force — перезаписать позицию, даже если её сменило другое устройство.
+	Force bool `json:"force"`
+}
+
+// handleProgress — позиция чтения:
+//
+//	GET  /api/progress?file_id=N — текущая позиция ({"progress": null}, если её нет);
+//	POST /api/progress           — сохранить позицию. Если с base_updated_at позицию
+//	                               сохранило другое устройство, ответ 409 с её текущим значением.
+func (s *Server) handleProgress(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		fileID, err := strconv.ParseInt(r.URL.Query().Get("file_id"), 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file id некорректен"})
+			return
+		}
+		s.withUser(w, r, func(ctx context.Context, user TelegramUser) {
+			p, ok, err := s.store.GetProgress(ctx, user.ID, fileID)
+			if errors.Is(err, db.ErrNotInLibrary) {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "файл не найден"})
+				return
+			}
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			if !ok {
+				writeJSON(w, http.StatusOK, map[string]any{"progress": nil})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"progress": p})
+		})
+	case http.MethodPost:
+		s.withUser(w, r, func(ctx context.Context, user TelegramUser) {
+			var body progressRequest
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+				return
+			}
+			if body.FileID == 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file_id пустой"})
+				return
+			}
+
+			p := s.progressFromRequest(ctx, user.ID, body)
+			saved, err := s.store.SaveProgress(ctx, user.ID, body.FileID, p, body.BaseUpdatedAt, body.Force)
+			switch {
+			case errors.Is(err, db.ErrProgressConflict):
+				writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error(), "progress": saved})
+			case errors.Is(err, db.ErrNotInLibrary):
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "файл не найден"})
+			case err != nil:
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			default:
+				writeJSON(w, http.StatusOK, map[string]any{"ok": true, "progress": saved})
+			}
+		})
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// progressFromRequest приводит позицию от клиента к общему виду. Процент по возможности
+// считается на сервере по абзацам книги, чтобы устройства с разной вёрсткой его не расходились.
+func (s *Server) progressFromRequest(ctx context.Context, userID int64, body progressRequest) db.Progress {
+	p := db.Progress{
+		Location: strings.TrimSpace(body.Location),
+		Percent:  min(max(body.Percent, 0), 100),
+		Device:   strings.TrimSpace(body.Device),
+	}
+	if device := []rune(p.Device); len(device) > maxDeviceLen {
+		p.Device = string(device[:maxDeviceLen])
+	}
+	if p.Location == "" {
+		p.Location = reader.ParagraphAnchor(max(body.Chapter, 0), max(body.Offset, 0))
+	}
+	chapter, paragraph, ok := reader.ParseAnchor(p.Location)
+	if !ok {
+		// Старые клиенты присылали произвольную строку: сохраняем её как есть.
+		return p
+	}
+	p.Chapter, p.Offset = chapter, paragraph
+
+	file, err := s.store.GetFileForUser(ctx, userID, body.FileID)
+	if err != nil {
+		return p
+	}
+	book, err := s.openBook(ctx, file)
+	if err != nil {
+		if !errors.Is(err, reader.ErrUnsupported) {
+			log.Printf("progress %d: %v", file.ID, err)
+		}
+		return p
+	}
+	p.Percent = book.Percent(chapter, paragraph)
+	return p
+}
@@ -0,0 +1,94 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"tor_project/internal/db"
+)
+
+func postProgress(t *testing.T, h http.Handler, initData string, body progressRequest) (int, db.Progress) {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/api/progress", bytes.NewReader(data))
+	req.Header.Set("X-Telegram-InitData", initData)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var resp struct {
+		Progress db.Progress `json:"progress"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec.Code, resp.Progress
+}
+
+func TestProgressSync(t *testing.T) {
+	srv, fileID, initData := newTestServer(t)
+	h := srv.Handler()
+
+	var got struct {
+		Progress *db.Progress `json:"progress"`
+	}
+	if code := getJSON(t, h, "/api/progress?file_id="+strconv.FormatInt(fileID, 10), initData, &got); code != http.StatusOK || got.Progress != nil {
+		t.Fatalf("empty progress: %d %+v", code, got.Progress)
+	}
+
+	// Процент считает сервер: абзацев в главах 3 и 2.
+	code, phone := postProgress(t, h, initData, progressRequest{FileID: fileID, Location: "c0p1", Percent: 99, Device: "phone"})
+	if code != http.StatusOK || phone.Chapter != 0 || phone.Offset != 1 || phone.Percent != 40 || phone.UpdatedAt == 0 {
+		t.Fatalf("phone: %d %+v", code, phone)
+	}
+
+	// Планшет не видел позицию телефона — конфликт, в ответе позиция телефона.
+	code, current := postProgress(t, h, initData, progressRequest{FileID: fileID, Location: "c1p0", Device: "tablet"})
+	if code != http.StatusConflict || current != phone {
+		t.Fatalf("tablet conflict: %d %+v", code, current)
+	}
+	code, tablet := postProgress(t, h, initData, progressRequest{FileID: fileID, Location: "c1p0", Device: "tablet", BaseUpdatedAt: phone.UpdatedAt})
+	if code != http.StatusOK || tablet.Percent != 80 || tablet.UpdatedAt <= phone.UpdatedAt {
+		t.Fatalf("tablet: %d %+v", code, tablet)
+	}
+
+	// Телефон отстал: без force конфликт, с force — перезапись.
+	if code, _ := postProgress(t, h, initData, progressRequest{FileID: fileID, Location: "c0p2", Device: "phone", BaseUpdatedAt: phone.UpdatedAt}); code != http.StatusConflict {
+		t.Fatalf("stale phone: %d", code)
+	}
+	code, forced := postProgress(t, h, initData, progressRequest{FileID: fileID, Location: "c0p2", Device: "phone", BaseUpdatedAt: phone.UpdatedAt, Force: true})
+	if code != http.StatusOK || forced.Location != "c0p2" {
+		t.Fatalf("forced: %d %+v", code, forced)
+	}
+	// Своё же устройство конфликтом не считается.
+	if code, _ := postProgress(t, h, initData, progressRequest{FileID: fileID, Location: "c0p0", Device: "phone"}); code != http.StatusOK {
+		t.Fatalf("same device: %d", code)
+	}
+
+	// Безымянные устройства друг друга не узнают: второе получает конфликт.
+	code, anonymous := postProgress(t, h, initData, progressRequest{FileID: fileID, Location: "c0p1", Force: true})
+	if code != http.StatusOK {
+		t.Fatalf("anonymous: %d", code)
+	}
+	if code, _ := postProgress(t, h, initData, progressRequest{FileID: fileID, Location: "c1p1"}); code != http.StatusConflict {
+		t.Fatalf("second anonymous: %d", code)
+	}
+	// Длинное имя обрезается по символам, а не посреди буквы.
+	code, named := postProgress(t, h, initData, progressRequest{FileID: fileID, Location: "c0p0", Device: strings.Repeat("ы", 100), BaseUpdatedAt: anonymous.UpdatedAt})
+	if code != http.StatusOK || named.Device != strings.Repeat("ы", maxDeviceLen) {
+		t.Fatalf("long device: %d %q", code, named.Device)
+	}
+
+	var library []db.LibraryItem
+	if code := getJSON(t, h, "/api/library", initData, &library); code != http.StatusOK {
+		t.Fatalf("library: %d", code)
+	}
+	if len(library) != 1 || library[0].Progress == nil || library[0].Progress.Percent != 20 {
+		t.Fatalf("library: %+v", library)
+	}
+
+	if code, _ := postProgress(t, h, initData, progressRequest{FileID: 999, Location: "c0p0"}); code != http.StatusNotFound {
+		t.Fatalf("unknown file: %d", code)
+	}
+}
@@ -0,0 +1,81 @@
+package httpapi
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"tor_project/internal/db"
+)
+
+// Ограничения поиска по библиотеке: длина запроса (в байтах) и число книг в ответе.
+const (
+	maxSearchQuery     = 200
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+type searchResult struct {
+	FileID int64  `json:"file_id"`
+	BookID int64  `json:"book_id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Format string `json:"format"`
+	// Snippet и TextSnippet — HTML: текст экранирован, найденные слова обёрнуты в <mark>.
+	Snippet     string `json:"snippet,omitempty"`
+	TextSnippet string `json:"text_snippet,omitempty"`
+}
+
+var snippetMarks = strings.NewReplacer(db.SnippetOpen, "<mark>", db.SnippetClose, "</mark>")
+
+// handleLibrarySearch — полнотекстовый поиск по своей библиотеке:
+//
+//	GET /api/library/search?q=...&limit=N — книги по убыванию релевантности со сниппетами
+//	                                        (сначала совпадения в названии, авторе и аннотации, потом в тексте).
+func (s *Server) handleLibrarySearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	s.withUser(w, r, func(ctx context.Context, user TelegramUser) {
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "пустой запрос"})
+			return
+		}
+		if len(query) > maxSearchQuery {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "слишком длинный запрос"})
+			return
+		}
+		limit := defaultSearchLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit некорректен"})
+				return
+			}
+			limit = min(n, maxSearchLimit)
+		}
+
+		hits, err := s.store.SearchLibrary(ctx, user.ID, query, limit)
+		results := make([]searchResult, 0, len(hits))
+		for _, hit := range hits {
+			results = append(results, searchResult{
+				FileID:      hit.FileID,
+				BookID:      hit.BookID,
+				Title:       hit.Title,
+				Author:      hit.Author,
+				Format:      hit.Format,
+				Snippet:     snippetHTML(hit.Snippet),
+				TextSnippet: snippetHTML(hit.TextSnippet),
+			})
+		}
+		writeResult(w, map[string]any{"results": results}, err)
+	})
+}
+
+func snippetHTML(snippet string) string {
+	return snippetMarks.Replace(html.EscapeString(snippet))
+}
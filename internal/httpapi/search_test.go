@@ -0,0 +1,48 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLibrarySearch(t *testing.T) {
+	srv, fileID, initData := newTestServer(t)
+	h := srv.Handler()
+
+	var resp struct {
+		Results []searchResult `json:"results"`
+	}
+	if code := getJSON(t, h, "/api/library/search?q=оруэл", initData, &resp); code != http.StatusOK {
+		t.Fatalf("search: %d", code)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].FileID != fileID || resp.Results[0].Snippet != "<mark>Оруэлл</mark>" {
+		t.Fatalf("results = %+v", resp.Results)
+	}
+
+	// Текст книги экранируется, найденные слова выделены.
+	if err := srv.store.SetBookText(context.Background(), fileID, "Министерство <правды> лжёт."); err != nil {
+		t.Fatal(err)
+	}
+	resp.Results = nil
+	if code := getJSON(t, h, "/api/library/search?q=правды", initData, &resp); code != http.StatusOK {
+		t.Fatalf("search: %d", code)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].Snippet != "" || resp.Results[0].TextSnippet != "Министерство &lt;<mark>правды</mark>&gt; лжёт." {
+		t.Fatalf("results = %+v", resp.Results)
+	}
+
+	// Чужая библиотека не видна.
+	other := buildSignedInitDataWithAlgo(t, "123456:ABCDEF", TelegramUser{ID: 7, Username: "other"}, time.Now(), true)
+	if code := getJSON(t, h, "/api/library/search?q=1984", other, &resp); code != http.StatusOK || len(resp.Results) != 0 {
+		t.Fatalf("other user: %d %+v", code, resp.Results)
+	}
+
+	if code := getJSON(t, h, "/api/library/search?q=+", initData, nil); code != http.StatusBadRequest {
+		t.Fatalf("empty query: %d", code)
+	}
+	if code := getJSON(t, h, "/api/library/search?q=1984&limit=x", initData, nil); code != http.StatusBadRequest {
+		t.Fatalf("bad limit: %d", code)
+	}
+}
@@ -5,17 +5,20 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
-	"path/filepath"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"tor_project/internal/db"
+	"tor_project/internal/storage"
 )
 
 type Server struct {
-	store      *db.Store
-	storageDir string
-	botToken   string
+	store    *db.Store
+	blobs    storage.BlobStore
+	botToken string
+	books    bookCache
 }
 
 type statusRecorder struct {
@@ -28,11 +31,11 @@ func (sr *statusRecorder) WriteHeader(code int) {
 	sr.ResponseWriter.WriteHeader(code)
 }
 
-func New(store *db.Store, storageDir string, botToken string) *Server {
+func New(store *db.Store, blobs storage.BlobStore, botToken string) *Server {
 	return &Server{
-		store:      store,
-		storageDir: storageDir,
-		botToken:   botToken,
+		store:    store,
+		blobs:    blobs,
+		botToken: botToken,
 	}
 }
 
@@ -40,8 +43,22 @@ func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/health", s.handleHealth)
 	mux.HandleFunc("/api/library", s.handleLibrary)
+	mux.HandleFunc("/api/library/search", s.handleLibrarySearch)
 	mux.HandleFunc("/api/files/", s.handleFile)
+	mux.HandleFunc("/api/books/", s.handleBook)
 	mux.HandleFunc("/api/progress", s.handleProgress)
+	mux.HandleFunc("/api/bookmarks", s.handleBookmarks)
+	mux.HandleFunc("/api/bookmarks/", s.handleBookmarks)
+	mux.HandleFunc("/api/highlights", s.handleHighlights)
+	mux.HandleFunc("/api/highlights/", s.handleHighlights)
+	mux.HandleFunc("/api/notes", s.handleNotes)
+	mux.HandleFunc("/api/notes/", s.handleNotes)
+	mux.HandleFunc("/api/shelves", s.handleShelves)
+	mux.HandleFunc("/api/shelves/", s.handleShelves)
+	mux.HandleFunc("/api/statuses", s.handleStatuses)
+	mux.HandleFunc("/api/statuses/", s.handleStatuses)
+	mux.HandleFunc("/api/tags", s.handleTags)
+	mux.HandleFunc("/api/tags/", s.handleTags)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
 		mux.ServeHTTP(rec, r)
@@ -84,36 +101,25 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		fullPath := filepath.Join(s.storageDir, file.Path)
-		setContentType(w, file.Format)
-		http.ServeFile(w, r, fullPath)
-	})
-}
-
-func (s *Server) handleProgress(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
-		return
-	}
-
-	s.withUser(w, r, func(ctx context.Context, user TelegramUser) {
-		var body struct {
-			FileID   int64  `json:"file_id"`
-			Location string `json:"location"`
+		if err := s.store.TouchBookFile(ctx, file.ID, time.Now()); err != nil {
+			log.Printf("TouchBookFile error: %v", err)
 		}
-		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
-			return
-		}
-		if body.FileID == 0 {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file_id пустой"})
+
+		// ServeContent сам отвечает на Range-запросы; из хранилища читаются только нужные байты.
+		content, info, err := storage.OpenBlob(ctx, s.blobs, file.Path)
+		if err != nil {
+			log.Printf("file: open %s error: %v", file.Path, err)
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "файл не найден"})
 			return
 		}
-		if err := s.store.UpdateProgress(ctx, user.ID, body.FileID, body.Location); err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
-			return
+		defer content.Close()
+
+		if file.MimeType != "" {
+			w.Header().Set("Content-Type", file.MimeType)
+		} else {
+			setContentType(w, file.Format)
 		}
-		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+		http.ServeContent(w, r, path.Base(file.Path), info.ModTime, content)
 	})
 }
 
@@ -0,0 +1,229 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"tor_project/internal/db"
+)
+
+// Ограничения на названия полок и теги (в байтах) и на число тегов у книги.
+const (
+	maxShelfName = 64
+	maxTagLen    = 48
+	maxBookTags  = 20
+)
+
+// handleShelves — полки. Книги на полках — это книги каталога (book_id), а не файлы:
+// на полку можно положить и нескачанную книгу.
+//
+//	GET    /api/shelves                      — полки с числом книг;
+//	POST   /api/shelves                      — создать ({name}); 409, если такая уже есть;
+//	GET    /api/shelves/{id}[/books]         — полка и её книги;
+//	PUT    /api/shelves/{id}                 — переименовать ({name});
+//	DELETE /api/shelves/{id}                 — удалить (книги остаются);
+//	POST   /api/shelves/{id}/books           — положить книгу ({book_id});
+//	DELETE /api/shelves/{id}/books/{book_id} — снять книгу.
+func (s *Server) handleShelves(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/shelves"), "/"), "/")
+	var shelfID, bookID int64
+	var err error
+	if parts[0] != "" || len(parts) > 1 {
+		if shelfID, err = strconv.ParseInt(parts[0], 10, 64); err != nil || shelfID <= 0 || len(parts) > 3 || len(parts) > 1 && parts[1] != "books" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return
+		}
+	}
+	if len(parts) == 3 {
+		if bookID, err = strconv.ParseInt(parts[2], 10, 64); err != nil || bookID <= 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return
+		}
+	}
+	books := len(parts) > 1
+
+	s.withUser(w, r, func(ctx context.Context, user TelegramUser) {
+		switch {
+		case shelfID == 0 && r.Method == http.MethodGet:
+			shelves, err := s.store.ListShelves(ctx, user.ID)
+			writeResult(w, map[string]any{"shelves": shelves}, err)
+		case shelfID == 0 && r.Method == http.MethodPost:
+			name, ok := decodeShelfName(w, r)
+			if !ok {
+				return
+			}
+			shelf, err := s.store.CreateShelf(ctx, user.ID, name)
+			writeResult(w, map[string]any{"shelf": shelf}, err)
+		case bookID == 0 && r.Method == http.MethodGet:
+			shelf, err := s.store.GetShelf(ctx, user.ID, shelfID)
+			if err != nil {
+				writeResult(w, nil, err)
+				return
+			}
+			list, err := s.store.ShelfBooks(ctx, user.ID, shelfID)
+			writeResult(w, map[string]any{"shelf": shelf, "books": list}, err)
+		case !books && r.Method == http.MethodPut:
+			name, ok := decodeShelfName(w, r)
+			if !ok {
+				return
+			}
+			writeResult(w, map[string]any{"ok": true}, s.store.RenameShelf(ctx, user.ID, shelfID, name))
+		case !books && r.Method == http.MethodDelete:
+			writeResult(w, map[string]any{"ok": true}, s.store.DeleteShelf(ctx, user.ID, shelfID))
+		case books && bookID == 0 && r.Method == http.MethodPost:
+			var body struct {
+				BookID int64 `json:"book_id"`
+			}
+			if !decodeJSON(w, r, &body) {
+				return
+			}
+			writeResult(w, map[string]any{"ok": true}, s.store.AddToShelf(ctx, user.ID, shelfID, body.BookID))
+		case books && bookID != 0 && r.Method == http.MethodDelete:
+			writeResult(w, map[string]any{"ok": true}, s.store.RemoveFromShelf(ctx, user.ID, shelfID, bookID))
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		}
+	})
+}
+
+// handleStatuses — статусы чтения (want, reading, finished, abandoned):
+//
+//	GET    /api/statuses           — число книг в каждом статусе;
+//	GET    /api/statuses?status=S  — книги в статусе;
+//	PUT    /api/statuses/{book_id} — задать статус ({status}; пустой снимает);
+//	DELETE /api/statuses/{book_id} — снять статус.
+func (s *Server) handleStatuses(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := resourceID(w, r, "/api/statuses")
+	if !ok {
+		return
+	}
+	s.withUser(w, r, func(ctx context.Context, user TelegramUser) {
+		switch {
+		case bookID == 0 && r.Method == http.MethodGet:
+			status := r.URL.Query().Get("status")
+			if status == "" {
+				counts, err := s.store.StatusCounts(ctx, user.ID)
+				writeResult(w, map[string]any{"counts": counts}, err)
+				return
+			}
+			if !db.ValidStatus(status) {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "неизвестный статус"})
+				return
+			}
+			books, err := s.store.BooksByStatus(ctx, user.ID, status)
+			writeResult(w, map[string]any{"books": books}, err)
+		case bookID != 0 && r.Method == http.MethodPut:
+			var body struct {
+				Status string `json:"status"`
+			}
+			if !decodeJSON(w, r, &body) {
+				return
+			}
+			if body.Status != "" && !db.ValidStatus(body.Status) {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "неизвестный статус"})
+				return
+			}
+			writeResult(w, map[string]any{"ok": true}, s.store.SetStatus(ctx, user.ID, bookID, body.Status))
+		case bookID != 0 && r.Method == http.MethodDelete:
+			writeResult(w, map[string]any{"ok": true}, s.store.SetStatus(ctx, user.ID, bookID, ""))
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		}
+	})
+}
+
+// handleTags — теги:
+//
+//	GET /api/tags           — теги с числом книг;
+//	GET /api/tags?tag=T     — книги с тегом;
+//	PUT /api/tags/{book_id} — заменить теги книги ({tags: [...]}).
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := resourceID(w, r, "/api/tags")
+	if !ok {
+		return
+	}
+	s.withUser(w, r, func(ctx context.Context, user TelegramUser) {
+		switch {
+		case bookID == 0 && r.Method == http.MethodGet:
+			if tag := normalizeTag(r.URL.Query().Get("tag")); tag != "" {
+				books, err := s.store.BooksByTag(ctx, user.ID, tag)
+				writeResult(w, map[string]any{"books": books}, err)
+				return
+			}
+			tags, err := s.store.ListTags(ctx, user.ID)
+			writeResult(w, map[string]any{"tags": tags}, err)
+		case bookID != 0 && r.Method == http.MethodPut:
+			var body struct {
+				Tags []string `json:"tags"`
+			}
+			if !decodeJSON(w, r, &body) {
+				return
+			}
+			tags, msg := normalizeTags(body.Tags)
+			if msg != "" {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+				return
+			}
+			writeResult(w, map[string]any{"tags": tags}, s.store.SetTags(ctx, user.ID, bookID, tags))
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		}
+	})
+}
+
+func decodeShelfName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return "", false
+	}
+	name := strings.TrimSpace(stripControl(body.Name))
+	switch {
+	case name == "":
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "название полки пустое"})
+		return "", false
+	case len(name) > maxShelfName:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "слишком длинное название"})
+		return "", false
+	}
+	return name, true
+}
+
+// normalizeTag приводит тег к одному виду: без "#" и пробелов по краям, в нижнем регистре.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(stripControl(tag)), "#")))
+}
+
+// normalizeTags нормализует теги и убирает повторы; непустое сообщение — ошибка для клиента.
+func normalizeTags(raw []string) ([]string, string) {
+	seen := make(map[string]bool)
+	tags := []string{}
+	for _, tag := range raw {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLen {
+			return nil, "слишком длинный тег"
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxBookTags {
+		return nil, "слишком много тегов"
+	}
+	return tags, ""
+}
+
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
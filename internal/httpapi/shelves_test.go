@@ -0,0 +1,93 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"tor_project/internal/db"
+	"tor_project/internal/models"
+)
+
+func TestShelvesStatusesTags(t *testing.T) {
+	srv, _, initData := newTestServer(t)
+	h := srv.Handler()
+
+	// Вторая книга не скачана — её карточка есть только в каталоге.
+	bookID, err := srv.store.SaveBookDetails(context.Background(), models.BookDetails{ID: "2", Title: "Скотный двор", Author: "Оруэлл"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := strconv.FormatInt(bookID, 10)
+
+	var created struct {
+		Shelf db.Shelf `json:"shelf"`
+	}
+	if code := sendJSON(t, h, http.MethodPost, "/api/shelves", initData, map[string]any{"name": " Антиутопии "}, &created); code != http.StatusOK || created.Shelf.Name != "Антиутопии" {
+		t.Fatalf("create: %d %+v", code, created.Shelf)
+	}
+	if code := sendJSON(t, h, http.MethodPost, "/api/shelves", initData, map[string]any{"name": "Антиутопии"}, nil); code != http.StatusConflict {
+		t.Fatalf("duplicate: %d", code)
+	}
+	shelf := "/api/shelves/" + strconv.FormatInt(created.Shelf.ID, 10)
+	if code := sendJSON(t, h, http.MethodPost, shelf+"/books", initData, map[string]any{"book_id": bookID}, nil); code != http.StatusOK {
+		t.Fatalf("add: %d", code)
+	}
+	if code := sendJSON(t, h, http.MethodPost, shelf+"/books", initData, map[string]any{"book_id": 999}, nil); code != http.StatusNotFound {
+		t.Fatalf("add unknown book: %d", code)
+	}
+
+	if code := sendJSON(t, h, http.MethodPut, "/api/statuses/"+book, initData, map[string]any{"status": "reading"}, nil); code != http.StatusOK {
+		t.Fatalf("status: %d", code)
+	}
+	if code := sendJSON(t, h, http.MethodPut, "/api/statuses/"+book, initData, map[string]any{"status": "someday"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("bad status: %d", code)
+	}
+	var tags struct {
+		Tags []string `json:"tags"`
+	}
+	if code := sendJSON(t, h, http.MethodPut, "/api/tags/"+book, initData, map[string]any{"tags": []string{"#Сатира", "сатира", " классика "}}, &tags); code != http.StatusOK || len(tags.Tags) != 2 {
+		t.Fatalf("tags: %d %v", code, tags.Tags)
+	}
+
+	var got struct {
+		Shelf db.Shelf      `json:"shelf"`
+		Books []db.UserBook `json:"books"`
+	}
+	if code := getJSON(t, h, shelf, initData, &got); code != http.StatusOK || got.Shelf.Books != 1 || len(got.Books) != 1 {
+		t.Fatalf("shelf: %d %+v", code, got)
+	}
+	if b := got.Books[0]; b.Title != "Скотный двор" || b.Status != "reading" || len(b.Tags) != 2 || b.Tags[0] != "классика" || b.FileID != 0 {
+		t.Fatalf("shelf book: %+v", b)
+	}
+	if code := getJSON(t, h, "/api/tags?tag=%23Сатира", initData, &got); code != http.StatusOK || len(got.Books) != 1 {
+		t.Fatalf("by tag: %d %+v", code, got.Books)
+	}
+	if code := getJSON(t, h, "/api/statuses?status=reading", initData, &got); code != http.StatusOK || len(got.Books) != 1 {
+		t.Fatalf("by status: %d %+v", code, got.Books)
+	}
+
+	// Чужие полки не видны.
+	other := buildSignedInitDataWithAlgo(t, "123456:ABCDEF", TelegramUser{ID: 7, Username: "other"}, time.Now(), true)
+	if code := getJSON(t, h, shelf, other, nil); code != http.StatusNotFound {
+		t.Fatalf("other user: %d", code)
+	}
+	if code := sendJSON(t, h, http.MethodPost, shelf+"/books", other, map[string]any{"book_id": bookID}, nil); code != http.StatusNotFound {
+		t.Fatalf("other user add: %d", code)
+	}
+
+	if code := sendJSON(t, h, http.MethodDelete, shelf+"/books/"+book, initData, nil, nil); code != http.StatusOK {
+		t.Fatalf("remove: %d", code)
+	}
+	if code := sendJSON(t, h, http.MethodDelete, shelf, initData, nil, nil); code != http.StatusOK {
+		t.Fatalf("delete: %d", code)
+	}
+	var shelves struct {
+		Shelves []db.Shelf `json:"shelves"`
+	}
+	if code := getJSON(t, h, "/api/shelves", initData, &shelves); code != http.StatusOK || len(shelves.Shelves) != 0 {
+		t.Fatalf("shelves: %d %+v", code, shelves.Shelves)
+	}
+}
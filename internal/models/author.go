@@ -0,0 +1,10 @@
+package models
+
+// Author describes a writer page on the site (/a/<id>).
+type Author struct {
+	ID   string
+	Name string
+
+	// BookCount is the number of books shown next to the author in search results (0 if unknown).
+	BookCount int
+}
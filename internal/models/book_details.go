@@ -19,4 +19,22 @@ type BookDetails struct {
 	CoverPath string
 
 	Formats []BookFormatOption
+
+	// Annotation is the plain-text book description.
+	Annotation string
+	Genres     []string
+
+	// Year is the publication year, 0 if unknown.
+	Year       int
+	Language   string
+	Translator string
+
+	// Series is the series title, SeriesNumber is the volume in it (0 if unknown).
+	SeriesID     string
+	Series       string
+	SeriesNumber int
+
+	// Pages is the page count (0 if unknown), Size is the file size as shown on the site, e.g. "345 Кб".
+	Pages int
+	Size  string
 }
@@ -0,0 +1,19 @@
+package models
+
+// SearchResult is a /booksearch page split into its sections.
+type SearchResult struct {
+	Books   []Book
+	Authors []Author
+	Series  []Series
+
+	// Totals come from the section headings ("Найденные книги (1 - 50 из 223)").
+	// They can be larger than the slices because the site pages long result lists.
+	TotalBooks   int
+	TotalAuthors int
+	TotalSeries  int
+}
+
+// IsEmpty reports whether no section contains anything.
+func (r SearchResult) IsEmpty() bool {
+	return len(r.Books) == 0 && len(r.Authors) == 0 && len(r.Series) == 0
+}
@@ -0,0 +1,21 @@
+package models
+
+// Series describes a book series page on the site (/sequence/<id>).
+type Series struct {
+	ID    string
+	Title string
+
+	// BookCount is the number of books shown next to the series in search results (0 if unknown).
+	BookCount int
+
+	// Books are the series volumes in reading order (filled only for a series page).
+	Books []SeriesBook
+}
+
+// SeriesBook is a book inside a series together with its volume number.
+type SeriesBook struct {
+	Book
+
+	// Number is the volume number in the series, 0 if the site doesn't show it.
+	Number int
+}
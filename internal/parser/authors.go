@@ -0,0 +1,121 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"tor_project/internal/models"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var (
+	bookHrefRe   = regexp.MustCompile(`^/b/(\d+)/?$`)
+	authorHrefRe = regexp.MustCompile(`^/a/(\d+)/?$`)
+	bookCountRe  = regexp.MustCompile(`\((\d+)\s+книг`)
+)
+
+// ParseAuthors extracts the "authors found" entries from a /booksearch page.
+func ParseAuthors(body io.Reader) ([]models.Author, error) {
+	result, err := ParseSearchResult(body)
+	if err != nil {
+		return nil, err
+	}
+	return result.Authors, nil
+}
+
+func parseAuthorItem(s *goquery.Selection) (models.Author, bool) {
+	if s.Find("a[href^='/b/']").Length() > 0 || s.Find("a[href^='/sequence/']").Length() > 0 {
+		return models.Author{}, false
+	}
+
+	link := s.Find("a[href^='/a/']").First()
+	href, _ := link.Attr("href")
+	m := authorHrefRe.FindStringSubmatch(strings.TrimSpace(href))
+	if len(m) != 2 {
+		return models.Author{}, false
+	}
+
+	name := normalizeAuthor(link.Text())
+	if name == "" {
+		return models.Author{}, false
+	}
+
+	return models.Author{
+		ID:        m[1],
+		Name:      name,
+		BookCount: parseBookCount(s.Text()),
+	}, true
+}
+
+// ParseAuthorBooks parses an author page (/a/<id>) and returns the author and the list of their books.
+// The page groups books by genre/series; we flatten it and keep the site order.
+func ParseAuthorBooks(body io.Reader, authorID string) (models.Author, []models.Book, error) {
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return models.Author{}, nil, fmt.Errorf("ошибка чтения HTML: %w", err)
+	}
+
+	author := models.Author{ID: authorID}
+
+	name := strings.TrimSpace(doc.Find("h1.title").First().Text())
+	if name == "" {
+		name = strings.TrimSpace(doc.Find("title").First().Text())
+	}
+	author.Name = normalizeFlibustaTitle(name)
+
+	content := doc.Find("#main").First()
+	if content.Length() == 0 {
+		content = doc.Find("body")
+	}
+
+	var books []models.Book
+	seen := make(map[string]struct{})
+
+	content.Find("a[href^='/b/']").Each(func(_ int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		m := bookHrefRe.FindStringSubmatch(strings.TrimSpace(href))
+		if len(m) != 2 {
+			return
+		}
+
+		id := m[1]
+		if _, ok := seen[id]; ok {
+			return
+		}
+
+		title := strings.TrimSpace(a.Text())
+		if title == "" {
+			return
+		}
+		seen[id] = struct{}{}
+
+		bookAuthor := author.Name
+		if bookAuthor == "" {
+			bookAuthor = "Неизвестен"
+		}
+
+		books = append(books, models.Book{
+			ID:     id,
+			Title:  title,
+			Author: bookAuthor,
+		})
+	})
+
+	author.BookCount = len(books)
+	return author, books, nil
+}
+
+func parseBookCount(text string) int {
+	m := bookCountRe.FindStringSubmatch(text)
+	if len(m) != 2 {
+		return 0
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0
+	}
+	return n
+}
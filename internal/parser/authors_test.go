@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+const authorsSearchFixture = `<html><body><div id="main">
+<h3> Найденные писатели (1 - 3 из 3):</h3>
+<ul>
+<li><a href="/a/9162">Джордж Оруэлл</a> (123 книги)</li>
+<li><a href="/a/77/">[Соня Оруэлл]</a> (1 книга)</li>
+<li><a href="/a/5">Оруэлл (псевдоним)</a></li>
+<li><a href="/a/6/edit">Ссылка на правку</a> (2 книги)</li>
+</ul>
+</div></body></html>`
+
+const authorPageFixture = `<html><head><title>Джордж Оруэлл | Флибуста</title></head><body>
+<div id="main">
+<h1 class="title">Джордж Оруэлл</h1>
+<h3>Антиутопия</h3>
+<a href="/b/609286">1984</a> [<a href="/b/609286/fb2">fb2</a>] [<a href="/b/609286/read">читать</a>]<br/>
+<a href="/b/609286">1984</a> (другое издание)<br/>
+<h3>Сказки</h3>
+<a href="/b/42/">Скотный двор</a> <a href="/a/9162">Джордж Оруэлл</a><br/>
+<a href="/b/43"> </a><br/>
+</div>
+<div id="sidebar"><a href="/b/1">Новинка сайта</a></div>
+</body></html>`
+
+func TestParseAuthors(t *testing.T) {
+	authors, err := ParseAuthors(strings.NewReader(authorsSearchFixture))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	var got []string
+	for _, a := range authors {
+		got = append(got, a.ID+"|"+a.Name)
+	}
+	if strings.Join(got, ",") != "9162|Джордж Оруэлл,77|Соня Оруэлл,5|Оруэлл (псевдоним)" {
+		t.Fatalf("authors: %q", got)
+	}
+	if authors[0].BookCount != 123 || authors[1].BookCount != 1 || authors[2].BookCount != 0 {
+		t.Fatalf("book counts: %+v", authors)
+	}
+}
+
+func TestParseAuthorBooks(t *testing.T) {
+	author, books, err := ParseAuthorBooks(strings.NewReader(authorPageFixture), "9162")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if author.ID != "9162" || author.Name != "Джордж Оруэлл" || author.BookCount != 2 {
+		t.Fatalf("author: %+v", author)
+	}
+
+	// Ссылки на форматы, повтор издания, пустое название и книги из сайдбара не попадают.
+	var got []string
+	for _, b := range books {
+		got = append(got, b.ID+"|"+b.Title+"|"+b.Author)
+	}
+	if strings.Join(got, ",") != "609286|1984|Джордж Оруэлл,42|Скотный двор|Джордж Оруэлл" {
+		t.Fatalf("books: %q", got)
+	}
+}
+
+func TestParseAuthorBooksWithoutHeading(t *testing.T) {
+	page := `<html><head><title>Флибуста</title></head><body><a href="/b/7">Мы</a></body></html>`
+
+	author, books, err := ParseAuthorBooks(strings.NewReader(page), "5")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if author.Name != "" || len(books) != 1 || books[0].Author != "Неизвестен" {
+		t.Fatalf("author %+v, books %+v", author, books)
+	}
+}
+
+func TestParseBookCount(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"(123 книги)", 123},
+		{"Джордж Оруэлл (1 книга)", 1},
+		{"(5   книг)", 5},
+		{"(5 томов)", 0},
+		{"123 книги", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := parseBookCount(tt.text); got != tt.want {
+			t.Errorf("parseBookCount(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
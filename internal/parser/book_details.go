@@ -4,14 +4,23 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"strconv"
 	"strings"
 	"tor_project/internal/models"
 
 	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
 )
 
 var (
 	sizeInParensRe = regexp.MustCompile(`\(([^)]+)\)\s*$`)
+
+	yearRe       = regexp.MustCompile(`(?i)(?:год издания|издани[ея]|издано)[^0-9]{0,20}((?:1[5-9]|20)\d\d)`)
+	languageRe   = regexp.MustCompile(`(?i)язык(?: книги)?\s*:\s*([\p{L}-]+)`)
+	pagesRe      = regexp.MustCompile(`(\d+)\s*(?:с\.|стр\.|страниц)`)
+	fileSizeRe   = regexp.MustCompile(`(?i)(\d+(?:[.,]\d+)?\s*(?:кб|мб|kb|mb))`)
+	seriesNumRe  = regexp.MustCompile(`^\s*(?:-|№|#)\s*(\d+)`)
+	whitespaceRe = regexp.MustCompile(`\s+`)
 )
 
 func normalizeFlibustaTitle(title string) string {
@@ -32,8 +41,16 @@ func normalizeFlibustaTitle(title string) string {
 
 func normalizeAuthor(text string) string {
 	text = strings.TrimSpace(text)
-	text = strings.Trim(text, "[]()")
-	text = strings.TrimSpace(text)
+	// Скобки вокруг имени ("[Автор]") снимаем, а пояснение в конце ("Бахтин (иллюстратор)") оставляем целым.
+	for len(text) >= 2 && (text[0] == '[' && text[len(text)-1] == ']' || text[0] == '(' && text[len(text)-1] == ')') {
+		text = strings.TrimSpace(text[1 : len(text)-1])
+	}
+	if !strings.ContainsAny(text, "[(") {
+		text = strings.TrimSpace(strings.TrimRight(text, "])"))
+	}
+	if !strings.ContainsAny(text, "])") {
+		text = strings.TrimSpace(strings.TrimLeft(text, "[("))
+	}
 
 	if text == "" {
 		return ""
@@ -189,5 +206,123 @@ func ParseBookDetails(body io.Reader, bookID string) (models.BookDetails, error)
 	})
 
 	details.Formats = opts
+
+	parseBookMeta(doc, content, &details)
 	return details, nil
 }
+
+// parseBookMeta fills annotation, genres, series, translator and other optional fields.
+// Every field is best-effort and left empty when the markup doesn't match.
+func parseBookMeta(doc *goquery.Document, content *goquery.Selection, details *models.BookDetails) {
+	if content.Length() == 0 {
+		content = doc.Find("body")
+	}
+
+	// Annotation: <h2>Аннотация</h2> followed by paragraphs up to the next heading/form.
+	content.Find("h2, h3").EachWithBreak(func(_ int, h *goquery.Selection) bool {
+		if !strings.Contains(strings.ToLower(h.Text()), "аннотац") {
+			return true
+		}
+		var parts []string
+		h.NextUntil("h2, h3, form, div, table").Each(func(_ int, p *goquery.Selection) {
+			if text := cleanText(p.Text()); text != "" {
+				parts = append(parts, text)
+			}
+		})
+		details.Annotation = strings.Join(parts, "\n\n")
+		return false
+	})
+
+	// Genres: links to /g/<genre>.
+	seenGenres := make(map[string]struct{})
+	content.Find("a[href^='/g/'], a.genre").Each(func(_ int, a *goquery.Selection) {
+		genre := cleanText(a.Text())
+		if genre == "" {
+			return
+		}
+		if _, ok := seenGenres[genre]; ok {
+			return
+		}
+		seenGenres[genre] = struct{}{}
+		details.Genres = append(details.Genres, genre)
+	})
+
+	// Series: "(<a href="/sequence/123">Серия</a> - 2)".
+	content.Find("a[href^='/sequence/']").EachWithBreak(func(_ int, a *goquery.Selection) bool {
+		href, _ := a.Attr("href")
+		m := seriesHrefRe.FindStringSubmatch(strings.TrimSpace(href))
+		if len(m) != 2 {
+			return true
+		}
+		details.SeriesID = m[1]
+		details.Series = cleanText(a.Text())
+
+		if len(a.Nodes) > 0 {
+			if next := a.Nodes[0].NextSibling; next != nil && next.Type == html.TextNode {
+				if n := seriesNumRe.FindStringSubmatch(next.Data); len(n) == 2 {
+					details.SeriesNumber, _ = strconv.Atoi(n[1])
+				}
+			}
+		}
+		return false
+	})
+
+	// Translator: "(перевод: <a href="/a/..">Имя</a>, ...)".
+	content.Find("a[href^='/a/']").Each(func(_ int, a *goquery.Selection) {
+		if len(a.Nodes) == 0 {
+			return
+		}
+		if !precededByTranslation(a.Nodes[0]) {
+			return
+		}
+		name := normalizeAuthor(a.Text())
+		if name == "" {
+			return
+		}
+		if details.Translator == "" {
+			details.Translator = name
+		} else {
+			details.Translator += ", " + name
+		}
+	})
+
+	text := cleanText(content.Text())
+	if m := yearRe.FindStringSubmatch(text); len(m) == 2 {
+		details.Year, _ = strconv.Atoi(m[1])
+	}
+	if m := languageRe.FindStringSubmatch(text); len(m) == 2 {
+		details.Language = m[1]
+	}
+	if m := pagesRe.FindStringSubmatch(text); len(m) == 2 {
+		details.Pages, _ = strconv.Atoi(m[1])
+	}
+	if m := fileSizeRe.FindStringSubmatch(text); len(m) == 2 {
+		details.Size = m[1]
+	}
+}
+
+// precededByTranslation reports whether the link belongs to a "перевод:" list,
+// i.e. the nearest preceding text (skipping other links and commas) mentions a translation.
+func precededByTranslation(n *html.Node) bool {
+	for prev := n.PrevSibling; prev != nil; prev = prev.PrevSibling {
+		switch prev.Type {
+		case html.TextNode:
+			data := strings.ToLower(prev.Data)
+			if strings.Contains(data, "перевод") {
+				return true
+			}
+			if strings.Trim(data, " ,\n\t") != "" {
+				return false
+			}
+		case html.ElementNode:
+			if prev.Data != "a" {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+func cleanText(text string) string {
+	return strings.TrimSpace(whitespaceRe.ReplaceAllString(text, " "))
+}
@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+const bookPageFixture = `<html><head><title>1984 | Флибуста</title></head><body>
+<div id="main">
+<h1 class="title">1984 (fb2)</h1>
+<a href="/a/9162">Джордж Оруэлл</a> (перевод: <a href="/a/111">Виктор Голышев</a>, <a href="/a/112">Дмитрий Иванов</a>)<br/>
+<p class="genre"><a href="/g/sf_social" class="genre">Социальная фантастика</a>, <a href="/g/dystopian" class="genre">Антиутопия</a></p>
+(<a href="/sequence/555">Антиутопии</a> - 3)<br/>
+Размер: 345 Кб, 256 с. Язык: ru. Год издания: 2014<br/>
+<a href="/b/609286/fb2">(fb2)</a> <a href="/b/609286/epub">(epub)</a> <a href="/b/609286/read">(читать)</a>
+<h2>Аннотация</h2>
+<p>Своеобразный антипод второй великой антиутопии XX века.</p>
+<p>Роман о тоталитарном обществе.</p>
+<form><input type="submit"/></form>
+</div></body></html>`
+
+func TestParseBookDetailsMeta(t *testing.T) {
+	details, err := ParseBookDetails(strings.NewReader(bookPageFixture), "609286")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	checks := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"author", details.Author, "Джордж Оруэлл"},
+		{"translator", details.Translator, "Виктор Голышев, Дмитрий Иванов"},
+		{"genres", strings.Join(details.Genres, "|"), "Социальная фантастика|Антиутопия"},
+		{"series", details.Series, "Антиутопии"},
+		{"series id", details.SeriesID, "555"},
+		{"series number", details.SeriesNumber, 3},
+		{"year", details.Year, 2014},
+		{"language", details.Language, "ru"},
+		{"pages", details.Pages, 256},
+		{"size", details.Size, "345 Кб"},
+		{"annotation", details.Annotation, "Своеобразный антипод второй великой антиутопии XX века.\n\nРоман о тоталитарном обществе."},
+		{"formats", len(details.Formats), 2},
+	}
+
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package parser
 import (
 	"fmt"
 	"io"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
@@ -12,44 +14,157 @@ import (
 	"tor_project/internal/models"
 )
 
+var sectionTotalRe = regexp.MustCompile(`из\s+(\d+)`)
+
+type sectionKind int
+
+const (
+	sectionUnknown sectionKind = iota
+	sectionBooks
+	sectionAuthors
+	sectionSeries
+)
+
 // ParseBooks принимает поток данных (HTML) и возвращает список книг.
 func ParseBooks(body io.Reader) ([]models.Book, error) {
+	result, err := ParseSearchResult(body)
+	if err != nil {
+		return nil, err
+	}
+	return result.Books, nil
+}
+
+// ParseSearchResult разбирает страницу поиска (/booksearch) по разделам:
+// книги, писатели и серии, вместе со счётчиками "из N" из заголовков.
+func ParseSearchResult(body io.Reader) (models.SearchResult, error) {
 	doc, err := goquery.NewDocumentFromReader(body)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка чтения HTML: %w", err)
+		return models.SearchResult{}, fmt.Errorf("ошибка чтения HTML: %w", err)
 	}
+	return parseSearchDocument(doc), nil
+}
 
-	var books []models.Book
+func parseSearchDocument(doc *goquery.Document) models.SearchResult {
+	var result models.SearchResult
+	foundHeadings := false
 
-	// Логика поиска по DOM-дереву
-	doc.Find("li").Each(func(i int, s *goquery.Selection) {
-		// Ищем ссылку на книгу (/b/...)
-		link := s.Find("a[href^='/b/']")
-		if link.Length() == 0 {
+	// Каждый раздел — это <h3>Найденные ... (1 - N из M):</h3>, за которым идёт <ul>.
+	doc.Find("h3").Each(func(_ int, h *goquery.Selection) {
+		heading := strings.TrimSpace(h.Text())
+		kind := detectSection(heading)
+		if kind == sectionUnknown {
 			return
 		}
+		foundHeadings = true
 
-		title := link.Text()
-		href, _ := link.Attr("href")
-		id := strings.TrimPrefix(href, "/b/")
+		items := h.NextUntil("h3").Filter("ul").Find("li")
+		switch kind {
+		case sectionBooks:
+			items.Each(func(_ int, li *goquery.Selection) {
+				if book, ok := parseBookItem(li); ok {
+					result.Books = append(result.Books, book)
+				}
+			})
+			result.TotalBooks = parseSectionTotal(heading, len(result.Books))
+		case sectionAuthors:
+			items.Each(func(_ int, li *goquery.Selection) {
+				if author, ok := parseAuthorItem(li); ok {
+					result.Authors = append(result.Authors, author)
+				}
+			})
+			result.TotalAuthors = parseSectionTotal(heading, len(result.Authors))
+		case sectionSeries:
+			items.Each(func(_ int, li *goquery.Selection) {
+				if series, ok := parseSeriesItem(li); ok {
+					result.Series = append(result.Series, series)
+				}
+			})
+			result.TotalSeries = parseSectionTotal(heading, len(result.Series))
+		}
+	})
 
-		// Ищем автора (/a/...)
-		var authors []string
-		s.Find("a[href^='/a/']").Each(func(_ int, a *goquery.Selection) {
-			authors = append(authors, a.Text())
-		})
+	if foundHeadings {
+		return result
+	}
 
-		authorStr := "Неизвестен"
-		if len(authors) > 0 {
-			authorStr = strings.Join(authors, ", ")
+	// Разметка без заголовков (старая тема или другой зеркальный сайт): угадываем раздел по ссылкам.
+	doc.Find("li").Each(func(_ int, li *goquery.Selection) {
+		if book, ok := parseBookItem(li); ok {
+			result.Books = append(result.Books, book)
+			return
 		}
+		if series, ok := parseSeriesItem(li); ok {
+			result.Series = append(result.Series, series)
+			return
+		}
+		if author, ok := parseAuthorItem(li); ok {
+			result.Authors = append(result.Authors, author)
+		}
+	})
+	result.TotalBooks = len(result.Books)
+	result.TotalAuthors = len(result.Authors)
+	result.TotalSeries = len(result.Series)
+	return result
+}
+
+func detectSection(heading string) sectionKind {
+	low := strings.ToLower(heading)
+	if !strings.Contains(low, "найден") {
+		return sectionUnknown
+	}
+	switch {
+	case strings.Contains(low, "книг"):
+		return sectionBooks
+	case strings.Contains(low, "писател"), strings.Contains(low, "автор"):
+		return sectionAuthors
+	case strings.Contains(low, "сери"):
+		return sectionSeries
+	}
+	return sectionUnknown
+}
+
+func parseSectionTotal(heading string, fallback int) int {
+	m := sectionTotalRe.FindStringSubmatch(heading)
+	if len(m) != 2 {
+		return fallback
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n < fallback {
+		return fallback
+	}
+	return n
+}
+
+func parseBookItem(s *goquery.Selection) (models.Book, bool) {
+	// Ищем ссылку на книгу (/b/...)
+	link := s.Find("a[href^='/b/']").First()
+	href, _ := link.Attr("href")
+	m := bookHrefRe.FindStringSubmatch(strings.TrimSpace(href))
+	if len(m) != 2 {
+		return models.Book{}, false
+	}
+
+	title := strings.TrimSpace(link.Text())
+	if title == "" {
+		return models.Book{}, false
+	}
 
-		books = append(books, models.Book{
-			ID:     id,
-			Title:  title,
-			Author: authorStr,
-		})
+	// Ищем автора (/a/...)
+	var authors []string
+	s.Find("a[href^='/a/']").Each(func(_ int, a *goquery.Selection) {
+		if name := strings.TrimSpace(a.Text()); name != "" {
+			authors = append(authors, name)
+		}
 	})
 
-	return books, nil
+	authorStr := "Неизвестен"
+	if len(authors) > 0 {
+		authorStr = strings.Join(authors, ", ")
+	}
+
+	return models.Book{
+		ID:     m[1],
+		Title:  title,
+		Author: authorStr,
+	}, true
 }
@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+const searchPageFixture = `<html><body>
+<ul class="links primary-links"><li><a href="/polka">Книжная полка</a></li></ul>
+<div id="main">
+<div class="item-list"><ul class="pager"><li class="pager-current first">1</li>
+<li class="pager-item"><a href="/booksearch?page=1&amp;ask=1984">2</a></li></ul></div>
+<h3> Найденные писатели (1 - 1 из 1):</h3>
+<ul><li><a href="/a/9162">Джордж Оруэлл</a> (123 книги)</li></ul>
+<h3> Найденные серии (1 - 2 из 2):</h3>
+<ul><li><a href="/sequence/56251"><span>1984</span></a> (1 книга)</li>
+<li><a href="/sequence/89934">Проект <span>1984</span></a> (4 книги)</li></ul>
+<h3> Найденные книги (1 - 2 из 223):</h3>
+<ul><li><a href="/b/609286"><span>1984</span></a> - <a href="/a/9162">Джордж Оруэлл</a></li>
+<li><a href="/b/823638">Волшебник Изумрудного города [1984]</a> - <a href="/a/20868">Александр Волков</a>, <a href="/a/312969">Виктор Бахтин (иллюстратор)</a></li></ul>
+</div>
+<div id="sidebar"><ul><li><a href="/b/1">Новинка без автора</a></li></ul></div>
+</body></html>`
+
+func TestParseSearchResultSections(t *testing.T) {
+	result, err := ParseSearchResult(strings.NewReader(searchPageFixture))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if len(result.Books) != 2 || result.TotalBooks != 223 {
+		t.Fatalf("books: got %d (total %d), want 2 (total 223)", len(result.Books), result.TotalBooks)
+	}
+	if got := result.Books[1].Author; got != "Александр Волков, Виктор Бахтин (иллюстратор)" {
+		t.Fatalf("unexpected authors: %q", got)
+	}
+
+	if len(result.Authors) != 1 || result.Authors[0].ID != "9162" || result.Authors[0].BookCount != 123 {
+		t.Fatalf("unexpected authors section: %+v", result.Authors)
+	}
+
+	if len(result.Series) != 2 || result.Series[1].Title != "Проект 1984" || result.Series[1].BookCount != 4 {
+		t.Fatalf("unexpected series section: %+v", result.Series)
+	}
+}
+
+func TestParseSearchResultWithoutHeadings(t *testing.T) {
+	page := `<ul><li><a href="/b/42">Мы</a> - <a href="/a/7">Евгений Замятин</a></li>
+<li><a href="/sequence/5">Антиутопии</a> (3 книги)</li>
+<li><a href="/a/7">Евгений Замятин</a></li></ul>`
+
+	result, err := ParseSearchResult(strings.NewReader(page))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(result.Books) != 1 || len(result.Series) != 1 || len(result.Authors) != 1 {
+		t.Fatalf("unexpected fallback result: %+v", result)
+	}
+}
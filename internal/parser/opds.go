@@ -0,0 +1,292 @@
+package parser
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"tor_project/internal/models"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	opdsRelAcquisition = "http://opds-spec.org/acquisition"
+	opdsRelImage       = "http://opds-spec.org/image"
+)
+
+var (
+	opdsBookHrefRe   = regexp.MustCompile(`^/b/(\d+)(?:/([^/?#]+))?/?$`)
+	opdsAuthorHrefRe = regexp.MustCompile(`/author/(\d+)`)
+	opdsAuthorTagRe  = regexp.MustCompile(`^tag:author:(\d+)$`)
+	opdsSeriesHrefRe = regexp.MustCompile(`/sequencebooks/(\d+)`)
+)
+
+// opdsSeriesIndexPath is the prefix of the series index navigation (/opds/sequencesindex/<prefix>).
+const opdsSeriesIndexPath = "/opds/sequencesindex/"
+
+// OPDSFeed is a parsed OPDS (Atom) catalog page.
+type OPDSFeed struct {
+	Title   string      `xml:"title"`
+	Links   []OPDSLink  `xml:"link"`
+	Entries []OPDSEntry `xml:"entry"`
+}
+
+// OPDSEntry is a single Atom entry: a book in acquisition feeds or a navigation item otherwise.
+type OPDSEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Authors    []OPDSAuthor   `xml:"author"`
+	Categories []OPDSCategory `xml:"category"`
+	Content    string         `xml:"content"`
+	Language   string         `xml:"http://purl.org/dc/terms/ language"`
+	Issued     string         `xml:"http://purl.org/dc/terms/ issued"`
+	Links      []OPDSLink     `xml:"link"`
+}
+
+// OPDSAuthor is an Atom <author> element.
+type OPDSAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri"`
+}
+
+// OPDSCategory is an Atom <category> element (genre on Flibusta).
+type OPDSCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+// OPDSLink is an Atom <link> element.
+type OPDSLink struct {
+	Href  string `xml:"href,attr"`
+	Rel   string `xml:"rel,attr"`
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr"`
+}
+
+// ParseOPDSFeed parses an OPDS catalog page. A standalone <entry> document
+// (an OPDS complete catalog entry) is returned as a feed with that single entry.
+func ParseOPDSFeed(body io.Reader) (OPDSFeed, error) {
+	decoder := xml.NewDecoder(body)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return OPDSFeed{}, fmt.Errorf("ошибка чтения OPDS: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		var feed OPDSFeed
+		if start.Name.Local == "entry" {
+			var entry OPDSEntry
+			if err := decoder.DecodeElement(&entry, &start); err != nil {
+				return OPDSFeed{}, fmt.Errorf("ошибка чтения OPDS: %w", err)
+			}
+			feed.Entries = []OPDSEntry{entry}
+			return feed, nil
+		}
+		if err := decoder.DecodeElement(&feed, &start); err != nil {
+			return OPDSFeed{}, fmt.Errorf("ошибка чтения OPDS: %w", err)
+		}
+		return feed, nil
+	}
+}
+
+// Next returns the href of the next page of the feed, if any.
+func (f OPDSFeed) Next() string {
+	for _, l := range f.Links {
+		if l.Rel == "next" {
+			return strings.TrimSpace(l.Href)
+		}
+	}
+	return ""
+}
+
+// Books returns all book entries of the feed (entries without a /b/<id> link are skipped).
+func (f OPDSFeed) Books() []models.BookDetails {
+	var books []models.BookDetails
+	for _, e := range f.Entries {
+		if details, ok := e.BookDetails(); ok {
+			books = append(books, details)
+		}
+	}
+	return books
+}
+
+// Authors returns author navigation entries of the feed (authors search results).
+func (f OPDSFeed) Authors() []models.Author {
+	var authors []models.Author
+	for _, e := range f.Entries {
+		id := ""
+		if m := opdsAuthorTagRe.FindStringSubmatch(strings.TrimSpace(e.ID)); len(m) == 2 {
+			id = m[1]
+		}
+		for _, l := range e.Links {
+			if id != "" {
+				break
+			}
+			if m := opdsAuthorHrefRe.FindStringSubmatch(l.Href); len(m) == 2 {
+				id = m[1]
+			}
+		}
+		if id == "" {
+			continue
+		}
+
+		name := strings.TrimSpace(e.Title)
+		if name == "" {
+			continue
+		}
+
+		authors = append(authors, models.Author{
+			ID:        id,
+			Name:      name,
+			BookCount: parseBookCount("(" + strings.TrimSpace(e.Content) + ")"),
+		})
+	}
+	return authors
+}
+
+// Series returns series navigation entries of the feed (links to /opds/sequencebooks/<id>).
+func (f OPDSFeed) Series() []models.Series {
+	var series []models.Series
+	for _, e := range f.Entries {
+		title := strings.TrimSpace(e.Title)
+		if title == "" {
+			continue
+		}
+		for _, l := range e.Links {
+			if m := opdsSeriesHrefRe.FindStringSubmatch(l.Href); len(m) == 2 {
+				series = append(series, models.Series{
+					ID:        m[1],
+					Title:     title,
+					BookCount: parseBookCount("(" + strings.TrimSpace(e.Content) + ")"),
+				})
+				break
+			}
+		}
+	}
+	return series
+}
+
+// SeriesIndex returns links to narrower pages of the series index: when a title prefix
+// matches too many series, the catalog lists longer prefixes instead of the series.
+func (f OPDSFeed) SeriesIndex() []string {
+	var links []string
+	for _, e := range f.Entries {
+		for _, l := range e.Links {
+			href := strings.TrimSpace(l.Href)
+			if strings.HasPrefix(href, opdsSeriesIndexPath) {
+				links = append(links, href)
+				break
+			}
+		}
+	}
+	return links
+}
+
+// BookID extracts the site book ID from the entry links.
+func (e OPDSEntry) BookID() string {
+	for _, l := range e.Links {
+		if m := opdsBookHrefRe.FindStringSubmatch(strings.TrimSpace(l.Href)); len(m) >= 2 {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// BookDetails converts a book entry into models.BookDetails.
+// CoverPath is returned as-is (relative to the site root).
+func (e OPDSEntry) BookDetails() (models.BookDetails, bool) {
+	id := e.BookID()
+	if id == "" {
+		return models.BookDetails{}, false
+	}
+
+	details := models.BookDetails{
+		ID:    id,
+		Title: strings.TrimSpace(e.Title),
+	}
+
+	var authors []string
+	for _, a := range e.Authors {
+		if name := strings.TrimSpace(a.Name); name != "" {
+			authors = append(authors, name)
+		}
+	}
+	details.Author = strings.Join(authors, ", ")
+
+	details.Annotation = e.annotation()
+	details.Language = strings.TrimSpace(e.Language)
+	if year, err := strconv.Atoi(strings.TrimSpace(e.Issued)); err == nil {
+		details.Year = year
+	}
+	for _, c := range e.Categories {
+		genre := strings.TrimSpace(c.Label)
+		if genre == "" {
+			genre = strings.TrimSpace(c.Term)
+		}
+		if genre != "" {
+			details.Genres = append(details.Genres, genre)
+		}
+	}
+
+	seen := make(map[string]struct{})
+	for _, l := range e.Links {
+		href := strings.TrimSpace(l.Href)
+		switch {
+		case strings.HasPrefix(l.Rel, opdsRelImage):
+			if details.CoverPath == "" || l.Rel == opdsRelImage {
+				details.CoverPath = href
+			}
+		case strings.HasPrefix(l.Rel, opdsRelAcquisition):
+			m := opdsBookHrefRe.FindStringSubmatch(href)
+			if len(m) != 3 || m[2] == "" {
+				continue
+			}
+			format := m[2]
+			switch strings.ToLower(format) {
+			case "read", "edit", "comments":
+				continue
+			}
+			if _, ok := seen[format]; ok {
+				continue
+			}
+			seen[format] = struct{}{}
+
+			details.Formats = append(details.Formats, models.BookFormatOption{
+				Path:  format,
+				Label: strings.ToUpper(format),
+			})
+		}
+	}
+
+	return details, true
+}
+
+// annotation returns the entry content (HTML on Flibusta) as plain text.
+func (e OPDSEntry) annotation() string {
+	content := strings.TrimSpace(e.Content)
+	if content == "" {
+		return ""
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return cleanText(content)
+	}
+
+	var parts []string
+	doc.Find("p").Each(func(_ int, p *goquery.Selection) {
+		if text := cleanText(p.Text()); text != "" {
+			parts = append(parts, text)
+		}
+	})
+	if len(parts) == 0 {
+		return cleanText(doc.Text())
+	}
+	return strings.Join(parts, "\n\n")
+}
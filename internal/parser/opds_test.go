@@ -0,0 +1,177 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+const opdsBooksFixture = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:dc="http://purl.org/dc/terms/" xmlns:os="http://a9.com/-/spec/opensearch/1.1/">
+<id>tag:search:books:1984</id>
+<title>Поиск книг: 1984</title>
+<link href="/opds/search?searchType=books&amp;searchTerm=1984&amp;pageNumber=1" rel="next" type="application/atom+xml;profile=opds-catalog"/>
+<entry>
+ <id>tag:book:3fa1</id>
+ <title>1984</title>
+ <author><name>Джордж Оруэлл</name><uri>/a/9162</uri></author>
+ <author><name> </name></author>
+ <category term="Социальная фантастика" label="Социальная фантастика"/>
+ <category term="dystopian"/>
+ <dc:language>ru</dc:language>
+ <dc:issued>2014</dc:issued>
+ <content type="text/html">&lt;p&gt;Своеобразный антипод второй великой антиутопии.&lt;/p&gt;&lt;p&gt;Роман о тоталитарном обществе.&lt;/p&gt;</content>
+ <link href="/i/86/609286/cover.jpg" rel="http://opds-spec.org/image/thumbnail" type="image/jpeg"/>
+ <link href="/i/86/609286/big.jpg" rel="http://opds-spec.org/image" type="image/jpeg"/>
+ <link href="/b/609286/fb2" rel="http://opds-spec.org/acquisition/open-access" type="application/fb2+zip"/>
+ <link href="/b/609286/epub" rel="http://opds-spec.org/acquisition/open-access" type="application/epub+zip"/>
+ <link href="/b/609286/fb2" rel="http://opds-spec.org/acquisition/open-access" type="application/fb2+zip"/>
+ <link href="/b/609286/read" rel="http://opds-spec.org/acquisition/open-access" type="text/html"/>
+ <link href="/b/609286" rel="alternate" type="text/html" title="Книга на сайте"/>
+</entry>
+<entry>
+ <id>tag:book:none</id>
+ <title>Запись без ссылки на книгу</title>
+ <link href="/opds/new/0/new" rel="subsection" type="application/atom+xml;profile=opds-catalog"/>
+</entry>
+</feed>`
+
+const opdsAuthorsFixture = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+<title>Поиск авторов: оруэлл</title>
+<entry>
+ <id>tag:author:9162</id>
+ <title>Оруэлл Джордж</title>
+ <content type="text">123 книги</content>
+ <link href="/opds/author/9162" type="application/atom+xml;profile=opds-catalog"/>
+</entry>
+<entry>
+ <id>tag:author:unknown</id>
+ <title>Оруэлл Соня</title>
+ <content type="text">2 книги</content>
+ <link href="/opds/author/77" type="application/atom+xml;profile=opds-catalog"/>
+</entry>
+<entry>
+ <id>tag:author:5</id>
+ <title> </title>
+</entry>
+</feed>`
+
+const opdsSeriesIndexFixture = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+<title>Серии на «Ант»</title>
+<entry>
+ <id>tag:sequences:Анти</id>
+ <title>Анти</title>
+ <content type="text">42 серии</content>
+ <link href="/opds/sequencesindex/%D0%90%D0%BD%D1%82%D0%B8" type="application/atom+xml;profile=opds-catalog"/>
+</entry>
+<entry>
+ <id>tag:sequence:555</id>
+ <title>Антология фантастики</title>
+ <content type="text">12 книг</content>
+ <link href="/opds/sequencebooks/555" type="application/atom+xml;profile=opds-catalog"/>
+</entry>
+</feed>`
+
+const opdsEntryFixture = `<?xml version="1.0" encoding="utf-8"?>
+<entry xmlns="http://www.w3.org/2005/Atom">
+ <title>Скотный двор</title>
+ <author><name>Джордж Оруэлл</name></author>
+ <content type="text">Сказка-притча.</content>
+ <link href="/b/42/mobi" rel="http://opds-spec.org/acquisition" type="application/x-mobipocket-ebook"/>
+ <link href="/b/42" rel="alternate" type="text/html"/>
+</entry>`
+
+func TestParseOPDSBooks(t *testing.T) {
+	feed, err := ParseOPDSFeed(strings.NewReader(opdsBooksFixture))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if next := feed.Next(); next != "/opds/search?searchType=books&searchTerm=1984&pageNumber=1" {
+		t.Fatalf("next: %q", next)
+	}
+
+	books := feed.Books()
+	if len(books) != 1 {
+		t.Fatalf("books: %+v", books)
+	}
+	book := books[0]
+
+	var formats []string
+	for _, f := range book.Formats {
+		formats = append(formats, f.Path+"="+f.Label)
+	}
+	checks := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"id", book.ID, "609286"},
+		{"title", book.Title, "1984"},
+		{"author", book.Author, "Джордж Оруэлл"},
+		{"genres", strings.Join(book.Genres, "|"), "Социальная фантастика|dystopian"},
+		{"language", book.Language, "ru"},
+		{"year", book.Year, 2014},
+		{"cover", book.CoverPath, "/i/86/609286/big.jpg"},
+		{"formats", strings.Join(formats, ","), "fb2=FB2,epub=EPUB"},
+		{"annotation", book.Annotation, "Своеобразный антипод второй великой антиутопии.\n\nРоман о тоталитарном обществе."},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestParseOPDSAuthors(t *testing.T) {
+	feed, err := ParseOPDSFeed(strings.NewReader(opdsAuthorsFixture))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	authors := feed.Authors()
+	if len(authors) != 2 {
+		t.Fatalf("authors: %+v", authors)
+	}
+	if authors[0].ID != "9162" || authors[0].Name != "Оруэлл Джордж" || authors[0].BookCount != 123 {
+		t.Fatalf("author from tag: %+v", authors[0])
+	}
+	// Без числового тега ID берётся из ссылки.
+	if authors[1].ID != "77" || authors[1].BookCount != 2 {
+		t.Fatalf("author from link: %+v", authors[1])
+	}
+}
+
+func TestParseOPDSSeriesIndex(t *testing.T) {
+	feed, err := ParseOPDSFeed(strings.NewReader(opdsSeriesIndexFixture))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	series := feed.Series()
+	if len(series) != 1 || series[0].ID != "555" || series[0].Title != "Антология фантастики" || series[0].BookCount != 12 {
+		t.Fatalf("series: %+v", series)
+	}
+	if index := feed.SeriesIndex(); len(index) != 1 || index[0] != "/opds/sequencesindex/%D0%90%D0%BD%D1%82%D0%B8" {
+		t.Fatalf("index: %q", index)
+	}
+}
+
+func TestParseOPDSEntry(t *testing.T) {
+	feed, err := ParseOPDSFeed(strings.NewReader(opdsEntryFixture))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	books := feed.Books()
+	if len(books) != 1 || books[0].ID != "42" || books[0].Title != "Скотный двор" || books[0].Annotation != "Сказка-притча." {
+		t.Fatalf("books: %+v", books)
+	}
+	if len(books[0].Formats) != 1 || books[0].Formats[0].Path != "mobi" {
+		t.Fatalf("formats: %+v", books[0].Formats)
+	}
+
+	if _, err := ParseOPDSFeed(strings.NewReader("<html><body>не OPDS")); err == nil {
+		t.Fatal("expected error for broken XML")
+	}
+}
@@ -0,0 +1,179 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"tor_project/internal/models"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+var (
+	seriesHrefRe   = regexp.MustCompile(`^/sequence/(\d+)/?$`)
+	volumeNumberRe = regexp.MustCompile(`(\d+)\.\s*$`)
+)
+
+// ParseSeriesResults extracts the "series found" entries from a /booksearch page.
+func ParseSeriesResults(body io.Reader) ([]models.Series, error) {
+	result, err := ParseSearchResult(body)
+	if err != nil {
+		return nil, err
+	}
+	return result.Series, nil
+}
+
+func parseSeriesItem(s *goquery.Selection) (models.Series, bool) {
+	link := s.Find("a[href^='/sequence/']").First()
+	href, _ := link.Attr("href")
+	m := seriesHrefRe.FindStringSubmatch(strings.TrimSpace(href))
+	if len(m) != 2 {
+		return models.Series{}, false
+	}
+
+	title := strings.TrimSpace(link.Text())
+	if title == "" {
+		return models.Series{}, false
+	}
+
+	return models.Series{
+		ID:        m[1],
+		Title:     title,
+		BookCount: parseBookCount(s.Text()),
+	}, true
+}
+
+// ParseSeries parses a series page (/sequence/<id>) and returns its volumes in reading order.
+// Volume numbers are taken from the "N." text right before each book link; books without
+// a number keep the site order and go after numbered ones.
+func ParseSeries(body io.Reader, seriesID string) (models.Series, error) {
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return models.Series{}, fmt.Errorf("ошибка чтения HTML: %w", err)
+	}
+
+	series := models.Series{ID: seriesID}
+
+	title := strings.TrimSpace(doc.Find("h1.title").First().Text())
+	if title == "" {
+		title = strings.TrimSpace(doc.Find("title").First().Text())
+	}
+	title = normalizeFlibustaTitle(title)
+	title = strings.TrimSpace(strings.TrimPrefix(title, "Серия"))
+	series.Title = strings.Trim(title, "«»\"-–: ")
+
+	content := doc.Find("#main").First()
+	if content.Length() == 0 {
+		content = doc.Find("body")
+	}
+	if content.Length() == 0 {
+		return series, nil
+	}
+
+	var books []models.SeriesBook
+	seen := make(map[string]struct{})
+	var textBefore strings.Builder
+	current := -1
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			textBefore.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if n.Data == "a" {
+				href := strings.TrimSpace(attr(n, "href"))
+
+				if m := bookHrefRe.FindStringSubmatch(href); len(m) == 2 {
+					id := m[1]
+					if _, ok := seen[id]; ok {
+						textBefore.Reset()
+						return
+					}
+					seen[id] = struct{}{}
+
+					book := models.SeriesBook{
+						Book: models.Book{ID: id, Title: strings.TrimSpace(nodeText(n))},
+					}
+					if m := volumeNumberRe.FindStringSubmatch(textBefore.String()); len(m) == 2 {
+						book.Number, _ = strconv.Atoi(m[1])
+					}
+
+					books = append(books, book)
+					current = len(books) - 1
+					textBefore.Reset()
+					return
+				}
+
+				if authorHrefRe.MatchString(href) && current >= 0 {
+					name := normalizeAuthor(nodeText(n))
+					if name != "" {
+						if books[current].Author == "" {
+							books[current].Author = name
+						} else {
+							books[current].Author += ", " + name
+						}
+					}
+					textBefore.Reset()
+					return
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(content.Nodes[0])
+
+	for i := range books {
+		if books[i].Author == "" {
+			books[i].Author = "Неизвестен"
+		}
+	}
+
+	sort.SliceStable(books, func(i, j int) bool {
+		return volumeOrder(books[i].Number) < volumeOrder(books[j].Number)
+	})
+
+	series.Books = books
+	series.BookCount = len(books)
+	return series, nil
+}
+
+func volumeOrder(n int) int {
+	if n <= 0 {
+		return math.MaxInt
+	}
+	return n
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func nodeText(n *html.Node) string {
+	var sb strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+	return sb.String()
+}
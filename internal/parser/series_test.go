@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+const seriesPageFixture = `<html><head><title>Серия «Дюна» | Флибуста</title></head><body>
+<div id="main">
+<h1 class="title">Серия: Дюна</h1>
+<form><input type="checkbox"/>3. <a href="/b/303">Дети Дюны</a> - <a href="/a/10">Фрэнк Герберт</a><br/></form>
+<p>1. <a href="/b/101">Дюна</a> - <a href="/a/10">Фрэнк Герберт</a></p>
+<p><a href="/b/999">Энциклопедия Дюны</a> - <a href="/a/11">Уиллис Макнелли</a>, <a href="/a/12">[Другие]</a></p>
+<p>2. <a href="/b/202">Мессия Дюны</a></p>
+<p>10. <a href="/b/1010">Охотники Дюны</a> - <a href="/a/13">Брайан Герберт</a>, <a href="/a/14">Кевин Андерсон</a></p>
+<p>1. <a href="/b/101">Дюна</a> (другое издание)</p>
+<p><a href="/b/888">Сборник без номера</a></p>
+</div>
+<div id="sidebar">1. <a href="/b/1">Новинка сайта</a></div>
+</body></html>`
+
+func TestParseSeriesVolumeOrder(t *testing.T) {
+	series, err := ParseSeries(strings.NewReader(seriesPageFixture), "77")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if series.ID != "77" || series.Title != "Дюна" || series.BookCount != 6 {
+		t.Fatalf("series: %+v", series)
+	}
+
+	// Номерные тома по номеру (10 после 3, а не после 1), без номера — в конце в порядке страницы.
+	var got []string
+	for _, b := range series.Books {
+		got = append(got, b.ID+"|"+b.Title+"|"+b.Author)
+	}
+	want := []string{
+		"101|Дюна|Фрэнк Герберт",
+		"202|Мессия Дюны|Неизвестен",
+		"303|Дети Дюны|Фрэнк Герберт",
+		"1010|Охотники Дюны|Брайан Герберт, Кевин Андерсон",
+		"999|Энциклопедия Дюны|Уиллис Макнелли, Другие",
+		"888|Сборник без номера|Неизвестен",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("books:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	numbers := []int{1, 2, 3, 10, 0, 0}
+	for i, b := range series.Books {
+		if b.Number != numbers[i] {
+			t.Errorf("%s: number %d, want %d", b.Title, b.Number, numbers[i])
+		}
+	}
+}
+
+func TestParseSeriesResults(t *testing.T) {
+	series, err := ParseSeriesResults(strings.NewReader(searchPageFixture))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(series) != 2 || series[0].ID != "56251" || series[0].Title != "1984" || series[0].BookCount != 1 {
+		t.Fatalf("series: %+v", series)
+	}
+}
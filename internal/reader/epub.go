@@ -0,0 +1,456 @@
+package reader
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// maxEPUBEntrySize — предел одного файла внутри EPUB (глава, оглавление, картинка).
+	maxEPUBEntrySize = 32 * 1024 * 1024
+	// maxEPUBUnpackedSize — предел всего, что распаковывается из одного EPUB.
+	maxEPUBUnpackedSize = 200 * 1024 * 1024
+)
+
+// FromEPUB делит EPUB на главы по spine: каждый XHTML-файл — глава. Названия глав
+// и оглавление берутся из nav (EPUB 3) или toc.ncx (EPUB 2).
+func FromEPUB(data []byte) (*Book, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения epub: %w", err)
+	}
+	e := &epubBook{files: make(map[string]*zip.File), mediaTypes: make(map[string]string)}
+	for _, f := range zr.File {
+		e.files[f.Name] = f
+	}
+
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := e.decodeXML("META-INF/container.xml", &container); err != nil {
+		return nil, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, fmt.Errorf("ошибка чтения epub: нет rootfile")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+
+	var pkg struct {
+		Title    string `xml:"metadata>title"`
+		Manifest []struct {
+			ID         string `xml:"id,attr"`
+			Href       string `xml:"href,attr"`
+			MediaType  string `xml:"media-type,attr"`
+			Properties string `xml:"properties,attr"`
+		} `xml:"manifest>item"`
+		Spine struct {
+			TOC   string `xml:"toc,attr"`
+			Items []struct {
+				IDRef string `xml:"idref,attr"`
+			} `xml:"itemref"`
+		} `xml:"spine"`
+	}
+	if err := e.decodeXML(opfPath, &pkg); err != nil {
+		return nil, err
+	}
+
+	type item struct{ path, mediaType string }
+	items := make(map[string]item)
+	navPath, ncxPath := "", ""
+	for _, it := range pkg.Manifest {
+		p := resolvePath(opfPath, it.Href)
+		items[it.ID] = item{path: p, mediaType: it.MediaType}
+		e.mediaTypes[p] = it.MediaType
+		if strings.Contains(" "+it.Properties+" ", " nav ") {
+			navPath = p
+		}
+		if it.ID == pkg.Spine.TOC || it.MediaType == "application/x-dtbncx+xml" {
+			ncxPath = p
+		}
+	}
+
+	b := newBuilder(strings.TrimSpace(pkg.Title))
+	var toc []pendingTOC
+	if navPath != "" {
+		toc = e.navTOC(navPath)
+	}
+	if len(toc) == 0 && ncxPath != "" {
+		toc = e.ncxTOC(ncxPath)
+	}
+	// Название главы — первый пункт оглавления, ведущий в её файл.
+	titles := make(map[string]string)
+	for _, t := range toc {
+		file, _, _ := strings.Cut(t.key, "#")
+		if _, ok := titles[file]; !ok {
+			titles[file] = t.title
+		}
+	}
+
+	for _, ref := range pkg.Spine.Items {
+		it, ok := items[ref.IDRef]
+		if !ok || (it.mediaType != "application/xhtml+xml" && it.mediaType != "text/html") {
+			continue
+		}
+		doc, err := e.readHTML(it.path)
+		if err != nil {
+			return nil, err
+		}
+		body := findElement(doc, "body")
+		if body == nil {
+			continue
+		}
+		title := titles[it.path]
+		if title == "" {
+			title = headingText(body)
+		}
+		c := b.newChapter(title)
+		c.mark(it.path)
+		e.file = it.path
+		e.children(c, c.root, body)
+	}
+
+	b.toc = toc
+	return b.finish(), nil
+}
+
+type epubBook struct {
+	files      map[string]*zip.File
+	mediaTypes map[string]string
+	// file — XHTML-файл, который сейчас разбирается (относительно него разрешаются ссылки).
+	file string
+	// unpacked — сколько байт уже распаковано из архива.
+	unpacked int64
+}
+
+// read распаковывает файл из архива. Защита от zip-бомб: файл больше maxEPUBEntrySize
+// не читается (размеру из заголовка не верим — чтение всё равно обрезается), а всего
+// распаковывается не больше maxEPUBUnpackedSize.
+func (e *epubBook) read(name string) ([]byte, error) {
+	f, ok := e.files[name]
+	if !ok {
+		return nil, fmt.Errorf("ошибка чтения epub: нет файла %s", name)
+	}
+	if f.UncompressedSize64 > maxEPUBEntrySize {
+		return nil, fmt.Errorf("ошибка чтения epub: файл %s больше %d байт", name, maxEPUBEntrySize)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения epub: %w", err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxEPUBEntrySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения epub: %w", err)
+	}
+	if len(data) > maxEPUBEntrySize {
+		return nil, fmt.Errorf("ошибка чтения epub: файл %s больше %d байт", name, maxEPUBEntrySize)
+	}
+	e.unpacked += int64(len(data))
+	if e.unpacked > maxEPUBUnpackedSize {
+		return nil, fmt.Errorf("ошибка чтения epub: распаковано больше %d байт", maxEPUBUnpackedSize)
+	}
+	return data, nil
+}
+
+func (e *epubBook) decodeXML(name string, v any) error {
+	data, err := e.read(name)
+	if err != nil {
+		return err
+	}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("ошибка разбора %s: %w", name, err)
+	}
+	return nil
+}
+
+func (e *epubBook) readHTML(name string) (*html.Node, error) {
+	data, err := e.read(name)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка разбора %s: %w", name, err)
+	}
+	return doc, nil
+}
+
+// navTOC читает оглавление EPUB 3: <nav epub:type="toc"> со вложенными <ol>.
+func (e *epubBook) navTOC(name string) []pendingTOC {
+	doc, err := e.readHTML(name)
+	if err != nil {
+		return nil
+	}
+	var nav *html.Node
+	walk(doc, func(n *html.Node) bool {
+		if n.Type == html.ElementNode && n.Data == "nav" && strings.Contains(attr(n, "epub:type")+" "+attr(n, "type"), "toc") {
+			nav = n
+			return false
+		}
+		return true
+	})
+	if nav == nil {
+		return nil
+	}
+
+	var out []pendingTOC
+	var list func(ol *html.Node, level int)
+	list = func(ol *html.Node, level int) {
+		for li := ol.FirstChild; li != nil; li = li.NextSibling {
+			if li.Type != html.ElementNode || li.Data != "li" {
+				continue
+			}
+			for c := li.FirstChild; c != nil; c = c.NextSibling {
+				switch {
+				case c.Type == html.ElementNode && c.Data == "a":
+					out = append(out, pendingTOC{title: nodeText(c), level: level, key: resolveKey(name, attr(c, "href"))})
+				case c.Type == html.ElementNode && c.Data == "ol":
+					list(c, level+1)
+				}
+			}
+		}
+	}
+	if ol := findElement(nav, "ol"); ol != nil {
+		list(ol, 1)
+	}
+	return out
+}
+
+// ncxTOC читает оглавление EPUB 2 (toc.ncx).
+func (e *epubBook) ncxTOC(name string) []pendingTOC {
+	type navPoint struct {
+		Label   string `xml:"navLabel>text"`
+		Content struct {
+			Src string `xml:"src,attr"`
+		} `xml:"content"`
+		Children []navPoint `xml:"navPoint"`
+	}
+	var ncx struct {
+		Points []navPoint `xml:"navMap>navPoint"`
+	}
+	if err := e.decodeXML(name, &ncx); err != nil {
+		return nil
+	}
+
+	var out []pendingTOC
+	var points func([]navPoint, int)
+	points = func(list []navPoint, level int) {
+		for _, p := range list {
+			out = append(out, pendingTOC{title: strings.Join(strings.Fields(p.Label), " "), level: level, key: resolveKey(name, p.Content.Src)})
+			points(p.Children, level+1)
+		}
+	}
+	points(ncx.Points, 1)
+	return out
+}
+
+// epubSkip — элементы, которые выбрасываются вместе с содержимым.
+var epubSkip = map[string]bool{
+	"script": true, "style": true, "head": true, "title": true, "noscript": true, "iframe": true,
+	"object": true, "embed": true, "form": true, "input": true, "button": true, "select": true,
+	"textarea": true, "svg": true, "math": true, "audio": true, "video": true, "canvas": true,
+	"link": true, "meta": true, "template": true,
+}
+
+// epubBlocks — абзацы: получают якоря.
+var epubBlocks = map[string]bool{
+	"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"li": true, "pre": true, "dt": true, "dd": true, "figcaption": true, "caption": true,
+}
+
+// epubContainers — блоки с абзацами внутри; семантические теги разделов становятся div.
+var epubContainers = map[string]string{
+	"div": "div", "section": "div", "article": "div", "aside": "div", "header": "div", "footer": "div",
+	"main": "div", "center": "div", "hgroup": "div", "nav": "div",
+	"blockquote": "blockquote", "ul": "ul", "ol": "ol", "dl": "dl", "figure": "figure",
+	"table": "table", "thead": "thead", "tbody": "tbody", "tfoot": "tfoot", "tr": "tr", "td": "td", "th": "th",
+}
+
+// epubInline — разрешённое оформление текста.
+var epubInline = map[string]string{
+	"em": "em", "i": "em", "strong": "strong", "b": "strong", "u": "u", "s": "del", "strike": "del",
+	"del": "del", "ins": "ins", "sub": "sub", "sup": "sup", "code": "code", "small": "small",
+	"big": "span", "span": "span", "font": "span", "cite": "cite", "q": "q", "abbr": "abbr", "mark": "mark",
+	"kbd": "kbd", "var": "var", "samp": "samp", "tt": "code",
+}
+
+func (e *epubBook) node(c *chapterBuilder, parent *html.Node, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		if c.block != "" || strings.TrimSpace(n.Data) != "" {
+			text(parent, n.Data)
+		}
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	tag := n.Data
+	if epubSkip[tag] {
+		return
+	}
+	if id := attr(n, "id"); id != "" {
+		c.mark(e.file + "#" + id)
+	}
+
+	switch {
+	case epubBlocks[tag]:
+		p, done := c.paragraph(parent, tag, "")
+		e.children(c, p, n)
+		done()
+	case epubContainers[tag] != "":
+		el := element(epubContainers[tag])
+		if tag == "td" || tag == "th" {
+			for _, a := range []string{"colspan", "rowspan"} {
+				if v := attr(n, a); v != "" {
+					el.Attr = append(el.Attr, html.Attribute{Key: a, Val: v})
+				}
+			}
+		}
+		parent.AppendChild(el)
+		e.children(c, el, n)
+	case epubInline[tag] != "":
+		el := element(epubInline[tag])
+		parent.AppendChild(el)
+		e.children(c, el, n)
+	case tag == "br" || tag == "hr":
+		parent.AppendChild(element(tag))
+	case tag == "a":
+		href := attr(n, "href")
+		key := ""
+		if href != "" && !isExternal(href) && !strings.Contains(href, ":") {
+			key = resolveKey(e.file, href)
+		}
+		note := strings.Contains(attr(n, "epub:type"), "noteref")
+		a := c.link(parent, href, key, note)
+		e.children(c, a, n)
+	case tag == "img":
+		e.image(c, parent, attr(n, "src"), attr(n, "alt"))
+	case tag == "image":
+		// <svg><image xlink:href=...> — обложки часто сделаны так; сам svg выбрасываем, картинку берём.
+		e.image(c, parent, attr(n, "href"), "")
+	default:
+		e.children(c, parent, n)
+	}
+}
+
+func (e *epubBook) children(c *chapterBuilder, parent *html.Node, n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == "svg" {
+			// Из svg оставляем только картинки.
+			walk(child, func(s *html.Node) bool {
+				if s.Type == html.ElementNode && s.Data == "image" {
+					e.node(c, parent, s)
+				}
+				return true
+			})
+			continue
+		}
+		e.node(c, parent, child)
+	}
+}
+
+func (e *epubBook) image(c *chapterBuilder, parent *html.Node, src string, alt string) {
+	if src == "" || strings.Contains(src, ":") {
+		return
+	}
+	name, _, _ := strings.Cut(resolveKey(e.file, src), "#")
+	data, err := e.read(name)
+	if err != nil {
+		return
+	}
+	c.image(parent, e.mediaTypes[name], data, alt)
+}
+
+// resolvePath разрешает относительный путь из файла base (пути в zip — всегда через "/").
+func resolvePath(base string, href string) string {
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return path.Clean(path.Join(path.Dir(base), href))
+}
+
+// resolveKey превращает ссылку "file.xhtml#id" из файла base в ключ "путь/file.xhtml#id".
+func resolveKey(base string, href string) string {
+	file, fragment, hasFragment := strings.Cut(href, "#")
+	resolved := base
+	if file != "" {
+		resolved = resolvePath(base, file)
+	}
+	if hasFragment && fragment != "" {
+		return resolved + "#" + fragment
+	}
+	return resolved
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		name := a.Key
+		if a.Namespace != "" {
+			name = a.Namespace + ":" + a.Key
+		}
+		if name == key || a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// walk обходит дерево, пока fn возвращает true.
+func walk(n *html.Node, fn func(*html.Node) bool) bool {
+	if !fn(n) {
+		return false
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if !walk(c, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func findElement(n *html.Node, tag string) *html.Node {
+	var found *html.Node
+	walk(n, func(c *html.Node) bool {
+		if c.Type == html.ElementNode && c.Data == tag {
+			found = c
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	walk(n, func(c *html.Node) bool {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+		}
+		return true
+	})
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// headingText — текст первого заголовка главы.
+func headingText(body *html.Node) string {
+	for _, tag := range []string{"h1", "h2", "h3"} {
+		if h := findElement(body, tag); h != nil {
+			return nodeText(h)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,185 @@
+package reader
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"tor_project/internal/fb2"
+)
+
+// FromFB2 делит FB2 на главы: каждый раздел верхнего уровня — глава, заголовок и эпиграфы
+// тела книги — отдельная первая глава, сноски — последняя глава «Примечания».
+func FromFB2(data []byte) (*Book, error) {
+	book, err := fb2.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := newBuilder(book.Title)
+	f := &fb2Converter{b: b, book: book}
+
+	if book.Body.Title != "" || len(book.Body.Content) > 0 {
+		c := b.newChapter(book.Title)
+		for _, line := range strings.Split(book.Body.Title, "\n") {
+			if line != "" {
+				p, done := c.paragraph(c.root, "h1", "")
+				text(p, line)
+				done()
+			}
+		}
+		for _, n := range book.Body.Content {
+			f.node(c, c.root, n)
+		}
+	}
+	for i := range book.Body.Sections {
+		s := &book.Body.Sections[i]
+		c := b.newChapter(s.Title)
+		f.section(c, c.root, s, 1, true)
+	}
+	if len(book.Notes) > 0 {
+		c := b.newChapter("Примечания")
+		c.mark("\x00notes")
+		b.addTOC("Примечания", 1, "\x00notes")
+		h, done := c.paragraph(c.root, "h1", "")
+		text(h, "Примечания")
+		done()
+		for i := range book.Notes {
+			f.section(c, c.root, &book.Notes[i], 2, false)
+		}
+	}
+	return b.finish(), nil
+}
+
+type fb2Converter struct {
+	b    *builder
+	book *fb2.Book
+	// sections — счётчик для ключей разделов без id.
+	sections int
+}
+
+// fb2Blocks — абзацы FB2: тег HTML и класс.
+var fb2Blocks = map[string][2]string{
+	"p":           {"p", ""},
+	"v":           {"p", "v"},
+	"subtitle":    {"p", "subtitle"},
+	"text-author": {"p", "text-author"},
+	"date":        {"p", "date"},
+}
+
+// fb2Containers — элементы, внутри которых абзацы.
+var fb2Containers = map[string][2]string{
+	"poem":       {"div", "poem"},
+	"stanza":     {"div", "stanza"},
+	"title":      {"div", "title"},
+	"epigraph":   {"blockquote", "epigraph"},
+	"cite":       {"blockquote", "cite"},
+	"annotation": {"div", "annotation"},
+	"table":      {"table", ""},
+	"tr":         {"tr", ""},
+}
+
+// fb2Inline — оформление внутри абзаца.
+var fb2Inline = map[string]string{
+	"emphasis":      "em",
+	"strong":        "strong",
+	"strikethrough": "del",
+	"sub":           "sub",
+	"sup":           "sup",
+	"code":          "code",
+}
+
+func (f *fb2Converter) section(c *chapterBuilder, parent *html.Node, s *fb2.Section, depth int, toc bool) {
+	key := "#" + s.ID
+	if s.ID == "" {
+		f.sections++
+		key = fmt.Sprintf("\x00section-%d", f.sections)
+	}
+	c.mark(key)
+	if s.Title != "" {
+		h, done := c.paragraph(parent, fmt.Sprintf("h%d", min(depth+1, 6)), "")
+		text(h, s.Title)
+		done()
+		if toc {
+			f.b.addTOC(s.Title, depth, key)
+		}
+	}
+	for _, n := range s.Content {
+		f.node(c, parent, n)
+	}
+	for i := range s.Sections {
+		f.section(c, parent, &s.Sections[i], depth+1, toc)
+	}
+}
+
+func (f *fb2Converter) node(c *chapterBuilder, parent *html.Node, n *fb2.Node) {
+	if n.IsText() {
+		if c.block != "" || strings.TrimSpace(n.Text) != "" {
+			text(parent, n.Text)
+		}
+		return
+	}
+	if id := n.Attr("id"); id != "" {
+		c.mark("#" + id)
+	}
+
+	if el, ok := fb2Blocks[n.Name]; ok {
+		p, done := c.paragraph(parent, el[0], el[1])
+		f.children(c, p, n)
+		done()
+		return
+	}
+	if el, ok := fb2Containers[n.Name]; ok {
+		div := element(el[0])
+		if el[1] != "" {
+			div.Attr = append(div.Attr, html.Attribute{Key: "class", Val: el[1]})
+		}
+		parent.AppendChild(div)
+		f.children(c, div, n)
+		return
+	}
+	if tag, ok := fb2Inline[n.Name]; ok {
+		el := element(tag)
+		parent.AppendChild(el)
+		f.children(c, el, n)
+		return
+	}
+
+	switch n.Name {
+	case "empty-line":
+		parent.AppendChild(element("br"))
+	case "td", "th":
+		cell := element(n.Name)
+		for _, attr := range []string{"colspan", "rowspan"} {
+			if v := n.Attr(attr); v != "" {
+				cell.Attr = append(cell.Attr, html.Attribute{Key: attr, Val: v})
+			}
+		}
+		parent.AppendChild(cell)
+		p, done := c.paragraph(cell, "span", "")
+		f.children(c, p, n)
+		done()
+	case "a":
+		href := n.Attr("href")
+		key := ""
+		if strings.HasPrefix(href, "#") {
+			key = href
+		}
+		a := c.link(parent, href, key, n.Attr("type") == "note")
+		f.children(c, a, n)
+	case "image":
+		if img := f.book.Binaries[strings.TrimPrefix(n.Attr("href"), "#")]; img != nil {
+			c.image(parent, img.ContentType, img.Data, n.Attr("alt"))
+		}
+	default:
+		// style и незнакомые элементы: только содержимое.
+		f.children(c, parent, n)
+	}
+}
+
+func (f *fb2Converter) children(c *chapterBuilder, parent *html.Node, n *fb2.Node) {
+	for _, child := range n.Children {
+		f.node(c, parent, child)
+	}
+}
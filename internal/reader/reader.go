@@ -0,0 +1,360 @@
+// Package reader готовит книгу для чтения в Mini App: делит её на главы и отдаёт каждую
+// главу очищенным HTML (только разрешённые теги, без скриптов, стилей и чужих атрибутов).
+//
+// У каждой главы есть якорь "c<N>", у каждого абзаца (p, заголовки, строки стихов, пункты
+// списков) — "c<N>p<M>", где N — номер главы, M — номер абзаца в ней, оба с нуля. Якоря
+// зависят только от содержимого файла, поэтому на них можно ссылаться из current_location.
+package reader
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ErrUnsupported — формат файла, который не умеем показывать.
+var ErrUnsupported = errors.New("формат не поддерживается читалкой")
+
+// Book — книга, разбитая на главы.
+type Book struct {
+	Title    string
+	Chapters []Chapter
+	// TOC — оглавление: главы и вложенные разделы со ссылками на якоря.
+	TOC []TOCEntry
+}
+
+// Chapter — глава, готовая к показу.
+type Chapter struct {
+	Index  int
+	Title  string
+	Anchor string
+	// HTML — содержимое главы; каждый абзац с id вида "c<N>p<M>".
+	HTML string
+	// Paragraphs — сколько в главе абзацев с якорями.
+	Paragraphs int
+}
+
+// TOCEntry — пункт оглавления. Level — вложенность, начиная с 1.
+type TOCEntry struct {
+	Title   string
+	Level   int
+	Chapter int
+	Anchor  string
+}
+
+// Open разбирает файл книги по его MIME-типу.
+func Open(data []byte, mimeType string) (*Book, error) {
+	switch mimeType {
+	case "application/x-fictionbook+xml":
+		return FromFB2(data)
+	case "application/epub+zip":
+		return FromEPUB(data)
+	}
+	return nil, ErrUnsupported
+}
+
+// MimeTypeForFormat — тип файла по формату для старых строк book_files без mime_type.
+func MimeTypeForFormat(format string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch {
+	case strings.Contains(format, "epub"):
+		return "application/epub+zip"
+	case format == "fb2":
+		return "application/x-fictionbook+xml"
+	}
+	return ""
+}
+
+// ParagraphAnchor — якорь абзаца.
+func ParagraphAnchor(chapter int, paragraph int) string {
+	return fmt.Sprintf("c%dp%d", chapter, paragraph)
+}
+
+// ChapterAnchor — якорь главы.
+func ChapterAnchor(chapter int) string {
+	return fmt.Sprintf("c%d", chapter)
+}
+
+// ParseAnchor разбирает якорь абзаца ("c3p12") или главы ("c3", тогда абзац 0).
+func ParseAnchor(anchor string) (chapter int, paragraph int, ok bool) {
+	if n, err := fmt.Sscanf(anchor, "c%dp%d", &chapter, &paragraph); err == nil && n == 2 {
+		return chapter, paragraph, chapter >= 0 && paragraph >= 0 && anchor == ParagraphAnchor(chapter, paragraph)
+	}
+	if _, err := fmt.Sscanf(anchor, "c%d", &chapter); err == nil {
+		return chapter, 0, chapter >= 0 && anchor == ChapterAnchor(chapter)
+	}
+	return 0, 0, false
+}
+
+// Percent — доля прочитанного (0–100) до абзаца paragraph главы chapter включительно,
+// считая по абзацам всей книги.
+func (b *Book) Percent(chapter int, paragraph int) float64 {
+	total, before := 0, 0
+	for _, ch := range b.Chapters {
+		switch {
+		case ch.Index < chapter:
+			before += ch.Paragraphs
+		case ch.Index == chapter:
+			before += min(paragraph+1, ch.Paragraphs)
+		}
+		total += ch.Paragraphs
+	}
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(before) / float64(total)
+}
+
+// Text — текст книги без разметки, строка на главу (для полнотекстового поиска).
+func (b *Book) Text() string {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	lines := make([]string, 0, len(b.Chapters))
+	for _, ch := range b.Chapters {
+		nodes, err := html.ParseFragment(strings.NewReader(ch.HTML), context)
+		if err != nil {
+			continue
+		}
+		var sb strings.Builder
+		for _, n := range nodes {
+			walk(n, func(c *html.Node) bool {
+				switch {
+				case c.Type == html.TextNode:
+					sb.WriteString(c.Data)
+				// Абзацы в HTML главы идут без пробелов между ними.
+				case c.Type == html.ElementNode && textBreaks[c.DataAtom]:
+					sb.WriteByte(' ')
+				}
+				return true
+			})
+		}
+		if line := strings.Join(strings.Fields(sb.String()), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// textBreaks — элементы, начало которых отделяет слова в Text.
+var textBreaks = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Blockquote: true, atom.Br: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Li: true, atom.Td: true, atom.Th: true, atom.Dt: true, atom.Dd: true,
+}
+
+// target — куда ведёт id из исходного файла.
+type target struct {
+	chapter int
+	anchor  string
+}
+
+// pendingLink — ссылка внутри книги; адрес подставляется, когда разобраны все главы.
+type pendingLink struct {
+	node *html.Node
+	key  string
+}
+
+type pendingTOC struct {
+	title string
+	level int
+	key   string
+}
+
+// builder собирает главы. Исходные id (в FB2 — "#id", в EPUB — "файл#id") запоминаются
+// как ключи, по ним потом разрешаются ссылки и оглавление.
+type builder struct {
+	title    string
+	chapters []*chapterBuilder
+	targets  map[string]target
+	links    []pendingLink
+	toc      []pendingTOC
+}
+
+type chapterBuilder struct {
+	b          *builder
+	index      int
+	title      string
+	root       *html.Node
+	paragraphs int
+	// block — якорь абзаца, внутри которого сейчас идёт разбор ("" — вне абзаца).
+	block string
+	// waiting — ключи, которым достанется якорь следующего абзаца (id разделов и контейнеров).
+	waiting []string
+}
+
+func newBuilder(title string) *builder {
+	return &builder{title: title, targets: make(map[string]target)}
+}
+
+func (b *builder) newChapter(title string) *chapterBuilder {
+	c := &chapterBuilder{b: b, index: len(b.chapters), title: title, root: &html.Node{Type: html.DocumentNode}}
+	b.chapters = append(b.chapters, c)
+	return c
+}
+
+// addTOC добавляет пункт оглавления, ведущий на ключ key.
+func (b *builder) addTOC(title string, level int, key string) {
+	if title = strings.TrimSpace(title); title != "" {
+		b.toc = append(b.toc, pendingTOC{title: title, level: level, key: key})
+	}
+}
+
+// mark запоминает исходный id: внутри абзаца он ведёт на этот абзац, иначе — на следующий.
+func (c *chapterBuilder) mark(key string) {
+	if key == "" {
+		return
+	}
+	if _, ok := c.b.targets[key]; ok {
+		return
+	}
+	if c.block != "" {
+		c.b.targets[key] = target{chapter: c.index, anchor: c.block}
+		return
+	}
+	c.waiting = append(c.waiting, key)
+}
+
+// paragraph создаёт элемент абзаца с якорем. Вложенные абзацы (p внутри li) якоря не получают.
+func (c *chapterBuilder) paragraph(parent *html.Node, tag string, class string) (el *html.Node, done func()) {
+	el = element(tag)
+	if class != "" {
+		el.Attr = append(el.Attr, html.Attribute{Key: "class", Val: class})
+	}
+	parent.AppendChild(el)
+	if c.block != "" {
+		return el, func() {}
+	}
+
+	anchor := ParagraphAnchor(c.index, c.paragraphs)
+	c.paragraphs++
+	el.Attr = append(el.Attr, html.Attribute{Key: "id", Val: anchor})
+	for _, key := range c.waiting {
+		if _, ok := c.b.targets[key]; !ok {
+			c.b.targets[key] = target{chapter: c.index, anchor: anchor}
+		}
+	}
+	c.waiting = nil
+	c.block = anchor
+	return el, func() { c.block = "" }
+}
+
+// link добавляет ссылку. Внешние (http, https, mailto) остаются как есть, внутренние
+// разрешаются в finish; прочие схемы (javascript: и т.п.) отбрасываются.
+func (c *chapterBuilder) link(parent *html.Node, href string, internalKey string, note bool) *html.Node {
+	a := element("a")
+	if note {
+		a.Attr = append(a.Attr, html.Attribute{Key: "class", Val: "note"})
+	}
+	switch {
+	case internalKey != "":
+		c.b.links = append(c.b.links, pendingLink{node: a, key: internalKey})
+	case isExternal(href):
+		a.Attr = append(a.Attr, html.Attribute{Key: "href", Val: href},
+			html.Attribute{Key: "target", Val: "_blank"}, html.Attribute{Key: "rel", Val: "noopener noreferrer"})
+	}
+	parent.AppendChild(a)
+	return a
+}
+
+// finish разрешает ссылки и оглавление и отрисовывает главы.
+func (b *builder) finish() *Book {
+	if len(b.chapters) == 0 {
+		b.newChapter(b.title)
+	}
+	for _, c := range b.chapters {
+		for _, key := range c.waiting {
+			if _, ok := b.targets[key]; !ok {
+				b.targets[key] = target{chapter: c.index, anchor: ChapterAnchor(c.index)}
+			}
+		}
+	}
+
+	for _, l := range b.links {
+		t, ok := b.targets[l.key]
+		if !ok {
+			continue
+		}
+		l.node.Attr = append(l.node.Attr,
+			html.Attribute{Key: "href", Val: "#" + t.anchor},
+			html.Attribute{Key: "data-chapter", Val: fmt.Sprint(t.chapter)})
+	}
+
+	book := &Book{Title: b.title}
+	for _, c := range b.chapters {
+		var buf bytes.Buffer
+		for n := c.root.FirstChild; n != nil; n = n.NextSibling {
+			html.Render(&buf, n)
+		}
+		title := c.title
+		if title == "" {
+			title = fmt.Sprintf("Глава %d", c.index+1)
+		}
+		book.Chapters = append(book.Chapters, Chapter{
+			Index:      c.index,
+			Title:      title,
+			Anchor:     ChapterAnchor(c.index),
+			HTML:       buf.String(),
+			Paragraphs: c.paragraphs,
+		})
+	}
+
+	for _, e := range b.toc {
+		if t, ok := b.targets[e.key]; ok {
+			book.TOC = append(book.TOC, TOCEntry{Title: e.title, Level: e.level, Chapter: t.chapter, Anchor: t.anchor})
+		}
+	}
+	if len(book.TOC) == 0 {
+		for _, ch := range book.Chapters {
+			book.TOC = append(book.TOC, TOCEntry{Title: ch.Title, Level: 1, Chapter: ch.Index, Anchor: ch.Anchor})
+		}
+	}
+	return book
+}
+
+func element(tag string) *html.Node {
+	return &html.Node{Type: html.ElementNode, Data: tag, DataAtom: atom.Lookup([]byte(tag))}
+}
+
+func text(parent *html.Node, s string) {
+	if s == "" {
+		return
+	}
+	if last := parent.LastChild; last != nil && last.Type == html.TextNode {
+		last.Data += s
+		return
+	}
+	parent.AppendChild(&html.Node{Type: html.TextNode, Data: s})
+}
+
+func isExternal(href string) bool {
+	low := strings.ToLower(strings.TrimSpace(href))
+	return strings.HasPrefix(low, "http://") || strings.HasPrefix(low, "https://") || strings.HasPrefix(low, "mailto:")
+}
+
+// imageTypes — картинки, которые отдаём как data: URI. SVG не пускаем: в нём может быть скрипт.
+var imageTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true}
+
+// maxInlineImage — картинки больше этого размера не встраиваются в главу.
+const maxInlineImage = 2 << 20
+
+// image вставляет картинку как data: URI; вне абзаца она оборачивается в свой абзац.
+func (c *chapterBuilder) image(parent *html.Node, contentType string, data []byte, alt string) {
+	if !imageTypes[contentType] || len(data) == 0 || len(data) > maxInlineImage {
+		return
+	}
+	if c.block == "" {
+		p, done := c.paragraph(parent, "p", "image")
+		defer done()
+		parent = p
+	}
+	img := element("img")
+	img.Attr = []html.Attribute{
+		{Key: "src", Val: "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)},
+		{Key: "alt", Val: alt},
+	}
+	parent.AppendChild(img)
+}
@@ -0,0 +1,207 @@
+package reader
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"tor_project/internal/epub"
+	"tor_project/internal/fb2"
+)
+
+const sampleFB2 = `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description><title-info><book-title>1984</book-title></title-info></description>
+<body>
+ <title><p>1984</p></title>
+ <epigraph><p>Война — это мир.</p></epigraph>
+ <section id="part1">
+  <title><p>Часть первая</p></title>
+  <section>
+   <title><p>Глава 1</p></title>
+   <p>Был холодный <emphasis>ясный</emphasis> день<a l:href="#n1" type="note">1</a>.</p>
+   <poem><stanza><v>Строка &lt;script&gt;</v></stanza></poem>
+  </section>
+ </section>
+ <section><title><p>Часть вторая</p></title><p>Текст.</p></section>
+</body>
+<body name="notes">
+ <section id="n1"><title><p>1</p></title><p>Сноска.</p></section>
+</body>
+</FictionBook>`
+
+func TestFromFB2(t *testing.T) {
+	book, err := Open([]byte(sampleFB2), "application/x-fictionbook+xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(book.Chapters) != 4 {
+		t.Fatalf("chapters: %d", len(book.Chapters))
+	}
+	if book.Chapters[1].Title != "Часть первая" || book.Chapters[3].Title != "Примечания" {
+		t.Fatalf("titles: %q, %q", book.Chapters[1].Title, book.Chapters[3].Title)
+	}
+
+	part1 := book.Chapters[1].HTML
+	for _, want := range []string{
+		`<h2 id="c1p0">Часть первая</h2>`,
+		`<h3 id="c1p1">Глава 1</h3>`,
+		`<p id="c1p2">Был холодный <em>ясный</em> день<a class="note" href="#c3p1" data-chapter="3">1</a>.</p>`,
+		`<p class="v" id="c1p3">Строка &lt;script&gt;</p>`,
+	} {
+		if !strings.Contains(part1, want) {
+			t.Fatalf("chapter 1 has no %s:\n%s", want, part1)
+		}
+	}
+	if book.Chapters[1].Paragraphs != 4 {
+		t.Fatalf("paragraphs: %d", book.Chapters[1].Paragraphs)
+	}
+
+	var toc []string
+	for _, e := range book.TOC {
+		toc = append(toc, strings.Repeat(" ", e.Level-1)+e.Title+"@"+e.Anchor)
+	}
+	if got := strings.Join(toc, "|"); got != "Часть первая@c1p0| Глава 1@c1p1|Часть вторая@c2p0|Примечания@c3p0" {
+		t.Fatalf("toc: %s", got)
+	}
+
+	// Якоря не меняются от разбора к разбору.
+	again, _ := FromFB2([]byte(sampleFB2))
+	if again.Chapters[1].HTML != part1 {
+		t.Fatal("anchors are not stable")
+	}
+}
+
+func TestAnchorPercent(t *testing.T) {
+	for anchor, want := range map[string][3]int{
+		"c3p12": {3, 12, 1},
+		"c3":    {3, 0, 1},
+		"c03p1": {0, 0, 0},
+		"c3p":   {0, 0, 0},
+		"p3":    {0, 0, 0},
+		"":      {0, 0, 0},
+	} {
+		chapter, paragraph, ok := ParseAnchor(anchor)
+		if ok != (want[2] == 1) || ok && (chapter != want[0] || paragraph != want[1]) {
+			t.Fatalf("ParseAnchor(%q) = %d, %d, %v", anchor, chapter, paragraph, ok)
+		}
+	}
+
+	book, err := FromFB2([]byte(sampleFB2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Абзацев по главам: 2, 4, 2, 3.
+	if got := book.Percent(1, 3); got != 100*6.0/11 {
+		t.Fatalf("percent: %v", got)
+	}
+	if got := book.Percent(3, 2); got != 100 {
+		t.Fatalf("percent at end: %v", got)
+	}
+}
+
+func TestBookText(t *testing.T) {
+	book, err := FromFB2([]byte(sampleFB2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(book.Text(), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("lines: %q", lines)
+	}
+	if want := "Часть первая Глава 1 Был холодный ясный день1. Строка <script>"; lines[1] != want {
+		t.Fatalf("chapter 1 text: %q", lines[1])
+	}
+}
+
+func TestFromEPUB(t *testing.T) {
+	// EPUB, собранный нашим же конвертером: главы и сноски должны совпасть с FB2.
+	parsed, err := fb2.Parse(strings.NewReader(sampleFB2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := epub.Convert(&buf, parsed, epub.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	book, err := Open(buf.Bytes(), "application/epub+zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// title.xhtml, две главы, примечания.
+	if len(book.Chapters) != 4 || book.Chapters[1].Title != "Часть первая" || book.Chapters[3].Title != "Примечания" {
+		t.Fatalf("chapters: %+v", book.Chapters)
+	}
+	if !strings.Contains(book.Chapters[1].HTML, `<a class="note" href="#c3p1" data-chapter="3">1</a>`) {
+		t.Fatalf("note link:\n%s", book.Chapters[1].HTML)
+	}
+	if len(book.TOC) < 4 || book.TOC[2].Title != "Глава 1" || book.TOC[2].Level != 2 || book.TOC[2].Anchor != "c1p1" {
+		t.Fatalf("toc: %+v", book.TOC)
+	}
+}
+
+func TestFromEPUBSanitizes(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name, content string) {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	add("mimetype", "application/epub+zip")
+	add("META-INF/container.xml", `<container><rootfiles><rootfile full-path="OPS/book.opf"/></rootfiles></container>`)
+	add("OPS/book.opf", `<package><metadata><title>Тест</title></metadata>
+<manifest><item id="ch" href="text/ch%201.xhtml" media-type="application/xhtml+xml"/></manifest>
+<spine><itemref idref="ch"/></spine></package>`)
+	add("OPS/text/ch 1.xhtml", `<html><head><style>p{}</style><script>alert(1)</script></head>
+<body onload="x()"><h1>Начало</h1>
+<p style="color:red" onclick="x()">Текст <a href="javascript:alert(1)">плохо</a> <a href="https://example.org">хорошо</a>
+<a href="#end">вниз</a><iframe src="https://evil"></iframe></p>
+<div id="end"><p>Конец</p></div></body></html>`)
+	zw.Close()
+
+	book, err := FromEPUB(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Title != "Тест" || len(book.Chapters) != 1 || book.Chapters[0].Title != "Начало" {
+		t.Fatalf("book: %+v", book)
+	}
+	got := book.Chapters[0].HTML
+	for _, bad := range []string{"script", "alert", "onclick", "onload", "style", "iframe", "evil"} {
+		if strings.Contains(got, bad) {
+			t.Fatalf("%q left in:\n%s", bad, got)
+		}
+	}
+	for _, want := range []string{
+		`<a>плохо</a>`,
+		`<a href="https://example.org" target="_blank" rel="noopener noreferrer">хорошо</a>`,
+		`<a href="#c0p2" data-chapter="0">вниз</a>`,
+		`<p id="c0p2">Конец</p>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("no %s in:\n%s", want, got)
+		}
+	}
+}
+
+func TestFromEPUBRejectsZipBomb(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, content []byte) {
+		w, _ := zw.Create(name)
+		w.Write(content)
+	}
+	add("mimetype", []byte("application/epub+zip"))
+	add("META-INF/container.xml", []byte(`<container><rootfiles><rootfile full-path="book.opf"/></rootfiles></container>`))
+	// Пробелы сжимаются в сотни раз: в архиве килобайты, распакованный файл больше предела.
+	add("book.opf", bytes.Repeat([]byte{' '}, maxEPUBEntrySize+1))
+	zw.Close()
+
+	if buf.Len() > maxEPUBEntrySize/100 {
+		t.Fatalf("archive is not compressed: %d bytes", buf.Len())
+	}
+	if _, err := FromEPUB(buf.Bytes()); err == nil || !strings.Contains(err.Error(), "book.opf") {
+		t.Fatalf("err = %v, want size limit error", err)
+	}
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"tor_project/internal/models"
+)
+
+// refreshTimeout ограничивает фоновое обновление устаревшей записи.
+const refreshTimeout = 3 * time.Minute
+
+// CacheStore — постоянное хранилище кэша (таблица cache_entries в SQLite).
+type CacheStore interface {
+	GetCacheEntry(ctx context.Context, key string) ([]byte, time.Time, bool, error)
+	PutCacheEntry(ctx context.Context, key string, value []byte, storedAt time.Time) error
+}
+
+// CacheConfig — настройки кэша.
+type CacheConfig struct {
+	// Size — сколько записей держать в памяти (LRU).
+	Size int
+	// SearchTTL и DetailsTTL — сколько запись считается свежей.
+	SearchTTL  time.Duration
+	DetailsTTL time.Duration
+	// StaleTTL — сколько после истечения TTL запись ещё отдаётся сразу, пока в фоне
+	// загружается новая версия (stale-while-revalidate).
+	StaleTTL time.Duration
+	// Store — необязательное постоянное хранилище; записи переживают перезапуск.
+	Store CacheStore
+}
+
+// CacheStats — счётчики обращений к кэшу.
+type CacheStats struct {
+	Hits   int64
+	Stale  int64
+	Misses int64
+}
+
+// CachedCatalog кэширует Search и GetBookDetails, остальные методы идут в каталог напрямую.
+type CachedCatalog struct {
+	Searcher
+	DetailsProvider
+
+	cfg CacheConfig
+	now func() time.Time
+
+	mu         sync.Mutex
+	lru        *lruCache
+	refreshing map[string]bool
+	stats      CacheStats
+}
+
+type cacheEntry struct {
+	// value хранится в JSON: так из кэша каждый раз выходит копия, и вызывающий
+	// (например, бот, сортирующий форматы) не портит закэшированные данные.
+	value    []byte
+	storedAt time.Time
+}
+
+func NewCachedCatalog(searcher Searcher, details DetailsProvider, cfg CacheConfig) *CachedCatalog {
+	return &CachedCatalog{
+		Searcher:        searcher,
+		DetailsProvider: details,
+		cfg:             cfg,
+		now:             time.Now,
+		lru:             newLRUCache(cfg.Size),
+		refreshing:      make(map[string]bool),
+	}
+}
+
+// Search отдаёт результаты поиска из кэша; пустые результаты не кэшируются.
+func (c *CachedCatalog) Search(ctx context.Context, query string) (models.SearchResult, error) {
+	key := "search:" + normalizeQuery(query)
+	return cached(ctx, c, key, c.cfg.SearchTTL,
+		func(ctx context.Context) (models.SearchResult, error) { return c.Searcher.Search(ctx, query) },
+		func(result models.SearchResult) bool { return !result.IsEmpty() },
+	)
+}
+
+// GetBookDetails отдаёт карточку книги из кэша.
+func (c *CachedCatalog) GetBookDetails(ctx context.Context, bookID string) (models.BookDetails, error) {
+	return cached(ctx, c, "details:"+bookID, c.cfg.DetailsTTL,
+		func(ctx context.Context) (models.BookDetails, error) {
+			return c.DetailsProvider.GetBookDetails(ctx, bookID)
+		},
+		func(models.BookDetails) bool { return true },
+	)
+}
+
+// Stats возвращает текущие счётчики кэша.
+func (c *CachedCatalog) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// cached — общий путь чтения: свежая запись отдаётся сразу, устаревшая тоже отдаётся,
+// но обновляется в фоне, а при промахе значение загружается и сохраняется (если keep разрешает).
+// Если загрузка не удалась, а в кэше есть хоть какая-то запись, отдаём её.
+func cached[T any](ctx context.Context, c *CachedCatalog, key string, ttl time.Duration, load func(context.Context) (T, error), keep func(T) bool) (T, error) {
+	var zero T
+
+	entry, found := c.get(ctx, key)
+	var value T
+	if found {
+		if err := json.Unmarshal(entry.value, &value); err != nil {
+			fmt.Printf("Кэш: битая запись %s: %v\n", key, err)
+			found = false
+		}
+	}
+
+	age := c.now().Sub(entry.storedAt)
+	switch {
+	case found && age < ttl:
+		c.count(key, "попадание", func(s *CacheStats) { s.Hits++ })
+		return value, nil
+	case found && age < ttl+c.cfg.StaleTTL:
+		c.count(key, "устарело, обновляю в фоне", func(s *CacheStats) { s.Stale++ })
+		refresh(ctx, c, key, load, keep)
+		return value, nil
+	}
+
+	c.count(key, "промах", func(s *CacheStats) { s.Misses++ })
+	fresh, err := load(ctx)
+	if err != nil {
+		if found && ctx.Err() == nil {
+			fmt.Printf("Кэш: %s не загрузилось (%v), отдаю старую запись\n", key, err)
+			return value, nil
+		}
+		return zero, err
+	}
+	if keep(fresh) {
+		c.put(ctx, key, fresh)
+	}
+	return fresh, nil
+}
+
+// refresh перезагружает запись в фоне; одновременно по одному ключу идёт не больше одного обновления.
+func refresh[T any](ctx context.Context, c *CachedCatalog, key string, load func(context.Context) (T, error), keep func(T) bool) {
+	c.mu.Lock()
+	if c.refreshing[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing[key] = true
+	c.mu.Unlock()
+
+	// Обновление переживает запрос пользователя, но не дольше refreshTimeout.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), refreshTimeout)
+	go func() {
+		defer cancel()
+		defer func() {
+			c.mu.Lock()
+			delete(c.refreshing, key)
+			c.mu.Unlock()
+		}()
+
+		fresh, err := load(ctx)
+		if err != nil {
+			fmt.Printf("Кэш: фоновое обновление %s не удалось: %v\n", key, err)
+			return
+		}
+		if keep(fresh) {
+			c.put(ctx, key, fresh)
+		}
+	}()
+}
+
+func (c *CachedCatalog) get(ctx context.Context, key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	entry, ok := c.lru.get(key)
+	c.mu.Unlock()
+	if ok || c.cfg.Store == nil {
+		return entry, ok
+	}
+
+	value, storedAt, ok, err := c.cfg.Store.GetCacheEntry(ctx, key)
+	if err != nil {
+		fmt.Printf("Кэш: %v\n", err)
+		return cacheEntry{}, false
+	}
+	if !ok {
+		return cacheEntry{}, false
+	}
+
+	entry = cacheEntry{value: value, storedAt: storedAt}
+	c.mu.Lock()
+	c.lru.put(key, entry)
+	c.mu.Unlock()
+	return entry, true
+}
+
+func (c *CachedCatalog) put(ctx context.Context, key string, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		fmt.Printf("Кэш: не удалось сохранить %s: %v\n", key, err)
+		return
+	}
+
+	entry := cacheEntry{value: data, storedAt: c.now()}
+	c.mu.Lock()
+	c.lru.put(key, entry)
+	c.mu.Unlock()
+
+	if c.cfg.Store != nil {
+		if err := c.cfg.Store.PutCacheEntry(ctx, key, entry.value, entry.storedAt); err != nil {
+			fmt.Printf("Кэш: %v\n", err)
+		}
+	}
+}
+
+func (c *CachedCatalog) count(key string, outcome string, inc func(*CacheStats)) {
+	c.mu.Lock()
+	inc(&c.stats)
+	stats := c.stats
+	c.mu.Unlock()
+
+	fmt.Printf("Кэш %s: %s (попаданий %d, устаревших %d, промахов %d)\n", key, outcome, stats.Hits, stats.Stale, stats.Misses)
+}
+
+// normalizeQuery приводит запрос к виду ключа: "  Война  и МИР " и "война и мир" — один ключ.
+func normalizeQuery(query string) string {
+	return strings.ToLower(strings.Join(strings.Fields(query), " "))
+}
+
+// lruCache — LRU фиксированного размера; не потокобезопасен, вызывается под CachedCatalog.mu.
+type lruCache struct {
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	entry cacheEntry
+}
+
+func newLRUCache(size int) *lruCache {
+	if size <= 0 {
+		size = 1
+	}
+	return &lruCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (l *lruCache) get(key string) (cacheEntry, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*lruItem).entry, true
+}
+
+func (l *lruCache) put(key string, entry cacheEntry) {
+	if el, ok := l.items[key]; ok {
+		el.Value.(*lruItem).entry = entry
+		l.order.MoveToFront(el)
+		return
+	}
+
+	l.items[key] = l.order.PushFront(&lruItem{key: key, entry: entry})
+	for l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*lruItem).key)
+	}
+}
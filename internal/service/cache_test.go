@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+	"tor_project/internal/models"
+)
+
+// memoryCacheStore — CacheStore в памяти вместо SQLite.
+type memoryCacheStore struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func (m *memoryCacheStore) GetCacheEntry(_ context.Context, key string) ([]byte, time.Time, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[key]
+	return entry.value, entry.storedAt, ok, nil
+}
+
+func (m *memoryCacheStore) PutCacheEntry(_ context.Context, key string, value []byte, storedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = cacheEntry{value: value, storedAt: storedAt}
+	return nil
+}
+
+func (f *FakeCatalog) calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Calls[method]
+}
+
+func TestCachedCatalog(t *testing.T) {
+	ctx := context.Background()
+	catalog := NewFakeCatalog()
+	catalog.AddBook(models.BookDetails{ID: "1", Title: "1984", Author: "Джордж Оруэлл"}, map[string][]byte{"fb2": nil})
+
+	store := &memoryCacheStore{entries: make(map[string]cacheEntry)}
+	cfg := CacheConfig{Size: 10, SearchTTL: time.Minute, DetailsTTL: time.Hour, StaleTTL: time.Hour, Store: store}
+	cache := NewCachedCatalog(catalog, catalog, cfg)
+
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	// Промах, затем попадание: одинаковые с точностью до регистра и пробелов запросы — один ключ.
+	if _, err := cache.Search(ctx, "1984"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := cache.Search(ctx, "  1984 ")
+	if err != nil || len(result.Books) != 1 {
+		t.Fatalf("cached search: %+v, %v", result, err)
+	}
+	if got := catalog.calls("Search"); got != 1 {
+		t.Fatalf("Search calls: got %d, want 1", got)
+	}
+
+	// Пустые результаты не кэшируются.
+	cache.Search(ctx, "нет такой книги")
+	cache.Search(ctx, "нет такой книги")
+	if got := catalog.calls("Search"); got != 3 {
+		t.Fatalf("Search calls after empty results: got %d, want 3", got)
+	}
+
+	// Устаревшая запись отдаётся сразу и обновляется в фоне.
+	now = now.Add(2 * time.Minute)
+	if _, err := cache.Search(ctx, "1984"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for catalog.calls("Search") != 4 {
+		if time.Now().After(deadline) {
+			t.Fatal("stale entry was not refreshed in background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := cache.Stats(); got.Hits != 1 || got.Stale != 1 || got.Misses != 3 {
+		t.Fatalf("stats: %+v", got)
+	}
+
+	// Новый экземпляр (перезапуск) берёт запись из постоянного хранилища.
+	if _, err := cache.GetBookDetails(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewCachedCatalog(catalog, catalog, cfg)
+	restarted.now = cache.now
+	details, err := restarted.GetBookDetails(ctx, "1")
+	if err != nil || details.Title != "1984" {
+		t.Fatalf("persisted details: %+v, %v", details, err)
+	}
+	if got := catalog.calls("GetBookDetails"); got != 1 {
+		t.Fatalf("GetBookDetails calls: got %d, want 1", got)
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"tor_project/internal/models"
+)
+
+// FakeCatalog — каталог в памяти для тестов бота и API без Tor.
+// Реализует Searcher, DetailsProvider и Downloader.
+type FakeCatalog struct {
+	mu sync.Mutex
+
+	// Books — карточки книг по ID; по ним же работает Search (поиск подстроки в названии и авторе).
+	Books   map[string]models.BookDetails
+	Authors map[string]models.Author
+	Series  map[string]models.Series
+
+	// Files — содержимое файлов по ключу "<bookID>:<format>".
+	Files map[string][]byte
+	// Covers — обложки по URL.
+	Covers map[string][]byte
+
+	// Calls считает вызовы методов (по имени метода).
+	Calls map[string]int
+
+	// Delay имитирует медленную сеть: Search и Download ждут столько перед ответом
+	// и прерываются по отмене контекста, как настоящий клиент.
+	Delay time.Duration
+}
+
+// NewFakeCatalog создаёт пустой каталог.
+func NewFakeCatalog() *FakeCatalog {
+	return &FakeCatalog{
+		Books:   make(map[string]models.BookDetails),
+		Authors: make(map[string]models.Author),
+		Series:  make(map[string]models.Series),
+		Files:   make(map[string][]byte),
+		Covers:  make(map[string][]byte),
+		Calls:   make(map[string]int),
+	}
+}
+
+// AddBook добавляет книгу и содержимое её файлов (format -> bytes).
+func (f *FakeCatalog) AddBook(details models.BookDetails, files map[string][]byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for format, data := range files {
+		f.Files[details.ID+":"+format] = data
+		if !hasFormat(details.Formats, format) {
+			details.Formats = append(details.Formats, models.BookFormatOption{Path: format, Label: strings.ToUpper(format)})
+		}
+	}
+	f.Books[details.ID] = details
+}
+
+func (f *FakeCatalog) Search(ctx context.Context, query string) (models.SearchResult, error) {
+	if err := f.wait(ctx); err != nil {
+		return models.SearchResult{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["Search"]++
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	var result models.SearchResult
+	for _, d := range f.Books {
+		if strings.Contains(strings.ToLower(d.Title), query) || strings.Contains(strings.ToLower(d.Author), query) {
+			result.Books = append(result.Books, bookFromDetails(d))
+		}
+	}
+	for _, a := range f.Authors {
+		if strings.Contains(strings.ToLower(a.Name), query) {
+			result.Authors = append(result.Authors, a)
+		}
+	}
+	for _, s := range f.Series {
+		if strings.Contains(strings.ToLower(s.Title), query) {
+			result.Series = append(result.Series, s)
+		}
+	}
+	sortBooks(result.Books)
+
+	result.TotalBooks = len(result.Books)
+	result.TotalAuthors = len(result.Authors)
+	result.TotalSeries = len(result.Series)
+	return result, nil
+}
+
+func (f *FakeCatalog) SearchAuthors(ctx context.Context, query string) ([]models.Author, error) {
+	result, err := f.Search(ctx, query)
+	return result.Authors, err
+}
+
+func (f *FakeCatalog) SearchSeries(ctx context.Context, query string) ([]models.Series, error) {
+	result, err := f.Search(ctx, query)
+	return result.Series, err
+}
+
+func (f *FakeCatalog) GetAuthorBooks(ctx context.Context, authorID string) (models.Author, []models.Book, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["GetAuthorBooks"]++
+
+	author, ok := f.Authors[authorID]
+	if !ok {
+		return models.Author{}, nil, fmt.Errorf("автор %s не найден", authorID)
+	}
+
+	var books []models.Book
+	for _, d := range f.Books {
+		if d.Author == author.Name {
+			books = append(books, bookFromDetails(d))
+		}
+	}
+	sortBooks(books)
+	return author, books, nil
+}
+
+func (f *FakeCatalog) GetSeries(ctx context.Context, seriesID string) (models.Series, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["GetSeries"]++
+
+	series, ok := f.Series[seriesID]
+	if !ok {
+		return models.Series{}, fmt.Errorf("серия %s не найдена", seriesID)
+	}
+	return series, nil
+}
+
+func (f *FakeCatalog) GetBookDetails(ctx context.Context, bookID string) (models.BookDetails, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["GetBookDetails"]++
+
+	details, ok := f.Books[bookID]
+	if !ok {
+		return models.BookDetails{}, fmt.Errorf("сервер вернул код: 404")
+	}
+	return details, nil
+}
+
+func (f *FakeCatalog) DownloadBytes(ctx context.Context, targetURL string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["DownloadBytes"]++
+
+	data, ok := f.Covers[targetURL]
+	if !ok {
+		return nil, fmt.Errorf("сервер вернул ошибку: 404 Not Found")
+	}
+	return data, nil
+}
+
+func (f *FakeCatalog) Download(ctx context.Context, bookID string, formatPath string) (DownloadStream, error) {
+	if err := f.wait(ctx); err != nil {
+		return DownloadStream{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["Download"]++
+
+	data, ok := f.Files[bookID+":"+formatPath]
+	if !ok {
+		return DownloadStream{}, &StatusError{StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+	}
+	return DownloadStream{
+		Body:     io.NopCloser(bytes.NewReader(data)),
+		Filename: bookID + "." + formatPath,
+		Size:     int64(len(data)),
+	}, nil
+}
+
+func (f *FakeCatalog) wait(ctx context.Context) error {
+	f.mu.Lock()
+	delay := f.Delay
+	f.mu.Unlock()
+
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	return sleepContext(ctx, delay)
+}
+
+func hasFormat(formats []models.BookFormatOption, format string) bool {
+	for _, opt := range formats {
+		if opt.Path == format {
+			return true
+		}
+	}
+	return false
+}
+
+// sortBooks упорядочивает книги по ID, чтобы результаты из map были стабильными.
+func sortBooks(books []models.Book) {
+	sort.Slice(books, func(i, j int) bool { return books[i].ID < books[j].ID })
+}
+
+var (
+	_ Searcher        = (*FakeCatalog)(nil)
+	_ DetailsProvider = (*FakeCatalog)(nil)
+	_ Downloader      = (*FakeCatalog)(nil)
+)
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"io"
+	"tor_project/internal/models"
+)
+
+// Searcher ищет по каталогу и открывает страницы авторов и серий.
+type Searcher interface {
+	Search(ctx context.Context, query string) (models.SearchResult, error)
+	SearchAuthors(ctx context.Context, query string) ([]models.Author, error)
+	SearchSeries(ctx context.Context, query string) ([]models.Series, error)
+	GetAuthorBooks(ctx context.Context, authorID string) (models.Author, []models.Book, error)
+	GetSeries(ctx context.Context, seriesID string) (models.Series, error)
+}
+
+// DetailsProvider отдаёт карточку книги и скачивает её обложку.
+type DetailsProvider interface {
+	GetBookDetails(ctx context.Context, bookID string) (models.BookDetails, error)
+	DownloadBytes(ctx context.Context, targetURL string) ([]byte, error)
+}
+
+// Downloader скачивает файл книги в нужном формате.
+// Поток (Body) нужно закрыть после чтения.
+type Downloader interface {
+	Download(ctx context.Context, bookID string, formatPath string) (DownloadStream, error)
+}
+
+// DownloadStream — открытый на чтение файл книги.
+type DownloadStream struct {
+	Body io.ReadCloser
+	// Filename — имя, которое предложил сервер, или "<id>.<формат>".
+	Filename string
+	// Size — размер из Content-Length; -1, если сервер его не сообщил.
+	Size int64
+}
+
+var (
+	_ Searcher        = (*FlibustaClient)(nil)
+	_ DetailsProvider = (*FlibustaClient)(nil)
+	_ Downloader      = (*FlibustaClient)(nil)
+
+	_ Searcher        = (*CachedCatalog)(nil)
+	_ DetailsProvider = (*CachedCatalog)(nil)
+)
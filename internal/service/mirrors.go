@@ -0,0 +1,271 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// minHTMLPageSize — HTML-страница сайта короче этого размера почти наверняка оборвана
+	// (Tor рвёт соединение или зеркало отдаёт заглушку).
+	minHTMLPageSize = 1000
+
+	mirrorBaseCooldown = 30 * time.Second
+	mirrorMaxCooldown  = 10 * time.Minute
+)
+
+// StatusError — сервер ответил, но не 200.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("сервер вернул код: %d", e.StatusCode)
+}
+
+// Mirrors хранит список зеркал сайта (onion и clearnet) и их состояние.
+// Упавшее зеркало уходит "на скамейку" с растущим таймаутом и пробуется последним.
+type Mirrors struct {
+	mu      sync.Mutex
+	mirrors []*mirror
+}
+
+type mirror struct {
+	baseURL   string
+	failures  int
+	downUntil time.Time
+	lastError string
+}
+
+// NewMirrors создаёт пул зеркал; порядок в списке — порядок предпочтения.
+func NewMirrors(urls ...string) *Mirrors {
+	m := &Mirrors{}
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		u = strings.TrimSuffix(strings.TrimSpace(u), "/")
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		m.mirrors = append(m.mirrors, &mirror{baseURL: u})
+	}
+	return m
+}
+
+// Ordered возвращает зеркала в порядке попыток: сначала здоровые (в порядке конфига),
+// затем упавшие — те, чей таймаут истекает раньше.
+func (m *Mirrors) Ordered() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var healthy, down []*mirror
+	for _, mr := range m.mirrors {
+		if mr.downUntil.After(now) {
+			down = append(down, mr)
+		} else {
+			healthy = append(healthy, mr)
+		}
+	}
+	for i := 1; i < len(down); i++ {
+		for j := i; j > 0 && down[j].downUntil.Before(down[j-1].downUntil); j-- {
+			down[j], down[j-1] = down[j-1], down[j]
+		}
+	}
+
+	urls := make([]string, 0, len(m.mirrors))
+	for _, mr := range append(healthy, down...) {
+		urls = append(urls, mr.baseURL)
+	}
+	return urls
+}
+
+// Path возвращает путь абсолютного URL, если он указывает на одно из зеркал.
+// Так ссылку (например, на обложку) можно загрузить с любого другого зеркала.
+func (m *Mirrors) Path(targetURL string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, mr := range m.mirrors {
+		if strings.HasPrefix(targetURL, mr.baseURL+"/") {
+			return strings.TrimPrefix(targetURL, mr.baseURL), true
+		}
+	}
+	return "", false
+}
+
+func (m *Mirrors) markOK(baseURL string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if mr := m.find(baseURL); mr != nil {
+		if mr.failures > 0 {
+			fmt.Printf("Зеркало снова доступно: %s\n", baseURL)
+		}
+		mr.failures = 0
+		mr.downUntil = time.Time{}
+		mr.lastError = ""
+	}
+}
+
+func (m *Mirrors) markFailed(baseURL string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mr := m.find(baseURL)
+	if mr == nil {
+		return
+	}
+
+	mr.failures++
+	cooldown := mirrorBaseCooldown << (mr.failures - 1)
+	if cooldown > mirrorMaxCooldown || cooldown <= 0 {
+		cooldown = mirrorMaxCooldown
+	}
+	mr.downUntil = time.Now().Add(cooldown)
+	mr.lastError = err.Error()
+	fmt.Printf("Зеркало %s недоступно (%d подряд, пауза %s): %v\n", baseURL, mr.failures, cooldown, err)
+}
+
+func (m *Mirrors) find(baseURL string) *mirror {
+	for _, mr := range m.mirrors {
+		if mr.baseURL == baseURL {
+			return mr
+		}
+	}
+	return nil
+}
+
+// Retryable сообщает, стоит ли пробовать другое зеркало (или повторить позже) после этой ошибки.
+// Ответы 4xx (кроме 429) означают, что такой страницы нет, и другое зеркало не поможет.
+func Retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// openFromMirrors выполняет GET path на первом ответившем зеркале.
+// Тело ответа нужно закрыть; второй результат — адрес зеркала, которое ответило.
+func openFromMirrors(ctx context.Context, client *http.Client, mirrors *Mirrors, path string) (*http.Response, string, error) {
+	var lastErr error
+	for _, base := range mirrors.Ordered() {
+		resp, err := get(ctx, client, base+path)
+		if err != nil {
+			err = fmt.Errorf("ошибка сети: %w", err)
+		} else if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			err = &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+
+		if err == nil {
+			mirrors.markOK(base)
+			return resp, base, nil
+		}
+		if ctx.Err() != nil {
+			// Запрос отменили мы сами — зеркало тут ни при чём.
+			return nil, base, ctx.Err()
+		}
+		if !Retryable(err) {
+			return nil, base, err
+		}
+
+		mirrors.markFailed(base, err)
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("не задано ни одного зеркала")
+	}
+	return nil, "", lastErr
+}
+
+// fetchFromMirrors загружает path целиком, переключаясь на следующее зеркало при ошибках сети,
+// 5xx и подозрительно коротких ответах (короче minSize байт).
+func fetchFromMirrors(ctx context.Context, client *http.Client, mirrors *Mirrors, path string, minSize int) (*bytes.Buffer, string, error) {
+	var lastErr error
+	for _, base := range mirrors.Ordered() {
+		body, err := fetchPage(ctx, client, base+path)
+		if err == nil && body.Len() < minSize {
+			err = fmt.Errorf("ответ слишком короткий (%d байт), возможно неполный", body.Len())
+		}
+
+		if err == nil {
+			mirrors.markOK(base)
+			return body, base, nil
+		}
+		if ctx.Err() != nil {
+			// Запрос отменили мы сами — зеркало тут ни при чём.
+			return nil, base, ctx.Err()
+		}
+		if !Retryable(err) {
+			return nil, base, err
+		}
+
+		mirrors.markFailed(base, err)
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("не задано ни одного зеркала")
+	}
+	return nil, "", lastErr
+}
+
+func fetchPage(ctx context.Context, httpClient *http.Client, targetURL string) (*bytes.Buffer, error) {
+	resp, err := get(ctx, httpClient, targetURL)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сети: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	var bodyBuf bytes.Buffer
+	if _, err := io.Copy(&bodyBuf, resp.Body); err != nil {
+		return nil, fmt.Errorf("ошибка чтения ответа: %w", err)
+	}
+
+	return &bodyBuf, nil
+}
+
+// get выполняет GET, который прерывается вместе с ctx (отмена пользователем, остановка, дедлайн).
+func get(ctx context.Context, client *http.Client, targetURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// sleepContext ждёт d или отмены ctx, смотря что наступит раньше.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// absoluteURL дописывает адрес зеркала к относительным ссылкам вида "/i/..".
+func absoluteURL(baseURL string, path string) string {
+	if strings.HasPrefix(path, "/") {
+		return strings.TrimSuffix(baseURL, "/") + path
+	}
+	return path
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetchFromMirrorsFailover(t *testing.T) {
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>обрыв</html>"))
+	}))
+	defer broken.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>" + strings.Repeat("x", minHTMLPageSize) + "</html>"))
+	}))
+	defer healthy.Close()
+
+	mirrors := NewMirrors(broken.URL, healthy.URL)
+
+	body, mirror, err := fetchFromMirrors(context.Background(), http.DefaultClient, mirrors, "/b/1", minHTMLPageSize)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if mirror != healthy.URL || body.Len() < minHTMLPageSize {
+		t.Fatalf("unexpected mirror %s (len %d)", mirror, body.Len())
+	}
+
+	// The short-response mirror is now cooling down and must be tried last.
+	if order := mirrors.Ordered(); order[0] != healthy.URL {
+		t.Fatalf("unexpected order after failure: %v", order)
+	}
+
+	if path, ok := mirrors.Path(broken.URL + "/i/1/cover.jpg"); !ok || path != "/i/1/cover.jpg" {
+		t.Fatalf("unexpected path rewrite: %q %v", path, ok)
+	}
+}
+
+func TestFetchFromMirrorsNotFoundIsFinal(t *testing.T) {
+	calls := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.NotFound(w, r)
+	})
+	first := httptest.NewServer(handler)
+	defer first.Close()
+	second := httptest.NewServer(handler)
+	defer second.Close()
+
+	mirrors := NewMirrors(first.URL, second.URL)
+
+	if _, _, err := fetchFromMirrors(context.Background(), http.DefaultClient, mirrors, "/b/404", 0); err == nil {
+		t.Fatal("expected 404 error")
+	}
+	if calls != 1 {
+		t.Fatalf("404 should not trigger failover, got %d calls", calls)
+	}
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"tor_project/internal/models"
+	"tor_project/internal/parser"
+)
+
+// ErrOPDSUnsupported означает, что операцию нельзя выполнить через OPDS и нужно идти в HTML.
+var ErrOPDSUnsupported = errors.New("операция не поддерживается OPDS")
+
+const (
+	// maxOPDSPages ограничивает обход ленты по ссылкам rel="next" (каждая страница — отдельный запрос через Tor).
+	maxOPDSPages = 5
+	// maxOPDSKnownBooks — сколько записей о книгах держим в памяти для GetBookDetails.
+	maxOPDSKnownBooks = 2000
+)
+
+// OPDSClient работает с OPDS-каталогом сайта (/opds). Atom-разметка стабильнее HTML.
+// Карточки книг из загруженных лент запоминаются, чтобы не запрашивать их повторно.
+type OPDSClient struct {
+	httpClient *http.Client
+	mirrors    *Mirrors
+
+	mu    sync.Mutex
+	known map[string]models.BookDetails
+}
+
+func NewOPDSClient(client *http.Client, mirrors *Mirrors) *OPDSClient {
+	return &OPDSClient{
+		httpClient: client,
+		mirrors:    mirrors,
+		known:      make(map[string]models.BookDetails),
+	}
+}
+
+// Search ищет книги, авторов и серии; запросы к каталогу идут параллельно. Без книг поиск
+// считается неудачным (ошибка — ищет HTML), сбой авторов или серий — нет: их раздел просто
+// будет пустым. Если не нашлось ни книг, ни авторов, возвращает ErrOPDSUnsupported: OPDS ищет
+// серии только по началу названия и мог что-то упустить, пусть попробует HTML.
+func (c *OPDSClient) Search(ctx context.Context, query string) (models.SearchResult, error) {
+	var (
+		wg                              sync.WaitGroup
+		booksFeed                       opdsPage
+		authors                         []models.Author
+		series                          []models.Series
+		booksErr, authorsErr, seriesErr error
+	)
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		booksFeed, booksErr = c.fetchFeed(ctx, "/opds/search?searchType=books&searchTerm="+url.QueryEscape(query))
+	}()
+	go func() {
+		defer wg.Done()
+		authors, authorsErr = c.SearchAuthors(ctx, query)
+	}()
+	go func() {
+		defer wg.Done()
+		series, seriesErr = c.SearchSeries(ctx, query)
+	}()
+	wg.Wait()
+
+	if booksErr != nil {
+		return models.SearchResult{}, booksErr
+	}
+	if authorsErr != nil {
+		fmt.Printf("OPDS: ошибка поиска авторов: %v\n", authorsErr)
+	}
+	if seriesErr != nil && !errors.Is(seriesErr, ErrOPDSUnsupported) {
+		fmt.Printf("OPDS: ошибка поиска серий: %v\n", seriesErr)
+	}
+
+	var result models.SearchResult
+	for _, details := range c.remember(booksFeed.Books(), booksFeed.mirror) {
+		result.Books = append(result.Books, bookFromDetails(details))
+	}
+	if len(result.Books) == 0 && len(authors) == 0 {
+		return models.SearchResult{}, ErrOPDSUnsupported
+	}
+	result.Authors = authors
+	result.Series = series
+	result.TotalBooks = len(result.Books)
+	result.TotalAuthors = len(result.Authors)
+	result.TotalSeries = len(result.Series)
+	return result, nil
+}
+
+// SearchAuthors ищет авторов через /opds/search?searchType=authors.
+func (c *OPDSClient) SearchAuthors(ctx context.Context, query string) ([]models.Author, error) {
+	feed, err := c.fetchFeed(ctx, "/opds/search?searchType=authors&searchTerm="+url.QueryEscape(query))
+	if err != nil {
+		return nil, err
+	}
+	return feed.Authors(), nil
+}
+
+// SearchSeries ищет серии по началу названия в указателе серий (/opds/sequencesindex/<начало>).
+// Если серий на это начало слишком много, указатель делит их на более длинные префиксы —
+// обходим и их, но не больше maxOPDSPages страниц. Искать слово внутри названия OPDS не умеет,
+// поэтому, если по началу ничего не нашлось, возвращает ErrOPDSUnsupported (ищет HTML).
+func (c *OPDSClient) SearchSeries(ctx context.Context, query string) ([]models.Series, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, ErrOPDSUnsupported
+	}
+
+	start := "/opds/sequencesindex/" + url.PathEscape(query)
+	queue := []string{start}
+	queued := map[string]bool{start: true}
+	found := make(map[string]bool)
+
+	var series []models.Series
+	for page := 0; page < maxOPDSPages && len(queue) > 0; page++ {
+		path := queue[0]
+		queue = queue[1:]
+
+		feed, err := c.fetchFeed(ctx, path)
+		if err != nil {
+			if page > 0 {
+				fmt.Printf("OPDS: ошибка загрузки указателя серий %s: %v\n", path, err)
+				break
+			}
+			return nil, err
+		}
+
+		for _, s := range feed.Series() {
+			if !found[s.ID] {
+				found[s.ID] = true
+				series = append(series, s)
+			}
+		}
+		for _, link := range append(feed.SeriesIndex(), feed.Next()) {
+			if link != "" && !queued[link] {
+				queued[link] = true
+				queue = append(queue, link)
+			}
+		}
+	}
+
+	if len(series) == 0 {
+		return nil, ErrOPDSUnsupported
+	}
+	return series, nil
+}
+
+// GetAuthorBooks загружает все книги автора (/opds/author/<id>/alphabet).
+func (c *OPDSClient) GetAuthorBooks(ctx context.Context, authorID string) (models.Author, []models.Book, error) {
+	feeds, err := c.fetchAll(ctx, fmt.Sprintf("/opds/author/%s/alphabet", authorID))
+	if err != nil {
+		return models.Author{}, nil, err
+	}
+
+	author := models.Author{ID: authorID}
+	var books []models.Book
+	for _, feed := range feeds {
+		for _, entry := range feed.Entries {
+			if author.Name == "" {
+				for _, a := range entry.Authors {
+					if strings.TrimSuffix(a.URI, "/") == "/a/"+authorID {
+						author.Name = strings.TrimSpace(a.Name)
+					}
+				}
+			}
+		}
+		for _, details := range c.remember(feed.Books(), feed.mirror) {
+			books = append(books, bookFromDetails(details))
+		}
+	}
+
+	author.BookCount = len(books)
+	return author, books, nil
+}
+
+// GetSeries загружает книги серии (/opds/sequencebooks/<id>) в порядке каталога.
+func (c *OPDSClient) GetSeries(ctx context.Context, seriesID string) (models.Series, error) {
+	feeds, err := c.fetchAll(ctx, fmt.Sprintf("/opds/sequencebooks/%s", seriesID))
+	if err != nil {
+		return models.Series{}, err
+	}
+
+	series := models.Series{ID: seriesID}
+	for i, feed := range feeds {
+		if i == 0 {
+			series.Title = strings.TrimSpace(feed.Title)
+		}
+		for _, details := range c.remember(feed.Books(), feed.mirror) {
+			series.Books = append(series.Books, models.SeriesBook{Book: bookFromDetails(details)})
+		}
+	}
+
+	series.BookCount = len(series.Books)
+	return series, nil
+}
+
+// GetBookDetails возвращает карточку книги. Книги из уже загруженных лент берутся из памяти,
+// остальные — из полной записи книги в каталоге (/opds/b/<id>).
+func (c *OPDSClient) GetBookDetails(ctx context.Context, bookID string) (models.BookDetails, error) {
+	c.mu.Lock()
+	details, ok := c.known[bookID]
+	c.mu.Unlock()
+	if ok {
+		return details, nil
+	}
+
+	feed, err := c.fetchFeed(ctx, "/opds/b/"+bookID)
+	if err != nil {
+		return models.BookDetails{}, err
+	}
+	for _, details := range c.remember(feed.Books(), feed.mirror) {
+		if details.ID == bookID {
+			return details, nil
+		}
+	}
+	return models.BookDetails{}, fmt.Errorf("книги %s нет в ответе OPDS", bookID)
+}
+
+// opdsPage — страница ленты вместе с зеркалом, которое её отдало (для абсолютных ссылок на обложки).
+type opdsPage struct {
+	parser.OPDSFeed
+	mirror string
+}
+
+func (c *OPDSClient) fetchFeed(ctx context.Context, path string) (opdsPage, error) {
+	body, mirror, err := fetchFromMirrors(ctx, c.httpClient, c.mirrors, path, 0)
+	if err != nil {
+		return opdsPage{}, err
+	}
+
+	feed, err := parser.ParseOPDSFeed(body)
+	if err != nil {
+		return opdsPage{}, err
+	}
+	return opdsPage{OPDSFeed: feed, mirror: mirror}, nil
+}
+
+// fetchAll загружает ленту и её продолжения (rel="next"), не более maxOPDSPages страниц.
+func (c *OPDSClient) fetchAll(ctx context.Context, path string) ([]opdsPage, error) {
+	var feeds []opdsPage
+	for page := 0; page < maxOPDSPages && path != ""; page++ {
+		feed, err := c.fetchFeed(ctx, path)
+		if err != nil {
+			if page > 0 {
+				// Часть ленты уже есть — лучше показать её, чем ничего.
+				fmt.Printf("OPDS: ошибка загрузки страницы %d: %v\n", page+1, err)
+				break
+			}
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+		path = feed.Next()
+	}
+	return feeds, nil
+}
+
+// remember сохраняет карточки книг для GetBookDetails и приводит обложки к абсолютному URL зеркала.
+func (c *OPDSClient) remember(books []models.BookDetails, mirror string) []models.BookDetails {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.known)+len(books) > maxOPDSKnownBooks {
+		c.known = make(map[string]models.BookDetails)
+	}
+
+	for i := range books {
+		if books[i].CoverPath != "" {
+			books[i].CoverPath = absoluteURL(mirror, books[i].CoverPath)
+		}
+		c.known[books[i].ID] = books[i]
+	}
+	return books
+}
+
+func bookFromDetails(details models.BookDetails) models.Book {
+	author := details.Author
+	if author == "" {
+		author = "Неизвестен"
+	}
+	return models.Book{
+		ID:     details.ID,
+		Title:  details.Title,
+		Author: author,
+	}
+}
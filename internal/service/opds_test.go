@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSite отдаёт страницы по пути с query-строкой; остальные пути — 404.
+type fakeSite struct {
+	mu       sync.Mutex
+	pages    map[string]string
+	broken   map[string]bool
+	requests []string
+}
+
+func newFakeSite(t *testing.T, pages map[string]string) (*fakeSite, *Mirrors) {
+	t.Helper()
+	site := &fakeSite{pages: pages, broken: make(map[string]bool)}
+	server := httptest.NewServer(site)
+	t.Cleanup(server.Close)
+	return site, NewMirrors(server.URL)
+}
+
+func (s *fakeSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := r.URL.RequestURI()
+	s.requests = append(s.requests, path)
+	if s.broken[path] {
+		http.Error(w, "сбой", http.StatusBadGateway)
+		return
+	}
+	page, ok := s.pages[path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte(page))
+}
+
+func (s *fakeSite) calls(prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, path := range s.requests {
+		if strings.HasPrefix(path, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func opdsFeed(entries ...string) string {
+	return `<?xml version="1.0" encoding="utf-8"?><feed xmlns="http://www.w3.org/2005/Atom"><title>Лента</title>` +
+		strings.Join(entries, "") + `</feed>`
+}
+
+func opdsBook(id, title, author string) string {
+	return `<entry><title>` + title + `</title><author><name>` + author + `</name></author>` +
+		`<link href="/i/` + id + `/cover.jpg" rel="http://opds-spec.org/image" type="image/jpeg"/>` +
+		`<link href="/b/` + id + `/fb2" rel="http://opds-spec.org/acquisition/open-access" type="application/fb2+zip"/>` +
+		`<link href="/b/` + id + `" rel="alternate" type="text/html"/></entry>`
+}
+
+func opdsNavigation(title, content, href string) string {
+	return `<entry><title>` + title + `</title><content type="text">` + content + `</content>` +
+		`<link href="` + href + `" type="application/atom+xml;profile=opds-catalog"/></entry>`
+}
+
+func TestOPDSClientSearchAndDetails(t *testing.T) {
+	ctx := context.Background()
+	site, mirrors := newFakeSite(t, map[string]string{
+		"/opds/search?searchType=books&searchTerm=1984": opdsFeed(opdsBook("609286", "1984", "Джордж Оруэлл")),
+		"/opds/search?searchType=authors&searchTerm=1984": opdsFeed(
+			`<entry><id>tag:author:9162</id><title>Оруэлл Джордж</title><content type="text">123 книги</content></entry>`),
+		"/opds/sequencesindex/1984": opdsFeed(opdsNavigation("1984 (сборник)", "2 книги", "/opds/sequencebooks/89")),
+		"/opds/b/42": `<?xml version="1.0"?><entry xmlns="http://www.w3.org/2005/Atom"><title>Скотный двор</title>` +
+			`<link href="/b/42/epub" rel="http://opds-spec.org/acquisition" type="application/epub+zip"/></entry>`,
+	})
+	client := NewOPDSClient(http.DefaultClient, mirrors)
+	mirror := mirrors.Ordered()[0]
+
+	result, err := client.Search(ctx, "1984")
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(result.Books) != 1 || result.Books[0].ID != "609286" || len(result.Authors) != 1 || result.Authors[0].BookCount != 123 {
+		t.Fatalf("result: %+v", result)
+	}
+	if len(result.Series) != 1 || result.Series[0].ID != "89" || result.TotalSeries != 1 {
+		t.Fatalf("series: %+v", result.Series)
+	}
+
+	// Книга из ленты поиска — без нового запроса, обложка уже абсолютная.
+	details, err := client.GetBookDetails(ctx, "609286")
+	if err != nil {
+		t.Fatalf("details: %v", err)
+	}
+	if details.CoverPath != mirror+"/i/609286/cover.jpg" || len(details.Formats) != 1 {
+		t.Fatalf("details: %+v", details)
+	}
+	if n := site.calls("/opds/b/"); n != 0 {
+		t.Fatalf("cached book requested from OPDS %d times", n)
+	}
+
+	// Незнакомая книга запрашивается из каталога.
+	details, err = client.GetBookDetails(ctx, "42")
+	if err != nil {
+		t.Fatalf("details: %v", err)
+	}
+	if details.Title != "Скотный двор" || len(details.Formats) != 1 || details.Formats[0].Path != "epub" {
+		t.Fatalf("details: %+v", details)
+	}
+
+	if _, err := client.GetBookDetails(ctx, "404"); err == nil {
+		t.Fatal("expected error for unknown book")
+	}
+}
+
+func TestOPDSClientSeries(t *testing.T) {
+	ctx := context.Background()
+	index := "/opds/sequencesindex/" + url.PathEscape("Ант")
+	narrower := "/opds/sequencesindex/" + url.PathEscape("Анти")
+	site, mirrors := newFakeSite(t, map[string]string{
+		index: opdsFeed(
+			opdsNavigation("Анти", "42 серии", narrower),
+			opdsNavigation("Антология фантастики", "12 книг", "/opds/sequencebooks/555"),
+		),
+		narrower: opdsFeed(
+			opdsNavigation("Антиутопии", "3 книги", "/opds/sequencebooks/777"),
+			opdsNavigation("Антология фантастики", "12 книг", "/opds/sequencebooks/555"),
+		),
+		"/opds/sequencebooks/777": `<?xml version="1.0"?><feed xmlns="http://www.w3.org/2005/Atom"><title>Антиутопии</title>` +
+			`<link href="/opds/sequencebooks/777/1" rel="next"/>` + opdsBook("1", "Мы", "Евгений Замятин") + `</feed>`,
+		"/opds/sequencebooks/777/1": opdsFeed(opdsBook("2", "1984", "Джордж Оруэлл")),
+	})
+	client := NewOPDSClient(http.DefaultClient, mirrors)
+
+	found, err := client.SearchSeries(ctx, " Ант ")
+	if err != nil {
+		t.Fatalf("search series: %v", err)
+	}
+	if len(found) != 2 || found[0].ID != "555" || found[0].BookCount != 12 || found[1].ID != "777" || found[1].Title != "Антиутопии" {
+		t.Fatalf("series: %+v", found)
+	}
+
+	// Ничего на такое начало — пусть ищет HTML.
+	if _, err := client.SearchSeries(ctx, "Юю"); err == nil {
+		t.Fatal("expected error for missing index page")
+	}
+	site.mu.Lock()
+	site.pages["/opds/sequencesindex/"+url.PathEscape("Юю")] = opdsFeed()
+	site.mu.Unlock()
+	if _, err := client.SearchSeries(ctx, "Юю"); !errors.Is(err, ErrOPDSUnsupported) {
+		t.Fatalf("empty index: got %v, want ErrOPDSUnsupported", err)
+	}
+
+	series, err := client.GetSeries(ctx, "777")
+	if err != nil {
+		t.Fatalf("series: %v", err)
+	}
+	if series.Title != "Антиутопии" || series.BookCount != 2 || series.Books[1].Title != "1984" {
+		t.Fatalf("series: %+v", series)
+	}
+}
+
+func TestOPDSClientSearchPartialResults(t *testing.T) {
+	ctx := context.Background()
+	authorsPath := "/opds/search?searchType=authors&searchTerm=1984"
+	site, mirrors := newFakeSite(t, map[string]string{
+		"/opds/search?searchType=books&searchTerm=1984": opdsFeed(opdsBook("609286", "1984", "Джордж Оруэлл")),
+		authorsPath: opdsFeed(),
+		"/opds/search?searchType=books&searchTerm=" + url.QueryEscape("мы"):   opdsFeed(),
+		"/opds/search?searchType=authors&searchTerm=" + url.QueryEscape("мы"): opdsFeed(),
+	})
+	site.broken[authorsPath] = true
+	client := NewOPDSClient(http.DefaultClient, mirrors)
+
+	// Сбой поиска авторов не мешает показать книги.
+	result, err := client.Search(ctx, "1984")
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(result.Books) != 1 || len(result.Authors) != 0 {
+		t.Fatalf("result: %+v", result)
+	}
+
+	// Ни книг, ни авторов — пусть ищет HTML.
+	if _, err := client.Search(ctx, "мы"); !errors.Is(err, ErrOPDSUnsupported) {
+		t.Fatalf("empty search: got %v, want ErrOPDSUnsupported", err)
+	}
+}
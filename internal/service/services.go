@@ -1,9 +1,9 @@
 package service
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"mime"
 	"net/http"
 	"net/url"
@@ -16,106 +16,201 @@ import (
 
 type FlibustaClient struct {
 	httpClient *http.Client
-	baseURL    string
+	mirrors    *Mirrors
+
+	// opds — необязательный OPDS-каталог; при ошибке OPDS запросы уходят в HTML-парсер.
+	opds *OPDSClient
 }
 
-func NewFlibustaClient(client *http.Client, baseURL string) *FlibustaClient {
+func NewFlibustaClient(client *http.Client, mirrors *Mirrors) *FlibustaClient {
 	return &FlibustaClient{
 		httpClient: client,
-		baseURL:    baseURL,
+		mirrors:    mirrors,
 	}
 }
 
-// Search ищет книги (код из предыдущего этапа)
-func (s *FlibustaClient) Search(query string) ([]models.Book, error) {
+// UseOPDS включает OPDS-каталог как основной источник; HTML остаётся запасным.
+func (s *FlibustaClient) UseOPDS(opds *OPDSClient) {
+	s.opds = opds
+}
+
+// Search ищет книги, авторов и серии; результат разбит по разделам страницы поиска.
+func (s *FlibustaClient) Search(ctx context.Context, query string) (models.SearchResult, error) {
+	if s.opds != nil {
+		result, err := s.opds.Search(ctx, query)
+		if err == nil {
+			return result, nil
+		}
+		logOPDSFallback("Search", err)
+	}
+
 	const maxAttempts = 3
 
 	// Подготовка запроса
 	safeQuery := url.QueryEscape(query)
-	targetURL := fmt.Sprintf("%s/booksearch?ask=%s", s.baseURL, safeQuery)
+	path := "/booksearch?ask=" + safeQuery
 
-	fmt.Printf("Запрос поиска: %s\n", targetURL)
+	fmt.Printf("Запрос поиска: %s\n", path)
 
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		// Выполнение запроса (Сеть)
-		resp, err := s.httpClient.Get(targetURL)
+		// Выполнение запроса (Сеть). Обрыв, 5xx и слишком короткий ответ переключают зеркало.
+		bodyBuf, mirror, err := fetchFromMirrors(ctx, s.httpClient, s.mirrors, path, minHTMLPageSize)
 		if err != nil {
-			return nil, fmt.Errorf("ошибка сети: %w", err)
+			return models.SearchResult{}, err
 		}
 
-		if resp.StatusCode != 200 {
-			resp.Body.Close()
-			return nil, fmt.Errorf("сервер вернул код: %d", resp.StatusCode)
+		fmt.Printf("Размер ответа: %d байт от %s (попытка %d/%d)\n", bodyBuf.Len(), mirror, attempt, maxAttempts)
+		_ = os.WriteFile("last_search_response.html", bodyBuf.Bytes(), 0644)
+
+		// Обработка ответа (Парсер)
+		result, err := parser.ParseSearchResult(bodyBuf)
+		if err != nil {
+			return models.SearchResult{}, fmt.Errorf("ошибка парсинга: %w", err)
 		}
 
-		// Читаем тело ответа в буфер, чтобы убедиться, что оно полностью загружено
-		var bodyBuf bytes.Buffer
-		if _, err := io.Copy(&bodyBuf, resp.Body); err != nil {
-			resp.Body.Close()
-			return nil, fmt.Errorf("ошибка чтения ответа: %w", err)
+		fmt.Printf("Найдено книг: %d, авторов: %d, серий: %d\n", result.TotalBooks, result.TotalAuthors, result.TotalSeries)
+		if !result.IsEmpty() {
+			return result, nil
 		}
-		resp.Body.Close()
 
-		// Проверяем минимальный размер ответа (если меньше 1000 байт, вероятно неполный)
-		if bodyBuf.Len() < 1000 {
-			return nil, fmt.Errorf("ответ слишком короткий (%d байт), возможно неполный", bodyBuf.Len())
+		if attempt < maxAttempts {
+			fmt.Printf("Ничего не найдено, повторяю через 1с (попытка %d/%d)\n", attempt+1, maxAttempts)
+			if err := sleepContext(ctx, 1*time.Second); err != nil {
+				return models.SearchResult{}, err
+			}
 		}
+	}
 
-		fmt.Printf("Размер ответа: %d байт (попытка %d/%d)\n", bodyBuf.Len(), attempt, maxAttempts)
-		_ = os.WriteFile("last_search_response.html", bodyBuf.Bytes(), 0644)
+	// После всех попыток — пустой результат без ошибки
+	return models.SearchResult{}, nil
+}
 
-		// Обработка ответа (Парсер)
-		books, err := parser.ParseBooks(&bodyBuf)
-		if err != nil {
-			return nil, fmt.Errorf("ошибка парсинга: %w", err)
+// SearchAuthors ищет авторов по запросу (раздел "Найденные писатели" страницы поиска).
+func (s *FlibustaClient) SearchAuthors(ctx context.Context, query string) ([]models.Author, error) {
+	if s.opds != nil {
+		authors, err := s.opds.SearchAuthors(ctx, query)
+		if err == nil {
+			return authors, nil
 		}
+		logOPDSFallback("SearchAuthors", err)
+	}
+
+	path := fmt.Sprintf("/booksearch?ask=%s&cha=on", url.QueryEscape(query))
 
-		fmt.Printf("Найдено книг: %d\n", len(books))
-		if len(books) > 0 {
-			return books, nil
+	fmt.Printf("Запрос поиска авторов: %s\n", path)
+
+	body, _, err := fetchFromMirrors(ctx, s.httpClient, s.mirrors, path, minHTMLPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	authors, err := parser.ParseAuthors(body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка парсинга: %w", err)
+	}
+
+	fmt.Printf("Найдено авторов: %d\n", len(authors))
+	return authors, nil
+}
+
+// GetAuthorBooks загружает страницу автора (/a/<id>) и возвращает его библиографию.
+func (s *FlibustaClient) GetAuthorBooks(ctx context.Context, authorID string) (models.Author, []models.Book, error) {
+	if s.opds != nil {
+		author, books, err := s.opds.GetAuthorBooks(ctx, authorID)
+		if err == nil {
+			return author, books, nil
 		}
+		logOPDSFallback("GetAuthorBooks", err)
+	}
 
-		if attempt < maxAttempts {
-			fmt.Printf("Книги не найдены, повторяю через 1с (попытка %d/%d)\n", attempt+1, maxAttempts)
-			time.Sleep(1 * time.Second)
+	body, _, err := fetchFromMirrors(ctx, s.httpClient, s.mirrors, "/a/"+authorID, minHTMLPageSize)
+	if err != nil {
+		return models.Author{}, nil, err
+	}
+
+	author, books, err := parser.ParseAuthorBooks(body, authorID)
+	if err != nil {
+		return models.Author{}, nil, fmt.Errorf("ошибка парсинга: %w", err)
+	}
+
+	return author, books, nil
+}
+
+// SearchSeries ищет серии по запросу (раздел "Найденные серии" страницы поиска).
+func (s *FlibustaClient) SearchSeries(ctx context.Context, query string) ([]models.Series, error) {
+	if s.opds != nil {
+		series, err := s.opds.SearchSeries(ctx, query)
+		if err == nil {
+			return series, nil
 		}
+		logOPDSFallback("SearchSeries", err)
 	}
 
-	// После всех попыток — пустой результат без ошибки
-	return []models.Book{}, nil
+	path := fmt.Sprintf("/booksearch?ask=%s&chs=on", url.QueryEscape(query))
+
+	fmt.Printf("Запрос поиска серий: %s\n", path)
+
+	body, _, err := fetchFromMirrors(ctx, s.httpClient, s.mirrors, path, minHTMLPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	series, err := parser.ParseSeriesResults(body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка парсинга: %w", err)
+	}
+
+	fmt.Printf("Найдено серий: %d\n", len(series))
+	return series, nil
+}
+
+// GetSeries загружает страницу серии (/sequence/<id>) и возвращает тома в порядке чтения.
+func (s *FlibustaClient) GetSeries(ctx context.Context, seriesID string) (models.Series, error) {
+	if s.opds != nil {
+		series, err := s.opds.GetSeries(ctx, seriesID)
+		if err == nil {
+			return series, nil
+		}
+		logOPDSFallback("GetSeries", err)
+	}
+
+	body, _, err := fetchFromMirrors(ctx, s.httpClient, s.mirrors, "/sequence/"+seriesID, minHTMLPageSize)
+	if err != nil {
+		return models.Series{}, err
+	}
+
+	series, err := parser.ParseSeries(body, seriesID)
+	if err != nil {
+		return models.Series{}, fmt.Errorf("ошибка парсинга: %w", err)
+	}
+
+	return series, nil
 }
 
 // DownloadFB2 скачивает книгу по ID.
-// Возвращает:
-// 1. Поток данных (body), который НУЖНО закрыть после чтения.
-// 2. Имя файла (которое предложил сервер, или сгенерированное).
-// 3. Ошибку.
-func (s *FlibustaClient) DownloadFB2(bookID string) (io.ReadCloser, string, error) {
-	return s.Download(bookID, "fb2")
+// Возвращает поток данных (Body), который НУЖНО закрыть после чтения,
+// и имя файла (которое предложил сервер, или сгенерированное).
+func (s *FlibustaClient) DownloadFB2(ctx context.Context, bookID string) (DownloadStream, error) {
+	return s.Download(ctx, bookID, "fb2")
 }
 
 // Download скачивает книгу по ID и формату.
 // formatPath examples: "fb2", "epub", "mobi", "fb2.zip".
-func (s *FlibustaClient) Download(bookID string, formatPath string) (io.ReadCloser, string, error) {
+func (s *FlibustaClient) Download(ctx context.Context, bookID string, formatPath string) (DownloadStream, error) {
 	formatPath = strings.TrimSpace(formatPath)
 	if formatPath == "" {
-		return nil, "", fmt.Errorf("пустой формат скачивания")
+		return DownloadStream{}, fmt.Errorf("пустой формат скачивания")
 	}
 
-	// Формируем URL: /b/{id}/{format}
-	downloadURL := fmt.Sprintf("%s/b/%s/%s", s.baseURL, bookID, formatPath)
-
-	fmt.Printf("Запрос на скачивание: %s\n", downloadURL)
+	// Формируем путь: /b/{id}/{format}; зеркало выбирается по доступности
+	downloadPath := fmt.Sprintf("/b/%s/%s", bookID, formatPath)
 
-	resp, err := s.httpClient.Get(downloadURL)
+	resp, mirror, err := openFromMirrors(ctx, s.httpClient, s.mirrors, downloadPath)
 	if err != nil {
-		return nil, "", fmt.Errorf("ошибка сети при скачивании: %w", err)
+		return DownloadStream{}, fmt.Errorf("ошибка при скачивании: %w", err)
 	}
 
-	if resp.StatusCode != 200 {
-		resp.Body.Close() // Обязательно закрываем, если не возвращаем наружу
-		return nil, "", fmt.Errorf("сервер вернул ошибку: %s", resp.Status)
-	}
+	fmt.Printf("Скачивание: %s%s\n", mirror, downloadPath)
 
 	// Пытаемся узнать имя файла, которое предлагает сервер
 	// Обычно оно лежит в заголовке Content-Disposition
@@ -123,59 +218,60 @@ func (s *FlibustaClient) Download(bookID string, formatPath string) (io.ReadClos
 
 	// Возвращаем тело ответа (Stream).
 	// ВАЖНО: Мы НЕ закрываем resp.Body здесь, это должен сделать тот, кто вызвал функцию.
-	return resp.Body, filename, nil
+	// ContentLength = -1, если сервер не прислал размер.
+	return DownloadStream{Body: resp.Body, Filename: filename, Size: resp.ContentLength}, nil
 }
 
 // GetBookDetails fetches a book page (/b/<id>) and extracts cover + available formats.
-func (s *FlibustaClient) GetBookDetails(bookID string) (models.BookDetails, error) {
-	targetURL := fmt.Sprintf("%s/b/%s", s.baseURL, bookID)
-
-	resp, err := s.httpClient.Get(targetURL)
-	if err != nil {
-		return models.BookDetails{}, fmt.Errorf("ошибка сети: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return models.BookDetails{}, fmt.Errorf("сервер вернул код: %d", resp.StatusCode)
+func (s *FlibustaClient) GetBookDetails(ctx context.Context, bookID string) (models.BookDetails, error) {
+	if s.opds != nil {
+		details, err := s.opds.GetBookDetails(ctx, bookID)
+		if err == nil {
+			return details, nil
+		}
+		logOPDSFallback("GetBookDetails", err)
 	}
 
-	var bodyBuf bytes.Buffer
-	if _, err := io.Copy(&bodyBuf, resp.Body); err != nil {
-		return models.BookDetails{}, fmt.Errorf("ошибка чтения ответа: %w", err)
+	body, mirror, err := fetchFromMirrors(ctx, s.httpClient, s.mirrors, "/b/"+bookID, minHTMLPageSize)
+	if err != nil {
+		return models.BookDetails{}, err
 	}
 
-	details, err := parser.ParseBookDetails(&bodyBuf, bookID)
+	details, err := parser.ParseBookDetails(body, bookID)
 	if err != nil {
 		return models.BookDetails{}, err
 	}
 
-	// Normalize cover path to absolute URL (needed to download the image via Tor client).
-	if strings.HasPrefix(details.CoverPath, "/") {
-		details.CoverPath = s.baseURL + details.CoverPath
-	}
+	// Normalize cover path to absolute URL on the mirror that answered (needed to download the image via Tor client).
+	details.CoverPath = absoluteURL(mirror, details.CoverPath)
 
 	return details, nil
 }
 
 // DownloadBytes downloads an arbitrary URL via the configured HTTP client (Tor) and returns bytes.
-func (s *FlibustaClient) DownloadBytes(targetURL string) ([]byte, error) {
-	resp, err := s.httpClient.Get(targetURL)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка сети: %w", err)
+// URLs pointing to one of the mirrors are retried on the other mirrors.
+func (s *FlibustaClient) DownloadBytes(ctx context.Context, targetURL string) ([]byte, error) {
+	if path, ok := s.mirrors.Path(targetURL); ok {
+		buf, _, err := fetchFromMirrors(ctx, s.httpClient, s.mirrors, path, 0)
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("сервер вернул ошибку: %s", resp.Status)
+	buf, err := fetchPage(ctx, s.httpClient, targetURL)
+	if err != nil {
+		return nil, err
 	}
+	return buf.Bytes(), nil
+}
 
-	var buf bytes.Buffer
-	if _, err := io.Copy(&buf, resp.Body); err != nil {
-		return nil, fmt.Errorf("ошибка чтения ответа: %w", err)
+func logOPDSFallback(op string, err error) {
+	// Отменённый контекст не повод для фолбэка: HTML-запрос всё равно сразу завершится той же ошибкой.
+	if errors.Is(err, ErrOPDSUnsupported) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
 	}
-
-	return buf.Bytes(), nil
+	fmt.Printf("OPDS %s: %v, переключаюсь на HTML\n", op, err)
 }
 
 // Вспомогательная функция для вытаскивания имени файла
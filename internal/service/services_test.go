@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// htmlPage дополняет страницу до minHTMLPageSize, иначе ответ считается обрывом.
+func htmlPage(body string) string {
+	return "<html><body>" + body + "<!--" + strings.Repeat(" ", minHTMLPageSize) + "--></body></html>"
+}
+
+func TestFlibustaClientOPDSFallback(t *testing.T) {
+	ctx := context.Background()
+	authorsPath := "/opds/search?searchType=authors&searchTerm=" + url.QueryEscape("оруэлл")
+	site, mirrors := newFakeSite(t, map[string]string{
+		authorsPath: opdsFeed(`<entry><id>tag:author:9162</id><title>Оруэлл Джордж</title></entry>`),
+		"/booksearch?ask=" + url.QueryEscape("оруэлл") + "&cha=on": htmlPage(
+			`<h3>Найденные писатели (1 - 1 из 1):</h3><ul><li><a href="/a/9162">Джордж Оруэлл</a> (123 книги)</li></ul>`),
+		"/opds/sequencesindex/" + url.PathEscape("1984"): opdsFeed(),
+		"/booksearch?ask=1984&chs=on": htmlPage(
+			`<h3>Найденные серии (1 - 1 из 1):</h3><ul><li><a href="/sequence/89934">Проект 1984</a> (4 книги)</li></ul>`),
+		"/opds/search?searchType=books&searchTerm=" + url.QueryEscape("дети дюны"):   opdsFeed(),
+		"/opds/search?searchType=authors&searchTerm=" + url.QueryEscape("дети дюны"): opdsFeed(),
+		"/booksearch?ask=" + url.QueryEscape("дети дюны"): htmlPage(
+			`<h3>Найденные книги (1 - 1 из 1):</h3><ul><li><a href="/b/303">Дети Дюны</a> - <a href="/a/10">Фрэнк Герберт</a></li></ul>`),
+		"/b/7": htmlPage(`<div id="main"><h1 class="title">Мы (fb2)</h1><a href="/a/5">Евгений Замятин</a>
+<img src="/i/7/cover.jpg" title="Cover image"/> <a href="/b/7/fb2">(fb2)</a></div>`),
+	})
+	client := NewFlibustaClient(http.DefaultClient, mirrors)
+	client.UseOPDS(NewOPDSClient(http.DefaultClient, mirrors))
+
+	// OPDS отвечает — HTML не нужен.
+	authors, err := client.SearchAuthors(ctx, "оруэлл")
+	if err != nil {
+		t.Fatalf("search authors: %v", err)
+	}
+	if len(authors) != 1 || authors[0].Name != "Оруэлл Джордж" || site.calls("/booksearch") != 0 {
+		t.Fatalf("authors from OPDS: %+v", authors)
+	}
+
+	// OPDS сломался — тот же запрос уходит в HTML.
+	site.mu.Lock()
+	site.broken[authorsPath] = true
+	site.mu.Unlock()
+	authors, err = client.SearchAuthors(ctx, "оруэлл")
+	if err != nil {
+		t.Fatalf("search authors: %v", err)
+	}
+	if len(authors) != 1 || authors[0].Name != "Джордж Оруэлл" || authors[0].BookCount != 123 {
+		t.Fatalf("authors from HTML: %+v", authors)
+	}
+
+	// Серии, которых нет в указателе OPDS, ищутся в HTML.
+	series, err := client.SearchSeries(ctx, "1984")
+	if err != nil {
+		t.Fatalf("search series: %v", err)
+	}
+	if len(series) != 1 || series[0].ID != "89934" || series[0].BookCount != 4 {
+		t.Fatalf("series from HTML: %+v", series)
+	}
+
+	// OPDS ничего не нашёл (он ищет серии только по началу названия) — ищет HTML.
+	result, err := client.Search(ctx, "дети дюны")
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(result.Books) != 1 || result.Books[0].ID != "303" {
+		t.Fatalf("search from HTML: %+v", result)
+	}
+
+	// Книги нет в каталоге OPDS — карточка со страницы книги.
+	details, err := client.GetBookDetails(ctx, "7")
+	if err != nil {
+		t.Fatalf("details: %v", err)
+	}
+	if site.calls("/opds/b/7") != 1 || details.Author != "Евгений Замятин" || len(details.Formats) != 1 {
+		t.Fatalf("details from HTML: %+v", details)
+	}
+	if details.CoverPath != mirrors.Ordered()[0]+"/i/7/cover.jpg" {
+		t.Fatalf("cover is not absolute: %q", details.CoverPath)
+	}
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrNotArchive — файл не архив с книгой (в том числе EPUB: он тоже zip, но читается как есть).
+var ErrNotArchive = errors.New("файл не архив с книгой")
+
+// MaxUnpackedSize — разумный предел для книги, распакованной из архива.
+const MaxUnpackedSize = 200 * 1024 * 1024
+
+// zipMagic — сигнатура начала zip-архива.
+var zipMagic = []byte("PK\x03\x04")
+
+// archivedBookExts — форматы книг, которые ищем внутри архива, в порядке предпочтения.
+var archivedBookExts = []string{".fb2", ".epub", ".txt", ".rtf", ".html", ".htm", ".pdf", ".djvu", ".mobi", ".doc"}
+
+// UnpackBook распаковывает книгу из zip-архива, лежащего в хранилище под ключом key,
+// и сохраняет её рядом как обычный файл (см. SaveBookFile). maxSize ограничивает
+// размер распакованной книги — защита от архивов-бомб. Если это не архив с книгой, — ErrNotArchive.
+func UnpackBook(ctx context.Context, blobs BlobStore, key string, maxSize int64) (SavedFile, error) {
+	body, err := blobs.Get(ctx, key)
+	if err != nil {
+		return SavedFile{}, err
+	}
+	defer body.Close()
+
+	head := make([]byte, len(zipMagic))
+	n, err := io.ReadFull(body, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return SavedFile{}, fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+	if !bytes.Equal(head[:n], zipMagic) {
+		return SavedFile{}, ErrNotArchive
+	}
+
+	// zip читается с конца (оглавление), поэтому архив нужен целиком в локальном файле.
+	tmp, err := os.CreateTemp("", "archive-*")
+	if err != nil {
+		return SavedFile{}, fmt.Errorf("не удалось создать файл: %w", err)
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	size, err := io.Copy(tmp, io.MultiReader(bytes.NewReader(head), body))
+	if err != nil {
+		return SavedFile{}, fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+
+	archive, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return SavedFile{}, ErrNotArchive
+	}
+	entry := findArchivedBook(archive.File)
+	if entry == nil {
+		return SavedFile{}, ErrNotArchive
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		return SavedFile{}, fmt.Errorf("ошибка распаковки %s: %w", entry.Name, err)
+	}
+	defer rc.Close()
+
+	return SaveBookFile(ctx, blobs, rc, maxSize)
+}
+
+// findArchivedBook выбирает книгу среди файлов архива. Архив с файлом "mimetype" —
+// это сам EPUB (или похожий контейнер), его не распаковываем.
+func findArchivedBook(files []*zip.File) *zip.File {
+	for _, f := range files {
+		if f.Name == "mimetype" {
+			return nil
+		}
+	}
+
+	for _, ext := range archivedBookExts {
+		for _, f := range files {
+			if f.FileInfo().IsDir() || strings.HasPrefix(path.Base(f.Name), ".") {
+				continue
+			}
+			if strings.EqualFold(path.Ext(f.Name), ext) {
+				return f
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func zipBytes(t *testing.T, files map[string]string, order ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range order {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(f, files[name])
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUnpackBook(t *testing.T) {
+	ctx := context.Background()
+	blobs := NewFSStore(t.TempDir())
+
+	save := func(data []byte) SavedFile {
+		t.Helper()
+		saved, err := SaveBookFile(ctx, blobs, bytes.NewReader(data), 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return saved
+	}
+
+	archive := save(zipBytes(t, map[string]string{
+		"readme.txt":      "не книга",
+		"Orwell_1984.fb2": "<FictionBook>1984</FictionBook>",
+	}, "readme.txt", "Orwell_1984.fb2"))
+
+	book, err := UnpackBook(ctx, blobs, archive.RelativePath, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(book.RelativePath, ".fb2") || book.MimeType != "application/x-fictionbook+xml" {
+		t.Fatalf("unpacked: %+v", book)
+	}
+	body, err := blobs.Get(ctx, book.RelativePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "<FictionBook>1984</FictionBook>" {
+		t.Fatalf("content: %q", data)
+	}
+
+	// Слишком большая книга внутри архива.
+	if _, err := UnpackBook(ctx, blobs, archive.RelativePath, 10); err != ErrTooLarge {
+		t.Fatalf("too large: got %v", err)
+	}
+
+	// EPUB — тоже zip, но его не распаковываем; как и обычную книгу.
+	epub := save(zipBytes(t, map[string]string{
+		"mimetype":          "application/epub+zip",
+		"OEBPS/content.opf": "<package/>",
+	}, "mimetype", "OEBPS/content.opf"))
+	plain := save([]byte("<FictionBook/>"))
+	if !strings.HasSuffix(epub.RelativePath, ".epub") {
+		t.Fatalf("epub path: %q", epub.RelativePath)
+	}
+	for _, key := range []string{epub.RelativePath, plain.RelativePath} {
+		if _, err := UnpackBook(ctx, blobs, key, 1024); err != ErrNotArchive {
+			t.Fatalf("%s: got %v, want ErrNotArchive", key, err)
+		}
+	}
+}
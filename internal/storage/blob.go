@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// BlobStore — хранилище файлов книг по ключу вида "ab/cd/<sha256>.fb2" (всегда через "/").
+// Отсутствующий объект — ErrMissing.
+type BlobStore interface {
+	// Put записывает объект целиком; size — его размер в байтах.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange читает length байт начиная с offset.
+	GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (BlobInfo, error)
+	// Delete удаляет объект; удаление отсутствующего — не ошибка.
+	Delete(ctx context.Context, key string) error
+	// List вызывает fn для каждого объекта хранилища.
+	List(ctx context.Context, fn func(BlobInfo) error) error
+}
+
+// BlobInfo — сведения об объекте хранилища.
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// OpenBlob открывает объект для чтения с перемоткой (например, для http.ServeContent):
+// байты читаются диапазонами с текущей позиции, весь объект заранее не загружается.
+func OpenBlob(ctx context.Context, blobs BlobStore, key string) (io.ReadSeekCloser, BlobInfo, error) {
+	info, err := blobs.Stat(ctx, key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	return &blobReader{ctx: ctx, blobs: blobs, key: key, size: info.Size}, info, nil
+}
+
+type blobReader struct {
+	ctx    context.Context
+	blobs  BlobStore
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.blobs.GetRange(r.ctx, r.key, r.offset, r.size-r.offset)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("некорректный whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("отрицательная позиция")
+	}
+
+	if offset != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *blobReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
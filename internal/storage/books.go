@@ -1,75 +1,188 @@
 package storage
 
 import (
-	"crypto/rand"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
-	"path/filepath"
+	"path"
+	"strings"
 )
 
+// ErrTooLarge — файл больше допустимого размера.
+var ErrTooLarge = errors.New("файл слишком большой")
+
 type SavedFile struct {
+	// RelativePath — ключ в хранилище вида "ab/cd/<sha256>.fb2" (всегда через "/").
 	RelativePath string
 	SizeBytes    int64
+	SHA256       string
+	MimeType     string
+	// Deduplicated — такой файл уже лежал в хранилище, новый не записывался.
+	Deduplicated bool
 }
 
-func SaveBookFile(baseDir string, originalName string, data io.Reader, maxSize int64) (SavedFile, error) {
-	if baseDir == "" {
-		return SavedFile{}, fmt.Errorf("пустая директория хранения")
-	}
-
-	if err := os.MkdirAll(baseDir, 0755); err != nil {
-		return SavedFile{}, fmt.Errorf("не удалось создать директорию хранения: %w", err)
-	}
-
-	ext := filepath.Ext(originalName)
-	if ext == "" {
-		ext = ".bin"
-	}
-
-	name, err := randomHex(16)
-	if err != nil {
-		return SavedFile{}, fmt.Errorf("не удалось сгенерировать имя файла: %w", err)
-	}
-
-	filename := name + ext
-	fullPath := filepath.Join(baseDir, filename)
-
-	out, err := os.Create(fullPath)
+// SaveBookFile сохраняет файл по его содержимому: ключ — SHA-256 байтов, разложенный
+// по подкаталогам из первых символов хэша, и расширение по сигнатуре файла (имя, под
+// которым его отдал сайт, не учитывается). Одинаковые файлы хранятся один раз.
+func SaveBookFile(ctx context.Context, blobs BlobStore, data io.Reader, maxSize int64) (SavedFile, error) {
+	// Ключ известен только после хэширования, поэтому сначала копим файл локально.
+	tmp, err := os.CreateTemp("", "download-*")
 	if err != nil {
 		return SavedFile{}, fmt.Errorf("не удалось создать файл: %w", err)
 	}
-	defer out.Close()
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
 
 	reader := data
 	if maxSize > 0 {
 		reader = io.LimitReader(data, maxSize+1)
 	}
 
-	n, err := io.Copy(out, reader)
+	hash := sha256.New()
+	sniff := &headWriter{limit: 512}
+	n, err := io.Copy(io.MultiWriter(tmp, hash, sniff), reader)
 	if err != nil {
-		_ = os.Remove(fullPath)
 		return SavedFile{}, fmt.Errorf("ошибка записи файла: %w", err)
 	}
 
 	if maxSize > 0 && n > maxSize {
-		_ = os.Remove(fullPath)
-		return SavedFile{}, fmt.Errorf("файл слишком большой")
+		return SavedFile{}, ErrTooLarge
 	}
 
-	return SavedFile{
-		RelativePath: filename,
+	sum := hex.EncodeToString(hash.Sum(nil))
+	ext := sniffExt(sniff.buf)
+	saved := SavedFile{
+		RelativePath: contentPath(sum, ext),
 		SizeBytes:    n,
-	}, nil
+		SHA256:       sum,
+		MimeType:     detectMimeType(ext, sniff.buf),
+	}
+
+	// Такой файл уже есть — новый не нужен. Ключ и есть хэш, поэтому хватает совпадения
+	// размера; целостность содержимого проверяет Verify.
+	if info, err := blobs.Stat(ctx, saved.RelativePath); err == nil && info.Size == n {
+		saved.Deduplicated = true
+		return saved, nil
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return SavedFile{}, fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+	if err := blobs.Put(ctx, saved.RelativePath, tmp, n); err != nil {
+		return SavedFile{}, fmt.Errorf("не удалось сохранить файл: %w", err)
+	}
+
+	return saved, nil
 }
 
-func randomHex(n int) (string, error) {
-	if n <= 0 {
-		return "", fmt.Errorf("некорректная длина")
+// contentPath — "ab/cd/<hash><ext>": два уровня подкаталогов, чтобы в одной папке не копились тысячи файлов.
+func contentPath(sum string, ext string) string {
+	return path.Join(sum[:2], sum[2:4], sum+ext)
+}
+
+// Сигнатуры форматов книг в начале файла.
+var (
+	pdfMagic  = []byte("%PDF-")
+	djvuMagic = []byte("AT&TFORM")
+	rtfMagic  = []byte("{\\rtf")
+	docMagic  = []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1")
+	mobiMagic = []byte("BOOKMOBI") // со смещения 60, как и у AZW3
+	utf8BOM   = []byte("\xEF\xBB\xBF")
+)
+
+// sniffExt определяет расширение файла книги по первым байтам. Сайт отдаёт fb2.zip и под
+// именем .fb2, поэтому у zip-архива расширение берётся из имени первого файла внутри
+// (оно есть в локальном заголовке): ".fb2.zip". EPUB тоже zip, но первым в нём лежит "mimetype".
+func sniffExt(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, zipMagic):
+		name := zipFirstName(head)
+		if name == "mimetype" {
+			return ".epub"
+		}
+		for _, ext := range archivedBookExts {
+			if strings.EqualFold(path.Ext(name), ext) {
+				return ext + ".zip"
+			}
+		}
+		return ".zip"
+	case bytes.HasPrefix(head, pdfMagic):
+		return ".pdf"
+	case bytes.HasPrefix(head, djvuMagic):
+		return ".djvu"
+	case bytes.HasPrefix(head, rtfMagic):
+		return ".rtf"
+	case bytes.HasPrefix(head, docMagic):
+		return ".doc"
+	case len(head) >= 68 && bytes.Equal(head[60:68], mobiMagic):
+		return ".mobi"
+	case bytes.Contains(head, []byte("<FictionBook")):
+		return ".fb2"
+	}
+
+	mimeType := http.DetectContentType(bytes.TrimPrefix(head, utf8BOM))
+	switch {
+	case strings.HasPrefix(mimeType, "text/html"):
+		return ".html"
+	case strings.HasPrefix(mimeType, "text/plain"):
+		return ".txt"
+	}
+	return ".bin"
+}
+
+// zipFirstName — имя первого файла zip-архива из его локального заголовка (если он
+// целиком попал в head).
+func zipFirstName(head []byte) string {
+	const headerLen = 30
+	if len(head) < headerLen {
+		return ""
+	}
+	nameLen := int(binary.LittleEndian.Uint16(head[26:28]))
+	if len(head) < headerLen+nameLen {
+		return ""
 	}
-	buf := make([]byte, n)
-	if _, err := rand.Read(buf); err != nil {
-		return "", err
+	return string(head[headerLen : headerLen+nameLen])
+}
+
+// bookMimeTypes — форматы книг, которых нет в стандартной таблице MIME.
+var bookMimeTypes = map[string]string{
+	".fb2":     "application/x-fictionbook+xml",
+	".fb2.zip": "application/x-zip-compressed-fb2",
+	".epub":    "application/epub+zip",
+	".mobi":    "application/x-mobipocket-ebook",
+	".azw3":    "application/vnd.amazon.ebook",
+	".djvu":    "image/vnd.djvu",
+	".pdf":     "application/pdf",
+	".doc":     "application/msword",
+	".rtf":     "application/rtf",
+	".txt":     "text/plain; charset=utf-8",
+}
+
+func detectMimeType(ext string, head []byte) string {
+	if mimeType, ok := bookMimeTypes[ext]; ok {
+		return mimeType
+	}
+	return http.DetectContentType(head)
+}
+
+// headWriter запоминает первые limit байт потока (для определения MIME-типа).
+type headWriter struct {
+	buf   []byte
+	limit int
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if room := w.limit - len(w.buf); room > 0 {
+		w.buf = append(w.buf, p[:min(room, len(p))]...)
 	}
-	return fmt.Sprintf("%x", buf), nil
+	return len(p), nil
 }
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveBookFileDeduplicatesAndVerifies(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	blobs := NewFSStore(dir)
+
+	first, err := SaveBookFile(ctx, blobs, strings.NewReader("<FictionBook/>"), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := SaveBookFile(ctx, blobs, strings.NewReader("<FictionBook/>"), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.RelativePath != second.RelativePath || !second.Deduplicated || first.Deduplicated {
+		t.Fatalf("not deduplicated: %+v vs %+v", first, second)
+	}
+	want := first.SHA256[:2] + "/" + first.SHA256[2:4] + "/" + first.SHA256 + ".fb2"
+	if first.RelativePath != want {
+		t.Fatalf("path: got %q, want %q", first.RelativePath, want)
+	}
+	if first.MimeType != "application/x-fictionbook+xml" {
+		t.Fatalf("mime: %q", first.MimeType)
+	}
+
+	zipped, err := SaveBookFile(ctx, blobs, bytes.NewReader(zipBytes(t, map[string]string{"Orwell_1984.fb2": "<FictionBook/>"}, "Orwell_1984.fb2")), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(zipped.RelativePath, ".fb2.zip") {
+		t.Fatalf("zip path: %q", zipped.RelativePath)
+	}
+
+	if _, err := SaveBookFile(ctx, blobs, strings.NewReader("0123456789"), 5); err != ErrTooLarge {
+		t.Fatalf("too large: got %v", err)
+	}
+
+	// Портим один файл и удаляем другой.
+	if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(first.RelativePath)), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, filepath.FromSlash(zipped.RelativePath))); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Verify(ctx, blobs, []FileCheck{
+		{ID: 1, Path: first.RelativePath, SHA256: first.SHA256},
+		{ID: 2, Path: zipped.RelativePath, SHA256: zipped.SHA256},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 2 || len(report.Corrupted) != 1 || report.Corrupted[0].ID != 1 || len(report.Missing) != 1 || report.Missing[0].ID != 2 {
+		t.Fatalf("report: %+v", report)
+	}
+}
+
+func TestSaveBookFileRewritesTruncatedBlob(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	blobs := NewFSStore(dir)
+
+	saved, err := SaveBookFile(ctx, blobs, strings.NewReader("<FictionBook>1984</FictionBook>"), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := filepath.Join(dir, filepath.FromSlash(saved.RelativePath))
+	if err := os.WriteFile(full, []byte("<FictionBook>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Недописанный файл под тем же ключом за копию не считается.
+	again, err := SaveBookFile(ctx, blobs, strings.NewReader("<FictionBook>1984</FictionBook>"), 1024)
+	if err != nil || again.Deduplicated {
+		t.Fatalf("again: %+v, %v", again, err)
+	}
+	if data, _ := os.ReadFile(full); string(data) != "<FictionBook>1984</FictionBook>" {
+		t.Fatalf("content: %q", data)
+	}
+}
+
+func TestSniffExt(t *testing.T) {
+	mobi := append(make([]byte, 60), "BOOKMOBI"...)
+	tests := []struct {
+		head []byte
+		want string
+	}{
+		{[]byte(`<?xml version="1.0" encoding="utf-8"?><FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0">`), ".fb2"},
+		{[]byte("%PDF-1.4"), ".pdf"},
+		{[]byte("AT&TFORM\x00\x00"), ".djvu"},
+		{[]byte("{\\rtf1\\ansi"), ".rtf"},
+		{mobi, ".mobi"},
+		{[]byte("\xEF\xBB\xBFПросто текст книги"), ".txt"},
+		{[]byte("<html><body>книга</body></html>"), ".html"},
+		{[]byte{0x00, 0x01, 0x02}, ".bin"},
+	}
+	for _, tt := range tests {
+		if got := sniffExt(tt.head); got != tt.want {
+			t.Errorf("sniffExt(%q) = %q, want %q", tt.head, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FSStore хранит объекты файлами в локальном каталоге; ключ — путь внутри него.
+// Недописанные файлы лежат во временном подкаталоге .tmp и тоже видны в List,
+// чтобы уборка хранилища удаляла брошенные.
+type FSStore struct {
+	dir string
+}
+
+var _ BlobStore = (*FSStore)(nil)
+
+func NewFSStore(dir string) *FSStore {
+	return &FSStore{dir: filepath.Clean(dir)}
+}
+
+// path переводит ключ в путь на диске, не выпуская его за пределы каталога.
+func (s *FSStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean == "/" || clean[1:] != key || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("некорректный ключ %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *FSStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	fullPath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	// Пишем во временный файл и переименовываем: читатели не видят недописанный объект.
+	tmpDir := filepath.Join(s.dir, ".tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return fmt.Errorf("не удалось создать директорию хранения: %w", err)
+	}
+	tmp, err := os.CreateTemp(tmpDir, "put-*")
+	if err != nil {
+		return fmt.Errorf("не удалось создать файл: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка записи файла: %w", err)
+	}
+	if size >= 0 && n != size {
+		return fmt.Errorf("ошибка записи файла: записано %d байт из %d", n, size)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return fmt.Errorf("не удалось создать директорию хранения: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), fullPath); err != nil {
+		return fmt.Errorf("не удалось сохранить файл: %w", err)
+	}
+	return nil
+}
+
+func (s *FSStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	fullPath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fullPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrMissing
+		}
+		return nil, fmt.Errorf("не удалось открыть файл: %w", err)
+	}
+	return f, nil
+}
+
+func (s *FSStore) GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	body, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	f := body.(*os.File)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (s *FSStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	fullPath, err := s.path(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return BlobInfo{}, ErrMissing
+		}
+		return BlobInfo{}, fmt.Errorf("не удалось прочитать файл: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return BlobInfo{}, ErrMissing
+	}
+	return BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *FSStore) Delete(ctx context.Context, key string) error {
+	fullPath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(fullPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("не удалось удалить файл: %w", err)
+	}
+	s.removeEmptyDirs(filepath.Dir(fullPath))
+	return nil
+}
+
+// removeEmptyDirs удаляет опустевшие подкаталоги вверх до корня хранилища (сам корень и .tmp остаются).
+func (s *FSStore) removeEmptyDirs(dir string) {
+	tmpDir := filepath.Join(s.dir, ".tmp")
+	for dir != s.dir && dir != tmpDir && strings.HasPrefix(dir, s.dir) {
+		// os.Remove не удаляет непустой каталог — на этом и останавливаемся.
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func (s *FSStore) List(ctx context.Context, fn func(BlobInfo) error) error {
+	err := filepath.WalkDir(s.dir, func(fullPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, fullPath)
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			// Файл удалили, пока мы обходили каталог.
+			return nil
+		}
+		return fn(BlobInfo{Key: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка обхода хранилища: %w", err)
+	}
+	return nil
+}
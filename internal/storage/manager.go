@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"tor_project/internal/db"
+)
+
+// ManagerConfig — настройки уборки хранилища.
+type ManagerConfig struct {
+	// MaxBytes — предел размера хранилища (0 — без ограничения). Сверх него вытесняются
+	// давно не нужные файлы, которых нет ни в одной библиотеке.
+	MaxBytes int64
+	// Interval — как часто запускать уборку.
+	Interval time.Duration
+	// Grace — файлы моложе этого не трогаем: они могут быть ещё на пути в book_files или в библиотеку.
+	Grace time.Duration
+	// KeepOrphans — не удалять объекты, которых нет в book_files. Нужно, когда хранилище
+	// (общий бакет S3) делят несколько экземпляров бота со своими БД: книга, скачанная
+	// соседом, для этого экземпляра выглядит сиротой.
+	KeepOrphans bool
+}
+
+var defaultManagerConfig = ManagerConfig{
+	Interval: time.Hour,
+	Grace:    time.Hour,
+}
+
+// Manager следит за хранилищем книг: удаляет файлы, о которых не знает БД,
+// строки БД без файлов и держит размер хранилища в пределах MaxBytes.
+type Manager struct {
+	store *db.Store
+	blobs BlobStore
+	cfg   ManagerConfig
+	now   func() time.Time
+}
+
+// CollectReport — итог одной уборки.
+type CollectReport struct {
+	// OrphansRemoved — файлы на диске без строки в book_files (включая брошенные во .tmp).
+	OrphansRemoved int
+	// RowsRemoved — строки book_files без файла, которых нет ни в одной библиотеке.
+	RowsRemoved int
+	// MissingInLibrary — файлы из библиотек, пропавшие с диска (скачаются заново по запросу).
+	MissingInLibrary int
+	// Evicted и FreedBytes — вытесненные из-за предела размера файлы.
+	Evicted    int
+	FreedBytes int64
+	// UsedBytes — размер хранилища после уборки.
+	UsedBytes int64
+}
+
+func NewManager(store *db.Store, blobs BlobStore, cfg ManagerConfig) *Manager {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultManagerConfig.Interval
+	}
+	if cfg.Grace <= 0 {
+		cfg.Grace = defaultManagerConfig.Grace
+	}
+	return &Manager{store: store, blobs: blobs, cfg: cfg, now: time.Now}
+}
+
+// Run убирает хранилище сразу и затем каждые Interval, пока не отменён ctx.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := m.Collect(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Уборка хранилища: %v", err)
+		} else if report.OrphansRemoved > 0 || report.RowsRemoved > 0 || report.Evicted > 0 {
+			log.Printf("Уборка хранилища: сирот %d, пустых записей %d, вытеснено %d (%d байт), занято %d байт",
+				report.OrphansRemoved, report.RowsRemoved, report.Evicted, report.FreedBytes, report.UsedBytes)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect сверяет диск с book_files и, если задан MaxBytes, вытесняет лишнее.
+func (m *Manager) Collect(ctx context.Context) (CollectReport, error) {
+	var report CollectReport
+	if err := m.sweep(ctx, &report); err != nil {
+		return report, err
+	}
+	if err := m.evict(ctx, &report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// sweep удаляет файлы, которых нет в book_files (если не задан KeepOrphans), и строки
+// book_files, чьих файлов нет на диске.
+func (m *Manager) sweep(ctx context.Context, report *CollectReport) error {
+	files, err := m.store.ListBookFiles(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !m.cfg.KeepOrphans {
+		if err := m.sweepOrphans(ctx, files, report); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := m.blobs.Stat(ctx, file.Path); !errors.Is(err, ErrMissing) {
+			continue
+		}
+
+		deleted, unused, err := m.store.DeleteUnusedBookFile(ctx, file.ID)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			// Архив, из которого распаковали пропавшую книгу, тоже больше не нужен.
+			m.deleteBlobs(ctx, unused)
+			report.RowsRemoved++
+		} else {
+			report.MissingInLibrary++
+		}
+	}
+	return nil
+}
+
+// sweepOrphans удаляет из хранилища объекты, которых нет среди files.
+func (m *Manager) sweepOrphans(ctx context.Context, files []db.BookFile, report *CollectReport) error {
+	known := make(map[string]bool, len(files))
+	for _, file := range files {
+		known[file.Path] = true
+		if file.ArchivePath != "" {
+			known[file.ArchivePath] = true
+		}
+	}
+
+	cutoff := m.now().Add(-m.cfg.Grace)
+	return m.blobs.List(ctx, func(info BlobInfo) error {
+		// Свежий файл может быть скачан прямо сейчас: строка в book_files появится чуть позже.
+		if known[info.Key] || info.ModTime.After(cutoff) {
+			return nil
+		}
+		if err := m.blobs.Delete(ctx, info.Key); err != nil {
+			log.Printf("Не удалось удалить %s: %v", info.Key, err)
+			return nil
+		}
+		report.OrphansRemoved++
+		return nil
+	})
+}
+
+// evict удаляет давно не нужные файлы вне библиотек, пока хранилище больше MaxBytes.
+func (m *Manager) evict(ctx context.Context, report *CollectReport) error {
+	used, err := m.store.StorageUsage(ctx)
+	if err != nil {
+		return err
+	}
+	report.UsedBytes = used
+	if m.cfg.MaxBytes <= 0 || used <= m.cfg.MaxBytes {
+		return nil
+	}
+
+	candidates, err := m.store.EvictionCandidates(ctx, m.now().Add(-m.cfg.Grace))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range candidates {
+		if used <= m.cfg.MaxBytes {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		deleted, unused, err := m.store.DeleteUnusedBookFile(ctx, file.ID)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			continue
+		}
+
+		sizes := map[string]int64{file.Path: file.SizeBytes, file.ArchivePath: file.ArchiveSizeBytes}
+		freed := int64(0)
+		for _, key := range m.deleteBlobs(ctx, unused) {
+			freed += sizes[key]
+		}
+		if freed == 0 {
+			continue
+		}
+		used -= freed
+		report.Evicted++
+		report.FreedBytes += freed
+	}
+	report.UsedBytes = used
+
+	if used > m.cfg.MaxBytes {
+		log.Printf("Хранилище занимает %d байт при пределе %d: остальное — книги из библиотек пользователей", used, m.cfg.MaxBytes)
+	}
+	return nil
+}
+
+// deleteBlobs удаляет объекты из хранилища и возвращает те, что удалось удалить.
+func (m *Manager) deleteBlobs(ctx context.Context, keys []string) []string {
+	var deleted []string
+	for _, key := range keys {
+		if err := m.blobs.Delete(ctx, key); err != nil {
+			log.Printf("Не удалось удалить %s: %v", key, err)
+			continue
+		}
+		deleted = append(deleted, key)
+	}
+	return deleted
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"tor_project/internal/db"
+)
+
+func TestManagerCollect(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "books")
+	blobs := NewFSStore(dir)
+
+	store, err := db.Open(filepath.Join(tmp, "app.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	save := func(sourceID string, content string) (int64, SavedFile) {
+		t.Helper()
+		saved, err := SaveBookFile(ctx, blobs, strings.NewReader(content), 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bookID, err := store.UpsertBook(ctx, sourceID, sourceID, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileID, err := store.InsertBookFile(ctx, bookID, db.BookFile{Path: saved.RelativePath, Format: "fb2", SizeBytes: saved.SizeBytes, SHA256: saved.SHA256})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fileID, saved
+	}
+	exists := func(rel string) bool {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(rel)))
+		return err == nil
+	}
+
+	now := time.Now()
+	libraryID, library := save("1", "в библиотеке")
+	oldID, old := save("2", "давно не нужна")
+	recentID, recent := save("3", "нужна недавно")
+	if err := store.EnsureUser(ctx, 7, "reader"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddToLibrary(ctx, 7, libraryID); err != nil {
+		t.Fatal(err)
+	}
+	for id, at := range map[int64]time.Time{libraryID: now.Add(-5 * time.Hour), oldID: now.Add(-3 * time.Hour), recentID: now.Add(-2 * time.Hour)} {
+		if err := store.TouchBookFile(ctx, id, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Запись без файла и файлы без записи: старые и один свежий, который может ещё скачиваться.
+	bookID, err := store.UpsertBook(ctx, "4", "пропавшая", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.InsertBookFile(ctx, bookID, db.BookFile{Path: "00/00/missing.fb2", Format: "fb2", SizeBytes: 10}); err != nil {
+		t.Fatal(err)
+	}
+	for _, rel := range []string{"ff/ee/orphan.fb2", ".tmp/download-1", ".tmp/download-2"} {
+		full := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if rel != ".tmp/download-2" {
+			if err := os.Chtimes(full, now.Add(-2*time.Hour), now.Add(-2*time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	m := NewManager(store, blobs, ManagerConfig{MaxBytes: library.SizeBytes + recent.SizeBytes})
+	report, err := m.Collect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.OrphansRemoved != 2 || report.RowsRemoved != 1 || report.Evicted != 1 || report.FreedBytes != old.SizeBytes {
+		t.Fatalf("report: %+v", report)
+	}
+	if report.UsedBytes != library.SizeBytes+recent.SizeBytes {
+		t.Fatalf("used: got %d, want %d", report.UsedBytes, library.SizeBytes+recent.SizeBytes)
+	}
+	if exists(old.RelativePath) || !exists(recent.RelativePath) || !exists(library.RelativePath) {
+		t.Fatal("evicted the wrong file")
+	}
+	if exists("ff/ee/orphan.fb2") || exists("ff") || exists(".tmp/download-1") || !exists(".tmp/download-2") {
+		t.Fatal("orphans not swept correctly")
+	}
+
+	// Книги из библиотек не вытесняются, даже если предел всё ещё превышен.
+	m.cfg.MaxBytes = 1
+	report, err = m.Collect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Evicted != 1 || !exists(library.RelativePath) || exists(recent.RelativePath) {
+		t.Fatalf("second pass: %+v", report)
+	}
+
+	usage, err := store.UserStorageUsage(ctx, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != library.SizeBytes {
+		t.Fatalf("user usage: got %d, want %d", usage, library.SizeBytes)
+	}
+}
+
+func TestManagerSharedBucket(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{t: t, objects: make(map[string][]byte), pageLen: 100}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	// Два экземпляра бота со своими БД на одном бакете.
+	type replica struct {
+		store *db.Store
+		blobs BlobStore
+		saved SavedFile
+	}
+	replicas := make([]replica, 2)
+	for i := range replicas {
+		blobs, err := NewS3Store(S3Config{Endpoint: server.URL, Bucket: "books", AccessKey: "minio", SecretKey: "minio-secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		store, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		sourceID := strconv.Itoa(i + 1)
+		saved, err := SaveBookFile(ctx, blobs, strings.NewReader("книга "+sourceID), 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bookID, err := store.UpsertBook(ctx, sourceID, sourceID, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.InsertBookFile(ctx, bookID, db.BookFile{Path: saved.RelativePath, Format: "fb2", SizeBytes: saved.SizeBytes, SHA256: saved.SHA256}); err != nil {
+			t.Fatal(err)
+		}
+		replicas[i] = replica{store: store, blobs: blobs, saved: saved}
+	}
+
+	// Объекты в фейковом бакете датированы 2024 годом, так что Grace их не спасает.
+	for _, r := range replicas {
+		report, err := NewManager(r.store, r.blobs, ManagerConfig{KeepOrphans: true}).Collect(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.OrphansRemoved != 0 || report.RowsRemoved != 0 {
+			t.Fatalf("report: %+v", report)
+		}
+	}
+	for _, r := range replicas {
+		if _, ok := fake.objects[r.saved.RelativePath]; !ok {
+			t.Fatalf("%s deleted by the other replica", r.saved.RelativePath)
+		}
+	}
+
+	// Без KeepOrphans экземпляр считает книгу соседа сиротой.
+	report, err := NewManager(replicas[0].store, replicas[0].blobs, ManagerConfig{}).Collect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OrphansRemoved != 1 {
+		t.Fatalf("report: %+v", report)
+	}
+	if _, ok := fake.objects[replicas[1].saved.RelativePath]; ok {
+		t.Fatal("orphan kept without KeepOrphans")
+	}
+}
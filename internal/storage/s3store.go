@@ -0,0 +1,348 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config — параметры S3-совместимого хранилища (AWS S3, MinIO и т.п.).
+type S3Config struct {
+	// Endpoint — адрес сервиса со схемой, например "http://minio:9000".
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Prefix — общий префикс ключей, чтобы делить бакет с другими данными.
+	Prefix string
+	// Client — HTTP-клиент (по умолчанию http.DefaultClient).
+	Client *http.Client
+}
+
+// S3Store хранит объекты в бакете S3-совместимого сервиса. Запросы подписываются
+// AWS Signature V4; адресация бакета — в пути (path-style), её понимают все реализации.
+type S3Store struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+var _ BlobStore = (*S3Store)(nil)
+
+// unsignedPayload — тело запроса не входит в подпись (его хэш пришлось бы считать заранее).
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("некорректный адрес S3 %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("не задан бакет S3")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3Store{cfg: cfg, endpoint: endpoint, client: client, now: time.Now}, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if size < 0 {
+		return fmt.Errorf("s3: размер объекта %s неизвестен", key)
+	}
+	req, err := s.newRequest(ctx, http.MethodPut, s.objectPath(key), nil, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+
+	resp, err := s.do(req, key)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, s.objectPath(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, key)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Store) GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	if length <= 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	req, err := s.newRequest(ctx, http.MethodGet, s.objectPath(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	resp, err := s.do(req, key)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		// Сервер проигнорировал Range: пропускаем начало сами.
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("s3: ошибка чтения %s: %w", key, err)
+		}
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, length), resp.Body}, nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	req, err := s.newRequest(ctx, http.MethodHead, s.objectPath(key), nil, nil)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	resp, err := s.do(req, key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	resp.Body.Close()
+
+	info := BlobInfo{Key: key, Size: resp.ContentLength}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, s.objectPath(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, key)
+	if err == ErrMissing {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listBucketResult — ответ ListObjectsV2.
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Store) List(ctx context.Context, fn func(BlobInfo) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if s.cfg.Prefix != "" {
+			query.Set("prefix", s.cfg.Prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := s.newRequest(ctx, http.MethodGet, "/"+s.cfg.Bucket, query, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := s.do(req, "")
+		if err != nil {
+			return err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3: ошибка разбора списка объектов: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			info := BlobInfo{Key: strings.TrimPrefix(obj.Key, s.cfg.Prefix), Size: obj.Size, ModTime: obj.LastModified}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *S3Store) objectPath(key string) string {
+	return "/" + s.cfg.Bucket + "/" + s.cfg.Prefix + key
+}
+
+// newRequest собирает запрос; путь и параметры кодируются так же, как в подписи.
+func (s *S3Store) newRequest(ctx context.Context, method string, objectPath string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := *s.endpoint
+	u.Path = u.Path + objectPath
+	u.RawPath = strings.TrimRight(s.endpoint.EscapedPath(), "/") + s3Escape(objectPath, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("s3: ошибка создания запроса: %w", err)
+	}
+	return req, nil
+}
+
+// do подписывает и выполняет запрос. 404 — ErrMissing, прочие ответы не 2xx — ошибка с кодом S3.
+func (s *S3Store) do(req *http.Request, key string) (*http.Response, error) {
+	signV4(req, unsignedPayload, s.cfg.AccessKey, s.cfg.SecretKey, s.cfg.Region, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %s %s: %w", req.Method, key, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrMissing
+	}
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	detail := resp.Status
+	if xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&s3Err) == nil && s3Err.Code != "" {
+		detail = s3Err.Code + ": " + s3Err.Message
+	}
+	return nil, fmt.Errorf("s3: %s %s: %s", req.Method, key, detail)
+}
+
+// signV4 подписывает запрос к S3 по AWS Signature Version 4 (заголовок Authorization).
+func signV4(req *http.Request, payloadHash string, accessKey string, secretKey string, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Подписываем host, range и все x-amz-* заголовки.
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalQuery — параметры, отсортированные по имени и закодированные по правилам SigV4.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape кодирует всё, кроме незарезервированных символов RFC 3986 (и "/", если escapeSlash=false).
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
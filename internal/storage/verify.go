@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrMissing — файла нет на диске.
+	ErrMissing = errors.New("файл отсутствует")
+	// ErrCorrupted — содержимое файла не совпадает с записанным хэшем.
+	ErrCorrupted = errors.New("файл повреждён")
+)
+
+// FileCheck — файл хранилища для проверки. Пустой SHA256 (файлы, сохранённые до
+// перехода на хэши) проверяется только на наличие.
+type FileCheck struct {
+	ID     int64
+	Path   string
+	SHA256 string
+}
+
+// VerifyReport — итог проверки хранилища.
+type VerifyReport struct {
+	Checked   int
+	Missing   []FileCheck
+	Corrupted []FileCheck
+}
+
+// VerifyFile проверяет, что объект key есть и (если wantSHA256 задан) его SHA-256 совпадает.
+func VerifyFile(ctx context.Context, blobs BlobStore, key string, wantSHA256 string) error {
+	if wantSHA256 == "" {
+		_, err := blobs.Stat(ctx, key)
+		return err
+	}
+
+	body, err := blobs.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		return fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != wantSHA256 {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+// Verify проверяет все файлы и собирает отсутствующие и повреждённые.
+// Прочие ошибки (нет прав, сбой диска) прерывают проверку.
+func Verify(ctx context.Context, blobs BlobStore, files []FileCheck) (VerifyReport, error) {
+	var report VerifyReport
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		err := VerifyFile(ctx, blobs, file.Path, file.SHA256)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrMissing):
+			report.Missing = append(report.Missing, file)
+		case errors.Is(err, ErrCorrupted):
+			report.Corrupted = append(report.Corrupted, file)
+		default:
+			return report, fmt.Errorf("%s: %w", file.Path, err)
+		}
+		report.Checked++
+	}
+	return report, nil
+}
@@ -2,14 +2,15 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"tor_project/internal/db"
@@ -18,23 +19,59 @@ import (
 	"tor_project/internal/storage"
 )
 
+// botAPI — методы tgbotapi.BotAPI, которыми пользуется бот (в тестах подменяется фейком).
+type botAPI interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+	GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel
+	StopReceivingUpdates()
+}
+
 type Bot struct {
-	bot        *tgbotapi.BotAPI
-	service    *service.FlibustaClient
+	bot        botAPI
+	searcher   service.Searcher
+	details    service.DetailsProvider
+	downloader service.Downloader
 	store      *db.Store
-	storageDir string
+	blobs      storage.BlobStore
 	miniAppURL string
 	sessions   map[int64]*searchSession
 	sessionsMu sync.Mutex
+
+	// ops — незавершённые операции чатов (поиск, карточка, скачивание); кнопка "Отмена"
+	// отменяет их контексты, и запросы через Tor обрываются сразу.
+	ops   map[int64]map[uint64]context.CancelFunc
+	opSeq uint64
+	opsMu sync.Mutex
+
+	workers WorkerPoolConfig
+
+	// Очередь скачиваний: jobs — отмена выполняемых заданий по ID (под opsMu),
+	// downloadWake будит воркеры, когда в очереди появилось задание.
+	downloads    DownloadQueueConfig
+	jobs         map[int64]context.CancelFunc
+	downloadWake chan struct{}
 }
 
 type searchSession struct {
+	// header — заголовок списка (например, имя автора); пустой для обычного поиска.
+	header   string
 	books    []models.Book
 	page     int
 	pageSize int
+
+	// Разделы поиска: авторы и серии показываются на отдельных вкладках.
+	authors []models.Author
+	series  []models.Series
+	totals  models.SearchResult
+	tab     string
+
+	// seriesID и volumes заполняются, когда в сессии лежит серия (тома в порядке чтения).
+	seriesID string
+	volumes  map[string]int
 }
 
-func NewBot(token string, svc *service.FlibustaClient, store *db.Store, storageDir string, miniAppURL string) (*Bot, error) {
+func NewBot(token string, searcher service.Searcher, details service.DetailsProvider, downloader service.Downloader, store *db.Store, blobs storage.BlobStore, miniAppURL string) (*Bot, error) {
 	bot, err := tgbotapi.NewBotAPI(token)
 	if err != nil {
 		return nil, err
@@ -43,21 +80,55 @@ func NewBot(token string, svc *service.FlibustaClient, store *db.Store, storageD
 	bot.Debug = false
 	log.Printf("Авторизован как %s", bot.Self.UserName)
 
+	return newBot(bot, searcher, details, downloader, store, blobs, miniAppURL), nil
+}
+
+func newBot(api botAPI, searcher service.Searcher, details service.DetailsProvider, downloader service.Downloader, store *db.Store, blobs storage.BlobStore, miniAppURL string) *Bot {
 	return &Bot{
-		bot:        bot,
-		service:    svc,
+		bot:        api,
+		searcher:   searcher,
+		details:    details,
+		downloader: downloader,
 		store:      store,
-		storageDir: storageDir,
+		blobs:      blobs,
 		miniAppURL: miniAppURL,
 		sessions:   make(map[int64]*searchSession),
-	}, nil
+		ops:        make(map[int64]map[uint64]context.CancelFunc),
+		workers:    defaultWorkerPool,
+
+		downloads:    defaultDownloadQueue,
+		jobs:         make(map[int64]context.CancelFunc),
+		downloadWake: make(chan struct{}, 1),
+	}
 }
 
 const (
 	defaultPageSize  = 10
-	cbBookPrefix     = "book:"
-	cbPagePrefix     = "page:"
-	cbDownloadPrefix = "dl:"
+	maxAuthorButtons = 20
+	maxSeriesButtons = 20
+
+	cbBookPrefix       = "book:"
+	cbPagePrefix       = "page:"
+	cbDownloadPrefix   = "dl:"
+	cbAuthorPrefix     = "author:"
+	cbSeriesPrefix     = "seq:"
+	cbSeriesNextPrefix = "seqnext:"
+	cbTabPrefix        = "tab:"
+	cbMorePrefix       = "more:"
+	cbCancel           = "cancel"
+
+	// Дедлайны операций: без них зависший запрос через Tor держит обработчик
+	// до таймаута HTTP-клиента.
+	requestTimeout  = 3 * time.Minute
+	downloadTimeout = 10 * time.Minute
+
+	// Лимиты Telegram на длину подписи к фото и текста сообщения.
+	maxCaptionLength = 1024
+	maxMessageLength = 4096
+
+	tabBooks   = "books"
+	tabAuthors = "authors"
+	tabSeries  = "series"
 )
 
 var (
@@ -66,66 +137,474 @@ var (
 	sizeLooseRe = regexp.MustCompile(`(?i)\b\d+(?:[.,]\d+)?\s*(?:kb|mb|gb|kib|mib|gib|кб|мб|гб)\b`)
 )
 
-// Start — главный цикл
-func (b *Bot) Start() {
+// SetWorkerPool задаёт число воркеров, размер очередей и таймаут остановки (до вызова Start).
+func (b *Bot) SetWorkerPool(cfg WorkerPoolConfig) {
+	b.workers = cfg
+}
+
+// Start — главный цикл. Обновления обрабатываются пулом воркеров (по порядку внутри чата).
+// После отмены ctx бот перестаёт получать обновления и даёт начатым операциям
+// DrainTimeout на завершение, после чего отменяет их.
+func (b *Bot) Start(ctx context.Context) {
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 	updates := b.bot.GetUpdatesChan(u)
 
-	for update := range updates {
-		// 1. Текстовое сообщение (Поиск)
-		if update.Message != nil {
-			b.handleMessage(update.Message)
+	// Операции живут дольше ctx: при остановке их отменяем только по истечении DrainTimeout.
+	workCtx, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+
+	pool := newWorkerPool(b.workers, func(update tgbotapi.Update) {
+		b.handleUpdate(workCtx, update)
+	})
+	log.Printf("Обработка обновлений: воркеров %d, очередь %d", max(b.workers.Workers, 1), b.workers.QueueSize)
+
+	downloads := b.startDownloadWorkers(workCtx)
+	log.Printf("Очередь скачиваний: воркеров %d, попыток %d", max(b.downloads.Workers, 1), b.downloads.MaxAttempts)
+
+	defer func() {
+		b.bot.StopReceivingUpdates()
+		close(downloads.stop)
+
+		timeout := time.NewTimer(b.workers.DrainTimeout)
+		defer timeout.Stop()
+
+		drained := pool.stop(b.workers.DrainTimeout)
+		if drained {
+			select {
+			case <-downloads.done:
+			case <-timeout.C:
+				drained = false
+			}
+		}
+		if !drained {
+			log.Printf("Операции не завершились за %s, отменяю", b.workers.DrainTimeout)
+			cancelWork()
+			pool.wait()
+			<-downloads.done
 		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+
+			// Отмену обрабатываем сразу, не дожидаясь обработчика, который она должна прервать.
+			if b.handleCancel(update) {
+				continue
+			}
+
+			if !pool.submit(ctx, update) {
+				return
+			}
+		}
+	}
+}
+
+// handleUpdate обрабатывает одно обновление с дедлайном операции.
+func (b *Bot) handleUpdate(ctx context.Context, update tgbotapi.Update) {
+	// 1. Текстовое сообщение (Поиск)
+	if update.Message != nil {
+		opCtx, done := b.startOp(ctx, update.Message.Chat.ID, requestTimeout)
+		defer done()
+		b.handleMessage(opCtx, update.Message)
+	}
+
+	// 2. Нажатие на кнопку (Скачивание)
+	if cb := update.CallbackQuery; cb != nil && cb.Message != nil {
+		timeout := requestTimeout
+		if strings.HasPrefix(cb.Data, cbDownloadPrefix) {
+			timeout = downloadTimeout
+		}
+		opCtx, done := b.startOp(ctx, cb.Message.Chat.ID, timeout)
+		defer done()
+		b.handleCallback(opCtx, cb)
+	}
+}
+
+// handleCancel обрабатывает /cancel и кнопку "Отмена"; false — обновление не про отмену.
+func (b *Bot) handleCancel(update tgbotapi.Update) bool {
+	var chatID int64
+	switch {
+	case update.Message != nil && update.Message.IsCommand() && update.Message.Command() == "cancel":
+		chatID = update.Message.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.Data == cbCancel && update.CallbackQuery.Message != nil:
+		chatID = update.CallbackQuery.Message.Chat.ID
+		b.bot.Request(tgbotapi.NewCallback(update.CallbackQuery.ID, "Отменяю…"))
+	default:
+		return false
+	}
+
+	if b.cancelOps(chatID)+b.cancelDownloads(chatID) > 0 {
+		b.sendMessage(chatID, "🚫 Отменено.")
+	} else {
+		b.sendMessage(chatID, "🤷 Нечего отменять.")
+	}
+	return true
+}
+
+// startOp регистрирует операцию чата с дедлайном timeout. done нужно вызвать по завершении.
+func (b *Bot) startOp(parent context.Context, chatID int64, timeout time.Duration) (context.Context, func()) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+
+	b.opsMu.Lock()
+	b.opSeq++
+	id := b.opSeq
+	if b.ops[chatID] == nil {
+		b.ops[chatID] = make(map[uint64]context.CancelFunc)
+	}
+	b.ops[chatID][id] = cancel
+	b.opsMu.Unlock()
 
-		// 2. Нажатие на кнопку (Скачивание)
-		if update.CallbackQuery != nil {
-			b.handleCallback(update.CallbackQuery)
+	return ctx, func() {
+		cancel()
+
+		b.opsMu.Lock()
+		delete(b.ops[chatID], id)
+		if len(b.ops[chatID]) == 0 {
+			delete(b.ops, chatID)
 		}
+		b.opsMu.Unlock()
+	}
+}
+
+// cancelOps отменяет все незавершённые операции чата и возвращает их число.
+func (b *Bot) cancelOps(chatID int64) int {
+	b.opsMu.Lock()
+	defer b.opsMu.Unlock()
+
+	ops := b.ops[chatID]
+	for _, cancel := range ops {
+		cancel()
 	}
+	delete(b.ops, chatID)
+	return len(ops)
 }
 
 // handleMessage — Обработка текста (ПОИСК)
-func (b *Bot) handleMessage(msg *tgbotapi.Message) {
-	if msg.IsCommand() && msg.Command() == "start" {
-		b.sendMessage(msg.Chat.ID, "Привет! Напиши название книги, я найду её)")
-		return
+func (b *Bot) handleMessage(ctx context.Context, msg *tgbotapi.Message) {
+	if msg.IsCommand() {
+		switch msg.Command() {
+		case "start":
+			b.sendMessage(msg.Chat.ID, "Привет! Напиши название книги, я найду её)\nПоиск по автору: /author <имя>\nПоиск серии: /series <название>\nПолки: /shelf [название]\nПоиск по своей библиотеке: /find <запрос>\nВыделения из книги: /highlights [название]\nОтменить запрос: /cancel")
+			return
+		case "author":
+			b.handleAuthorSearch(ctx, msg.Chat.ID, msg.CommandArguments())
+			return
+		case "series":
+			b.handleSeriesSearch(ctx, msg.Chat.ID, msg.CommandArguments())
+			return
+		case "shelf":
+			b.handleShelf(ctx, msg)
+			return
+		case "highlights":
+			b.handleHighlights(ctx, msg.Chat.ID, msg.From.ID, msg.CommandArguments())
+			return
+		case "find":
+			b.handleFind(ctx, msg.Chat.ID, msg.From.ID, msg.CommandArguments())
+			return
+		}
 	}
 
 	query := msg.Text
 	chatID := msg.Chat.ID
 
-	b.sendMessage(chatID, "🔎 Ищу: "+query+"...")
+	b.sendCancellable(chatID, "🔎 Ищу: "+query+"...")
 
 	// Вызов сервиса поиска
-	books, err := b.service.Search(query)
+	result, err := b.searcher.Search(ctx, query)
 	if err != nil {
-		b.sendMessage(chatID, "❌ Ошибка поиска (возможно, Tor устал).")
+		b.sendFailure(ctx, chatID, "❌ Ошибка поиска (возможно, Tor устал).")
 		log.Printf("Error searching: %v", err)
 		return
 	}
 
-	if len(books) == 0 {
+	if result.IsEmpty() {
 		b.sendMessage(chatID, "😔 Ничего не найдено.")
 		return
 	}
 
 	// Сохраняем результаты и отправляем первую страницу
-	b.storeSession(chatID, books)
+	b.storeSearchSession(chatID, result)
 	b.sendBooksPage(chatID, 0)
 }
 
-func (b *Bot) storeSession(chatID int64, books []models.Book) {
+// handleAuthorSearch — поиск авторов (/author <имя>)
+func (b *Bot) handleAuthorSearch(ctx context.Context, chatID int64, query string) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		b.sendMessage(chatID, "✍️ Напиши имя автора после команды, например: /author Оруэлл")
+		return
+	}
+
+	b.sendCancellable(chatID, "🔎 Ищу автора: "+query+"...")
+
+	authors, err := b.searcher.SearchAuthors(ctx, query)
+	if err != nil {
+		b.sendFailure(ctx, chatID, "❌ Ошибка поиска (возможно, Tor устал).")
+		log.Printf("Error searching authors: %v", err)
+		return
+	}
+
+	if len(authors) == 0 {
+		b.sendMessage(chatID, "😔 Авторы не найдены.")
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, author := range authors {
+		if i >= maxAuthorButtons {
+			break
+		}
+		text := author.Name
+		if author.BookCount > 0 {
+			text = fmt.Sprintf("%s (%d)", author.Name, author.BookCount)
+		}
+		btn := tgbotapi.NewInlineKeyboardButtonData(text, cbAuthorPrefix+author.ID)
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{btn})
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("👤 Найдено авторов: %d", len(authors)))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.bot.Send(msg)
+}
+
+// sendAuthorBooks загружает библиографию автора и показывает её постранично.
+func (b *Bot) sendAuthorBooks(ctx context.Context, chatID int64, authorID string) {
+	author, books, err := b.searcher.GetAuthorBooks(ctx, authorID)
+	if err != nil {
+		b.sendFailure(ctx, chatID, "❌ Не удалось загрузить страницу автора (Tor/сайт может тупить).")
+		log.Printf("GetAuthorBooks error: %v", err)
+		return
+	}
+
+	if len(books) == 0 {
+		b.sendMessage(chatID, "😔 У автора не найдено книг.")
+		return
+	}
+
+	header := "✍️ " + author.Name
+	if author.Name == "" {
+		header = "✍️ Книги автора"
+	}
+
+	b.storeSession(chatID, header, books)
+	b.sendBooksPage(chatID, 0)
+}
+
+// handleSeriesSearch — поиск серий (/series <название>)
+func (b *Bot) handleSeriesSearch(ctx context.Context, chatID int64, query string) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		b.sendMessage(chatID, "📚 Напиши название серии после команды, например: /series Дюна")
+		return
+	}
+
+	b.sendCancellable(chatID, "🔎 Ищу серию: "+query+"...")
+
+	series, err := b.searcher.SearchSeries(ctx, query)
+	if err != nil {
+		b.sendFailure(ctx, chatID, "❌ Ошибка поиска (возможно, Tor устал).")
+		log.Printf("Error searching series: %v", err)
+		return
+	}
+
+	if len(series) == 0 {
+		b.sendMessage(chatID, "😔 Серии не найдены.")
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, item := range series {
+		if i >= maxSeriesButtons {
+			break
+		}
+		text := item.Title
+		if item.BookCount > 0 {
+			text = fmt.Sprintf("%s (%d)", item.Title, item.BookCount)
+		}
+		btn := tgbotapi.NewInlineKeyboardButtonData(text, cbSeriesPrefix+item.ID)
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{btn})
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("📚 Найдено серий: %d", len(series)))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.bot.Send(msg)
+}
+
+// sendSeries загружает серию и показывает тома в порядке чтения.
+func (b *Bot) sendSeries(ctx context.Context, chatID int64, seriesID string) {
+	series, err := b.loadSeries(ctx, chatID, seriesID)
+	if err != nil {
+		b.sendFailure(ctx, chatID, "❌ Не удалось загрузить серию (Tor/сайт может тупить).")
+		log.Printf("GetSeries error: %v", err)
+		return
+	}
+
+	if len(series.Books) == 0 {
+		b.sendMessage(chatID, "😔 В серии не найдено книг.")
+		return
+	}
+
+	b.sendBooksPage(chatID, 0)
+}
+
+// loadSeries скачивает серию и кладёт её в сессию чата.
+func (b *Bot) loadSeries(ctx context.Context, chatID int64, seriesID string) (models.Series, error) {
+	series, err := b.searcher.GetSeries(ctx, seriesID)
+	if err != nil {
+		return models.Series{}, err
+	}
+	if len(series.Books) == 0 {
+		return series, nil
+	}
+
+	header := "📚 Серия: " + series.Title
+	if series.Title == "" {
+		header = "📚 Серия"
+	}
+
+	books := make([]models.Book, 0, len(series.Books))
+	volumes := make(map[string]int, len(series.Books))
+	for _, vol := range series.Books {
+		books = append(books, vol.Book)
+		if vol.Number > 0 {
+			volumes[vol.ID] = vol.Number
+		}
+	}
+
+	b.storeSession(chatID, header, books)
+
+	b.sessionsMu.Lock()
+	if session, ok := b.sessions[chatID]; ok {
+		session.seriesID = seriesID
+		session.volumes = volumes
+	}
+	b.sessionsMu.Unlock()
+
+	return series, nil
+}
+
+// sendNextUnread ставит на скачивание первый том серии, которого ещё нет в библиотеке
+// пользователя, в предпочтительном формате (см. preferredFormat).
+func (b *Bot) sendNextUnread(ctx context.Context, chatID int64, userID int64, username string, seriesID string) {
+	session, ok := b.getSession(chatID)
+	if !ok || session.seriesID != seriesID {
+		if _, err := b.loadSeries(ctx, chatID, seriesID); err != nil {
+			b.sendFailure(ctx, chatID, "❌ Не удалось загрузить серию (Tor/сайт может тупить).")
+			log.Printf("GetSeries error: %v", err)
+			return
+		}
+		session, ok = b.getSession(chatID)
+		if !ok || session.seriesID != seriesID {
+			b.sendMessage(chatID, "😔 В серии не найдено книг.")
+			return
+		}
+	}
+
+	owned := map[string]bool{}
+	if b.store != nil {
+		ids, err := b.store.LibrarySourceIDs(ctx, userID)
+		if err != nil {
+			log.Printf("LibrarySourceIDs error: %v", err)
+		} else {
+			owned = ids
+		}
+	}
+
+	for _, book := range session.books {
+		if owned[book.ID] {
+			continue
+		}
+		details, err := b.details.GetBookDetails(ctx, book.ID)
+		if err != nil {
+			b.sendFailure(ctx, chatID, "❌ Не удалось получить информацию о книге (Tor/сайт может тупить).")
+			log.Printf("GetBookDetails error: %v", err)
+			return
+		}
+		b.downloadAndSend(ctx, chatID, userID, username, book.ID, preferredFormat(details.Formats))
+		return
+	}
+
+	b.sendMessage(chatID, "🎉 Все тома этой серии уже есть в твоей библиотеке.")
+}
+
+// seriesFormats — форматы для скачивания тома серии одной кнопкой, по убыванию
+// предпочтения: FB2 открывается в Mini App и конвертируется в EPUB.
+var seriesFormats = []string{"fb2", "epub", "mobi", "pdf", "txt"}
+
+// preferredFormat выбирает формат тома из предложенных сайтом. Если сайт форматы не
+// указал, берём FB2 — как и в запасных кнопках карточки.
+func preferredFormat(formats []models.BookFormatOption) string {
+	for _, want := range seriesFormats {
+		for _, opt := range formats {
+			if strings.EqualFold(strings.TrimSpace(opt.Path), want) {
+				return opt.Path
+			}
+		}
+	}
+	if len(formats) > 0 {
+		return formats[0].Path
+	}
+	return seriesFormats[0]
+}
+
+func (b *Bot) storeSession(chatID int64, header string, books []models.Book) {
 	b.sessionsMu.Lock()
 	defer b.sessionsMu.Unlock()
 
 	b.sessions[chatID] = &searchSession{
+		header:   header,
 		books:    books,
 		page:     0,
 		pageSize: defaultPageSize,
 	}
 }
 
+// storeSearchSession сохраняет результаты поиска по разделам и выбирает первую непустую вкладку.
+func (b *Bot) storeSearchSession(chatID int64, result models.SearchResult) {
+	b.sessionsMu.Lock()
+	defer b.sessionsMu.Unlock()
+
+	tab := tabBooks
+	switch {
+	case len(result.Books) > 0:
+		tab = tabBooks
+	case len(result.Authors) > 0:
+		tab = tabAuthors
+	case len(result.Series) > 0:
+		tab = tabSeries
+	}
+
+	b.sessions[chatID] = &searchSession{
+		books:    result.Books,
+		authors:  result.Authors,
+		series:   result.Series,
+		totals:   result,
+		tab:      tab,
+		page:     0,
+		pageSize: defaultPageSize,
+	}
+}
+
+func (b *Bot) setSessionTab(chatID int64, tab string) bool {
+	b.sessionsMu.Lock()
+	defer b.sessionsMu.Unlock()
+
+	session, ok := b.sessions[chatID]
+	if !ok {
+		return false
+	}
+	session.tab = tab
+	session.page = 0
+	return true
+}
+
 func (b *Bot) getSession(chatID int64) (*searchSession, bool) {
 	b.sessionsMu.Lock()
 	defer b.sessionsMu.Unlock()
@@ -169,13 +648,83 @@ func (b *Bot) findBookInSession(chatID int64, bookID string) (models.Book, bool)
 	return models.Book{}, false
 }
 
+// tabButtons возвращает по одной кнопке на каждый элемент активной вкладки.
+func tabButtons(session *searchSession) []tgbotapi.InlineKeyboardButton {
+	var buttons []tgbotapi.InlineKeyboardButton
+
+	switch session.tab {
+	case tabAuthors:
+		for _, author := range session.authors {
+			text := author.Name
+			if author.BookCount > 0 {
+				text = fmt.Sprintf("%s (%d)", author.Name, author.BookCount)
+			}
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("👤 "+text, cbAuthorPrefix+author.ID))
+		}
+	case tabSeries:
+		for _, item := range session.series {
+			text := item.Title
+			if item.BookCount > 0 {
+				text = fmt.Sprintf("%s (%d)", item.Title, item.BookCount)
+			}
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("🗂 "+text, cbSeriesPrefix+item.ID))
+		}
+	default:
+		for _, book := range session.books {
+			text := fmt.Sprintf("%s - %s", book.Title, book.Author)
+			if n, ok := session.volumes[book.ID]; ok {
+				text = fmt.Sprintf("%d. %s", n, text)
+			}
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(text, cbBookPrefix+book.ID))
+		}
+	}
+
+	return buttons
+}
+
+// tabsRow — строка вкладок "Книги / Авторы / Серии"; nil, если непустой раздел только один.
+func tabsRow(session *searchSession) []tgbotapi.InlineKeyboardButton {
+	tabs := []struct {
+		name  string
+		label string
+		count int
+		total int
+	}{
+		{tabBooks, "📚 Книги", len(session.books), session.totals.TotalBooks},
+		{tabAuthors, "👤 Авторы", len(session.authors), session.totals.TotalAuthors},
+		{tabSeries, "🗂 Серии", len(session.series), session.totals.TotalSeries},
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	for _, tab := range tabs {
+		if tab.count == 0 {
+			continue
+		}
+		text := fmt.Sprintf("%s (%d)", tab.label, tab.total)
+		if tab.name == session.tab {
+			text = "• " + text + " •"
+		}
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(text, cbTabPrefix+tab.name))
+	}
+
+	if len(row) < 2 {
+		return nil
+	}
+	return row
+}
+
 func (b *Bot) buildPage(chatID int64, page int) (string, tgbotapi.InlineKeyboardMarkup, bool) {
 	session, ok := b.getSession(chatID)
-	if !ok || len(session.books) == 0 {
+	if !ok {
 		return "", tgbotapi.InlineKeyboardMarkup{}, false
 	}
 
-	total := len(session.books)
+	buttons := tabButtons(session)
+	if len(buttons) == 0 {
+		return "", tgbotapi.InlineKeyboardMarkup{}, false
+	}
+
+	total := len(buttons)
 	pages := totalPages(total, session.pageSize)
 	page = clampPage(page, pages)
 
@@ -187,10 +736,11 @@ func (b *Bot) buildPage(chatID int64, page int) (string, tgbotapi.InlineKeyboard
 
 	var rows [][]tgbotapi.InlineKeyboardButton
 
-	for _, book := range session.books[start:end] {
-		text := fmt.Sprintf("%s - %s", book.Title, book.Author)
-		data := cbBookPrefix + book.ID
-		btn := tgbotapi.NewInlineKeyboardButtonData(text, data)
+	if tabs := tabsRow(session); tabs != nil {
+		rows = append(rows, tabs)
+	}
+
+	for _, btn := range buttons[start:end] {
 		rows = append(rows, []tgbotapi.InlineKeyboardButton{btn})
 	}
 
@@ -216,6 +766,11 @@ func (b *Bot) buildPage(chatID int64, page int) (string, tgbotapi.InlineKeyboard
 		rows = append(rows, navRow)
 	}
 
+	if session.seriesID != "" {
+		next := tgbotapi.NewInlineKeyboardButtonData("⏭ Скачать следующий непрочитанный том", cbSeriesNextPrefix+session.seriesID)
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{next})
+	}
+
 	// Обновляем текущую страницу в сессии
 	b.sessionsMu.Lock()
 	if session, ok := b.sessions[chatID]; ok {
@@ -223,7 +778,21 @@ func (b *Bot) buildPage(chatID int64, page int) (string, tgbotapi.InlineKeyboard
 	}
 	b.sessionsMu.Unlock()
 
-	text := fmt.Sprintf("📚 Найдено книг: %d\nСтраница %d/%d", total, page+1, pages)
+	var text string
+	switch {
+	case session.header != "":
+		text = fmt.Sprintf("%s\n📚 Книг: %d\nСтраница %d/%d", session.header, total, page+1, pages)
+	case session.tab == tabAuthors:
+		text = fmt.Sprintf("👤 Найдено авторов: %d\nСтраница %d/%d", session.totals.TotalAuthors, page+1, pages)
+	case session.tab == tabSeries:
+		text = fmt.Sprintf("🗂 Найдено серий: %d\nСтраница %d/%d", session.totals.TotalSeries, page+1, pages)
+	default:
+		text = fmt.Sprintf("📚 Найдено книг: %d\nСтраница %d/%d", session.totals.TotalBooks, page+1, pages)
+		if session.totals.TotalBooks > total {
+			text = fmt.Sprintf("📚 Найдено книг: %d (показаны первые %d)\nСтраница %d/%d", session.totals.TotalBooks, total, page+1, pages)
+		}
+	}
+
 	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
 	return text, markup, true
 }
@@ -282,26 +851,23 @@ func formatButtonText(opt models.BookFormatOption) string {
 	return format
 }
 
-func (b *Bot) sendBookDetails(chatID int64, bookID string) {
-	details, err := b.service.GetBookDetails(bookID)
+func (b *Bot) sendBookDetails(ctx context.Context, chatID int64, bookID string) {
+	details, err := b.details.GetBookDetails(ctx, bookID)
 	if err != nil {
-		b.sendMessage(chatID, "❌ Не удалось получить информацию о книге (Tor/сайт может тупить).")
+		b.sendFailure(ctx, chatID, "❌ Не удалось получить информацию о книге (Tor/сайт может тупить).")
 		log.Printf("GetBookDetails error: %v", err)
 		return
 	}
 
-	// Prefer title/author from the search session to avoid parsing mistakes from HTML.
-	if book, ok := b.findBookInSession(chatID, bookID); ok {
-		details.Title = book.Title
-		details.Author = book.Author
+	// В БД — только то, что пришло с сайта: строки из списка поиска и заглушки ниже
+	// нужны лишь для подписи.
+	if b.store != nil {
+		if _, err := b.store.SaveBookDetails(ctx, details); err != nil {
+			log.Printf("SaveBookDetails error: %v", err)
+		}
 	}
 
-	if details.Title == "" {
-		details.Title = "Без названия"
-	}
-	if details.Author == "" {
-		details.Author = "Автор неизвестен"
-	}
+	details = b.cardDetails(chatID, details)
 
 	var rows [][]tgbotapi.InlineKeyboardButton
 	if len(details.Formats) == 0 {
@@ -336,14 +902,34 @@ func (b *Bot) sendBookDetails(chatID int64, bookID string) {
 		if len(row) > 0 {
 			rows = append(rows, row)
 		}
+		if offersEPUBConversion(details.Formats) {
+			rows = append(rows, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(
+				"EPUB (из FB2)", cbDownloadPrefix+bookID+":"+fb2EPUBFormat)})
+		}
+	}
+
+	caption, truncated := bookCaption(details)
+
+	var extraRow []tgbotapi.InlineKeyboardButton
+	if truncated {
+		extraRow = append(extraRow, tgbotapi.NewInlineKeyboardButtonData("📄 Подробнее", cbMorePrefix+bookID))
+	}
+	if details.SeriesID != "" {
+		extraRow = append(extraRow, tgbotapi.NewInlineKeyboardButtonData("📚 Вся серия", cbSeriesPrefix+details.SeriesID))
+	}
+	if len(extraRow) > 0 {
+		rows = append(rows, extraRow)
+	}
+	if b.store != nil {
+		// На полку и в статус книгу можно отправить, не скачивая.
+		rows = append(rows, shelfButtons(bookID))
 	}
 
 	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
-	caption := fmt.Sprintf("📖 %s\n✍️ %s", details.Title, details.Author)
 
 	// If we have a cover URL, download it via Tor and upload as bytes (Telegram can't fetch .onion URLs).
 	if details.CoverPath != "" {
-		coverBytes, err := b.service.DownloadBytes(details.CoverPath)
+		coverBytes, err := b.details.DownloadBytes(ctx, details.CoverPath)
 		if err != nil {
 			log.Printf("Cover download error: %v", err)
 		} else if len(coverBytes) > 0 {
@@ -361,8 +947,160 @@ func (b *Bot) sendBookDetails(chatID int64, bookID string) {
 	b.bot.Send(msg)
 }
 
+// cardDetails готовит карточку к показу: название и автор из списка поиска, заглушки
+// вместо пустых. В БД такие подстановки не пишутся.
+func (b *Bot) cardDetails(chatID int64, details models.BookDetails) models.BookDetails {
+	// Prefer title/author from the search session to avoid parsing mistakes from HTML.
+	if book, ok := b.findBookInSession(chatID, details.ID); ok {
+		details.Title = book.Title
+		details.Author = book.Author
+	}
+
+	if details.Title == "" {
+		details.Title = "Без названия"
+	}
+	if details.Author == "" {
+		details.Author = "Автор неизвестен"
+	}
+	return details
+}
+
+// bookCaption собирает подпись карточки книги. Шапка и аннотация обрезаются так, чтобы подпись
+// влезла в лимит Telegram; второй результат сообщает, что что-то не влезло (полный текст — по
+// кнопке "Подробнее").
+func bookCaption(details models.BookDetails) (string, bool) {
+	const ellipsis = "…"
+	head := bookHeader(details)
+	// Длинные название, авторы или жанры тоже режем: подпись длиннее лимита Telegram не примет.
+	if utf16Len(head) > maxCaptionLength {
+		return truncateUTF16(head, maxCaptionLength-utf16Len(ellipsis)) + ellipsis, true
+	}
+	annotation := strings.TrimSpace(details.Annotation)
+	if annotation == "" {
+		return head, false
+	}
+
+	const sep = "\n\n"
+	room := maxCaptionLength - utf16Len(head) - utf16Len(sep)
+	if utf16Len(annotation) <= room {
+		return head + sep + annotation, false
+	}
+
+	room -= utf16Len(ellipsis)
+	if room <= 0 {
+		return head, true
+	}
+	return head + sep + truncateUTF16(annotation, room) + ellipsis, true
+}
+
+// bookHeader — шапка карточки книги: название, автор и остальные сведения без аннотации.
+func bookHeader(details models.BookDetails) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📖 %s\n✍️ %s", details.Title, details.Author)
+
+	if details.Translator != "" {
+		fmt.Fprintf(&sb, "\n🔤 Перевод: %s", details.Translator)
+	}
+	if details.Series != "" {
+		if details.SeriesNumber > 0 {
+			fmt.Fprintf(&sb, "\n📚 Серия: %s #%d", details.Series, details.SeriesNumber)
+		} else {
+			fmt.Fprintf(&sb, "\n📚 Серия: %s", details.Series)
+		}
+	}
+	if len(details.Genres) > 0 {
+		fmt.Fprintf(&sb, "\n🏷 %s", strings.Join(details.Genres, ", "))
+	}
+
+	var facts []string
+	if details.Year > 0 {
+		facts = append(facts, fmt.Sprintf("%d г.", details.Year))
+	}
+	if details.Language != "" {
+		facts = append(facts, details.Language)
+	}
+	if details.Pages > 0 {
+		facts = append(facts, fmt.Sprintf("%d с.", details.Pages))
+	}
+	if details.Size != "" {
+		facts = append(facts, details.Size)
+	}
+	if len(facts) > 0 {
+		fmt.Fprintf(&sb, "\n🗓 %s", strings.Join(facts, " · "))
+	}
+	return sb.String()
+}
+
+// utf16Len считает длину так же, как Telegram (в UTF-16 code units).
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+func truncateUTF16(s string, limit int) string {
+	n := 0
+	for i, r := range s {
+		size := 1
+		if r >= 0x10000 {
+			size = 2
+		}
+		if n+size > limit {
+			return strings.TrimSpace(s[:i])
+		}
+		n += size
+	}
+	return s
+}
+
+// sendAnnotation отправляет полную карточку книги — шапку и аннотацию, которые не влезли
+// в подпись (кнопка "Подробнее").
+func (b *Bot) sendAnnotation(ctx context.Context, chatID int64, bookID string) {
+	details, err := b.details.GetBookDetails(ctx, bookID)
+	if err != nil {
+		b.sendFailure(ctx, chatID, "❌ Не удалось получить информацию о книге (Tor/сайт может тупить).")
+		log.Printf("GetBookDetails error: %v", err)
+		return
+	}
+	details = b.cardDetails(chatID, details)
+
+	annotation := strings.TrimSpace(details.Annotation)
+	if annotation == "" && b.store != nil {
+		text, err := b.store.GetBookAnnotation(ctx, bookID)
+		if err != nil {
+			log.Printf("GetBookAnnotation error: %v", err)
+		}
+		annotation = text
+	}
+
+	head := bookHeader(details)
+	if annotation == "" && utf16Len(head) <= maxCaptionLength {
+		b.sendMessage(chatID, "😔 У книги нет аннотации.")
+		return
+	}
+
+	text := head
+	if annotation != "" {
+		text += "\n\n" + annotation
+	}
+	for text != "" {
+		chunk := truncateUTF16(text, maxMessageLength)
+		if chunk == "" {
+			break
+		}
+		b.sendMessage(chatID, chunk)
+		text = strings.TrimSpace(text[len(chunk):])
+	}
+}
+
 // handleCallback — Обработка нажатия на кнопку (СКАЧИВАНИЕ)
-func (b *Bot) handleCallback(cb *tgbotapi.CallbackQuery) {
+func (b *Bot) handleCallback(ctx context.Context, cb *tgbotapi.CallbackQuery) {
 	if cb.Message == nil {
 		return
 	}
@@ -387,6 +1125,27 @@ func (b *Bot) handleCallback(cb *tgbotapi.CallbackQuery) {
 		return
 	}
 
+	// Переключение вкладки результатов поиска
+	if strings.HasPrefix(data, cbTabPrefix) {
+		callbackResp := tgbotapi.NewCallback(cb.ID, "")
+		b.bot.Request(callbackResp)
+
+		tab := strings.TrimPrefix(data, cbTabPrefix)
+		switch tab {
+		case tabBooks, tabAuthors, tabSeries:
+		default:
+			log.Printf("Invalid tab callback data: %q", data)
+			return
+		}
+
+		if !b.setSessionTab(chatID, tab) {
+			b.sendMessage(chatID, "⚠️ Результаты поиска устарели. Напиши запрос ещё раз.")
+			return
+		}
+		b.editBooksPage(chatID, cb.Message.MessageID, 0)
+		return
+	}
+
 	// Выбор формата (скачивание)
 	if strings.HasPrefix(data, cbDownloadPrefix) {
 		rest := strings.TrimPrefix(data, cbDownloadPrefix)
@@ -405,135 +1164,108 @@ func (b *Bot) handleCallback(cb *tgbotapi.CallbackQuery) {
 
 		userID := cb.From.ID
 		username := cb.From.UserName
-		b.downloadAndSend(chatID, userID, username, bookID, formatPath)
+		b.downloadAndSend(ctx, chatID, userID, username, bookID, formatPath)
 		return
 	}
 
-	// Выбор книги: показываем карточку (обложка + форматы)
-	if strings.HasPrefix(data, cbBookPrefix) {
-		callbackResp := tgbotapi.NewCallback(cb.ID, "Открываю…")
+	// Выбор автора: показываем его библиографию
+	if strings.HasPrefix(data, cbAuthorPrefix) {
+		callbackResp := tgbotapi.NewCallback(cb.ID, "Загружаю книги автора…")
 		b.bot.Request(callbackResp)
 
-		bookID := strings.TrimPrefix(data, cbBookPrefix)
-		b.sendBookDetails(chatID, bookID)
+		authorID := strings.TrimPrefix(data, cbAuthorPrefix)
+		b.sendAuthorBooks(ctx, chatID, authorID)
 		return
 	}
 
-	// Backward compatibility: old callbacks might contain just the numeric bookID.
-	if data != "" {
-		callbackResp := tgbotapi.NewCallback(cb.ID, "Открываю…")
+	// Скачивание следующего непрочитанного тома серии
+	if strings.HasPrefix(data, cbSeriesNextPrefix) {
+		callbackResp := tgbotapi.NewCallback(cb.ID, "Ищу следующий том…")
 		b.bot.Request(callbackResp)
 
-		b.sendBookDetails(chatID, data)
+		seriesID := strings.TrimPrefix(data, cbSeriesNextPrefix)
+		b.sendNextUnread(ctx, chatID, cb.From.ID, cb.From.UserName, seriesID)
 		return
 	}
-}
 
-func (b *Bot) downloadAndSend(chatID int64, userID int64, username string, bookID string, formatPath string) {
-	// Отправляем сообщение, чтобы юзер видел прогресс
-	loadingMsg, errLoading := b.bot.Send(tgbotapi.NewMessage(chatID, "⏳ Скачиваю файл... Подождите..."))
+	// Выбор серии: показываем тома по порядку
+	if strings.HasPrefix(data, cbSeriesPrefix) {
+		callbackResp := tgbotapi.NewCallback(cb.ID, "Загружаю серию…")
+		b.bot.Request(callbackResp)
 
-	// Отправляем сообщение, чтобы юзер видел прогресс
-	// Вспомогательная функция для удаления сообщения о загрузке
-	deleteLoadingMsg := func() {
-		if errLoading == nil && loadingMsg.MessageID != 0 {
-			delMsg := tgbotapi.NewDeleteMessage(chatID, loadingMsg.MessageID)
-			b.bot.Send(delMsg)
-		}
+		seriesID := strings.TrimPrefix(data, cbSeriesPrefix)
+		b.sendSeries(ctx, chatID, seriesID)
+		return
 	}
 
-	// 2. Качаем файл (получаем поток stream)
-	stream, filename, err := b.service.Download(bookID, formatPath)
-	if err != nil {
-		// Удаляем сообщение о загрузке при ошибке
-		deleteLoadingMsg()
-		b.sendMessage(chatID, "❌ Не удалось скачать файл. Возможно, ссылка устарела или Tor тупит.")
-		log.Printf("Download error: %v", err)
+	// Полки и статусы чтения
+	if b.handleShelfCallback(ctx, cb) {
 		return
 	}
-	// Обязательно закрываем поток после чтения!
-	defer stream.Close()
 
-	// 3. Сохраняем файл на диск (Telegram лимит ~50MB)
-	const maxFileSize = 50 * 1024 * 1024 // 50MB
-	saved, err := storage.SaveBookFile(b.storageDir, filename, stream, maxFileSize)
-	if err != nil {
-		deleteLoadingMsg()
-		if strings.Contains(err.Error(), "слишком большой") {
-			b.sendMessage(chatID, "❌ Файл слишком большой. Максимальный размер: 50 MB.")
-		} else {
-			b.sendMessage(chatID, "❌ Ошибка при сохранении файла.")
+	// Экспорт выделений книги
+	if strings.HasPrefix(data, cbHighlightsPrefix) {
+		callbackResp := tgbotapi.NewCallback(cb.ID, "Собираю выделения…")
+		b.bot.Request(callbackResp)
+
+		fileID, err := strconv.ParseInt(strings.TrimPrefix(data, cbHighlightsPrefix), 10, 64)
+		if err != nil || b.store == nil {
+			log.Printf("Invalid highlights callback data: %q", data)
+			return
 		}
-		log.Printf("Save file error: %v", err)
+		b.sendHighlightsByID(ctx, chatID, cb.From.ID, fileID)
 		return
 	}
 
-	fullPath := filepath.Join(b.storageDir, saved.RelativePath)
-	absPath, err := filepath.Abs(fullPath)
-	if err != nil {
-		absPath = fullPath
-	}
+	// Полная аннотация книги
+	if strings.HasPrefix(data, cbMorePrefix) {
+		callbackResp := tgbotapi.NewCallback(cb.ID, "")
+		b.bot.Request(callbackResp)
 
-	// 4. Сохраняем метаданные в БД
-	if b.store != nil {
-		ctx := context.Background()
-		if err := b.store.EnsureUser(ctx, userID, username); err != nil {
-			log.Printf("EnsureUser error: %v", err)
-		} else {
-			title := ""
-			author := ""
-			if book, ok := b.findBookInSession(chatID, bookID); ok {
-				title = book.Title
-				author = book.Author
-			}
-			bookDBID, err := b.store.UpsertBook(ctx, bookID, title, author)
-			if err != nil {
-				log.Printf("UpsertBook error: %v", err)
-			} else {
-				fileID, err := b.store.InsertBookFile(ctx, bookDBID, formatPath, saved.RelativePath, saved.SizeBytes)
-				if err != nil {
-					log.Printf("InsertBookFile error: %v", err)
-				} else if err := b.store.AddToLibrary(ctx, userID, fileID); err != nil {
-					log.Printf("AddToLibrary error: %v", err)
-				}
-			}
-		}
+		bookID := strings.TrimPrefix(data, cbMorePrefix)
+		b.sendAnnotation(ctx, chatID, bookID)
+		return
 	}
 
-	// Создаем документ
-	docMsg := tgbotapi.NewDocument(chatID, tgbotapi.FilePath(absPath))
-	docMsg.Caption = "📖 Ваша книга. Приятного чтения!"
+	// Выбор книги: показываем карточку (обложка + форматы)
+	if strings.HasPrefix(data, cbBookPrefix) {
+		callbackResp := tgbotapi.NewCallback(cb.ID, "Открываю…")
+		b.bot.Request(callbackResp)
+
+		bookID := strings.TrimPrefix(data, cbBookPrefix)
+		b.sendBookDetails(ctx, chatID, bookID)
+		return
+	}
 
-	if b.miniAppURL != "" {
-		type webAppInfo struct {
-			URL string `json:"url"`
-		}
-		type inlineKeyboardButton struct {
-			Text   string      `json:"text"`
-			WebApp *webAppInfo `json:"web_app,omitempty"`
-		}
-		type inlineKeyboardMarkup struct {
-			InlineKeyboard [][]inlineKeyboardButton `json:"inline_keyboard"`
-		}
+	// Backward compatibility: old callbacks might contain just the numeric bookID.
+	if data != "" {
+		callbackResp := tgbotapi.NewCallback(cb.ID, "Открываю…")
+		b.bot.Request(callbackResp)
 
-		docMsg.ReplyMarkup = inlineKeyboardMarkup{
-			InlineKeyboard: [][]inlineKeyboardButton{
-				{
-					{Text: "Читать онлайн", WebApp: &webAppInfo{URL: b.miniAppURL}},
-				},
-			},
-		}
+		b.sendBookDetails(ctx, chatID, data)
+		return
 	}
+}
 
-	// 5. Отправляем файл
-	if _, err := b.bot.Send(docMsg); err != nil {
-		// Удаляем сообщение о загрузке при ошибке
-		deleteLoadingMsg()
-		b.sendMessage(chatID, fmt.Sprintf("❌ Ошибка при отправке файла в Telegram: %v", err))
-		log.Printf("Send file error: %v", err)
-	} else {
-		// Если все ок — удаляем сообщение "Скачиваю..."
-		deleteLoadingMsg()
+// sendCancellable отправляет сообщение о начатой операции с кнопкой "Отмена".
+func (b *Bot) sendCancellable(chatID int64, text string) (tgbotapi.Message, error) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("✖️ Отмена", cbCancel)),
+	)
+	return b.bot.Send(msg)
+}
+
+// sendFailure сообщает об ошибке операции. Отменённая операция молчит (пользователю уже
+// ответили на отмену, а при остановке бота писать некуда), просроченная — пишет про таймаут.
+func (b *Bot) sendFailure(ctx context.Context, chatID int64, text string) {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		b.sendMessage(chatID, "⌛ Сайт слишком долго не отвечает, попробуй ещё раз позже.")
+	case ctx.Err() != nil:
+		return
+	default:
+		b.sendMessage(chatID, text)
 	}
 }
 
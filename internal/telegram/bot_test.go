@@ -0,0 +1,881 @@
+package telegram
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"tor_project/internal/db"
+	"tor_project/internal/models"
+	"tor_project/internal/service"
+	"tor_project/internal/storage"
+)
+
+const (
+	testChatID = int64(100)
+	testUserID = int64(42)
+)
+
+// fakeAPI записывает всё, что бот отправляет в Telegram.
+type fakeAPI struct {
+	mu     sync.Mutex
+	sent   []tgbotapi.Chattable
+	nextID int
+
+	// rejectFileIDs имитирует Telegram, не принимающий сохранённые file_id.
+	rejectFileIDs bool
+}
+
+func (f *fakeAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	doc, isDoc := c.(tgbotapi.DocumentConfig)
+	if isDoc && f.rejectFileIDs {
+		if _, ok := doc.File.(tgbotapi.FileID); ok {
+			return tgbotapi.Message{}, fmt.Errorf("Bad Request: wrong file identifier")
+		}
+	}
+
+	f.sent = append(f.sent, c)
+	f.nextID++
+	msg := tgbotapi.Message{MessageID: f.nextID, Chat: &tgbotapi.Chat{ID: testChatID}}
+	if isDoc {
+		msg.Document = &tgbotapi.Document{FileID: fmt.Sprintf("tg-file-%d", f.nextID)}
+	}
+	return msg, nil
+}
+
+// documents возвращает отправленные файлы по порядку.
+func (f *fakeAPI) documents() []tgbotapi.RequestFileData {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var out []tgbotapi.RequestFileData
+	for _, c := range f.sent {
+		if doc, ok := c.(tgbotapi.DocumentConfig); ok {
+			out = append(out, doc.File)
+		}
+	}
+	return out
+}
+
+func (f *fakeAPI) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (f *fakeAPI) GetUpdatesChan(tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
+	ch := make(chan tgbotapi.Update)
+	close(ch)
+	return ch
+}
+
+func (f *fakeAPI) StopReceivingUpdates() {}
+
+// texts возвращает тексты (и подписи) всех отправленных сообщений по порядку.
+func (f *fakeAPI) texts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var out []string
+	for _, c := range f.sent {
+		switch m := c.(type) {
+		case tgbotapi.MessageConfig:
+			out = append(out, m.Text)
+		case tgbotapi.EditMessageTextConfig:
+			out = append(out, m.Text)
+		case tgbotapi.PhotoConfig:
+			out = append(out, m.Caption)
+		case tgbotapi.DocumentConfig:
+			out = append(out, "document:"+m.Caption)
+		}
+	}
+	return out
+}
+
+type step struct {
+	text     string
+	callback string
+}
+
+func newTestCatalog() *service.FakeCatalog {
+	catalog := service.NewFakeCatalog()
+	catalog.AddBook(models.BookDetails{ID: "1", Title: "1984", Author: "Джордж Оруэлл", Annotation: "Антиутопия."},
+		map[string][]byte{"fb2": []byte("<FictionBook/>"), "epub": []byte("PK-epub")})
+	catalog.AddBook(models.BookDetails{ID: "2", Title: "Скотный двор", Author: "Джордж Оруэлл"},
+		map[string][]byte{"fb2": []byte("<FictionBook>farm</FictionBook>")})
+	catalog.Authors["9162"] = models.Author{ID: "9162", Name: "Джордж Оруэлл", BookCount: 2}
+	catalog.Series["5"] = models.Series{ID: "5", Title: "Оруэлл: избранное", Books: []models.SeriesBook{
+		{Book: models.Book{ID: "1", Title: "1984", Author: "Джордж Оруэлл"}, Number: 1},
+		{Book: models.Book{ID: "2", Title: "Скотный двор", Author: "Джордж Оруэлл"}, Number: 2},
+	}}
+	return catalog
+}
+
+func newTestBot(t *testing.T) (*Bot, *fakeAPI, *db.Store) {
+	t.Helper()
+
+	dir := t.TempDir()
+	store, err := db.Open(filepath.Join(dir, "app.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	api := &fakeAPI{}
+	catalog := newTestCatalog()
+	bot := newBot(api, catalog, catalog, catalog, store, storage.NewFSStore(filepath.Join(dir, "books")), "")
+	return bot, api, store
+}
+
+func (b *Bot) runSteps(steps []step) {
+	for i, s := range steps {
+		if s.callback != "" {
+			b.handleUpdate(context.Background(), tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+				ID:      "cb",
+				Data:    s.callback,
+				From:    &tgbotapi.User{ID: testUserID, UserName: "reader"},
+				Message: &tgbotapi.Message{MessageID: i + 1, Chat: &tgbotapi.Chat{ID: testChatID}},
+			}})
+			// Скачивание идёт через очередь: выполняем её здесь же, чтобы шаги шли по порядку.
+			for b.processNextDownload(context.Background()) {
+			}
+			continue
+		}
+
+		msg := &tgbotapi.Message{
+			MessageID: i + 1,
+			Text:      s.text,
+			Chat:      &tgbotapi.Chat{ID: testChatID},
+			From:      &tgbotapi.User{ID: testUserID, UserName: "reader"},
+		}
+		if strings.HasPrefix(s.text, "/") {
+			cmd := strings.SplitN(s.text, " ", 2)[0]
+			msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(cmd)}}
+		}
+		b.handleUpdate(context.Background(), tgbotapi.Update{Message: msg})
+	}
+}
+
+func TestBotFlow(t *testing.T) {
+	tests := []struct {
+		name        string
+		steps       []step
+		wantLast    string
+		wantLibrary []string
+	}{
+		{
+			name:     "search shows books page",
+			steps:    []step{{text: "1984"}},
+			wantLast: "Найдено книг: 1",
+		},
+		{
+			name:     "nothing found",
+			steps:    []step{{text: "Война и мир"}},
+			wantLast: "Ничего не найдено",
+		},
+		{
+			name:     "book card after search",
+			steps:    []step{{text: "1984"}, {callback: cbBookPrefix + "1"}},
+			wantLast: "📖 1984\n✍️ Джордж Оруэлл",
+		},
+		{
+			name:        "search, details, download, library",
+			steps:       []step{{text: "1984"}, {callback: cbBookPrefix + "1"}, {callback: cbDownloadPrefix + "1:fb2"}},
+			wantLast:    "✅ Готово: «1984» (FB2)",
+			wantLibrary: []string{"1984"},
+		},
+		{
+			name:     "download of a missing format fails",
+			steps:    []step{{callback: cbDownloadPrefix + "1:pdf"}},
+			wantLast: "Не удалось скачать файл",
+		},
+		{
+			name:     "author search lists matches",
+			steps:    []step{{text: "/author оруэлл"}},
+			wantLast: "👤 Найдено авторов: 1",
+		},
+		{
+			name:     "author search without a name",
+			steps:    []step{{text: "/author"}},
+			wantLast: "Напиши имя автора",
+		},
+		{
+			name:     "author not found",
+			steps:    []step{{text: "/author Толстой"}},
+			wantLast: "Авторы не найдены",
+		},
+		{
+			name:     "author bibliography",
+			steps:    []step{{text: "/author Оруэлл"}, {callback: cbAuthorPrefix + "9162"}},
+			wantLast: "✍️ Джордж Оруэлл\n📚 Книг: 2",
+		},
+		{
+			name:     "unknown author page",
+			steps:    []step{{callback: cbAuthorPrefix + "1"}},
+			wantLast: "Не удалось загрузить страницу автора",
+		},
+		{
+			name:        "series next unread volume",
+			steps:       []step{{text: "/series Оруэлл"}, {callback: cbSeriesPrefix + "5"}, {callback: cbDownloadPrefix + "1:fb2"}, {callback: cbSeriesNextPrefix + "5"}},
+			wantLast:    "✅ Готово: «Скотный двор» (FB2)",
+			wantLibrary: []string{"1984", "Скотный двор"},
+		},
+		{
+			name:        "series next unread volume without opening the series",
+			steps:       []step{{callback: cbSeriesNextPrefix + "5"}},
+			wantLast:    "✅ Готово: «1984» (FB2)",
+			wantLibrary: []string{"1984"},
+		},
+		{
+			name: "series next unread volume when all are owned",
+			steps: []step{{text: "Оруэлл"}, {callback: cbDownloadPrefix + "1:fb2"}, {callback: cbDownloadPrefix + "2:fb2"},
+				{callback: cbSeriesPrefix + "5"}, {callback: cbSeriesNextPrefix + "5"}},
+			wantLast:    "Все тома этой серии уже есть",
+			wantLibrary: []string{"1984", "Скотный двор"},
+		},
+		{
+			name:     "series next unread volume of an unknown series",
+			steps:    []step{{callback: cbSeriesNextPrefix + "404"}},
+			wantLast: "Не удалось загрузить серию",
+		},
+		{
+			name:     "annotation button",
+			steps:    []step{{callback: cbMorePrefix + "1"}},
+			wantLast: "Антиутопия.",
+		},
+		{
+			name:     "annotation button shows the whole card",
+			steps:    []step{{callback: cbMorePrefix + "1"}},
+			wantLast: "📖 1984\n✍️ Джордж Оруэлл",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot, api, store := newTestBot(t)
+			bot.runSteps(tt.steps)
+
+			texts := api.texts()
+			if len(texts) == 0 {
+				t.Fatal("bot sent nothing")
+			}
+			last := texts[len(texts)-1]
+			if !strings.Contains(last, tt.wantLast) {
+				t.Fatalf("last message %q does not contain %q\nall: %q", last, tt.wantLast, texts)
+			}
+
+			items, err := store.ListLibrary(context.Background(), testUserID)
+			if err != nil {
+				t.Fatalf("list library: %v", err)
+			}
+			var titles []string
+			for _, item := range items {
+				titles = append(titles, item.Title)
+			}
+			// Книги, добавленные в одну секунду, идут в библиотеке в любом порядке.
+			sort.Strings(titles)
+			if strings.Join(titles, "|") != strings.Join(tt.wantLibrary, "|") {
+				t.Fatalf("library: got %q, want %q", titles, tt.wantLibrary)
+			}
+		})
+	}
+}
+
+func TestCancelAbortsDownload(t *testing.T) {
+	bot, api, store := newTestBot(t)
+	catalog := bot.downloader.(*service.FakeCatalog)
+	catalog.Delay = time.Minute
+
+	chat := &tgbotapi.Chat{ID: testChatID}
+	from := &tgbotapi.User{ID: testUserID, UserName: "reader"}
+
+	bot.handleUpdate(context.Background(), tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID: "dl", Data: cbDownloadPrefix + "1:fb2", From: from, Message: &tgbotapi.Message{MessageID: 1, Chat: chat},
+	}})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bot.processNextDownload(context.Background())
+	}()
+
+	// Ждём, пока воркер возьмёт задание.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		bot.opsMu.Lock()
+		started := len(bot.jobs) > 0
+		bot.opsMu.Unlock()
+		if started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("download did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !bot.handleCancel(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID: "cancel", Data: cbCancel, From: from, Message: &tgbotapi.Message{MessageID: 2, Chat: chat},
+	}}) {
+		t.Fatal("cancel callback not handled")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download was not aborted by cancel")
+	}
+
+	texts := api.texts()
+	if last := texts[len(texts)-1]; !strings.Contains(last, "Отменено") {
+		t.Fatalf("last message %q, all: %q", last, texts)
+	}
+	items, err := store.ListLibrary(context.Background(), testUserID)
+	if err != nil {
+		t.Fatalf("list library: %v", err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("cancelled download got into library: %+v", items)
+	}
+}
+
+func TestDownloadQueueDeduplicates(t *testing.T) {
+	bot, api, store := newTestBot(t)
+	catalog := bot.downloader.(*service.FakeCatalog)
+
+	// Двое просят одну и ту же книгу, пока задание ещё в очереди.
+	for i, userID := range []int64{testUserID, testUserID + 1} {
+		bot.handleUpdate(context.Background(), tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:      "dl",
+			Data:    cbDownloadPrefix + "1:fb2",
+			From:    &tgbotapi.User{ID: userID},
+			Message: &tgbotapi.Message{MessageID: i + 1, Chat: &tgbotapi.Chat{ID: userID}},
+		}})
+	}
+	for bot.processNextDownload(context.Background()) {
+	}
+
+	if got := catalog.Calls["Download"]; got != 1 {
+		t.Fatalf("Download calls: got %d, want 1", got)
+	}
+
+	documents := 0
+	for _, text := range api.texts() {
+		if strings.HasPrefix(text, "document:") {
+			documents++
+		}
+	}
+	if documents != 2 {
+		t.Fatalf("documents sent: got %d, want 2\nall: %q", documents, api.texts())
+	}
+
+	for _, userID := range []int64{testUserID, testUserID + 1} {
+		items, err := store.ListLibrary(context.Background(), userID)
+		if err != nil {
+			t.Fatalf("list library: %v", err)
+		}
+		if len(items) != 1 {
+			t.Fatalf("library of %d: %+v", userID, items)
+		}
+	}
+}
+
+func TestDownloadReusesStoredFile(t *testing.T) {
+	bot, api, store := newTestBot(t)
+	catalog := bot.downloader.(*service.FakeCatalog)
+	ctx := context.Background()
+
+	download := func(userID int64) {
+		bot.handleUpdate(ctx, tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:      "dl",
+			Data:    cbDownloadPrefix + "1:fb2",
+			From:    &tgbotapi.User{ID: userID},
+			Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: userID}},
+		}})
+		for bot.processNextDownload(ctx) {
+		}
+	}
+
+	download(testUserID)
+	download(testUserID + 1)
+
+	if got := catalog.Calls["Download"]; got != 1 {
+		t.Fatalf("Download calls: got %d, want 1", got)
+	}
+	files, err := store.FindBookFiles(ctx, "1", "fb2")
+	if err != nil || len(files) != 1 {
+		t.Fatalf("book files: %+v, %v", files, err)
+	}
+	items, err := store.ListLibrary(ctx, testUserID+1)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("library of second user: %+v, %v", items, err)
+	}
+	if texts := api.texts(); !strings.HasPrefix(texts[len(texts)-1], "document:") {
+		t.Fatalf("second user got no file: %q", texts)
+	}
+
+	// Файл пропал с диска — книга скачивается заново.
+	if err := bot.blobs.Delete(ctx, files[0].Path); err != nil {
+		t.Fatal(err)
+	}
+	download(testUserID + 2)
+	if got := catalog.Calls["Download"]; got != 2 {
+		t.Fatalf("Download calls after file loss: got %d, want 2", got)
+	}
+}
+
+func TestDownloadUserQuota(t *testing.T) {
+	bot, api, _ := newTestBot(t)
+	catalog := bot.downloader.(*service.FakeCatalog)
+	ctx := context.Background()
+	// fb2 книги 1 — 14 байт, её epub — 7 байт, fb2 книги 2 — 31 байт.
+	bot.downloads.UserQuota = 21
+
+	enqueue := func(userID int64, book string) {
+		bot.handleUpdate(ctx, tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:      "dl",
+			Data:    cbDownloadPrefix + book,
+			From:    &tgbotapi.User{ID: userID},
+			Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: userID}},
+		}})
+	}
+	download := func(userID int64, book string) string {
+		enqueue(userID, book)
+		for bot.processNextDownload(ctx) {
+		}
+		texts := api.texts()
+		return texts[len(texts)-1]
+	}
+
+	// Обе книги встали в очередь при пустой библиотеке, но вместе не влезают: вторую
+	// пользователь получает, а в библиотеку она не попадает.
+	other := testUserID + 2
+	enqueue(other, "1:fb2")
+	enqueue(other, "2:fb2")
+	for bot.processNextDownload(ctx) {
+	}
+	texts := api.texts()
+	if last := texts[len(texts)-1]; !strings.Contains(last, "✅ Готово") || !strings.Contains(last, "не добавлена") {
+		t.Fatalf("delivered over quota: %q", last)
+	}
+	items, err := bot.store.ListLibrary(ctx, other)
+	if err != nil {
+		t.Fatalf("list library: %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("library: %+v", items)
+	}
+
+	// Размер нескачанной книги неизвестен: пока квота не заполнена, она скачивается.
+	download(testUserID, "1:fb2")
+	if last := download(testUserID, "1:epub"); !strings.HasPrefix(last, "✅ Готово") || strings.Contains(last, "не добавлена") {
+		t.Fatalf("second book not kept: %q", last)
+	}
+	// Квота заполнена, но книгу из своей библиотеки получить можно — без нового скачивания.
+	if last := download(testUserID, "1:fb2"); !strings.HasPrefix(last, "document:") {
+		t.Fatalf("own book refused: %q", last)
+	}
+	if last := download(testUserID, "2:fb2"); !strings.Contains(last, "Библиотека заполнена") {
+		t.Fatalf("new book over quota: %q", last)
+	}
+	if got := catalog.Calls["Download"]; got != 3 {
+		t.Fatalf("Download calls: got %d, want 3", got)
+	}
+
+	// Уже скачанная книга известного размера, которая не влезет, не добавляется.
+	download(testUserID+1, "1:fb2")
+	if last := download(testUserID+1, "2:fb2"); !strings.Contains(last, "не помещается") {
+		t.Fatalf("stored book over quota: %q", last)
+	}
+}
+
+func TestSendReusesTelegramFileID(t *testing.T) {
+	bot, api, store := newTestBot(t)
+	ctx := context.Background()
+
+	download := func(userID int64) {
+		bot.handleUpdate(ctx, tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:      "dl",
+			Data:    cbDownloadPrefix + "1:fb2",
+			From:    &tgbotapi.User{ID: userID},
+			Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: userID}},
+		}})
+		for bot.processNextDownload(ctx) {
+		}
+	}
+
+	// Первая отправка загружает файл, вторая идёт по file_id.
+	download(testUserID)
+	download(testUserID + 1)
+
+	docs := api.documents()
+	if len(docs) != 2 {
+		t.Fatalf("documents: %v", docs)
+	}
+	if _, ok := docs[0].(tgbotapi.FileReader); !ok {
+		t.Fatalf("first send: got %T, want upload", docs[0])
+	}
+	firstID, ok := docs[1].(tgbotapi.FileID)
+	if !ok {
+		t.Fatalf("second send: got %T, want file_id", docs[1])
+	}
+
+	// Telegram не принял file_id — файл загружается снова, и file_id обновляется.
+	api.mu.Lock()
+	api.rejectFileIDs = true
+	api.mu.Unlock()
+	download(testUserID + 2)
+
+	docs = api.documents()
+	if _, ok := docs[len(docs)-1].(tgbotapi.FileReader); !ok {
+		t.Fatalf("fallback send: got %T, want upload", docs[len(docs)-1])
+	}
+	files, err := store.FindBookFiles(ctx, "1", "fb2")
+	if err != nil || len(files) != 1 {
+		t.Fatalf("book files: %+v, %v", files, err)
+	}
+	if files[0].TelegramFileID == "" || files[0].TelegramFileID == string(firstID) {
+		t.Fatalf("file_id not refreshed: %q", files[0].TelegramFileID)
+	}
+}
+
+func TestDownloadUnpacksZippedBook(t *testing.T) {
+	bot, api, store := newTestBot(t)
+	catalog := bot.downloader.(*service.FakeCatalog)
+	ctx := context.Background()
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, _ := zw.Create("Orwell_Animal_Farm.fb2")
+	w.Write([]byte("<FictionBook>farm</FictionBook>"))
+	zw.Close()
+	catalog.AddBook(models.BookDetails{ID: "3", Title: "Скотный двор"}, map[string][]byte{"fb2": archive.Bytes()})
+
+	bot.handleUpdate(ctx, tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "dl",
+		Data:    cbDownloadPrefix + "3:fb2",
+		From:    &tgbotapi.User{ID: testUserID},
+		Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: testChatID}},
+	}})
+	for bot.processNextDownload(ctx) {
+	}
+
+	// В библиотеке — распакованная книга, в Telegram ушёл архив.
+	files, err := store.FindBookFiles(ctx, "3", "fb2")
+	if err != nil || len(files) != 1 {
+		t.Fatalf("book files: %+v, %v", files, err)
+	}
+	file := files[0]
+	if !strings.HasSuffix(file.Path, ".fb2") || !strings.HasSuffix(file.ArchivePath, ".fb2.zip") || file.MimeType != "application/x-fictionbook+xml" {
+		t.Fatalf("stored file: %+v", file)
+	}
+
+	docs := api.documents()
+	if len(docs) != 1 {
+		t.Fatalf("documents: %v", docs)
+	}
+	if doc, ok := docs[0].(tgbotapi.FileReader); !ok || !strings.HasSuffix(doc.Name, ".fb2.zip") {
+		t.Fatalf("sent: %#v", docs[0])
+	}
+}
+
+func TestDownloadReadsFB2Metadata(t *testing.T) {
+	bot, _, store := newTestBot(t)
+	catalog := bot.downloader.(*service.FakeCatalog)
+	ctx := context.Background()
+
+	book := `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info>
+<author><first-name>Джордж</first-name><last-name>Оруэлл</last-name></author>
+<book-title>Скотный двор. Сказка</book-title>
+<annotation><p>Все животные равны.</p></annotation>
+<lang>ru</lang>
+</title-info></description><body><section><p>Текст</p></section></body></FictionBook>`
+	catalog.AddBook(models.BookDetails{ID: "4", Title: "скотный двор"}, map[string][]byte{"fb2": []byte(book)})
+
+	bot.handleUpdate(ctx, tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "dl",
+		Data:    cbDownloadPrefix + "4:fb2",
+		From:    &tgbotapi.User{ID: testUserID},
+		Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: testChatID}},
+	}})
+	for bot.processNextDownload(ctx) {
+	}
+
+	items, err := store.ListLibrary(ctx, testUserID)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("library: %+v, %v", items, err)
+	}
+	if items[0].Title != "Скотный двор. Сказка" || items[0].Author != "Джордж Оруэлл" {
+		t.Fatalf("library item: %+v", items[0])
+	}
+	if annotation, err := store.GetBookAnnotation(ctx, "4"); err != nil || annotation != "Все животные равны." {
+		t.Fatalf("annotation: %q, %v", annotation, err)
+	}
+}
+
+func TestDownloadConvertsFB2ToEPUB(t *testing.T) {
+	bot, api, store := newTestBot(t)
+	ctx := context.Background()
+
+	// У «Скотного двора» есть только FB2: в карточке появляется конвертация в EPUB.
+	bot.runSteps([]step{{callback: cbBookPrefix + "2"}})
+	convert := cbDownloadPrefix + "2:" + fb2EPUBFormat
+	found := false
+	api.mu.Lock()
+	for _, c := range api.sent {
+		var markup any
+		switch m := c.(type) {
+		case tgbotapi.PhotoConfig:
+			markup = m.ReplyMarkup
+		case tgbotapi.MessageConfig:
+			markup = m.ReplyMarkup
+		}
+		if kb, ok := markup.(tgbotapi.InlineKeyboardMarkup); ok {
+			for _, row := range kb.InlineKeyboard {
+				for _, btn := range row {
+					found = found || (btn.CallbackData != nil && *btn.CallbackData == convert)
+				}
+			}
+		}
+	}
+	api.mu.Unlock()
+	if !found {
+		t.Fatal("no EPUB conversion button")
+	}
+
+	bot.runSteps([]step{{callback: convert}})
+	if texts := api.texts(); !strings.Contains(texts[len(texts)-1], "(EPUB из FB2)") {
+		t.Fatalf("last message: %q", texts[len(texts)-1])
+	}
+
+	// Сконвертированный файл лежит отдельной строкой, рядом с исходным FB2.
+	converted, err := store.FindBookFiles(ctx, "2", fb2EPUBFormat)
+	if err != nil || len(converted) != 1 || converted[0].MimeType != "application/epub+zip" {
+		t.Fatalf("converted: %+v, %v", converted, err)
+	}
+	if source, err := store.FindBookFiles(ctx, "2", "fb2"); err != nil || len(source) != 1 {
+		t.Fatalf("source: %+v, %v", source, err)
+	}
+	body, err := bot.blobs.Get(ctx, converted[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil || zr.File[0].Name != "mimetype" {
+		t.Fatalf("epub: %v", err)
+	}
+
+	// Повторный запрос отдаёт уже готовую конвертацию.
+	bot.runSteps([]step{{callback: convert}})
+	if files, _ := store.FindBookFiles(ctx, "2", fb2EPUBFormat); len(files) != 1 || len(api.documents()) != 2 {
+		t.Fatalf("second request: files %+v, documents %d", files, len(api.documents()))
+	}
+}
+
+func TestHighlightsExport(t *testing.T) {
+	bot, api, store := newTestBot(t)
+	ctx := context.Background()
+
+	bot.runSteps([]step{{text: "/highlights"}})
+	if texts := api.texts(); !strings.Contains(texts[len(texts)-1], "Выделений пока нет") {
+		t.Fatalf("empty: %q", texts[len(texts)-1])
+	}
+
+	bot.runSteps([]step{{text: "1984"}, {callback: cbDownloadPrefix + "1:fb2"}})
+	items, err := store.ListLibrary(ctx, testUserID)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("library: %+v, %v", items, err)
+	}
+	fileID := items[0].FileID
+	second, err := store.AddHighlight(ctx, testUserID, db.Highlight{FileID: fileID, Chapter: 0, StartParagraph: 5, EndParagraph: 5, EndChar: 4, Color: "yellow", Text: "Второе"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddHighlight(ctx, testUserID, db.Highlight{FileID: fileID, Chapter: 0, StartParagraph: 1, EndParagraph: 1, EndChar: 4, Color: "yellow", Text: "Первое"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddNote(ctx, testUserID, db.Note{FileID: fileID, HighlightID: &second.ID, Location: "c0p5", Offset: 5, Text: "К второму"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddNote(ctx, testUserID, db.Note{FileID: fileID, Chapter: 2, Location: "c2p0", Text: "В конце"}); err != nil {
+		t.Fatal(err)
+	}
+
+	bot.runSteps([]step{{text: "/highlights 1984"}})
+	texts := api.texts()
+	want := "# 1984\n_Джордж Оруэлл_\n\n## Глава 1\n\n> Первое\n\n> Второе\n\n📝 К второму\n\n## Глава 3\n\n📝 В конце\n"
+	if got := texts[len(texts)-1]; got != want {
+		t.Fatalf("export:\n%s", got)
+	}
+
+	// Длинный экспорт уходит файлом.
+	if _, err := store.AddHighlight(ctx, testUserID, db.Highlight{FileID: fileID, Chapter: 1, EndChar: 1, Color: "blue", Text: strings.Repeat("слово ", 1000)}); err != nil {
+		t.Fatal(err)
+	}
+	bot.runSteps([]step{{callback: cbHighlightsPrefix + strconv.FormatInt(fileID, 10)}})
+	docs := api.documents()
+	doc, ok := docs[len(docs)-1].(tgbotapi.FileBytes)
+	if !ok || doc.Name != "1984.md" || !strings.HasPrefix(string(doc.Bytes), "# 1984\n") {
+		t.Fatalf("document: %#v", docs[len(docs)-1])
+	}
+}
+
+// callbackData возвращает данные всех кнопок последнего сообщения с клавиатурой.
+func (f *fakeAPI) callbackData() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := len(f.sent) - 1; i >= 0; i-- {
+		var markup any
+		switch m := f.sent[i].(type) {
+		case tgbotapi.PhotoConfig:
+			markup = m.ReplyMarkup
+		case tgbotapi.MessageConfig:
+			markup = m.ReplyMarkup
+		case tgbotapi.EditMessageReplyMarkupConfig:
+			markup = *m.ReplyMarkup
+		}
+		if kb, ok := markup.(tgbotapi.InlineKeyboardMarkup); ok {
+			var out []string
+			for _, row := range kb.InlineKeyboard {
+				for _, btn := range row {
+					if btn.CallbackData != nil {
+						out = append(out, btn.Text+"="+*btn.CallbackData)
+					}
+				}
+			}
+			return out
+		}
+	}
+	return nil
+}
+
+func TestShelvesAndStatuses(t *testing.T) {
+	bot, api, store := newTestBot(t)
+	ctx := context.Background()
+
+	bot.runSteps([]step{{text: "/shelf Любимое"}})
+	shelf, err := store.FindShelf(ctx, testUserID, "Любимое")
+	if err != nil {
+		t.Fatalf("shelf not created: %v", err)
+	}
+	shelfID := strconv.FormatInt(shelf.ID, 10)
+
+	// Книгу кладём на полку прямо из карточки, не скачивая.
+	bot.runSteps([]step{{callback: cbBookPrefix + "1"}})
+	if buttons := strings.Join(api.callbackData(), " "); !strings.Contains(buttons, "="+cbShelvesPrefix+"1") || !strings.Contains(buttons, "="+cbStatusPrefix+"1") {
+		t.Fatalf("card buttons: %s", buttons)
+	}
+	bot.runSteps([]step{{callback: cbShelvesPrefix + "1"}})
+	toggle := cbShelfTogglePrefix + shelfID + ":1"
+	if buttons := api.callbackData(); len(buttons) != 1 || buttons[0] != "Любимое="+toggle {
+		t.Fatalf("shelf picker: %v", buttons)
+	}
+	bot.runSteps([]step{{callback: toggle}})
+	if buttons := api.callbackData(); len(buttons) != 1 || buttons[0] != "✓ Любимое="+toggle {
+		t.Fatalf("after toggle: %v", buttons)
+	}
+	books, err := store.ShelfBooks(ctx, testUserID, shelf.ID)
+	if err != nil || len(books) != 1 || books[0].SourceID != "1" || books[0].FileID != 0 {
+		t.Fatalf("shelf books: %+v, %v", books, err)
+	}
+	if files, _ := store.FindBookFiles(ctx, "1", "fb2"); len(files) != 0 {
+		t.Fatalf("book was downloaded: %+v", files)
+	}
+
+	bot.runSteps([]step{{callback: cbSetStatusPrefix + db.StatusFinished + ":1"}})
+	if status, err := store.BookStatus(ctx, testUserID, books[0].BookID); err != nil || status != db.StatusFinished {
+		t.Fatalf("status: %q, %v", status, err)
+	}
+
+	bot.runSteps([]step{{text: "/shelf"}})
+	if buttons := strings.Join(api.callbackData(), " "); !strings.Contains(buttons, "📚 Любимое (1)="+cbShelfOpenPrefix+shelfID) ||
+		!strings.Contains(buttons, "✅ Прочитано (1)="+cbStatusListPrefix+db.StatusFinished) {
+		t.Fatalf("shelves: %s", buttons)
+	}
+	bot.runSteps([]step{{callback: cbShelfOpenPrefix + shelfID}})
+	if buttons := api.callbackData(); len(buttons) == 0 || !strings.HasSuffix(buttons[0], "="+cbBookPrefix+"1") {
+		t.Fatalf("shelf page: %v", buttons)
+	}
+
+	// Повторное нажатие снимает книгу с полки.
+	bot.runSteps([]step{{callback: toggle}})
+	if books, _ := store.ShelfBooks(ctx, testUserID, shelf.ID); len(books) != 0 {
+		t.Fatalf("book left on shelf: %+v", books)
+	}
+}
+
+func TestFindInLibrary(t *testing.T) {
+	bot, api, store := newTestBot(t)
+	ctx := context.Background()
+
+	bot.runSteps([]step{{text: "/find оруэлл"}})
+	if texts := api.texts(); !strings.Contains(texts[len(texts)-1], "ничего не нашлось") {
+		t.Fatalf("empty library: %q", texts[len(texts)-1])
+	}
+
+	bot.runSteps([]step{{text: "1984"}, {callback: cbDownloadPrefix + "1:fb2"}})
+	items, err := store.ListLibrary(ctx, testUserID)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("library: %+v, %v", items, err)
+	}
+	if err := store.SetBookText(ctx, items[0].FileID, "Война — это мир. Свобода — это рабство."); err != nil {
+		t.Fatal(err)
+	}
+
+	bot.runSteps([]step{{text: "/find свобод"}})
+	texts := api.texts()
+	want := "🔎 В библиотеке по запросу «свобод»:\n\n1. <b>1984</b> — Джордж Оруэлл\n   📖 Война — это мир. <b>Свобода</b> — это рабство.\n"
+	if got := texts[len(texts)-1]; got != want {
+		t.Fatalf("find:\n%q", got)
+	}
+	if got := api.callbackData(); len(got) != 1 || got[0] != "1. 1984="+cbBookPrefix+"1" {
+		t.Fatalf("buttons: %v", got)
+	}
+}
+
+func TestBookCaptionFitsTelegramLimit(t *testing.T) {
+	details := models.BookDetails{Title: "1984", Author: "Джордж Оруэлл", Annotation: strings.Repeat("Антиутопия 📖. ", 200)}
+
+	caption, truncated := bookCaption(details)
+	if !truncated || utf16Len(caption) > maxCaptionLength || !strings.HasPrefix(caption, "📖 1984\n✍️ Джордж Оруэлл\n\nАнтиутопия") {
+		t.Fatalf("long annotation: truncated=%v, len=%d\n%s", truncated, utf16Len(caption), caption)
+	}
+
+	// Сборники с сотнями авторов и жанров: шапка сама длиннее лимита.
+	var authors []string
+	for i := 0; i < 200; i++ {
+		authors = append(authors, fmt.Sprintf("Автор 𝔄%d", i))
+	}
+	details.Author = strings.Join(authors, ", ")
+	details.Genres = []string{strings.Repeat("Жанр ", 100)}
+
+	caption, truncated = bookCaption(details)
+	if !truncated || utf16Len(caption) > maxCaptionLength || !strings.HasSuffix(caption, "…") {
+		t.Fatalf("long header: truncated=%v, len=%d", truncated, utf16Len(caption))
+	}
+
+	details.Annotation = ""
+	if caption, truncated = bookCaption(details); !truncated || utf16Len(caption) > maxCaptionLength {
+		t.Fatalf("long header without annotation: truncated=%v, len=%d", truncated, utf16Len(caption))
+	}
+
+	details = models.BookDetails{Title: "1984", Author: "Джордж Оруэлл", Annotation: "Антиутопия."}
+	if caption, truncated = bookCaption(details); truncated || caption != "📖 1984\n✍️ Джордж Оруэлл\n\nАнтиутопия." {
+		t.Fatalf("short card: truncated=%v\n%s", truncated, caption)
+	}
+}
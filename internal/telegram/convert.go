@@ -0,0 +1,105 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"tor_project/internal/db"
+	"tor_project/internal/epub"
+	"tor_project/internal/fb2"
+	"tor_project/internal/models"
+	"tor_project/internal/storage"
+)
+
+// fb2EPUBFormat — «виртуальный» формат: EPUB, собранный на сервере из FB2, когда сайт EPUB
+// не отдаёт. Готовая конвертация хранится в book_files отдельной строкой с этим форматом.
+const fb2EPUBFormat = "fb2-epub"
+
+// errConversion — FB2 скачан, но собрать из него EPUB не получилось; повтор не поможет.
+var errConversion = errors.New("ошибка конвертации в epub")
+
+// formatLabel — формат для сообщений пользователю.
+func formatLabel(format string) string {
+	if format == fb2EPUBFormat {
+		return "EPUB из FB2"
+	}
+	return strings.ToUpper(format)
+}
+
+// offersEPUBConversion — есть FB2, но нет EPUB: тогда в клавиатуре появляется кнопка конвертации.
+func offersEPUBConversion(formats []models.BookFormatOption) bool {
+	hasFB2 := false
+	for _, f := range formats {
+		switch strings.ToLower(strings.TrimSpace(f.Path)) {
+		case "epub":
+			return false
+		case "fb2", "fb2.zip":
+			hasFB2 = true
+		}
+	}
+	return hasFB2
+}
+
+// convertToEPUB собирает EPUB из FB2 книги (скачивая FB2, если его ещё нет) и записывает результат
+// в book_files с форматом fb2EPUBFormat.
+func (b *Bot) convertToEPUB(ctx context.Context, job db.DownloadJob, requests []db.DownloadRequest) (db.BookFile, error) {
+	source, ok := b.storedFile(ctx, job.SourceID, "fb2")
+	if !ok {
+		var err error
+		if source, err = b.downloadFile(ctx, job, "fb2", requests); err != nil {
+			return db.BookFile{}, err
+		}
+	}
+
+	for _, req := range requests {
+		b.editStatus(req, "🔄 Конвертирую в EPUB "+jobLabel(req, job)+"...", true)
+	}
+
+	body, err := b.blobs.Get(ctx, source.Path)
+	if err != nil {
+		return db.BookFile{}, err
+	}
+	book, err := fb2.Parse(body)
+	body.Close()
+	if err != nil {
+		return db.BookFile{}, fmt.Errorf("%w: %v", errConversion, err)
+	}
+
+	var out bytes.Buffer
+	if err := epub.Convert(&out, book, epub.Options{Identifier: "urn:flibusta:" + job.SourceID}); err != nil {
+		return db.BookFile{}, fmt.Errorf("%w: %v", errConversion, err)
+	}
+	saved, err := storage.SaveBookFile(ctx, b.blobs, &out, maxFileSize)
+	if err != nil {
+		return db.BookFile{}, err
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	file := db.BookFile{
+		Path:      saved.RelativePath,
+		Format:    fb2EPUBFormat,
+		SizeBytes: saved.SizeBytes,
+		SHA256:    saved.SHA256,
+		MimeType:  saved.MimeType,
+	}
+
+	title, author := book.Title, book.AuthorNames()
+	for _, req := range requests {
+		if title == "" {
+			title = req.Title
+		}
+		if author == "" {
+			author = req.Author
+		}
+	}
+	if bookDBID, err := b.store.EnsureBook(ctx, job.SourceID, title, author); err != nil {
+		log.Printf("EnsureBook error: %v", err)
+	} else if file.ID, err = b.store.InsertBookFile(ctx, bookDBID, file); err != nil {
+		log.Printf("InsertBookFile error: %v", err)
+	}
+	return file, nil
+}
@@ -0,0 +1,707 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"tor_project/internal/db"
+	"tor_project/internal/fb2"
+	"tor_project/internal/models"
+	"tor_project/internal/service"
+	"tor_project/internal/storage"
+)
+
+// DownloadQueueConfig — настройки очереди скачиваний.
+type DownloadQueueConfig struct {
+	// Workers — сколько книг скачивается одновременно.
+	Workers int
+	// MaxAttempts — сколько раз пробовать скачать книгу, прежде чем сдаться.
+	MaxAttempts int
+	// RetryDelay — пауза перед второй попыткой; дальше она удваивается, но не больше MaxRetryDelay.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// UserQuota — сколько байт могут занимать книги в библиотеке одного пользователя (0 — без ограничения).
+	UserQuota int64
+}
+
+var defaultDownloadQueue = DownloadQueueConfig{
+	Workers:       2,
+	MaxAttempts:   5,
+	RetryDelay:    30 * time.Second,
+	MaxRetryDelay: 10 * time.Minute,
+}
+
+const (
+	// Telegram лимит на отправку файлов ботом.
+	maxFileSize = 50 * 1024 * 1024 // 50MB
+
+	// progressInterval — как часто обновлять статус во время скачивания (Telegram ограничивает частоту правок).
+	progressInterval = 3 * time.Second
+	// downloadPollInterval — как часто воркеры проверяют очередь без явного сигнала (для отложенных повторов).
+	downloadPollInterval = 5 * time.Second
+)
+
+// SetDownloadQueue задаёт параметры очереди скачиваний (до вызова Start).
+func (b *Bot) SetDownloadQueue(cfg DownloadQueueConfig) {
+	b.downloads = cfg
+}
+
+// downloadAndSend ставит книгу в очередь скачивания и отправляет статусное сообщение,
+// которое дальше редактирует воркер. Если ту же книгу в том же формате уже кто-то качает,
+// запрос присоединяется к этому заданию.
+func (b *Bot) downloadAndSend(ctx context.Context, chatID int64, userID int64, username string, bookID string, formatPath string) {
+	req := db.DownloadRequest{UserID: userID, Username: username, ChatID: chatID}
+	if book, ok := b.findBookInSession(chatID, bookID); ok {
+		req.Title = book.Title
+		req.Author = book.Author
+	}
+
+	// Книгу уже скачивали: отдаём файл с диска, без Tor и без очереди. Книгу из своей
+	// библиотеки можно получить и при заполненной квоте.
+	file, stored := b.storedFile(ctx, bookID, formatPath)
+	if !stored || !b.inLibrary(ctx, userID, file.ID) {
+		// Размер ещё не скачанной книги неизвестен: её пропускаем, пока квота не заполнена.
+		if b.quotaExceeded(ctx, chatID, userID, file.SizeBytes+file.ArchiveSizeBytes) {
+			return
+		}
+	}
+	if stored {
+		b.sendStoredFile(ctx, req, file)
+		return
+	}
+
+	job, requestID, joined, err := b.store.EnqueueDownload(ctx, bookID, formatPath, req)
+	if err != nil {
+		b.sendFailure(ctx, chatID, "❌ Не удалось поставить книгу в очередь.")
+		log.Printf("EnqueueDownload error: %v", err)
+		return
+	}
+
+	text := "🕓 В очереди: " + jobLabel(req, job)
+	if joined {
+		text = "🕓 Эту книгу уже скачивают, пришлю, как только будет готово: " + jobLabel(req, job)
+	}
+	msg, err := b.sendCancellable(chatID, text)
+	if err == nil && msg.MessageID != 0 {
+		if err := b.store.SetDownloadRequestMessage(ctx, requestID, msg.MessageID); err != nil {
+			log.Printf("SetDownloadRequestMessage error: %v", err)
+		}
+	}
+
+	b.wakeDownloads()
+}
+
+// quotaExceeded сообщает пользователю, что книга размером incoming не помещается в его
+// библиотеку. Ошибки подсчёта скачивание не блокируют.
+func (b *Bot) quotaExceeded(ctx context.Context, chatID int64, userID int64, incoming int64) bool {
+	if b.downloads.UserQuota <= 0 {
+		return false
+	}
+	used, err := b.store.UserStorageUsage(ctx, userID)
+	if err != nil {
+		log.Printf("UserStorageUsage error: %v", err)
+		return false
+	}
+	if used >= b.downloads.UserQuota {
+		b.sendMessage(chatID, fmt.Sprintf("📦 Библиотека заполнена: %s из %s. Новые книги добавить нельзя.",
+			formatBytes(used), formatBytes(b.downloads.UserQuota)))
+		return true
+	}
+	if used+incoming > b.downloads.UserQuota {
+		b.sendMessage(chatID, fmt.Sprintf("📦 Книга (%s) не помещается в библиотеку: занято %s из %s.",
+			formatBytes(incoming), formatBytes(used), formatBytes(b.downloads.UserQuota)))
+		return true
+	}
+	return false
+}
+
+// fitsQuota — помещается ли ещё incoming байт в библиотеку пользователя. Ошибки подсчёта
+// книгу не задерживают.
+func (b *Bot) fitsQuota(ctx context.Context, userID int64, incoming int64) bool {
+	if b.downloads.UserQuota <= 0 {
+		return true
+	}
+	used, err := b.store.UserStorageUsage(ctx, userID)
+	if err != nil {
+		log.Printf("UserStorageUsage error: %v", err)
+		return true
+	}
+	return used+incoming <= b.downloads.UserQuota
+}
+
+// inLibrary — есть ли файл в библиотеке пользователя. При ошибке считаем, что нет.
+func (b *Bot) inLibrary(ctx context.Context, userID int64, fileID int64) bool {
+	if fileID == 0 {
+		return false
+	}
+	ok, err := b.store.InLibrary(ctx, userID, fileID)
+	if err != nil {
+		log.Printf("InLibrary error: %v", err)
+	}
+	return ok
+}
+
+func (b *Bot) wakeDownloads() {
+	select {
+	case b.downloadWake <- struct{}{}:
+	default:
+	}
+}
+
+// downloadWorkers — фоновые воркеры очереди скачиваний.
+type downloadWorkers struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startDownloadWorkers запускает воркеры; после close(stop) они доделывают текущее задание и выходят.
+func (b *Bot) startDownloadWorkers(ctx context.Context) *downloadWorkers {
+	if n, err := b.store.ResetStaleDownloadJobs(ctx); err != nil {
+		log.Printf("ResetStaleDownloadJobs error: %v", err)
+	} else if n > 0 {
+		log.Printf("Очередь скачиваний: возвращено прерванных заданий: %d", n)
+	}
+
+	w := &downloadWorkers{stop: make(chan struct{}), done: make(chan struct{})}
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(b.downloads.Workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-w.stop:
+					return
+				default:
+				}
+
+				if b.processNextDownload(ctx) {
+					continue
+				}
+
+				select {
+				case <-w.stop:
+					return
+				case <-b.downloadWake:
+				case <-time.After(downloadPollInterval):
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(w.done)
+	}()
+	return w
+}
+
+// processNextDownload берёт из очереди одно готовое задание и выполняет его; false — очередь пуста.
+func (b *Bot) processNextDownload(ctx context.Context) bool {
+	job, ok, err := b.store.ClaimDownloadJob(ctx, time.Now())
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("ClaimDownloadJob error: %v", err)
+		}
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	b.runDownloadJob(ctx, job)
+	return true
+}
+
+func (b *Bot) runDownloadJob(ctx context.Context, job db.DownloadJob) {
+	jobCtx, cancel := context.WithTimeout(ctx, downloadTimeout)
+	b.opsMu.Lock()
+	b.jobs[job.ID] = cancel
+	b.opsMu.Unlock()
+	defer func() {
+		cancel()
+		b.opsMu.Lock()
+		delete(b.jobs, job.ID)
+		b.opsMu.Unlock()
+	}()
+
+	// Учёт в БД и финальные статусы пишем и после отмены jobCtx.
+	bg := context.WithoutCancel(ctx)
+
+	err := b.fetchJob(jobCtx, job)
+	if err == nil {
+		return
+	}
+
+	if status, statusErr := b.store.GetDownloadJobStatus(bg, job.ID); statusErr == nil && status == db.DownloadCanceled {
+		// Отменили все, кто ждал; сообщения уже обновлены при отмене.
+		return
+	}
+
+	requests, reqErr := b.store.DownloadRequests(bg, job.ID)
+	if reqErr != nil {
+		log.Printf("DownloadRequests error: %v", reqErr)
+	}
+
+	if ctx.Err() != nil {
+		// Бот останавливается: задание доделаем после перезапуска.
+		if err := b.store.RequeueDownloadJob(bg, job.ID); err != nil {
+			log.Printf("RequeueDownloadJob error: %v", err)
+		}
+		for _, req := range requests {
+			b.editStatus(req, "🕓 Бот перезапускается, скачивание продолжится после перезапуска: "+jobLabel(req, job), true)
+		}
+		return
+	}
+
+	log.Printf("Download job %d (%s/%s) attempt %d error: %v", job.ID, job.SourceID, job.Format, job.Attempts, err)
+
+	if errors.Is(err, storage.ErrTooLarge) {
+		b.failJob(bg, job, requests, err, "❌ Файл слишком большой. Максимальный размер: 50 MB.")
+		return
+	}
+	if errors.Is(err, errConversion) {
+		b.failJob(bg, job, requests, err, "❌ Не удалось сконвертировать книгу в EPUB. Попробуйте скачать FB2.")
+		return
+	}
+	if !service.Retryable(err) || job.Attempts >= b.downloads.MaxAttempts {
+		b.failJob(bg, job, requests, err, "❌ Не удалось скачать файл. Возможно, ссылка устарела или Tor тупит.")
+		return
+	}
+
+	delay := retryDelay(b.downloads, job.Attempts)
+	if err := b.store.RetryDownloadJob(bg, job.ID, time.Now().Add(delay), err.Error()); err != nil {
+		log.Printf("RetryDownloadJob error: %v", err)
+	}
+	for _, req := range requests {
+		b.editStatus(req, fmt.Sprintf("🔁 Не получилось скачать %s, повторю через %s (попытка %d из %d).",
+			jobLabel(req, job), delay, job.Attempts+1, b.downloads.MaxAttempts), true)
+	}
+}
+
+func (b *Bot) failJob(ctx context.Context, job db.DownloadJob, requests []db.DownloadRequest, cause error, text string) {
+	if err := b.store.SetDownloadJobStatus(ctx, job.ID, db.DownloadFailed, cause.Error()); err != nil {
+		log.Printf("SetDownloadJobStatus error: %v", err)
+	}
+	for _, req := range requests {
+		b.editStatus(req, text, false)
+	}
+}
+
+// fetchJob скачивает файл, сохраняет его и рассылает всем, кто ждёт задание.
+func (b *Bot) fetchJob(ctx context.Context, job db.DownloadJob) error {
+	requests, err := b.store.DownloadRequests(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+	if len(requests) == 0 {
+		return b.store.SetDownloadJobStatus(ctx, job.ID, db.DownloadCanceled, "")
+	}
+
+	file, err := b.obtainFile(ctx, job, requests)
+	if err != nil {
+		return err
+	}
+
+	// Дальше задание не отменяется: файл уже скачан, осталось разослать.
+	ctx = context.WithoutCancel(ctx)
+	if err := b.store.SetDownloadJobStatus(ctx, job.ID, db.DownloadUploading, ""); err != nil {
+		log.Printf("SetDownloadJobStatus error: %v", err)
+	}
+
+	// Рассылаем файл. Пока задание в статусе uploading, к нему могут присоединиться новые
+	// запросы, поэтому после перевода в done перечитываем список и досылаем оставшимся.
+	sent := make(map[int64]bool)
+	deliver := func(requests []db.DownloadRequest) {
+		for _, req := range requests {
+			if sent[req.ID] {
+				continue
+			}
+			sent[req.ID] = true
+			b.deliver(ctx, req, job, file)
+		}
+	}
+
+	deliver(requests)
+	if err := b.store.SetDownloadJobStatus(ctx, job.ID, db.DownloadDone, ""); err != nil {
+		log.Printf("SetDownloadJobStatus error: %v", err)
+	}
+	if late, err := b.store.DownloadRequests(ctx, job.ID); err != nil {
+		log.Printf("DownloadRequests error: %v", err)
+	} else {
+		deliver(late)
+	}
+	return nil
+}
+
+// obtainFile возвращает файл задания: уже лежащий в хранилище (его могло скачать другое задание,
+// пока это ждало очереди) или скачанный заново. file.ID — строка book_files (0, если БД не записалась).
+func (b *Bot) obtainFile(ctx context.Context, job db.DownloadJob, requests []db.DownloadRequest) (db.BookFile, error) {
+	if file, ok := b.storedFile(ctx, job.SourceID, job.Format); ok {
+		return file, nil
+	}
+	if job.Format == fb2EPUBFormat {
+		return b.convertToEPUB(ctx, job, requests)
+	}
+	return b.downloadFile(ctx, job, job.Format, requests)
+}
+
+// downloadFile скачивает книгу в формате format, сохраняет файл и записывает его в book_files.
+func (b *Bot) downloadFile(ctx context.Context, job db.DownloadJob, format string, requests []db.DownloadRequest) (db.BookFile, error) {
+	for _, req := range requests {
+		b.editStatus(req, "⬇️ Скачиваю "+jobLabel(req, job)+"...", true)
+	}
+
+	// 1. Качаем файл (получаем поток stream)
+	stream, err := b.downloader.Download(ctx, job.SourceID, format)
+	if err != nil {
+		return db.BookFile{}, err
+	}
+	// Обязательно закрываем поток после чтения!
+	defer stream.Body.Close()
+
+	progress := &progressReader{
+		r:     stream.Body,
+		total: stream.Size,
+		report: func(read, total int64) {
+			for _, req := range requests {
+				b.editStatus(req, "⬇️ Скачиваю "+jobLabel(req, job)+": "+progressText(read, total), true)
+			}
+		},
+	}
+
+	// 2. Сохраняем файл в хранилище (Telegram лимит ~50MB)
+	saved, err := storage.SaveBookFile(ctx, b.blobs, progress, maxFileSize)
+	if err != nil {
+		return db.BookFile{}, err
+	}
+
+	// 3. Сохраняем метаданные в БД (файл уже в хранилище, поэтому не зависим от отмены)
+	ctx = context.WithoutCancel(ctx)
+	title, author := "", ""
+	for _, req := range requests {
+		if title == "" {
+			title, author = req.Title, req.Author
+		}
+	}
+	file := db.BookFile{
+		Path:      saved.RelativePath,
+		Format:    format,
+		SizeBytes: saved.SizeBytes,
+		SHA256:    saved.SHA256,
+		MimeType:  saved.MimeType,
+	}
+
+	// Флибуста часто отдаёт fb2.zip: в библиотеку кладём саму книгу, чтобы её мог открыть
+	// Mini App, а архив оставляем для отправки в Telegram (он меньше).
+	if book, err := storage.UnpackBook(ctx, b.blobs, saved.RelativePath, storage.MaxUnpackedSize); err == nil {
+		file.ArchivePath, file.ArchiveSizeBytes = file.Path, file.SizeBytes
+		file.Path, file.SizeBytes, file.SHA256, file.MimeType = book.RelativePath, book.SizeBytes, book.SHA256, book.MimeType
+	} else if !errors.Is(err, storage.ErrNotArchive) {
+		log.Printf("UnpackBook %s error: %v", saved.RelativePath, err)
+	}
+
+	// Название и автор из поисковой выдачи нужны, только если книги ещё нет в БД:
+	// карточку с сайта они не затирают. Описание внутри самой книги (FB2) важнее.
+	bookDBID, err := b.store.EnsureBook(ctx, job.SourceID, title, author)
+	if err != nil {
+		log.Printf("EnsureBook error: %v", err)
+		return file, nil
+	}
+	if meta, ok := b.fileMetadata(ctx, file); ok {
+		if err := b.store.SaveFileMetadata(ctx, bookDBID, meta); err != nil {
+			log.Printf("SaveFileMetadata error: %v", err)
+		}
+	}
+	if file.ID, err = b.store.InsertBookFile(ctx, bookDBID, file); err != nil {
+		log.Printf("InsertBookFile error: %v", err)
+	}
+	return file, nil
+}
+
+// fileMetadata читает описание книги из скачанного FB2. Для других форматов и битых файлов
+// возвращает false — тогда в карточке остаются данные из поиска.
+func (b *Bot) fileMetadata(ctx context.Context, file db.BookFile) (models.BookDetails, bool) {
+	if file.MimeType != "application/x-fictionbook+xml" {
+		return models.BookDetails{}, false
+	}
+	body, err := b.blobs.Get(ctx, file.Path)
+	if err != nil {
+		log.Printf("fb2 %s: %v", file.Path, err)
+		return models.BookDetails{}, false
+	}
+	defer body.Close()
+
+	book, err := fb2.Parse(body)
+	if err != nil {
+		log.Printf("fb2 %s: %v", file.Path, err)
+		return models.BookDetails{}, false
+	}
+	details := models.BookDetails{
+		Title:      book.Title,
+		Author:     book.AuthorNames(),
+		Annotation: book.Annotation,
+		Genres:     book.Genres,
+		Year:       book.Year,
+		Language:   book.Lang,
+		Translator: book.TranslatorNames(),
+	}
+	if len(book.Sequences) > 0 {
+		details.Series, details.SeriesNumber = book.Sequences[0].Name, book.Sequences[0].Number
+	}
+	return details, true
+}
+
+// storedFile ищет уже скачанный файл книги, который всё ещё лежит в хранилище.
+func (b *Bot) storedFile(ctx context.Context, sourceID string, format string) (db.BookFile, bool) {
+	files, err := b.store.FindBookFiles(ctx, sourceID, format)
+	if err != nil {
+		log.Printf("FindBookFiles error: %v", err)
+		return db.BookFile{}, false
+	}
+
+	for _, file := range files {
+		// Полный хэш здесь не считаем (это делает проверка хранилища), но размер обязан совпасть.
+		info, err := b.blobs.Stat(ctx, file.Path)
+		if err != nil || info.Size == 0 {
+			log.Printf("Файл %s (book_files.id=%d) пропал из хранилища, пропускаю", file.Path, file.ID)
+			continue
+		}
+		if file.SizeBytes > 0 && info.Size != file.SizeBytes {
+			log.Printf("Файл %s (book_files.id=%d) повреждён: %d байт вместо %d, пропускаю", file.Path, file.ID, info.Size, file.SizeBytes)
+			continue
+		}
+		return file, true
+	}
+	return db.BookFile{}, false
+}
+
+// sendStoredFile сразу отдаёт уже скачанный файл: добавляет его в библиотеку и отправляет в чат.
+func (b *Bot) sendStoredFile(ctx context.Context, req db.DownloadRequest, file db.BookFile) {
+	if err := b.store.EnsureUser(ctx, req.UserID, req.Username); err != nil {
+		log.Printf("EnsureUser error: %v", err)
+	} else if err := b.store.AddToLibrary(ctx, req.UserID, file.ID); err != nil {
+		log.Printf("AddToLibrary error: %v", err)
+	}
+
+	if err := b.sendBookFile(ctx, req.ChatID, file); err != nil {
+		b.sendMessage(req.ChatID, fmt.Sprintf("❌ Ошибка при отправке файла в Telegram: %v", err))
+		log.Printf("Send file error: %v", err)
+	}
+}
+
+// deliver добавляет файл в библиотеку пользователя и отправляет его в чат.
+func (b *Bot) deliver(ctx context.Context, req db.DownloadRequest, job db.DownloadJob, file db.BookFile) {
+	b.editStatus(req, "📤 Отправляю "+jobLabel(req, job)+"...", false)
+
+	// Пока книга стояла в очереди, библиотека могла заполниться, а размер книги стал известен
+	// только сейчас: файл всё равно отправляем, но в библиотеку не кладём.
+	kept := true
+	if file.ID != 0 {
+		if err := b.store.EnsureUser(ctx, req.UserID, req.Username); err != nil {
+			log.Printf("EnsureUser error: %v", err)
+		} else if !b.inLibrary(ctx, req.UserID, file.ID) && !b.fitsQuota(ctx, req.UserID, file.SizeBytes+file.ArchiveSizeBytes) {
+			kept = false
+		} else if err := b.store.AddToLibrary(ctx, req.UserID, file.ID); err != nil {
+			log.Printf("AddToLibrary error: %v", err)
+		}
+	}
+
+	if err := b.sendBookFile(ctx, req.ChatID, file); err != nil {
+		b.editStatus(req, fmt.Sprintf("❌ Ошибка при отправке файла в Telegram: %v", err), false)
+		log.Printf("Send file error: %v", err)
+		return
+	}
+	if !kept {
+		b.editStatus(req, fmt.Sprintf("✅ Готово: %s\n📦 В библиотеку книга не добавлена: она не помещается в %s.",
+			jobLabel(req, job), formatBytes(b.downloads.UserQuota)), false)
+		return
+	}
+	b.editStatus(req, "✅ Готово: "+jobLabel(req, job), false)
+}
+
+// sendBookFile отправляет файл книги (с кнопкой Mini App, если она настроена).
+// Если файл уже отправлялся, Telegram получает сохранённый file_id вместо загрузки байтов;
+// если file_id не принят, файл загружается из хранилища заново и file_id обновляется.
+func (b *Bot) sendBookFile(ctx context.Context, chatID int64, file db.BookFile) error {
+	fileID := file.ID
+	if fileID != 0 {
+		if err := b.store.TouchBookFile(ctx, fileID, time.Now()); err != nil {
+			log.Printf("TouchBookFile error: %v", err)
+		}
+
+		telegramFileID, err := b.store.GetTelegramFileID(ctx, fileID)
+		if err != nil {
+			log.Printf("GetTelegramFileID error: %v", err)
+		}
+		if telegramFileID != "" {
+			_, err := b.bot.Send(b.bookDocument(chatID, tgbotapi.FileID(telegramFileID)))
+			if err == nil {
+				return nil
+			}
+			log.Printf("Telegram не принял file_id файла %d, загружаю заново: %v", fileID, err)
+		}
+	}
+
+	// Книгу из архива отправляем архивом, как её отдал сайт; если архив пропал — саму книгу.
+	key := file.Path
+	if file.ArchivePath != "" {
+		if _, err := b.blobs.Stat(ctx, file.ArchivePath); err == nil {
+			key = file.ArchivePath
+		}
+	}
+	body, err := b.blobs.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	msg, err := b.bot.Send(b.bookDocument(chatID, tgbotapi.FileReader{Name: path.Base(key), Reader: body}))
+	if err != nil {
+		return err
+	}
+
+	if fileID != 0 && msg.Document != nil && msg.Document.FileID != "" {
+		if err := b.store.SetTelegramFileID(ctx, fileID, msg.Document.FileID); err != nil {
+			log.Printf("SetTelegramFileID error: %v", err)
+		}
+	}
+	return nil
+}
+
+// bookDocument собирает сообщение с файлом книги.
+func (b *Bot) bookDocument(chatID int64, file tgbotapi.RequestFileData) tgbotapi.DocumentConfig {
+	// Создаем документ
+	docMsg := tgbotapi.NewDocument(chatID, file)
+	docMsg.Caption = "📖 Ваша книга. Приятного чтения!"
+
+	if b.miniAppURL != "" {
+		type webAppInfo struct {
+			URL string `json:"url"`
+		}
+		type inlineKeyboardButton struct {
+			Text   string      `json:"text"`
+			WebApp *webAppInfo `json:"web_app,omitempty"`
+		}
+		type inlineKeyboardMarkup struct {
+			InlineKeyboard [][]inlineKeyboardButton `json:"inline_keyboard"`
+		}
+
+		docMsg.ReplyMarkup = inlineKeyboardMarkup{
+			InlineKeyboard: [][]inlineKeyboardButton{
+				{
+					{Text: "Читать онлайн", WebApp: &webAppInfo{URL: b.miniAppURL}},
+				},
+			},
+		}
+	}
+
+	return docMsg
+}
+
+// cancelDownloads снимает запросы чата с очереди и останавливает задания, которые больше никто не ждёт.
+func (b *Bot) cancelDownloads(chatID int64) int {
+	ctx := context.Background()
+	removed, canceledJobs, err := b.store.CancelDownloadRequests(ctx, chatID)
+	if err != nil {
+		log.Printf("CancelDownloadRequests error: %v", err)
+		return 0
+	}
+
+	b.opsMu.Lock()
+	for _, id := range canceledJobs {
+		if cancel, ok := b.jobs[id]; ok {
+			cancel()
+		}
+	}
+	b.opsMu.Unlock()
+
+	for _, req := range removed {
+		b.editStatus(req, "🚫 Скачивание отменено.", false)
+	}
+	return len(removed)
+}
+
+// editStatus переписывает статусное сообщение запроса; cancellable оставляет кнопку "Отмена".
+func (b *Bot) editStatus(req db.DownloadRequest, text string, cancellable bool) {
+	if req.MessageID == 0 {
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(req.ChatID, req.MessageID, text)
+	if cancellable {
+		markup := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("✖️ Отмена", cbCancel)),
+		)
+		edit.ReplyMarkup = &markup
+	}
+	if _, err := b.bot.Send(edit); err != nil {
+		log.Printf("Edit status error: %v", err)
+	}
+}
+
+// jobLabel — «Название» (FORMAT) для статусных сообщений.
+func jobLabel(req db.DownloadRequest, job db.DownloadJob) string {
+	title := req.Title
+	if title == "" {
+		title = "книга " + job.SourceID
+	}
+	return fmt.Sprintf("«%s» (%s)", title, formatLabel(job.Format))
+}
+
+// retryDelay — экспоненциальная пауза перед следующей попыткой.
+func retryDelay(cfg DownloadQueueConfig, attempts int) time.Duration {
+	delay := cfg.RetryDelay
+	for i := 1; i < attempts && delay < cfg.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, cfg.MaxRetryDelay)
+}
+
+func progressText(read, total int64) string {
+	if total > 0 {
+		return fmt.Sprintf("%s из %s (%d%%)", formatBytes(read), formatBytes(total), read*100/total)
+	}
+	return formatBytes(read)
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	case n >= 1024:
+		return fmt.Sprintf("%.0f KB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+// progressReader считает прочитанные байты и не чаще progressInterval сообщает о прогрессе.
+type progressReader struct {
+	r      io.Reader
+	total  int64
+	read   int64
+	last   time.Time
+	report func(read, total int64)
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.r.Read(buf)
+	p.read += int64(n)
+
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		if !p.last.IsZero() {
+			p.report(p.read, p.total)
+		}
+		p.last = now
+	}
+	return n, err
+}
@@ -0,0 +1,100 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"tor_project/internal/db"
+)
+
+const maxFindResults = 10
+
+var findMarks = strings.NewReplacer(db.SnippetOpen, "<b>", db.SnippetClose, "</b>")
+
+// handleFind — /find <запрос>: поиск только по своей библиотеке — по названиям, авторам,
+// аннотациям и, если тексты книг проиндексированы, по самим книгам. Tor не нужен.
+func (b *Bot) handleFind(ctx context.Context, chatID int64, userID int64, query string) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		b.sendMessage(chatID, "🔎 Напиши, что найти в своей библиотеке, например: /find Оруэлл")
+		return
+	}
+	if b.store == nil {
+		b.sendMessage(chatID, "⚠️ Библиотека недоступна.")
+		return
+	}
+
+	hits, err := b.store.SearchLibrary(ctx, userID, query, maxFindResults)
+	if err != nil {
+		b.sendMessage(chatID, "❌ Ошибка поиска по библиотеке.")
+		log.Printf("SearchLibrary error: %v", err)
+		return
+	}
+	if len(hits) == 0 {
+		b.sendMessage(chatID, "😔 В твоей библиотеке ничего не нашлось.")
+		return
+	}
+
+	text, rows := findResults(query, hits)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	if len(rows) > 0 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+	b.bot.Send(msg)
+}
+
+// findResults собирает ответ /find (HTML, найденные слова жирным) и кнопки карточек книг.
+// Разные файлы одной книги показываются одной строкой.
+func findResults(query string, hits []db.SearchHit) (string, [][]tgbotapi.InlineKeyboardButton) {
+	var sb strings.Builder
+	var rows [][]tgbotapi.InlineKeyboardButton
+	sb.WriteString("🔎 В библиотеке по запросу «" + html.EscapeString(query) + "»:\n")
+
+	seen := make(map[int64]bool)
+	n := 0
+	for _, hit := range hits {
+		if seen[hit.BookID] {
+			continue
+		}
+		seen[hit.BookID] = true
+		n++
+
+		title := strings.TrimSpace(hit.Title)
+		if title == "" {
+			title = "Без названия"
+		}
+		var item strings.Builder
+		item.WriteString(fmt.Sprintf("\n%d. <b>%s</b>", n, html.EscapeString(title)))
+		if author := strings.TrimSpace(hit.Author); author != "" {
+			item.WriteString(" — " + html.EscapeString(author))
+		}
+		item.WriteString("\n")
+		// Сниппет названия повторял бы строку выше, поэтому из описания показываем только аннотацию.
+		if plain := stripMarks(hit.Snippet); plain != "" && plain != hit.Title && plain != hit.Author {
+			item.WriteString("   " + findMarks.Replace(html.EscapeString(hit.Snippet)) + "\n")
+		}
+		if hit.TextSnippet != "" {
+			item.WriteString("   📖 " + findMarks.Replace(html.EscapeString(hit.TextSnippet)) + "\n")
+		}
+		if utf16Len(sb.String())+utf16Len(item.String()) > maxMessageLength {
+			break
+		}
+		sb.WriteString(item.String())
+
+		if hit.SourceID != "" {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d. %s", n, title), cbBookPrefix+hit.SourceID),
+			))
+		}
+	}
+	return sb.String(), rows
+}
+
+func stripMarks(snippet string) string {
+	return strings.NewReplacer(db.SnippetOpen, "", db.SnippetClose, "").Replace(snippet)
+}
@@ -0,0 +1,210 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"tor_project/internal/db"
+	"tor_project/internal/reader"
+)
+
+const (
+	cbHighlightsPrefix  = "hl:"
+	maxHighlightButtons = 20
+)
+
+// handleHighlights — /highlights [название]: экспорт выделений книги в Markdown. Без названия
+// (или если под него подходит несколько книг) показывает книги с выделениями кнопками.
+func (b *Bot) handleHighlights(ctx context.Context, chatID int64, userID int64, query string) {
+	if b.store == nil {
+		b.sendMessage(chatID, "⚠️ Библиотека недоступна.")
+		return
+	}
+	books, err := b.store.AnnotatedBooks(ctx, userID)
+	if err != nil {
+		b.sendMessage(chatID, "❌ Не удалось загрузить выделения.")
+		log.Printf("AnnotatedBooks error: %v", err)
+		return
+	}
+	if len(books) == 0 {
+		b.sendMessage(chatID, "🖍 Выделений пока нет. Отмечай фрагменты в читалке — и их можно будет выгрузить сюда.")
+		return
+	}
+
+	if query = strings.ToLower(strings.TrimSpace(query)); query != "" {
+		var matched []db.AnnotatedBook
+		for _, book := range books {
+			if strings.Contains(strings.ToLower(book.Title+" "+book.Author), query) {
+				matched = append(matched, book)
+			}
+		}
+		if len(matched) == 0 {
+			b.sendMessage(chatID, "😔 Среди книг с выделениями такой нет.")
+			return
+		}
+		books = matched
+	}
+	if len(books) == 1 {
+		b.sendHighlights(ctx, chatID, userID, books[0])
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, book := range books {
+		if i >= maxHighlightButtons {
+			break
+		}
+		text := fmt.Sprintf("%s (%d)", book.Title, book.Highlights)
+		if book.Author != "" {
+			text = fmt.Sprintf("%s — %s (%d)", book.Title, book.Author, book.Highlights)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(text, cbHighlightsPrefix+strconv.FormatInt(book.FileID, 10)),
+		))
+	}
+	msg := tgbotapi.NewMessage(chatID, "🖍 Выделения из какой книги выгрузить?")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.bot.Send(msg)
+}
+
+// sendHighlightsByID — кнопка с книгой из списка /highlights.
+func (b *Bot) sendHighlightsByID(ctx context.Context, chatID int64, userID int64, fileID int64) {
+	books, err := b.store.AnnotatedBooks(ctx, userID)
+	if err != nil {
+		b.sendMessage(chatID, "❌ Не удалось загрузить выделения.")
+		log.Printf("AnnotatedBooks error: %v", err)
+		return
+	}
+	for _, book := range books {
+		if book.FileID == fileID {
+			b.sendHighlights(ctx, chatID, userID, book)
+			return
+		}
+	}
+	b.sendMessage(chatID, "😔 В этой книге больше нет выделений.")
+}
+
+// sendHighlights отправляет выделения и заметки книги: коротким сообщением или файлом .md,
+// если в сообщение они не помещаются.
+func (b *Bot) sendHighlights(ctx context.Context, chatID int64, userID int64, book db.AnnotatedBook) {
+	highlights, err := b.store.ListHighlights(ctx, userID, book.FileID)
+	if err != nil {
+		b.sendMessage(chatID, "❌ Не удалось загрузить выделения.")
+		log.Printf("ListHighlights error: %v", err)
+		return
+	}
+	notes, err := b.store.ListNotes(ctx, userID, book.FileID)
+	if err != nil {
+		b.sendMessage(chatID, "❌ Не удалось загрузить выделения.")
+		log.Printf("ListNotes error: %v", err)
+		return
+	}
+
+	text := highlightsMarkdown(book, b.chapterTitles(ctx, userID, book.FileID), highlights, notes)
+	if utf16Len(text) <= maxMessageLength {
+		b.sendMessage(chatID, text)
+		return
+	}
+	name := strings.TrimSpace(book.Title)
+	if name == "" {
+		name = "highlights"
+	}
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name) + ".md"
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: name, Bytes: []byte(text)})
+	doc.Caption = fmt.Sprintf("🖍 Выделения: %d", len(highlights))
+	if _, err := b.bot.Send(doc); err != nil {
+		log.Printf("send highlights error: %v", err)
+	}
+}
+
+// chapterTitles — названия глав книги для заголовков экспорта. Если книгу не удаётся
+// открыть, главы останутся с номерами.
+func (b *Bot) chapterTitles(ctx context.Context, userID int64, fileID int64) []string {
+	file, err := b.store.GetFileForUser(ctx, userID, fileID)
+	if err != nil || file.MimeType == "" || b.blobs == nil {
+		return nil
+	}
+	body, err := b.blobs.Get(ctx, file.Path)
+	if err != nil {
+		log.Printf("highlights: %v", err)
+		return nil
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		log.Printf("highlights: %v", err)
+		return nil
+	}
+	parsed, err := reader.Open(data, file.MimeType)
+	if err != nil {
+		return nil
+	}
+	titles := make([]string, len(parsed.Chapters))
+	for i, ch := range parsed.Chapters {
+		titles[i] = ch.Title
+	}
+	return titles
+}
+
+// highlightsMarkdown собирает выделения и заметки по главам в порядке чтения.
+func highlightsMarkdown(book db.AnnotatedBook, chapterTitles []string, highlights []db.Highlight, notes []db.Note) string {
+	var sb strings.Builder
+	title := strings.TrimSpace(book.Title)
+	if title == "" {
+		title = "Без названия"
+	}
+	sb.WriteString("# " + title + "\n")
+	if author := strings.TrimSpace(book.Author); author != "" {
+		sb.WriteString("_" + author + "_\n")
+	}
+
+	// Заметки к выделениям идут под ними, остальные — на своём месте среди выделений.
+	attached := make(map[int64][]db.Note)
+	var loose []db.Note
+	for _, n := range notes {
+		if n.HighlightID != nil {
+			attached[*n.HighlightID] = append(attached[*n.HighlightID], n)
+		} else {
+			loose = append(loose, n)
+		}
+	}
+
+	chapter := -1
+	heading := func(ch int) {
+		if ch == chapter {
+			return
+		}
+		chapter = ch
+		name := fmt.Sprintf("Глава %d", ch+1)
+		if ch < len(chapterTitles) && strings.TrimSpace(chapterTitles[ch]) != "" {
+			name = strings.TrimSpace(chapterTitles[ch])
+		}
+		sb.WriteString("\n## " + name + "\n")
+	}
+	writeNote := func(n db.Note) {
+		sb.WriteString("\n📝 " + strings.ReplaceAll(n.Text, "\n", "\n   ") + "\n")
+	}
+
+	for _, h := range highlights {
+		for len(loose) > 0 && (loose[0].Chapter < h.Chapter || loose[0].Chapter == h.Chapter && loose[0].Offset < h.StartParagraph) {
+			heading(loose[0].Chapter)
+			writeNote(loose[0])
+			loose = loose[1:]
+		}
+		heading(h.Chapter)
+		sb.WriteString("\n> " + strings.ReplaceAll(strings.TrimSpace(h.Text), "\n", "\n> ") + "\n")
+		for _, n := range attached[h.ID] {
+			writeNote(n)
+		}
+	}
+	for _, n := range loose {
+		heading(n.Chapter)
+		writeNote(n)
+	}
+	return sb.String()
+}
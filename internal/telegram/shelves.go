@@ -0,0 +1,392 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"tor_project/internal/db"
+	"tor_project/internal/models"
+)
+
+const (
+	cbShelvesPrefix     = "shelves:" // выбор полок для книги: shelves:<bookID>
+	cbShelfTogglePrefix = "shelft:"  // положить/снять: shelft:<shelfID>:<bookID>
+	cbShelfOpenPrefix   = "shelfo:"  // книги полки: shelfo:<shelfID>
+	cbStatusPrefix      = "status:"  // выбор статуса: status:<bookID>
+	cbSetStatusPrefix   = "sets:"    // задать статус: sets:<status>:<bookID> (пустой — снять)
+	cbStatusListPrefix  = "statl:"   // книги в статусе: statl:<status>
+
+	maxShelfNameLength = 64
+)
+
+// statusLabels — подписи статусов чтения.
+var statusLabels = map[string]string{
+	db.StatusWantToRead: "📌 Хочу прочитать",
+	db.StatusReading:    "📖 Читаю",
+	db.StatusFinished:   "✅ Прочитано",
+	db.StatusAbandoned:  "💤 Брошено",
+}
+
+// handleShelf — /shelf: без аргумента показывает полки и статусы, с названием — открывает
+// полку (или создаёт, если такой ещё нет).
+func (b *Bot) handleShelf(ctx context.Context, msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	if b.store == nil {
+		b.sendMessage(chatID, "⚠️ Библиотека недоступна.")
+		return
+	}
+	if err := b.store.EnsureUser(ctx, msg.From.ID, msg.From.UserName); err != nil {
+		b.sendMessage(chatID, "❌ Не удалось открыть полки.")
+		log.Printf("EnsureUser error: %v", err)
+		return
+	}
+
+	name := strings.TrimSpace(msg.CommandArguments())
+	if name == "" {
+		b.sendShelves(ctx, chatID, msg.From.ID)
+		return
+	}
+	if len(name) > maxShelfNameLength {
+		b.sendMessage(chatID, "✂️ Слишком длинное название полки.")
+		return
+	}
+
+	shelf, err := b.store.FindShelf(ctx, msg.From.ID, name)
+	if errors.Is(err, db.ErrNotFound) {
+		if _, err := b.store.CreateShelf(ctx, msg.From.ID, name); err != nil {
+			b.sendMessage(chatID, "❌ Не удалось создать полку.")
+			log.Printf("CreateShelf error: %v", err)
+			return
+		}
+		b.sendMessage(chatID, fmt.Sprintf("📚 Полка «%s» создана. Класть на неё книги можно кнопкой «На полку» в карточке книги.", name))
+		return
+	}
+	if err != nil {
+		b.sendMessage(chatID, "❌ Не удалось открыть полку.")
+		log.Printf("FindShelf error: %v", err)
+		return
+	}
+	b.sendShelfBooks(ctx, chatID, msg.From.ID, shelf.ID)
+}
+
+// sendShelves показывает полки и статусы пользователя кнопками.
+func (b *Bot) sendShelves(ctx context.Context, chatID int64, userID int64) {
+	shelves, err := b.store.ListShelves(ctx, userID)
+	if err != nil {
+		b.sendMessage(chatID, "❌ Не удалось загрузить полки.")
+		log.Printf("ListShelves error: %v", err)
+		return
+	}
+	counts, err := b.store.StatusCounts(ctx, userID)
+	if err != nil {
+		b.sendMessage(chatID, "❌ Не удалось загрузить полки.")
+		log.Printf("StatusCounts error: %v", err)
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, shelf := range shelves {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("📚 %s (%d)", shelf.Name, shelf.Books), cbShelfOpenPrefix+strconv.FormatInt(shelf.ID, 10))))
+	}
+	var row []tgbotapi.InlineKeyboardButton
+	for _, status := range db.ReadingStatuses {
+		if counts[status] == 0 {
+			continue
+		}
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("%s (%d)", statusLabels[status], counts[status]), cbStatusListPrefix+status))
+		if len(row) == 2 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		b.sendMessage(chatID, "📚 Полок пока нет. Создай: /shelf <название> — и клади на неё книги из карточки книги.")
+		return
+	}
+	msg := tgbotapi.NewMessage(chatID, "📚 Полки и статусы\nНовая полка: /shelf <название>")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.bot.Send(msg)
+}
+
+// sendShelfBooks показывает книги полки постранично, как результаты поиска.
+func (b *Bot) sendShelfBooks(ctx context.Context, chatID int64, userID int64, shelfID int64) {
+	shelf, err := b.store.GetShelf(ctx, userID, shelfID)
+	if errors.Is(err, db.ErrNotFound) {
+		b.sendMessage(chatID, "😔 Такой полки больше нет.")
+		return
+	}
+	var books []db.UserBook
+	if err == nil {
+		books, err = b.store.ShelfBooks(ctx, userID, shelfID)
+	}
+	if err != nil {
+		b.sendMessage(chatID, "❌ Не удалось открыть полку.")
+		log.Printf("ShelfBooks error: %v", err)
+		return
+	}
+	b.sendUserBooks(chatID, "📚 "+shelf.Name, books, fmt.Sprintf("📚 Полка «%s» пока пуста.", shelf.Name))
+}
+
+func (b *Bot) sendUserBooks(chatID int64, header string, books []db.UserBook, empty string) {
+	var list []models.Book
+	for _, book := range books {
+		// Без ID на сайте карточку не открыть.
+		if book.SourceID != "" {
+			list = append(list, models.Book{ID: book.SourceID, Title: book.Title, Author: book.Author})
+		}
+	}
+	if len(list) == 0 {
+		b.sendMessage(chatID, empty)
+		return
+	}
+	b.storeSession(chatID, header, list)
+	b.sendBooksPage(chatID, 0)
+}
+
+// shelfButtons — кнопки полок и статуса для карточки книги.
+func shelfButtons(bookID string) []tgbotapi.InlineKeyboardButton {
+	return []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("📚 На полку", cbShelvesPrefix+bookID),
+		tgbotapi.NewInlineKeyboardButtonData("🔖 Статус", cbStatusPrefix+bookID),
+	}
+}
+
+// handleShelfCallback обрабатывает кнопки полок и статусов; false — кнопка не про них.
+func (b *Bot) handleShelfCallback(ctx context.Context, cb *tgbotapi.CallbackQuery) bool {
+	data := cb.Data
+	chatID := cb.Message.Chat.ID
+	userID := cb.From.ID
+
+	var handle func()
+	switch {
+	case strings.HasPrefix(data, cbShelvesPrefix):
+		handle = func() { b.sendShelfPicker(ctx, chatID, userID, strings.TrimPrefix(data, cbShelvesPrefix)) }
+	case strings.HasPrefix(data, cbShelfTogglePrefix):
+		shelf, bookID, _ := strings.Cut(strings.TrimPrefix(data, cbShelfTogglePrefix), ":")
+		shelfID, err := strconv.ParseInt(shelf, 10, 64)
+		if err != nil || bookID == "" {
+			log.Printf("Invalid shelf callback data: %q", data)
+			return true
+		}
+		handle = func() { b.toggleShelf(ctx, chatID, cb.Message.MessageID, userID, shelfID, bookID) }
+	case strings.HasPrefix(data, cbShelfOpenPrefix):
+		shelfID, err := strconv.ParseInt(strings.TrimPrefix(data, cbShelfOpenPrefix), 10, 64)
+		if err != nil {
+			log.Printf("Invalid shelf callback data: %q", data)
+			return true
+		}
+		handle = func() { b.sendShelfBooks(ctx, chatID, userID, shelfID) }
+	case strings.HasPrefix(data, cbStatusPrefix):
+		handle = func() { b.sendStatusPicker(ctx, chatID, userID, strings.TrimPrefix(data, cbStatusPrefix)) }
+	case strings.HasPrefix(data, cbSetStatusPrefix):
+		status, bookID, _ := strings.Cut(strings.TrimPrefix(data, cbSetStatusPrefix), ":")
+		if status != "" && !db.ValidStatus(status) || bookID == "" {
+			log.Printf("Invalid status callback data: %q", data)
+			return true
+		}
+		handle = func() { b.setStatus(ctx, chatID, cb.Message.MessageID, userID, bookID, status) }
+	case strings.HasPrefix(data, cbStatusListPrefix):
+		status := strings.TrimPrefix(data, cbStatusListPrefix)
+		if !db.ValidStatus(status) {
+			log.Printf("Invalid status callback data: %q", data)
+			return true
+		}
+		handle = func() {
+			books, err := b.store.BooksByStatus(ctx, userID, status)
+			if err != nil {
+				b.sendMessage(chatID, "❌ Не удалось загрузить книги.")
+				log.Printf("BooksByStatus error: %v", err)
+				return
+			}
+			b.sendUserBooks(chatID, statusLabels[status], books, "😔 Таких книг больше нет.")
+		}
+	default:
+		return false
+	}
+
+	b.bot.Request(tgbotapi.NewCallback(cb.ID, ""))
+	if b.store == nil {
+		b.sendMessage(chatID, "⚠️ Библиотека недоступна.")
+		return true
+	}
+	if err := b.store.EnsureUser(ctx, userID, cb.From.UserName); err != nil {
+		b.sendMessage(chatID, "❌ Не удалось открыть полки.")
+		log.Printf("EnsureUser error: %v", err)
+		return true
+	}
+	handle()
+	return true
+}
+
+// catalogBookID — id книги в БД по ID на сайте. Карточка сохраняется при показе, но если
+// её нет (например, кнопка из старого сообщения), загружаем её заново.
+func (b *Bot) catalogBookID(ctx context.Context, chatID int64, sourceID string) (int64, error) {
+	id, err := b.store.BookIDBySource(ctx, sourceID)
+	if !errors.Is(err, db.ErrNotFound) {
+		return id, err
+	}
+	details, err := b.details.GetBookDetails(ctx, sourceID)
+	if err != nil {
+		return 0, err
+	}
+	if book, ok := b.findBookInSession(chatID, sourceID); ok {
+		details.Title = book.Title
+		details.Author = book.Author
+	}
+	return b.store.SaveBookDetails(ctx, details)
+}
+
+func (b *Bot) sendShelfPicker(ctx context.Context, chatID int64, userID int64, sourceID string) {
+	markup, ok := b.shelfPickerMarkup(ctx, chatID, userID, sourceID)
+	if !ok {
+		return
+	}
+	if len(markup.InlineKeyboard) == 0 {
+		b.sendMessage(chatID, "📚 Полок пока нет. Создай: /shelf <название> — и нажми «На полку» ещё раз.")
+		return
+	}
+	msg := tgbotapi.NewMessage(chatID, "📚 На какие полки положить книгу?")
+	msg.ReplyMarkup = markup
+	b.bot.Send(msg)
+}
+
+func (b *Bot) toggleShelf(ctx context.Context, chatID int64, messageID int, userID int64, shelfID int64, sourceID string) {
+	bookID, err := b.catalogBookID(ctx, chatID, sourceID)
+	if err != nil {
+		b.sendFailure(ctx, chatID, "❌ Не удалось найти книгу.")
+		log.Printf("catalogBookID error: %v", err)
+		return
+	}
+	on, err := b.store.BookShelves(ctx, userID, bookID)
+	if err == nil {
+		if on[shelfID] {
+			err = b.store.RemoveFromShelf(ctx, userID, shelfID, bookID)
+		} else {
+			err = b.store.AddToShelf(ctx, userID, shelfID, bookID)
+		}
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		b.sendMessage(chatID, "😔 Такой полки больше нет.")
+	} else if err != nil {
+		b.sendMessage(chatID, "❌ Не удалось изменить полку.")
+		log.Printf("toggleShelf error: %v", err)
+		return
+	}
+
+	if markup, ok := b.shelfPickerMarkup(ctx, chatID, userID, sourceID); ok {
+		b.editMarkup(chatID, messageID, markup)
+	}
+}
+
+// shelfPickerMarkup — полки пользователя кнопками, отмеченные — те, где книга уже лежит.
+func (b *Bot) shelfPickerMarkup(ctx context.Context, chatID int64, userID int64, sourceID string) (tgbotapi.InlineKeyboardMarkup, bool) {
+	bookID, err := b.catalogBookID(ctx, chatID, sourceID)
+	if err != nil {
+		b.sendFailure(ctx, chatID, "❌ Не удалось найти книгу.")
+		log.Printf("catalogBookID error: %v", err)
+		return tgbotapi.InlineKeyboardMarkup{}, false
+	}
+	shelves, err := b.store.ListShelves(ctx, userID)
+	if err != nil {
+		b.sendMessage(chatID, "❌ Не удалось загрузить полки.")
+		log.Printf("ListShelves error: %v", err)
+		return tgbotapi.InlineKeyboardMarkup{}, false
+	}
+	on, err := b.store.BookShelves(ctx, userID, bookID)
+	if err != nil {
+		b.sendMessage(chatID, "❌ Не удалось загрузить полки.")
+		log.Printf("BookShelves error: %v", err)
+		return tgbotapi.InlineKeyboardMarkup{}, false
+	}
+
+	markup := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+	for _, shelf := range shelves {
+		text := shelf.Name
+		if on[shelf.ID] {
+			text = "✓ " + text
+		}
+		markup.InlineKeyboard = append(markup.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			text, fmt.Sprintf("%s%d:%s", cbShelfTogglePrefix, shelf.ID, sourceID))))
+	}
+	return markup, true
+}
+
+func (b *Bot) sendStatusPicker(ctx context.Context, chatID int64, userID int64, sourceID string) {
+	markup, ok := b.statusPickerMarkup(ctx, chatID, userID, sourceID)
+	if !ok {
+		return
+	}
+	msg := tgbotapi.NewMessage(chatID, "🔖 Статус книги:")
+	msg.ReplyMarkup = markup
+	b.bot.Send(msg)
+}
+
+func (b *Bot) setStatus(ctx context.Context, chatID int64, messageID int, userID int64, sourceID string, status string) {
+	bookID, err := b.catalogBookID(ctx, chatID, sourceID)
+	if err != nil {
+		b.sendFailure(ctx, chatID, "❌ Не удалось найти книгу.")
+		log.Printf("catalogBookID error: %v", err)
+		return
+	}
+	if err := b.store.SetStatus(ctx, userID, bookID, status); err != nil {
+		b.sendMessage(chatID, "❌ Не удалось сохранить статус.")
+		log.Printf("SetStatus error: %v", err)
+		return
+	}
+	if markup, ok := b.statusPickerMarkup(ctx, chatID, userID, sourceID); ok {
+		b.editMarkup(chatID, messageID, markup)
+	}
+}
+
+// statusPickerMarkup — статусы кнопками, текущий отмечен.
+func (b *Bot) statusPickerMarkup(ctx context.Context, chatID int64, userID int64, sourceID string) (tgbotapi.InlineKeyboardMarkup, bool) {
+	bookID, err := b.catalogBookID(ctx, chatID, sourceID)
+	if err != nil {
+		b.sendFailure(ctx, chatID, "❌ Не удалось найти книгу.")
+		log.Printf("catalogBookID error: %v", err)
+		return tgbotapi.InlineKeyboardMarkup{}, false
+	}
+	current, err := b.store.BookStatus(ctx, userID, bookID)
+	if err != nil {
+		b.sendMessage(chatID, "❌ Не удалось загрузить статус.")
+		log.Printf("BookStatus error: %v", err)
+		return tgbotapi.InlineKeyboardMarkup{}, false
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for _, status := range db.ReadingStatuses {
+		text := statusLabels[status]
+		if status == current {
+			text = "✓ " + text
+		}
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(text, cbSetStatusPrefix+status+":"+sourceID))
+		if len(row) == 2 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if current != "" {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✖️ Снять статус", cbSetStatusPrefix+":"+sourceID)))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...), true
+}
+
+func (b *Bot) editMarkup(chatID int64, messageID int, markup tgbotapi.InlineKeyboardMarkup) {
+	edit := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, markup)
+	if _, err := b.bot.Send(edit); err != nil {
+		log.Printf("Edit markup error: %v", err)
+	}
+}
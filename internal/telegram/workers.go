@@ -0,0 +1,102 @@
+package telegram
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// WorkerPoolConfig — настройки параллельной обработки обновлений.
+type WorkerPoolConfig struct {
+	// Workers — сколько обновлений обрабатывается одновременно.
+	Workers int
+	// QueueSize — сколько обновлений может ждать своей очереди у одного воркера.
+	QueueSize int
+	// DrainTimeout — сколько при остановке ждать начатые операции, прежде чем отменить их.
+	DrainTimeout time.Duration
+}
+
+var defaultWorkerPool = WorkerPoolConfig{
+	Workers:      8,
+	QueueSize:    64,
+	DrainTimeout: 30 * time.Second,
+}
+
+// workerPool раскладывает обновления по воркерам по chatID: обновления одного чата всегда
+// попадают к одному воркеру и обрабатываются строго по порядку, а медленное скачивание
+// в одном чате не задерживает остальные (кроме чатов, попавших к тому же воркеру).
+type workerPool struct {
+	queues []chan tgbotapi.Update
+	wg     sync.WaitGroup
+}
+
+func newWorkerPool(cfg WorkerPoolConfig, handle func(tgbotapi.Update)) *workerPool {
+	workers := max(cfg.Workers, 1)
+	queueSize := max(cfg.QueueSize, 0)
+
+	p := &workerPool{queues: make([]chan tgbotapi.Update, workers)}
+	for i := range p.queues {
+		queue := make(chan tgbotapi.Update, queueSize)
+		p.queues[i] = queue
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for update := range queue {
+				handle(update)
+			}
+		}()
+	}
+	return p
+}
+
+// submit ставит обновление в очередь его чата. Если очередь полна, ждёт места;
+// false — ctx отменили раньше, обновление не принято.
+func (p *workerPool) submit(ctx context.Context, update tgbotapi.Update) bool {
+	queue := p.queues[uint64(updateChatID(update))%uint64(len(p.queues))]
+	select {
+	case queue <- update:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// stop закрывает очереди (уже принятые обновления будут обработаны) и ждёт воркеры
+// не дольше timeout; false — за это время они не закончили.
+func (p *workerPool) stop(timeout time.Duration) bool {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// wait ждёт воркеры без ограничения по времени.
+func (p *workerPool) wait() {
+	p.wg.Wait()
+}
+
+// updateChatID возвращает чат обновления; у обновлений без чата — 0.
+func updateChatID(update tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil && update.Message.Chat != nil:
+		return update.Message.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil && update.CallbackQuery.Message.Chat != nil:
+		return update.CallbackQuery.Message.Chat.ID
+	}
+	return 0
+}
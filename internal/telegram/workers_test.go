@@ -0,0 +1,63 @@
+package telegram
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func chatUpdate(chatID int64, text string) tgbotapi.Update {
+	return tgbotapi.Update{Message: &tgbotapi.Message{Text: text, Chat: &tgbotapi.Chat{ID: chatID}}}
+}
+
+func TestWorkerPool(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen = make(map[int64][]string)
+	)
+	slowStarted := make(chan struct{})
+	release := make(chan struct{})
+
+	pool := newWorkerPool(WorkerPoolConfig{Workers: 2, QueueSize: 8}, func(update tgbotapi.Update) {
+		if update.Message.Text == "slow" {
+			close(slowStarted)
+			<-release
+		}
+		mu.Lock()
+		seen[update.Message.Chat.ID] = append(seen[update.Message.Chat.ID], update.Message.Text)
+		mu.Unlock()
+	})
+
+	ctx := context.Background()
+	// Чат 1 занят медленной операцией; следующее его обновление ждёт её, а чат 2 — нет.
+	pool.submit(ctx, chatUpdate(1, "slow"))
+	<-slowStarted
+	pool.submit(ctx, chatUpdate(1, "after slow"))
+	pool.submit(ctx, chatUpdate(2, "fast"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		done := len(seen[2]) == 1
+		mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("chat 2 was blocked by chat 1")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Остановка ждёт начатое и то, что уже в очереди.
+	close(release)
+	if !pool.stop(5 * time.Second) {
+		t.Fatal("pool did not drain")
+	}
+	if got := seen[1]; len(got) != 2 || got[0] != "slow" || got[1] != "after slow" {
+		t.Fatalf("chat 1 order: %q", got)
+	}
+}
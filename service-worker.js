@@ -1,4 +1,4 @@
-const CACHE = 'reader-cache-v1';
+const CACHE = 'reader-cache-v4';
 const ASSETS = [
   '/',
   '/index.html',